@@ -0,0 +1,274 @@
+package cp
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/taskcluster/taskcluster-worker/commands"
+	"github.com/taskcluster/taskcluster-worker/plugins/interactive"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+)
+
+func init() {
+	commands.Register("cp", cmd{})
+}
+
+type cmd struct{}
+
+func (cmd) Summary() string {
+	return "Copy files into or out of an interactive task"
+}
+
+func (cmd) Usage() string {
+	return `
+taskcluster-worker cp will copy files or folders between the local machine and
+an interactive task, while the task is running. This is similar to using scp.
+
+The <URL> is the 'filesUrl' from 'sockets.json' of the interactive task. Paths
+inside the task must be prefixed with '` + remotePrefix + `', exactly one of
+<source> and <target> must be a path inside the task.
+
+usage: taskcluster-worker cp [options] <URL> <source> <target>
+
+options:
+  -r --recursive  Copy folders recursively.
+  -h --help       Show this screen.
+
+examples:
+  taskcluster-worker cp <URL> ./build.sh task:build.sh
+  taskcluster-worker cp -r <URL> task:/home/worker/logs ./logs
+`
+}
+
+// remotePrefix is the prefix for paths inside the task
+const remotePrefix = "task:"
+
+func (cmd) Execute(arguments map[string]interface{}) bool {
+	URL := arguments["<URL>"].(string)
+	source := arguments["<source>"].(string)
+	target := arguments["<target>"].(string)
+	recursive := arguments["--recursive"].(bool)
+
+	// Parse URL
+	u, err := url.Parse(URL)
+	if err != nil {
+		fmt.Println("Failed to parse URL, error: ", err)
+		return false
+	}
+
+	// Determine the direction of the copy
+	download := strings.HasPrefix(source, remotePrefix)
+	upload := strings.HasPrefix(target, remotePrefix)
+	if download == upload {
+		fmt.Println("Exactly one of <source> and <target> must be prefixed with '" + remotePrefix + "'")
+		return false
+	}
+
+	switch {
+	case download && recursive:
+		err = downloadFolder(u, source[len(remotePrefix):], target)
+	case download:
+		err = downloadFile(u, source[len(remotePrefix):], target)
+	case recursive:
+		err = uploadFolder(u, source, target[len(remotePrefix):])
+	default:
+		err = uploadFile(u, source, target[len(remotePrefix):])
+	}
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	return true
+}
+
+// fileURL returns the URL for a remote path, optionally as tar archive
+func fileURL(u *url.URL, p string, archive bool) *url.URL {
+	target := *u
+	qs := target.Query()
+	qs.Set("path", p)
+	if archive {
+		qs.Set("archive", "tar")
+	}
+	target.RawQuery = qs.Encode()
+	return &target
+}
+
+// checkResponse returns an error if the response doesn't have a 2xx status
+func checkResponse(res *http.Response, p string) error {
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return nil
+	case res.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("No such file or folder in the task: %s", p)
+	case res.StatusCode == http.StatusBadRequest:
+		return fmt.Errorf("Invalid path or file in the task: %s", p)
+	case res.StatusCode == http.StatusUnauthorized, res.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("Credentials are missing or insufficient to copy files in the task")
+	case res.StatusCode == http.StatusGone:
+		return fmt.Errorf("Task execution has halted, files can't be copied anymore")
+	case res.StatusCode == http.StatusNotImplemented:
+		return fmt.Errorf("Copying files isn't supported by the engine running the task")
+	default:
+		return fmt.Errorf("Failed to copy %s, status: %d", p, res.StatusCode)
+	}
+}
+
+func downloadFile(u *url.URL, source, target string) error {
+	res, err := request(http.MethodGet, fileURL(u, source, false), nil)
+	if err != nil {
+		return fmt.Errorf("Failed to download %s, error: %s", source, err)
+	}
+	defer res.Body.Close()
+	if err = checkResponse(res, source); err != nil {
+		return err
+	}
+
+	// If target is a folder, we write the file inside it
+	if info, serr := os.Stat(target); serr == nil && info.IsDir() {
+		target = filepath.Join(target, path.Base(source))
+	}
+
+	debug("Writing %s to %s", source, target)
+	f, err := os.Create(target)
+	if err != nil {
+		return fmt.Errorf("Failed to create file: %s, error: %s", target, err)
+	}
+	_, err = ioext.CopyAndClose(f, res.Body)
+	if err != nil {
+		return fmt.Errorf("Failed to download %s, error: %s", source, err)
+	}
+	return nil
+}
+
+func downloadFolder(u *url.URL, source, target string) error {
+	res, err := request(http.MethodGet, fileURL(u, source, true), nil)
+	if err != nil {
+		return fmt.Errorf("Failed to download %s, error: %s", source, err)
+	}
+	defer res.Body.Close()
+	if err = checkResponse(res, source); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(res.Body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to download %s, error: %s", source, err)
+		}
+
+		// Clean the name, so that it can't leave the target folder
+		name := filepath.FromSlash(path.Clean("/" + hdr.Name)[1:])
+		p := filepath.Join(target, name)
+		debug("Writing %s to %s", hdr.Name, p)
+		if err = os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			return fmt.Errorf("Failed to create folder for: %s, error: %s", p, err)
+		}
+		f, err := os.Create(p)
+		if err != nil {
+			return fmt.Errorf("Failed to create file: %s, error: %s", p, err)
+		}
+		if _, err = ioext.CopyAndClose(f, tr); err != nil {
+			return fmt.Errorf("Failed to download %s, error: %s", hdr.Name, err)
+		}
+	}
+}
+
+func uploadFile(u *url.URL, source, target string) error {
+	f, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("Failed to open file: %s, error: %s", source, err)
+	}
+	defer f.Close()
+
+	// If target is a folder, we write the file inside it
+	if strings.HasSuffix(target, "/") {
+		target += filepath.Base(source)
+	}
+
+	debug("Uploading %s to %s", source, target)
+	return put(fileURL(u, target, false), f, target)
+}
+
+func uploadFolder(u *url.URL, source, target string) error {
+	// Write a tar-stream of source to a pipe, while we upload it
+	r, w := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(w)
+		err := filepath.Walk(source, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			// Skip anything that isn't a plain file
+			if !ioext.IsPlainFileInfo(info) {
+				return nil
+			}
+			name, err := filepath.Rel(source, p)
+			if err != nil {
+				return err
+			}
+			debug("Uploading %s to %s", p, target)
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			err = tw.WriteHeader(&tar.Header{
+				Name:     filepath.ToSlash(name),
+				Mode:     int64(info.Mode().Perm()),
+				Size:     info.Size(),
+				ModTime:  info.ModTime(),
+				Typeflag: tar.TypeReg,
+			})
+			if err != nil {
+				return err
+			}
+			_, err = io.CopyN(tw, f, info.Size())
+			return err
+		})
+		if err == nil {
+			err = tw.Close()
+		}
+		w.CloseWithError(err)
+	}()
+	defer r.Close()
+
+	return put(fileURL(u, target, true), r, target)
+}
+
+// request sends a request authorized with credentials from the environment,
+// if any are present.
+func request(method string, u *url.URL, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request, error: %s", err)
+	}
+	authorization, err := interactive.AuthorizationHeader(method, u)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to sign request, error: %s", err)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// put sends body to the given URL with a PUT request
+func put(u *url.URL, body io.Reader, p string) error {
+	res, err := request(http.MethodPut, u, body)
+	if err != nil {
+		return fmt.Errorf("Failed to upload %s, error: %s", p, err)
+	}
+	defer res.Body.Close()
+	return checkResponse(res, p)
+}
@@ -0,0 +1,7 @@
+// Package cp provides a CommandProvider that implements a CLI tool for copying
+// files and folders into and out of an interactive taskcluster-worker task.
+package cp
+
+import "github.com/taskcluster/taskcluster-worker/runtime/util"
+
+var debug = util.Debug("cp")
@@ -0,0 +1,204 @@
+package forward
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/taskcluster/taskcluster-worker/commands"
+	"github.com/taskcluster/taskcluster-worker/plugins/interactive"
+	"github.com/taskcluster/taskcluster-worker/plugins/interactive/displayclient"
+	"github.com/taskcluster/taskcluster-worker/plugins/interactive/displayconsts"
+)
+
+func init() {
+	commands.Register("forward", cmd{})
+}
+
+type cmd struct{}
+
+func (cmd) Summary() string {
+	return "Forward local TCP ports to ports inside an interactive task"
+}
+
+func (cmd) Usage() string {
+	return `
+taskcluster-worker forward will listen on local ports and forward each TCP
+connection over a websocket to a port inside an interactive task. This is
+similar to using 'ssh -L', and can be used to attach debuggers or open
+development servers running inside a task.
+
+The <URL> is the 'forwardSocketUrl' from 'sockets.json' of the interactive task.
+Ports are given as <port> or <local-port>:<remote-port>, if no local port is
+given the remote port is used.
+
+usage: taskcluster-worker forward [options] <URL> <ports>...
+
+options:
+  -b --bind <address>  Local address to listen on [default: 127.0.0.1].
+  -h --help            Show this screen.
+`
+}
+
+var dialer = websocket.Dialer{
+	HandshakeTimeout: displayconsts.DisplayHandshakeTimeout,
+	ReadBufferSize:   displayconsts.DisplayMaxMessageSize,
+	WriteBufferSize:  displayconsts.DisplayMaxMessageSize,
+}
+
+func (cmd) Execute(arguments map[string]interface{}) bool {
+	URL := arguments["<URL>"].(string)
+	ports := arguments["<ports>"].([]string)
+	address := arguments["--bind"].(string)
+
+	// Parse URL
+	u, err := url.Parse(URL)
+	if err != nil {
+		fmt.Println("Failed to parse URL, error: ", err)
+		return false
+	}
+
+	// Parse ports and start listening
+	var listeners []net.Listener
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	wg := sync.WaitGroup{}
+	for _, spec := range ports {
+		local, remote, err := parsePorts(spec)
+		if err != nil {
+			fmt.Println(err)
+			return false
+		}
+		l, err := net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(local)))
+		if err != nil {
+			fmt.Printf("Failed to listen on port %d, error: %s\n", local, err)
+			return false
+		}
+		listeners = append(listeners, l)
+		fmt.Printf("Forwarding %s to port %d inside the task\n", l.Addr(), remote)
+
+		wg.Add(1)
+		go func(l net.Listener, remote int) {
+			defer wg.Done()
+			serve(l, u, remote)
+		}(l, remote)
+	}
+
+	// Wait for all listeners to stop
+	wg.Wait()
+	return true
+}
+
+// parsePorts parses a port specification on the form <port> or
+// <local-port>:<remote-port>
+func parsePorts(spec string) (local int, remote int, err error) {
+	parts := strings.SplitN(spec, ":", 2)
+	local, err = strconv.Atoi(parts[0])
+	if err == nil && len(parts) == 2 {
+		remote, err = strconv.Atoi(parts[1])
+	} else {
+		remote = local
+	}
+	if err != nil || local < 0 || local > 65535 || remote < 1 || remote > 65535 {
+		return 0, 0, fmt.Errorf("Invalid port specification: '%s'", spec)
+	}
+	return
+}
+
+// serve accepts connections from l and forwards them to the remote port
+func serve(l net.Listener, u *url.URL, remote int) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			debug("Stopped listening on %s, error: %s", l.Addr(), err)
+			return
+		}
+		go forward(conn, u, remote)
+	}
+}
+
+// forward opens a websocket to the remote port and connects it to conn
+func forward(conn net.Conn, u *url.URL, remote int) {
+	defer conn.Close()
+
+	// Set port in querystring
+	target := *u
+	qs := target.Query()
+	qs.Set("port", strconv.Itoa(remote))
+	target.RawQuery = qs.Encode()
+
+	// Sign request with credentials from environment, if any are present
+	authorization, err := interactive.AuthorizationHeader(http.MethodGet, &target)
+	if err != nil {
+		fmt.Println("Failed to sign request, error: ", err)
+		return
+	}
+	header := http.Header{}
+	if authorization != "" {
+		header.Set("Authorization", authorization)
+	}
+
+	// Connect to remote websocket
+	ws, res, err := dialer.Dial(target.String(), header)
+	if err == websocket.ErrBadHandshake {
+		switch res.StatusCode {
+		case http.StatusNotFound:
+			fmt.Printf("Failed to forward connection, nothing is listening on port %d\n", remote)
+		case http.StatusUnauthorized, http.StatusForbidden:
+			fmt.Println("Failed to forward connection, credentials are missing or insufficient")
+		case http.StatusGone:
+			fmt.Println("Failed to forward connection, task execution has halted")
+		default:
+			fmt.Println("Failed to forward connection, status: ", res.StatusCode)
+		}
+		return
+	}
+	if err != nil {
+		fmt.Println("Failed to forward connection, error: ", err)
+		return
+	}
+	debug("Forwarding connection from %s to port %d", conn.RemoteAddr(), remote)
+
+	// Copy data in both directions, when one direction is done we only close
+	// for writing, so data can still be received in the other direction. Both
+	// are closed when the deferred calls run, after both directions are done.
+	client := displayclient.New(ws)
+	defer client.Close()
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, err := io.Copy(client, conn)
+		if err != nil {
+			// Abort the other direction too, if copying failed
+			client.Close()
+			return
+		}
+		closeWrite(client)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(conn, client)
+		closeWrite(conn)
+	}()
+	wg.Wait()
+}
+
+// closeWrite closes c for writing, if c supports half-closing the connection,
+// like net.TCPConn does.
+func closeWrite(c interface{}) {
+	if cw, ok := c.(interface {
+		CloseWrite() error
+	}); ok {
+		cw.CloseWrite()
+	}
+}
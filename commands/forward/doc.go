@@ -0,0 +1,8 @@
+// Package forward provides a CommandProvider that implements a CLI tool for
+// forwarding TCP connections from localhost to ports inside an interactive
+// taskcluster-worker task, similar to 'ssh -L'.
+package forward
+
+import "github.com/taskcluster/taskcluster-worker/runtime/util"
+
+var debug = util.Debug("forward")
@@ -29,6 +29,7 @@ func buildImage(
 	boot, cdrom string,
 	linuxBootOptions vm.LinuxBootOptions,
 	size int,
+	snapshot bool,
 ) error {
 	// Find absolute outputFile
 	outputFile, err := filepath.Abs(outputFile)
@@ -93,7 +94,7 @@ func buildImage(
 	monitor.Info("Creating virtual machine")
 	vm, err := vm.NewVirtualMachine(
 		img.Machine().DeriveLimits(), img, net, socketFolder,
-		boot, cdrom, linuxBootOptions,
+		boot, cdrom, nil, linuxBootOptions,
 		monitor.WithTag("component", "vm"),
 	)
 	if err != nil {
@@ -134,6 +135,14 @@ func buildImage(
 		return err
 	}
 
+	// Save a snapshot of the running virtual machine, if requested
+	if snapshot {
+		err = saveSnapshot(monitor, img, tempFolder, socketFolder, vncPort)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Package up the finished image
 	monitor.Info("Package virtual machine image")
 	err = img.Package(outputFile)
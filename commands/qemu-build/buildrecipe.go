@@ -0,0 +1,287 @@
+package qemubuild
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/taskcluster/taskcluster-worker/commands/qemu-run"
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/image"
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/metaservice"
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/network"
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/vm"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+)
+
+// provisionTimeout is the maximum time to wait for qemu-guest-tools to request
+// a task, and for the virtual machine to stop after the shutdown command.
+const provisionTimeout = 15 * time.Minute
+
+// provisionService is a meta-data service that reports when qemu-guest-tools
+// requests a task, as this is when the guest is ready to be provisioned.
+// Provisioning is done through actions, so no task is ever given to guest-tools.
+type provisionService struct {
+	*metaservice.MetaService
+	once  sync.Once
+	ready chan struct{}
+}
+
+func (s *provisionService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && r.URL.Path == "/engine/v1/execute" {
+		debug("provision service: guest-tools requested a task")
+		s.once.Do(func() { close(s.ready) })
+		// Guest-tools will retry, the image is never given a task
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	s.MetaService.ServeHTTP(w, r)
+}
+
+// buildImageFromRecipe boots the base image given in recipeFile, provisions it
+// as described in the recipe and packages the result with a manifest.json
+// describing what was done.
+func buildImageFromRecipe(
+	monitor runtime.Monitor,
+	recipeFile, outputFile string,
+	vncPort int,
+	snapshot bool,
+) error {
+	// Find absolute outputFile
+	outputFile, err := filepath.Abs(outputFile)
+	if err != nil {
+		monitor.Error("Failed to resolve output file, error: ", err)
+		return err
+	}
+
+	// Load recipe and create manifest before booting anything, so we fail early
+	r, err := loadRecipe(recipeFile)
+	if err != nil {
+		monitor.Error("Failed to load recipe from ", recipeFile, " error: ", err)
+		return err
+	}
+	data, err := r.Manifest()
+	if err != nil {
+		monitor.Error("Failed to create manifest, error: ", err)
+		return err
+	}
+
+	// Create temp folder for the image
+	tempFolder, err := ioutil.TempDir("", "taskcluster-worker-build-image-")
+	if err != nil {
+		monitor.Error("Failed to create temporary folder, error: ", err)
+		return err
+	}
+	defer os.RemoveAll(tempFolder)
+
+	monitor.Info("Loading base image: ", r.BaseImage)
+	img, err := image.NewMutableImageFromFile(r.BaseImage, tempFolder)
+	if err != nil {
+		monitor.Error("Failed to load image, error: ", err)
+		return err
+	}
+	img.SetManifest(data)
+
+	// Create temp folder for sockets
+	socketFolder, err := ioutil.TempDir("", "taskcluster-worker-sockets-")
+	if err != nil {
+		monitor.Error("Failed to create temporary folder, error: ", err)
+		return err
+	}
+	defer os.RemoveAll(socketFolder)
+
+	// Create temporary storage for files sent to the virtual machine
+	storage, err := runtime.NewTemporaryStorage(filepath.Join(tempFolder, "storage"))
+	if err != nil {
+		monitor.Error("Failed to create temporary storage, error: ", err)
+		return err
+	}
+
+	// Setup a user-space network
+	monitor.Info("Creating user-space network")
+	net, err := network.NewUserNetwork(tempFolder)
+	if err != nil {
+		monitor.Error("Failed to create user-space network, error: ", err)
+		return err
+	}
+
+	// Create meta-data service without a task, provisioning is done with actions
+	service := &provisionService{
+		MetaService: metaservice.New(nil, nil, os.Stdout, func(bool) {}, &runtime.Environment{
+			TemporaryStorage: storage,
+			Monitor:          monitor,
+		}),
+		ready: make(chan struct{}),
+	}
+
+	// Create virtual machine
+	monitor.Info("Creating virtual machine")
+	machine, err := vm.NewVirtualMachine(
+		img.Machine().DeriveLimits(), img, net, socketFolder,
+		"", "", nil, vm.LinuxBootOptions{},
+		monitor.WithTag("component", "vm"),
+	)
+	if err != nil {
+		monitor.Error("Failed to create virtual-machine, error: ", err)
+		return err
+	}
+	machine.SetHTTPHandler(service)
+
+	monitor.Info("Starting virtual machine, waiting for qemu-guest-tools")
+	machine.Start()
+	if vncPort != 0 {
+		go qemurun.ExposeVNC(machine.VNCSocket(), vncPort, machine.Done)
+	}
+
+	// Wait for interrupt to gracefully kill everything
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+
+	// Provision the virtual machine, and wait for it to stop after the shutdown
+	// command, unless something fails or we get interrupted.
+	provisioned := make(chan error, 1)
+	go func() {
+		provisioned <- provision(monitor, r, service)
+	}()
+	select {
+	case err = <-provisioned:
+		if err != nil {
+			break
+		}
+		monitor.Info("Waiting for virtual machine to stop")
+		select {
+		case <-machine.Done:
+			err = machine.Error
+		case <-time.After(provisionTimeout):
+			err = errors.New("virtual machine didn't stop after the shutdown command")
+		case <-interrupted:
+			err = errors.New("SIGINT received, aborting virtual machine")
+		}
+	case <-interrupted:
+		err = errors.New("SIGINT received, aborting virtual machine")
+	case <-machine.Done:
+		err = machine.Error
+		if err == nil {
+			err = errors.New("virtual machine stopped before provisioning was done")
+		}
+	}
+	service.StopPollers()
+	machine.Kill()
+	<-machine.Done
+	signal.Stop(interrupted)
+	defer img.Dispose()
+
+	if err != nil {
+		if e, ok := err.(*exec.ExitError); ok {
+			monitor.Error("QEMU error: ", string(e.Stderr))
+		}
+		monitor.Error("Failed to provision virtual machine, error: ", err)
+		return err
+	}
+
+	// Save a snapshot of the running virtual machine, if requested
+	if snapshot {
+		err = saveSnapshot(monitor, img, tempFolder, socketFolder, vncPort)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Package up the finished image
+	monitor.Info("Package virtual machine image")
+	err = img.Package(outputFile)
+	if err != nil {
+		monitor.Error("Failed to package finished image, error: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// provision waits for qemu-guest-tools to be ready, then copies files, runs
+// commands, writes the guest-tools configuration and runs the shutdown command
+// as given in the recipe.
+func provision(monitor runtime.Monitor, r *recipe, service *provisionService) error {
+	select {
+	case <-service.ready:
+	case <-time.After(provisionTimeout):
+		return errors.New("qemu-guest-tools didn't request a task before timeout")
+	}
+
+	for _, f := range r.Files {
+		monitor.Info("Copying file: ", f.Source, " to ", f.Destination)
+		file, err := os.Open(f.Source)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open file: %s", f.Source)
+		}
+		err = service.PutFile(f.Destination, file)
+		file.Close()
+		if err != nil {
+			return errors.Wrapf(err, "failed to copy file to: %s", f.Destination)
+		}
+	}
+
+	for _, command := range r.Commands {
+		monitor.Info("Running command: ", strings.Join(command, " "))
+		success, err := runCommand(service.MetaService, command)
+		if err != nil {
+			return errors.Wrapf(err, "failed to run command: %v", command)
+		}
+		if !success {
+			return errors.Errorf("command failed: %v", command)
+		}
+	}
+
+	// JSON is valid YAML, so we just write the configuration as JSON
+	if r.GuestTools.ConfigFile != "" {
+		monitor.Info("Writing qemu-guest-tools config: ", r.GuestTools.ConfigFile)
+		data, err := json.MarshalIndent(r.GuestTools.Config, "", "  ")
+		if err != nil {
+			panic(errors.Wrap(err, "failed to json.Marshal qemu-guest-tools config"))
+		}
+		err = service.PutFile(r.GuestTools.ConfigFile, bytes.NewReader(data))
+		if err != nil {
+			return errors.Wrapf(err, "failed to write file: %s", r.GuestTools.ConfigFile)
+		}
+	}
+
+	// The shutdown command won't report success, as the virtual machine stops
+	monitor.Info("Running shutdown command: ", strings.Join(r.Shutdown, " "))
+	shell, err := service.ExecShell(r.Shutdown, false)
+	if err != nil {
+		return errors.Wrap(err, "failed to run shutdown command")
+	}
+	shell.StdinPipe().Close()
+	go io.Copy(os.Stdout, shell.StdoutPipe())
+	go io.Copy(os.Stdout, shell.StderrPipe())
+	return nil
+}
+
+// runCommand executes command in the virtual machine, forwarding output to
+// stdout, and returns true if the command was successful.
+func runCommand(meta *metaservice.MetaService, command []string) (bool, error) {
+	shell, err := meta.ExecShell(command, false)
+	if err != nil {
+		return false, err
+	}
+	shell.StdinPipe().Close()
+
+	stderrDone := make(chan struct{})
+	go func() {
+		io.Copy(os.Stdout, shell.StderrPipe())
+		close(stderrDone)
+	}()
+	io.Copy(os.Stdout, shell.StdoutPipe())
+	<-stderrDone
+
+	return shell.Wait()
+}
@@ -19,9 +19,24 @@ taskcluster-worker qemu-build takes a machine definition as JSON or an existing
 image and two ISO files to mounted as CDs and creates a virtual machine that
 will be saved to disk when terminated.
 
+If --snapshot is given the finished image is booted again, and when
+qemu-guest-tools requests a task a snapshot of the running virtual machine is
+saved in the image. The QEMU engine restores tasks from this snapshot, instead
+of booting the virtual machine.
+
+The from-recipe mode builds an image without user interaction, as described in
+a YAML recipe file. The base image from the recipe is booted, and provisioned
+using qemu-guest-tools, which must be installed in the base image. Files are
+copied into the virtual machine, commands are executed and qemu-guest-tools
+configuration is written, then the shutdown command is executed. The resulting
+image contains a manifest.json with hashes of the base image and files copied,
+as well as the commands executed. The options --size, --boot, --cdrom,
+--kernel, --append and --initrd are not used with from-recipe.
+
 usage:
   taskcluster-worker qemu-build [options] from-new <machine.json> <result.tar.zst>
   taskcluster-worker qemu-build [options] from-image <image.tar.zst> <result.tar.zst>
+  taskcluster-worker qemu-build [options] from-recipe <recipe.yml> <result.tar.zst>
 
 options:
      --vnc <port>       Expose VNC on given port.
@@ -31,7 +46,22 @@ options:
      --kernel <image>   Multi-boot option -kernel for QEMU.
      --append <cmdline> Multi-boot option -append for QEMU.
      --initrd <file>    Multi-boot option -initrd for QEMU.
+     --snapshot         Save a snapshot once qemu-guest-tools is running.
   -h --help             Show this screen.
+
+recipe:
+  baseImage: base-image.tar.zst   # Image to build from, relative to recipe
+  files:                          # Files to copy into the virtual machine
+    - source:       setup/run-task.sh
+      destination:  /usr/local/bin/run-task.sh
+  commands:                       # Commands to run in order, must succeed
+    - ['chmod', '+x', '/usr/local/bin/run-task.sh']
+  guestTools:                     # qemu-guest-tools configuration to write
+    configFile: /etc/qemu-guest-tools.yml
+    config:
+      user:     worker
+      workdir:  /home/worker
+  shutdown: ['poweroff']          # Command that stops the virtual machine
 `
 }
 
@@ -43,6 +73,7 @@ func (cmd) Execute(arguments map[string]interface{}) bool {
 	outputFile := arguments["<result.tar.zst>"].(string)
 	fromNew := arguments["from-new"].(bool)
 	fromImage := arguments["from-image"].(bool)
+	fromRecipe := arguments["from-recipe"].(bool)
 	var vncPort int64
 	var err error
 	if vnc, ok := arguments["--vnc"].(string); ok {
@@ -53,6 +84,7 @@ func (cmd) Execute(arguments map[string]interface{}) bool {
 	}
 	boot, _ := arguments["--boot"].(string)
 	cdrom, _ := arguments["--cdrom"].(string)
+	snapshot, _ := arguments["--snapshot"].(bool)
 	size, err := strconv.ParseInt(arguments["--size"].(string), 10, 32)
 	if err != nil {
 		monitor.Panic("Couldn't parse --size, error: ", err)
@@ -60,6 +92,12 @@ func (cmd) Execute(arguments map[string]interface{}) bool {
 	if size > 80 {
 		monitor.Panic("Images have a sanity limit of 80 GiB!")
 	}
+	if fromRecipe {
+		return buildImageFromRecipe(
+			monitor, arguments["<recipe.yml>"].(string), outputFile,
+			int(vncPort), snapshot,
+		) == nil
+	}
 	if fromNew == fromImage {
 		panic("Impossible arguments")
 	}
@@ -77,6 +115,6 @@ func (cmd) Execute(arguments map[string]interface{}) bool {
 		monitor, inputFile, outputFile,
 		fromImage, int(vncPort),
 		boot, cdrom, linuxBootOptions,
-		int(size),
+		int(size), snapshot,
 	) == nil
 }
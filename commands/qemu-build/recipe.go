@@ -0,0 +1,222 @@
+package qemubuild
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	schematypes "github.com/taskcluster/go-schematypes"
+	"github.com/taskcluster/taskcluster-worker/commands/qemu-guest-tools"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+	"github.com/taskcluster/taskcluster-worker/runtime/util"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// recipe is a declarative description of how to build an image, see
+// recipeSchema for details.
+type recipe struct {
+	BaseImage  string           `json:"baseImage"`
+	Files      []recipeFile     `json:"files"`
+	Commands   [][]string       `json:"commands"`
+	GuestTools recipeGuestTools `json:"guestTools"`
+	Shutdown   []string         `json:"shutdown"`
+}
+
+type recipeFile struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+type recipeGuestTools struct {
+	ConfigFile string                 `json:"configFile"`
+	Config     map[string]interface{} `json:"config"`
+}
+
+var recipeSchema = schematypes.Object{
+	Title: "Image Recipe",
+	Description: util.Markdown(`
+		Recipe for building an image with 'taskcluster-worker qemu-build
+		from-recipe'. The base image is booted and provisioned through
+		qemu-guest-tools, which must be installed in the base image.
+
+		Files are copied into the virtual machine first, then commands are
+		executed in the given order, and finally the qemu-guest-tools
+		configuration is written, before the shutdown command is executed.
+	`),
+	Properties: schematypes.Properties{
+		"baseImage": schematypes.String{
+			Title: "Base Image",
+			Description: util.Markdown(`
+				Image file to build from, relative to the recipe file.
+			`),
+		},
+		"files": schematypes.Array{
+			Title: "Files",
+			Description: util.Markdown(`
+				Files to copy into the virtual machine, 'source' is relative to the
+				recipe file and 'destination' is an absolute path in the virtual
+				machine.
+			`),
+			Items: schematypes.Object{
+				Properties: schematypes.Properties{
+					"source":      schematypes.String{},
+					"destination": schematypes.String{},
+				},
+				Required: []string{"source", "destination"},
+			},
+		},
+		"commands": schematypes.Array{
+			Title: "Provisioning Commands",
+			Description: util.Markdown(`
+				Commands to execute in the virtual machine, the build is aborted if a
+				command exits non-zero.
+			`),
+			Items: schematypes.Array{Items: schematypes.String{}},
+		},
+		"guestTools": schematypes.Object{
+			Title: "Guest Tools Configuration",
+			Description: util.Markdown(`
+				Configuration for qemu-guest-tools to be written to 'configFile' in
+				the virtual machine, the guest must be setup to start qemu-guest-tools
+				with '--config <configFile>'.
+			`),
+			Properties: schematypes.Properties{
+				"configFile": schematypes.String{},
+				"config":     schematypes.Object{AdditionalProperties: true},
+			},
+			Required: []string{"configFile", "config"},
+		},
+		"shutdown": schematypes.Array{
+			Title: "Shutdown Command",
+			Description: util.Markdown(`
+				Command that powers off the virtual machine, such as
+				'["sudo", "poweroff"]'.
+			`),
+			Items: schematypes.String{},
+		},
+	},
+	Required: []string{"baseImage", "shutdown"},
+}
+
+// loadRecipe reads a recipe from a YAML file and resolves paths relative to
+// the folder containing the recipe file.
+func loadRecipe(recipeFile string) (*recipe, error) {
+	data, err := ioext.BoundedReadFile(recipeFile, 1024*1024)
+	if err == ioext.ErrFileTooBig {
+		return nil, errors.New("recipe file is larger than 1MiB")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read recipe file")
+	}
+
+	// Parse YAML and validate against schema
+	var value interface{}
+	if err = yaml.Unmarshal(data, &value); err != nil {
+		return nil, errors.Wrap(err, "failed to parse recipe file")
+	}
+	value = util.ConvertSimpleJSONTypes(value)
+	if err = recipeSchema.Validate(value); err != nil {
+		return nil, errors.Wrap(err, "invalid recipe file")
+	}
+	var r recipe
+	schematypes.MustValidateAndMap(recipeSchema, value, &r)
+
+	if len(r.Shutdown) == 0 {
+		return nil, errors.New("invalid recipe file, 'shutdown' must be a command")
+	}
+	for _, c := range r.Commands {
+		if len(c) == 0 {
+			return nil, errors.New("invalid recipe file, 'commands' cannot contain empty commands")
+		}
+	}
+	if r.GuestTools.Config != nil {
+		if err = qemuguesttools.ConfigSchema.Validate(r.GuestTools.Config); err != nil {
+			return nil, errors.Wrap(err, "invalid 'guestTools.config' in recipe file")
+		}
+	}
+
+	// Resolve paths relative to the recipe file
+	folder := filepath.Dir(recipeFile)
+	if !filepath.IsAbs(r.BaseImage) {
+		r.BaseImage = filepath.Join(folder, r.BaseImage)
+	}
+	for i, f := range r.Files {
+		if !filepath.IsAbs(f.Source) {
+			r.Files[i].Source = filepath.Join(folder, f.Source)
+		}
+	}
+
+	return &r, nil
+}
+
+// manifest is the manifest.json file embedded in images built from a recipe,
+// source files are identified by hash, so that the manifest only depends on
+// what was copied into the virtual machine, not where it was on the host.
+type manifest struct {
+	BaseImage  string            `json:"baseImage"`
+	Files      []manifestFile    `json:"files"`
+	Commands   [][]string        `json:"commands"`
+	GuestTools *recipeGuestTools `json:"guestTools,omitempty"`
+	Shutdown   []string          `json:"shutdown"`
+}
+
+type manifestFile struct {
+	Destination string `json:"destination"`
+	SHA256      string `json:"sha256"`
+}
+
+// Manifest returns the JSON manifest for r, this hashes the base image and
+// all source files.
+func (r *recipe) Manifest() ([]byte, error) {
+	hash, err := hashFile(r.BaseImage)
+	if err != nil {
+		return nil, err
+	}
+	m := manifest{
+		BaseImage: "sha256:" + hash,
+		Files:     []manifestFile{},
+		Commands:  r.Commands,
+		Shutdown:  r.Shutdown,
+	}
+	if m.Commands == nil {
+		m.Commands = [][]string{}
+	}
+	for _, f := range r.Files {
+		hash, err = hashFile(f.Source)
+		if err != nil {
+			return nil, err
+		}
+		m.Files = append(m.Files, manifestFile{
+			Destination: f.Destination,
+			SHA256:      hash,
+		})
+	}
+	if r.GuestTools.ConfigFile != "" {
+		m.GuestTools = &r.GuestTools
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		panic(fmt.Sprintf("Failed to json.Marshal manifest.json, err: %s", err))
+	}
+	return data, nil
+}
+
+// hashFile returns the hex encoded sha256 hash of file
+func hashFile(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to open file: %s", file)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.Wrapf(err, "failed to read file: %s", file)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,122 @@
+package qemubuild
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testRecipe = `
+baseImage: base.tar.zst
+files:
+  - source: setup.sh
+    destination: /usr/local/bin/setup.sh
+commands:
+  - ['sh', '/usr/local/bin/setup.sh']
+guestTools:
+  configFile: /etc/qemu-guest-tools.yml
+  config:
+    user: worker
+    env:
+      HOME: /home/worker
+shutdown: ['poweroff']
+`
+
+func writeRecipe(t *testing.T, folder, recipe string) string {
+	for name, data := range map[string]string{
+		"recipe.yml":   recipe,
+		"base.tar.zst": "base-image",
+		"setup.sh":     "#!/bin/sh\necho hello\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(folder, name), []byte(data), 0644); err != nil {
+			t.Fatal("Failed to write file: ", name, " error: ", err)
+		}
+	}
+	return filepath.Join(folder, "recipe.yml")
+}
+
+func TestLoadRecipe(t *testing.T) {
+	folder, err := ioutil.TempDir("", "qemu-build-recipe-")
+	if err != nil {
+		t.Fatal("Failed to create temporary folder, error: ", err)
+	}
+	defer os.RemoveAll(folder)
+
+	r, err := loadRecipe(writeRecipe(t, folder, testRecipe))
+	if err != nil {
+		t.Fatal("Failed to load recipe, error: ", err)
+	}
+	if r.BaseImage != filepath.Join(folder, "base.tar.zst") {
+		t.Error("Expected baseImage relative to recipe, got: ", r.BaseImage)
+	}
+	if len(r.Files) != 1 || r.Files[0].Source != filepath.Join(folder, "setup.sh") {
+		t.Error("Expected source relative to recipe, got: ", r.Files)
+	}
+	if len(r.Commands) != 1 || len(r.Commands[0]) != 2 {
+		t.Error("Unexpected commands: ", r.Commands)
+	}
+	if r.GuestTools.Config["user"] != "worker" {
+		t.Error("Unexpected guestTools config: ", r.GuestTools.Config)
+	}
+
+	// The manifest must only depend on what was copied into the image
+	m1, err := r.Manifest()
+	if err != nil {
+		t.Fatal("Failed to create manifest, error: ", err)
+	}
+	other, err := ioutil.TempDir("", "qemu-build-recipe-")
+	if err != nil {
+		t.Fatal("Failed to create temporary folder, error: ", err)
+	}
+	defer os.RemoveAll(other)
+	r, err = loadRecipe(writeRecipe(t, other, testRecipe))
+	if err != nil {
+		t.Fatal("Failed to load recipe, error: ", err)
+	}
+	m2, err := r.Manifest()
+	if err != nil {
+		t.Fatal("Failed to create manifest, error: ", err)
+	}
+	if string(m1) != string(m2) {
+		t.Error("Expected identical manifests, got: ", string(m1), " and ", string(m2))
+	}
+
+	var m manifest
+	if err = json.Unmarshal(m1, &m); err != nil {
+		t.Fatal("Failed to parse manifest, error: ", err)
+	}
+	if len(m.Files) != 1 || m.Files[0].Destination != "/usr/local/bin/setup.sh" || len(m.Files[0].SHA256) != 64 {
+		t.Error("Unexpected files in manifest: ", string(m1))
+	}
+	if m.GuestTools == nil || m.GuestTools.ConfigFile != "/etc/qemu-guest-tools.yml" {
+		t.Error("Expected guestTools in manifest: ", string(m1))
+	}
+}
+
+func TestLoadRecipeInvalid(t *testing.T) {
+	folder, err := ioutil.TempDir("", "qemu-build-recipe-")
+	if err != nil {
+		t.Fatal("Failed to create temporary folder, error: ", err)
+	}
+	defer os.RemoveAll(folder)
+
+	for _, recipe := range []string{
+		// missing shutdown
+		"baseImage: base.tar.zst\n",
+		// empty shutdown command
+		"baseImage: base.tar.zst\nshutdown: []\n",
+		// empty command
+		"baseImage: base.tar.zst\nshutdown: ['poweroff']\ncommands: [[]]\n",
+		// missing guest-tools config
+		"baseImage: base.tar.zst\nshutdown: ['poweroff']\nguestTools: {}\n",
+		// invalid guest-tools config
+		"baseImage: base.tar.zst\nshutdown: ['poweroff']\n" +
+			"guestTools: {configFile: /etc/config.yml, config: {user: [42]}}\n",
+	} {
+		if _, err := loadRecipe(writeRecipe(t, folder, recipe)); err == nil {
+			t.Error("Expected error for invalid recipe: ", recipe)
+		}
+	}
+}
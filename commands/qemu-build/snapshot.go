@@ -0,0 +1,117 @@
+package qemubuild
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/taskcluster/taskcluster-worker/commands/qemu-run"
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/image"
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/network"
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/vm"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+)
+
+// snapshotTimeout is the maximum time to wait for qemu-guest-tools to request
+// a task, before giving up on saving a snapshot.
+const snapshotTimeout = 15 * time.Minute
+
+// snapshotService is a logService that also reports when qemu-guest-tools
+// requests a task from the meta-data service, as this is when the guest is
+// ready to be snapshotted.
+type snapshotService struct {
+	logService
+	once  sync.Once
+	ready chan struct{}
+}
+
+func (s *snapshotService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && r.URL.Path == "/engine/v1/execute" {
+		debug("snapshot service: guest-tools requested a task")
+		s.once.Do(func() { close(s.ready) })
+		// Guest-tools will retry until restored into a network where the
+		// meta-data service has a task to execute.
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	s.logService.ServeHTTP(w, r)
+}
+
+// saveSnapshot boots img from a qcow2 layer, and saves a snapshot of the
+// virtual machine once qemu-guest-tools is requesting a task. The virtual
+// machine state is saved to img.StateFile(), which will be packaged with the
+// image along with the qcow2 layer.
+func saveSnapshot(
+	monitor runtime.Monitor,
+	img *image.MutableImage,
+	tempFolder, socketFolder string,
+	vncPort int,
+) error {
+	// Saved state is only valid with the disk state in layer.qcow2
+	if err := img.CreateLayer(); err != nil {
+		monitor.Error("Failed to create layer.qcow2, error: ", err)
+		return err
+	}
+
+	// Setup a user-space network with meta-data service that tells us when
+	// qemu-guest-tools is ready
+	net, err := network.NewUserNetwork(tempFolder)
+	if err != nil {
+		monitor.Error("Failed to create user-space network, error: ", err)
+		return err
+	}
+	defer net.Release()
+	service := &snapshotService{
+		logService: logService{Destination: os.Stdout},
+		ready:      make(chan struct{}),
+	}
+	net.SetHandler(service)
+
+	// Create virtual machine
+	machine, err := vm.NewVirtualMachine(
+		img.Machine().DeriveLimits(), img, net, socketFolder,
+		"", "", nil, vm.LinuxBootOptions{},
+		monitor.WithTag("component", "vm"),
+	)
+	if err != nil {
+		monitor.Error("Failed to create virtual-machine, error: ", err)
+		return err
+	}
+
+	// Store the machine with defaults applied, so that restoring the snapshot
+	// uses the exact same machine configuration.
+	img.SetMachine(machine.Machine())
+
+	monitor.Info("Starting virtual machine, waiting for qemu-guest-tools")
+	machine.Start()
+	if vncPort != 0 {
+		go qemurun.ExposeVNC(machine.VNCSocket(), vncPort, machine.Done)
+	}
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	defer signal.Stop(interrupted)
+
+	select {
+	case <-service.ready:
+		monitor.Info("Saving snapshot: ", img.StateFile())
+		err = machine.SaveSnapshot(img.StateFile())
+	case <-time.After(snapshotTimeout):
+		err = errors.New("qemu-guest-tools didn't request a task before timeout")
+	case <-interrupted:
+		err = errors.New("SIGINT received, aborting virtual machine")
+	case <-machine.Done:
+		err = machine.Error
+		if err == nil {
+			err = errors.New("virtual machine stopped before snapshot was saved")
+		}
+	}
+	machine.Kill()
+	if err != nil {
+		monitor.Error("Failed to save snapshot, error: ", err)
+	}
+	return err
+}
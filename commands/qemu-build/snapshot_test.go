@@ -0,0 +1,87 @@
+//+build qemu
+
+package qemubuild
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/taskcluster/slugid-go/slugid"
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/image"
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/network"
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/vm"
+	"github.com/taskcluster/taskcluster-worker/runtime/gc"
+	"github.com/taskcluster/taskcluster-worker/runtime/mocks"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	monitor := mocks.NewMockMonitor(true)
+
+	inputImageFile, err := filepath.Abs("../../engines/qemu/test-image/tinycore-worker.tar.zst")
+	require.NoError(t, err)
+	tempFolder, err := ioutil.TempDir("", "qemu-build-snapshot-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempFolder)
+	socketFolder, err := ioutil.TempDir("", "qemu-build-snapshot-sockets-")
+	require.NoError(t, err)
+	defer os.RemoveAll(socketFolder)
+	outputFile := filepath.Join(os.TempDir(), slugid.Nice())
+	defer os.Remove(outputFile)
+
+	debug(" - Save snapshot of the test image")
+	imageFolder := filepath.Join(tempFolder, "image")
+	require.NoError(t, os.Mkdir(imageFolder, 0700))
+	img, err := image.NewMutableImageFromFile(inputImageFile, imageFolder)
+	require.NoError(t, err)
+	defer img.Dispose()
+	require.NoError(t, saveSnapshot(monitor, img, tempFolder, socketFolder, 0))
+	require.NoError(t, img.Package(outputFile))
+
+	debug(" - Load the snapshot image")
+	manager, err := image.NewManager(filepath.Join(tempFolder, "images"), &gc.GarbageCollector{}, monitor)
+	require.NoError(t, err)
+	instance, err := manager.Instance("snapshot", func(target *os.File) error {
+		f, ferr := os.Open(outputFile)
+		if ferr != nil {
+			return ferr
+		}
+		defer f.Close()
+		_, ferr = io.Copy(target, f)
+		return ferr
+	})
+	require.NoError(t, err)
+	defer instance.Release()
+	require.NotEmpty(t, instance.Snapshot(), "expected the image to have saved state")
+
+	debug(" - Restore virtual machine and wait for qemu-guest-tools")
+	net, err := network.NewUserNetwork(tempFolder)
+	require.NoError(t, err)
+	defer net.Release()
+	service := &snapshotService{
+		logService: logService{Destination: os.Stdout},
+		ready:      make(chan struct{}),
+	}
+	net.SetHandler(service)
+	machine, err := vm.NewVirtualMachine(
+		instance.Machine().DeriveLimits(), instance, net, socketFolder,
+		"", "", nil, vm.LinuxBootOptions{},
+		monitor.WithTag("component", "vm"),
+	)
+	require.NoError(t, err)
+	machine.Start()
+	defer machine.Kill()
+
+	select {
+	case <-service.ready:
+	case <-machine.Done:
+		require.NoError(t, machine.Error, "virtual machine stopped after restore")
+		t.Fatal("virtual machine stopped after restore")
+	case <-time.After(2 * time.Minute):
+		t.Fatal("qemu-guest-tools didn't request a task after restore")
+	}
+}
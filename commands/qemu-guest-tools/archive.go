@@ -0,0 +1,82 @@
+package qemuguesttools
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// writeArchive writes a tar archive of folder to w. The archive contains
+// directories, plain files and symbolic links, with paths relative to folder.
+// Files that can't be read are skipped, as are devices, sockets and pipes.
+func writeArchive(w io.Writer, folder string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(folder, func(p string, info os.FileInfo, err error) error {
+		// Stop if there is an error with the folder we were asked to archive,
+		// ignore errors with anything inside the folder.
+		if err != nil {
+			if p == folder {
+				return err
+			}
+			return nil
+		}
+		if p == folder {
+			return nil // Don't include the folder itself
+		}
+		name, err := filepath.Rel(folder, p)
+		if err != nil {
+			return nil
+		}
+		name = filepath.ToSlash(name)
+
+		switch {
+		case info.Mode().IsDir():
+			hdr, herr := tar.FileInfoHeader(info, "")
+			if herr != nil {
+				return nil
+			}
+			hdr.Name = name + "/"
+			return tw.WriteHeader(hdr)
+		case info.Mode()&os.ModeSymlink != 0:
+			target, lerr := os.Readlink(p)
+			if lerr != nil {
+				return nil
+			}
+			hdr, herr := tar.FileInfoHeader(info, target)
+			if herr != nil {
+				return nil
+			}
+			hdr.Name = name
+			return tw.WriteHeader(hdr)
+		case info.Mode().IsRegular():
+			// Open before writing the header, so unreadable files are skipped
+			f, oerr := os.Open(p)
+			if oerr != nil {
+				return nil
+			}
+			defer f.Close()
+			hdr, herr := tar.FileInfoHeader(info, "")
+			if herr != nil {
+				return nil
+			}
+			hdr.Name = name
+			if err = tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			// If the file is truncated while we're copying, we can't produce a valid
+			// archive, so we fail and the meta-data service will retry.
+			if _, err = io.CopyN(tw, f, hdr.Size); err != nil {
+				return fmt.Errorf("Failed to archive file: %s, error: %s", p, err)
+			}
+			return nil
+		default:
+			return nil // Skip devices, sockets and pipes
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
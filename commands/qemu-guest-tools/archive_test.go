@@ -0,0 +1,65 @@
+// +build !windows
+
+package qemuguesttools
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteArchive(t *testing.T) {
+	folder, err := ioutil.TempDir("", "guest-tools-archive-")
+	nilOrFatal(t, err, "Failed to create temporary folder")
+	defer os.RemoveAll(folder)
+
+	err = os.MkdirAll(filepath.Join(folder, "sub-folder"), 0755)
+	nilOrFatal(t, err, "Failed to create sub-folder")
+	err = ioutil.WriteFile(filepath.Join(folder, "hello.txt"), []byte("hello-world"), 0644)
+	nilOrFatal(t, err, "Failed to create hello.txt")
+	err = ioutil.WriteFile(filepath.Join(folder, "sub-folder", "run.sh"), []byte("#!/bin/sh\n"), 0755)
+	nilOrFatal(t, err, "Failed to create run.sh")
+	err = os.Symlink("../hello.txt", filepath.Join(folder, "sub-folder", "link.txt"))
+	nilOrFatal(t, err, "Failed to create symlink")
+
+	var b bytes.Buffer
+	err = writeArchive(&b, folder)
+	nilOrFatal(t, err, "Failed to write archive")
+
+	// Read the archive and check the entries
+	headers := make(map[string]*tar.Header)
+	contents := make(map[string]string)
+	tr := tar.NewReader(&b)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		nilOrFatal(t, err, "Failed to read archive")
+		data, err := ioutil.ReadAll(tr)
+		nilOrFatal(t, err, "Failed to read entry: ", hdr.Name)
+		headers[hdr.Name] = hdr
+		contents[hdr.Name] = string(data)
+	}
+	assert(t, len(headers) == 4, "Expected 4 entries, got: ", len(headers))
+
+	hdr := headers["sub-folder/"]
+	assert(t, hdr != nil && hdr.Typeflag == tar.TypeDir, "Expected sub-folder/ to be a directory")
+	hdr = headers["hello.txt"]
+	assert(t, hdr != nil && hdr.Typeflag == tar.TypeReg, "Expected hello.txt to be a plain file")
+	assert(t, contents["hello.txt"] == "hello-world", "Wrong content: ", contents["hello.txt"])
+	assert(t, hdr.FileInfo().Mode().Perm() == 0644, "Wrong mode: ", hdr.FileInfo().Mode())
+	hdr = headers["sub-folder/run.sh"]
+	assert(t, hdr != nil && hdr.FileInfo().Mode().Perm() == 0755, "Expected run.sh to be executable")
+	hdr = headers["sub-folder/link.txt"]
+	assert(t, hdr != nil && hdr.Typeflag == tar.TypeSymlink, "Expected link.txt to be a symlink")
+	assert(t, hdr.Linkname == "../hello.txt", "Wrong link target: ", hdr.Linkname)
+
+	// Archiving a missing folder is an error
+	err = writeArchive(ioutil.Discard, filepath.Join(folder, "no-such-folder"))
+	assert(t, err != nil, "Expected error for missing folder")
+}
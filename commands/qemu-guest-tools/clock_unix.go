@@ -0,0 +1,14 @@
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package qemuguesttools
+
+import (
+	"syscall"
+	"time"
+)
+
+// setClock sets the system clock, this requires root privileges.
+func setClock(t time.Time) error {
+	tv := syscall.NsecToTimeval(t.UnixNano())
+	return syscall.Settimeofday(&tv)
+}
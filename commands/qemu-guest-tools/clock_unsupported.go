@@ -0,0 +1,14 @@
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package qemuguesttools
+
+import (
+	"fmt"
+	goruntime "runtime"
+	"time"
+)
+
+// setClock is not supported on this platform.
+func setClock(t time.Time) error {
+	return fmt.Errorf("setting the clock is not supported by qemu-guest-tools on %s", goruntime.GOOS)
+}
@@ -13,7 +13,6 @@
 package qemuguesttools
 
 import (
-	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -57,7 +56,11 @@ Usage:
 
 Options:
   -c, --config <file>  Load YAML configuration for file.
-      --host <ip>      IP-address of meta-data server [default: 169.254.169.254].
+      --host <ip>      IP-address of meta-data server, IPv6 addresses such as
+                       fd00:ec2::254 are also supported [default: 169.254.169.254].
+      --transport <t>  Transport for reaching the meta-data service, either
+                       'network', 'virtio-serial' or 'auto' which uses the
+                       virtio-serial port, if present [default: auto].
   -h, --help           Show this screen.
 
 Configuration:
@@ -74,6 +77,7 @@ func (cmd) Execute(arguments map[string]interface{}) bool {
 	monitor := monitoring.NewLoggingMonitor("info", nil, "").WithTag("component", "qemu-guest-tools")
 
 	host := arguments["--host"].(string)
+	transport := arguments["--transport"].(string)
 	configFile, _ := arguments["--config"].(string)
 
 	// Load configuration
@@ -87,16 +91,32 @@ func (cmd) Execute(arguments map[string]interface{}) bool {
 		if err := yaml.Unmarshal(data, &c); err != nil {
 			monitor.Panicf("Failed to parse configFile: %s, error: %s", configFile, err)
 		}
-		c = convertSimpleJSONTypes(c)
-		if err := configSchema.Validate(c); err != nil {
+		c = util.ConvertSimpleJSONTypes(c)
+		if err := ConfigSchema.Validate(c); err != nil {
 			monitor.Panicf("Invalid configFile: %s, error: %s", configFile, err)
 		}
-		schematypes.MustValidateAndMap(configSchema, c, &C)
+		schematypes.MustValidateAndMap(ConfigSchema, c, &C)
 	}
 
 	// Create guest tools
 	g := new(C, host, monitor)
 
+	// Use the virtio-serial port, if requested or available
+	switch transport {
+	case "network":
+	case "auto":
+		if _, err := os.Stat(serialPortPath()); err != nil {
+			break
+		}
+		fallthrough
+	case "virtio-serial":
+		monitor.Info("Using virtio-serial port: ", serialPortPath())
+		g.useSerialPort(openSerialPort)
+	default:
+		monitor.Errorf("Unknown transport: '%s'", transport)
+		return false
+	}
+
 	if arguments["post-log"].(bool) {
 		logFile := arguments["<log-file>"].(string)
 		var r io.Reader
@@ -129,28 +149,3 @@ func (cmd) Execute(arguments map[string]interface{}) bool {
 
 	return true
 }
-
-func convertSimpleJSONTypes(val interface{}) interface{} {
-	switch val := val.(type) {
-	case []interface{}:
-		r := make([]interface{}, len(val))
-		for i, v := range val {
-			r[i] = convertSimpleJSONTypes(v)
-		}
-		return r
-	case map[interface{}]interface{}:
-		r := make(map[string]interface{})
-		for k, v := range val {
-			s, ok := k.(string)
-			if !ok {
-				s = fmt.Sprintf("%v", k)
-			}
-			r[s] = convertSimpleJSONTypes(v)
-		}
-		return r
-	case int:
-		return float64(val)
-	default:
-		return val
-	}
-}
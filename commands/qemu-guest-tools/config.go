@@ -13,7 +13,9 @@ type config struct {
 	WorkDir    string            `json:"workdir,omitempty"`
 }
 
-var configSchema schematypes.Schema = schematypes.Object{
+// ConfigSchema is the schema for the YAML configuration file given to
+// qemu-guest-tools with --config.
+var ConfigSchema schematypes.Schema = schematypes.Object{
 	Title: "Configuration for qemu-guest-tools",
 	Description: util.Markdown(`
 			Configuration for 'taskcluster-worker qemu-guest-tools', this configures
@@ -4,7 +4,9 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -30,6 +32,8 @@ type guestTools struct {
 	baseURL       string
 	got           *got.Got
 	gotpoll       *got.Got
+	client        *http.Client      // Client for replies and the task log
+	dialer        *websocket.Dialer // Dialer for websocket replies
 	monitor       runtime.Monitor
 	taskLog       io.Writer
 	pollingCtx    context.Context
@@ -55,12 +59,19 @@ func new(config config, host string, monitor runtime.Monitor) *guestTools {
 	gotpoll := *got
 	gotpoll.Client = &http.Client{Timeout: pollTimeout + 5*time.Second}
 
+	// IPv6 addresses must be wrapped in brackets in URLs
+	if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+		host = "[" + host + "]"
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	return &guestTools{
 		config:        config,
 		baseURL:       "http://" + host + "/",
 		got:           got,
 		gotpoll:       &gotpoll,
+		client:        &http.Client{Timeout: 0},
+		dialer:        &dialer,
 		monitor:       monitor,
 		pollingCtx:    ctx,
 		cancelPolling: cancel,
@@ -94,6 +105,10 @@ func (g *guestTools) Run() {
 		time.Sleep(200 * time.Millisecond)
 	}
 
+	// Set the clock, if the virtual machine was restored from saved state the
+	// clock is that of when the state was saved.
+	g.syncClock(task.Time)
+
 	// Start sending task log
 	taskLog, logSent := g.CreateTaskLog()
 
@@ -126,6 +141,12 @@ func (g *guestTools) Run() {
 		}
 	}
 
+	// Mount volumes before executing the task
+	if err = g.mountVolumes(task.Mounts); err != nil {
+		fmt.Fprintf(taskLog, "[qemu-guest-tools] Failed to mount volumes, error: %s\n", err)
+		goto resolved
+	}
+
 	// Execute the task
 	proc, err = system.StartProcess(system.ProcessOptions{
 		Arguments:     append(g.config.Entrypoint, task.Command...),
@@ -155,6 +176,9 @@ func (g *guestTools) Run() {
 	}
 
 resolved:
+	// Ensure data written to volumes is persisted before the VM is stopped
+	g.syncVolumes(task.Mounts)
+
 	// Close/flush the task log
 	err = taskLog.Close()
 	if err != nil {
@@ -183,8 +207,7 @@ func (g *guestTools) CreateTaskLog() (io.WriteCloser, <-chan struct{}) {
 
 	done := make(chan struct{})
 	go func() {
-		client := http.Client{Timeout: 0}
-		res, err := client.Do(req)
+		res, err := g.client.Do(req)
 
 		if err != nil {
 			g.monitor.Println("Failed to send log, error: ", err)
@@ -214,8 +237,11 @@ func (g *guestTools) poll(ctx context.Context) {
 	ctx, cancel := context.WithTimeout(ctx, pollTimeout)
 	defer cancel()
 
-	// Poll the metaservice for an action to perform
-	res, err := g.gotpoll.Get(g.url("engine/v1/poll")).WithContext(ctx).Send()
+	// Poll the metaservice for an action to perform, listing optional actions
+	// that we support
+	res, err := g.gotpoll.Get(
+		g.url("engine/v1/poll?supports=" + metaservice.ActionGetArchive),
+	).WithContext(ctx).Send()
 	if err != nil {
 		// if this wasn't a deadline exceeded error, we'll sleep a second to avoid
 		// spinning the CPU while waiting for DHCP to come up.
@@ -243,10 +269,16 @@ func (g *guestTools) dispatchAction(action metaservice.Action) {
 		return // Do nothing we have to poll again
 	case "get-artifact":
 		go g.doGetArtifact(action.ID, action.Path)
+	case metaservice.ActionGetArchive:
+		go g.doGetArchive(action.ID, action.Path)
 	case "list-folder":
 		go g.doListFolder(action.ID, action.Path)
+	case "put-file":
+		go g.doPutFile(action.ID, action.Path)
 	case "exec-shell":
 		go g.doExecShell(action.ID, action.Command, action.TTY)
+	case "dial-port":
+		go g.doDialPort(action.ID, action.Port)
 	case "kill-process":
 		go g.doKillProcess(action.ID)
 	default:
@@ -277,7 +309,7 @@ func (g *guestTools) doGetArtifact(ID, path string) {
 	}
 
 	// Send the reply
-	res, err := http.DefaultClient.Do(req)
+	res, err := g.client.Do(req)
 	if err != nil {
 		g.monitor.Error("Reply with artifact for path: ", path, " failed error: ", err)
 		return
@@ -289,6 +321,122 @@ func (g *guestTools) doGetArtifact(ID, path string) {
 	}
 }
 
+func (g *guestTools) doGetArchive(ID, path string) {
+	// Archive the working directory, if no path is given
+	if path == "" {
+		path = g.config.WorkDir
+	}
+	if path == "" {
+		path, _ = os.Getwd()
+	}
+	g.monitor.Info("Sending archive of: ", path)
+
+	// Resolve symlinks and check that the folder exists, if not we reply with an
+	// empty body, as we still have to report this in the reply
+	var body io.Reader
+	folder, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		var info os.FileInfo
+		info, err = os.Stat(folder)
+		if err == nil && !info.IsDir() {
+			err = fmt.Errorf("'%s' is not a folder", path)
+		}
+	}
+	if err == nil {
+		reader, writer := io.Pipe()
+		go func() {
+			writer.CloseWithError(writeArchive(writer, folder))
+		}()
+		defer reader.Close()
+		body = reader
+	}
+
+	// Create reply
+	req, rerr := http.NewRequest(http.MethodPost, g.url("engine/v1/reply?id="+ID), body)
+	if rerr != nil {
+		g.monitor.Panic("Failed to create reply request, error: ", rerr)
+	}
+	// If body is nil, the folder is missing
+	if body == nil {
+		g.monitor.Info("Unable to archive: ", path, " error: ", err)
+		req.Header.Set("X-Taskcluster-Worker-Error", "file-not-found")
+	} else {
+		req.Header.Set("Content-Type", "application/x-tar")
+	}
+
+	// Send the reply
+	res, err := g.client.Do(req)
+	if err != nil {
+		g.monitor.Error("Reply with archive of path: ", path, " failed error: ", err)
+		return
+	}
+	defer res.Body.Close()
+	// Log any errors, we can't really do much
+	if res.StatusCode != http.StatusOK {
+		g.monitor.Error("Reply with archive of path: ", path, " got status: ", res.StatusCode)
+	}
+}
+
+func (g *guestTools) doPutFile(ID, path string) {
+	g.monitor.Info("Writing file: ", path)
+
+	// Create parent folders and the file, if this fails we report it in the
+	// reply, as we still have to reply (just without fetching the file)
+	var f *os.File
+	err := os.MkdirAll(filepath.Dir(path), 0777)
+	if err == nil {
+		f, err = os.Create(path)
+	}
+
+	// Create reply
+	req, rerr := http.NewRequest(http.MethodPost, g.url("engine/v1/reply?id="+ID), nil)
+	if rerr != nil {
+		g.monitor.Panic("Failed to create reply request, error: ", rerr)
+	}
+	if err != nil {
+		g.monitor.Error("Failed to create file: ", path, " error: ", err)
+		req.Header.Set("X-Taskcluster-Worker-Error", "cannot-write-file")
+	} else {
+		defer f.Close()
+		g.setFileOwner(path)
+	}
+
+	// Send the reply, the response body is the file to be written
+	res, err := g.client.Do(req)
+	if err != nil {
+		g.monitor.Error("Reply for put-file for path: ", path, " failed error: ", err)
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		g.monitor.Error("Reply for put-file for path: ", path, " got status: ", res.StatusCode)
+		return
+	}
+	if f == nil {
+		return
+	}
+	if _, err = io.Copy(f, res.Body); err != nil {
+		g.monitor.Error("Failed to write file: ", path, " error: ", err)
+	}
+}
+
+// setFileOwner changes the owner of a file to the configured user, if any
+func (g *guestTools) setFileOwner(path string) {
+	if g.config.User == "" || goruntime.GOOS == "windows" {
+		return
+	}
+	owner, err := system.FindUser(g.config.User)
+	if err != nil {
+		g.monitor.Errorf(
+			"Couldn't find user referenced in qemu-guest-tools configuration: %s", g.config.User,
+		)
+		return
+	}
+	if err = system.ChangeOwner(path, owner); err != nil {
+		g.monitor.Error("Failed to change owner of: ", path, " error: ", err)
+	}
+}
+
 func (g *guestTools) doListFolder(ID, path string) {
 	g.monitor.Info("Listing path: ", path)
 
@@ -356,7 +504,7 @@ var dialer = websocket.Dialer{
 
 func (g *guestTools) doExecShell(ID string, command []string, tty bool) {
 	// Establish a websocket reply
-	ws, _, err := dialer.Dial("ws:"+g.url("engine/v1/reply?id=" + ID)[5:], nil)
+	ws, _, err := g.dialer.Dial("ws:"+g.url("engine/v1/reply?id=" + ID)[5:], nil)
 	if err != nil {
 		g.monitor.Error("Failed to establish websocket for reply to ID = ", ID)
 		return
@@ -429,3 +577,59 @@ func (g *guestTools) doExecShell(ID string, command []string, tty bool) {
 
 	handler.Terminated(result)
 }
+
+func (g *guestTools) doDialPort(ID string, port int) {
+	g.monitor.Info("Dialing port: ", port)
+
+	// Connect to the port, if this fails we report that the port wasn't found
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), 15*time.Second)
+	if err != nil {
+		g.monitor.Info("Failed to dial port: ", port, " error: ", err)
+		req, err := http.NewRequest(http.MethodPost, g.url("engine/v1/reply?id="+ID), nil)
+		if err != nil {
+			g.monitor.Panic("Failed to create reply request, error: ", err)
+		}
+		req.Header.Set("X-Taskcluster-Worker-Error", "port-not-found")
+		res, err := g.client.Do(req)
+		if err != nil {
+			g.monitor.Error("Reply with port-not-found for port: ", port, " failed error: ", err)
+			return
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			g.monitor.Error("Reply with port-not-found for port: ", port, " got status: ", res.StatusCode)
+		}
+		return
+	}
+
+	// Establish a websocket reply
+	ws, _, err := g.dialer.Dial("ws:"+g.url("engine/v1/reply?id=" + ID)[5:], nil)
+	if err != nil {
+		g.monitor.Error("Failed to establish websocket for reply to ID = ", ID)
+		conn.Close()
+		return
+	}
+
+	// Forward data between the websocket and the connection, this is the same as
+	// serving a display, so we use a DisplayHandler.
+	interactive.NewDisplayHandler(ws, conn, g.monitor.WithTag("port", fmt.Sprintf("%d", port)))
+}
+
+// maxClockSkew is the maximum allowed difference between guest and host clock
+const maxClockSkew = 1 * time.Second
+
+// syncClock sets the system clock to hostTime, if it's off by more than
+// maxClockSkew. Failures are logged, as tasks can run with a skewed clock.
+func (g *guestTools) syncClock(hostTime time.Time) {
+	if hostTime.IsZero() {
+		return // metaservice didn't send the time
+	}
+	skew := time.Since(hostTime)
+	if skew > -maxClockSkew && skew < maxClockSkew {
+		return
+	}
+	g.monitor.Infof("Setting clock to %s, clock was off by %s", hostTime, skew)
+	if err := setClock(hostTime); err != nil {
+		g.monitor.Errorf("Failed to set clock, error: %s", err)
+	}
+}
@@ -0,0 +1,79 @@
+package qemuguesttools
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	goruntime "runtime"
+	"strings"
+
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/metaservice"
+)
+
+// diskByIDPrefix is the path prefix for virtio block devices by serial
+const diskByIDPrefix = "/dev/disk/by-id/virtio-"
+
+// mountVolumes mounts volumes given in the task, disk volumes are formatted by
+// the host before they are first attached, and memory disks are mounted as
+// tmpfs.
+func (g *guestTools) mountVolumes(mounts []metaservice.Mount) error {
+	if len(mounts) > 0 && goruntime.GOOS != "linux" {
+		return fmt.Errorf("volumes are not supported by qemu-guest-tools on %s", goruntime.GOOS)
+	}
+	for _, m := range mounts {
+		g.monitor.Info("Mounting volume: ", m.Tag, " at: ", m.Mountpoint)
+		if err := os.MkdirAll(m.Mountpoint, 0755); err != nil {
+			return fmt.Errorf("failed to create mountpoint: %s, error: %s", m.Mountpoint, err)
+		}
+		var args []string
+		if m.ReadOnly {
+			args = append(args, "-o", "ro")
+		}
+		switch m.Type {
+		case metaservice.MountTypeFolder:
+			options := "trans=virtio,version=9p2000.L"
+			if m.ReadOnly {
+				options += ",ro"
+			}
+			args = []string{"-t", "9p", "-o", options, m.Tag, m.Mountpoint}
+		case metaservice.MountTypeDisk:
+			args = append(args, diskByIDPrefix+m.Tag, m.Mountpoint)
+		case metaservice.MountTypeMemory:
+			options := fmt.Sprintf("size=%dm", m.Size)
+			if m.ReadOnly {
+				options += ",ro"
+			}
+			args = []string{"-t", "tmpfs", "-o", options, "tmpfs", m.Mountpoint}
+		default:
+			return fmt.Errorf("unsupported volume type: %s", m.Type)
+		}
+		if err := run("mount", args...); err != nil {
+			return fmt.Errorf("failed to mount volume at: %s, error: %s", m.Mountpoint, err)
+		}
+		if !m.ReadOnly {
+			g.setFileOwner(m.Mountpoint)
+		}
+	}
+	return nil
+}
+
+// syncVolumes flushes data written to disk volumes, volumes remain mounted so
+// artifacts can be extracted from them after the task has finished.
+func (g *guestTools) syncVolumes(mounts []metaservice.Mount) {
+	if len(mounts) == 0 {
+		return
+	}
+	if err := run("sync"); err != nil {
+		g.monitor.Error("Failed to sync volumes, error: ", err)
+	}
+}
+
+// run executes a command and returns an error including output if it fails
+func run(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("'%s %s' failed, error: %s, output: %s",
+			name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package qemuguesttools
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	goruntime "runtime"
+	"sync"
+
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/metaservice"
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/serialmux"
+)
+
+// serialPortPath returns the path of the virtio-serial port for the meta-data
+// service inside the guest.
+func serialPortPath() string {
+	if goruntime.GOOS == "windows" {
+		return `\\.\Global\` + metaservice.SerialPortName
+	}
+	return "/dev/virtio-ports/" + metaservice.SerialPortName
+}
+
+// openSerialPort opens the virtio-serial port for the meta-data service.
+func openSerialPort() (io.ReadWriteCloser, error) {
+	return os.OpenFile(serialPortPath(), os.O_RDWR, 0)
+}
+
+// serialDialer dials streams multiplexed over the virtio-serial port, the port
+// is re-opened if the session fails.
+type serialDialer struct {
+	m       sync.Mutex
+	open    func() (io.ReadWriteCloser, error)
+	session *serialmux.Session
+}
+
+// Dial opens a new stream to the meta-data service, network and address are
+// ignored as the port is only connected to the meta-data service.
+func (d *serialDialer) Dial(network, address string) (net.Conn, error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	// Discard the session, if it has failed
+	if d.session != nil {
+		select {
+		case <-d.session.Done():
+			d.session = nil
+		default:
+		}
+	}
+	if d.session == nil {
+		port, err := d.open()
+		if err != nil {
+			return nil, err
+		}
+		d.session = serialmux.Client(port)
+	}
+	return d.session.Open()
+}
+
+// useSerialPort configures guest-tools to talk to the meta-data service over
+// the virtio-serial port returned by open, instead of the network.
+func (g *guestTools) useSerialPort(open func() (io.ReadWriteCloser, error)) {
+	d := &serialDialer{open: open}
+	transport := &http.Transport{Dial: d.Dial}
+
+	g.got.Client.Transport = transport
+	g.gotpoll.Client.Transport = transport
+	g.client = &http.Client{Transport: transport}
+	dialer := *g.dialer
+	dialer.NetDial = d.Dial
+	g.dialer = &dialer
+}
@@ -0,0 +1,101 @@
+// +build linux windows
+
+package qemuguesttools
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	rt "runtime"
+	"strings"
+	"testing"
+
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/metaservice"
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/serialmux"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/atomics"
+	"github.com/taskcluster/taskcluster-worker/runtime/mocks"
+)
+
+func TestGuestToolsSerialPort(t *testing.T) {
+	// Create temporary storage
+	storage, err := runtime.NewTemporaryStorage(os.TempDir())
+	if err != nil {
+		panic("Failed to create TemporaryStorage")
+	}
+	environment := &runtime.Environment{
+		TemporaryStorage: storage,
+		Monitor:          mocks.NewMockMonitor(true),
+	}
+
+	// platform specific hello world command
+	helloWorldCommand := []string{"sh", "-c", "echo \"$TEST_TEXT\" && true"}
+	if rt.GOOS == "windows" {
+		helloWorldCommand = []string{`c:\Windows\system32\cmd.exe`, "/C", "echo %TEST_TEXT% && exit 0"}
+	}
+
+	// Setup a new MetaService
+	logTask := bytes.NewBuffer(nil)
+	result := false
+	var resolved atomics.Once
+	s := metaservice.New(helloWorldCommand, map[string]string{
+		"TEST_TEXT": "Hello world",
+	}, logTask, func(r bool) {
+		if !resolved.Do(func() { result = r }) {
+			panic("It shouldn't be possible to resolve twice")
+		}
+	}, environment)
+	defer s.StopPollers() // Hack to stop pollers, otherwise server will block
+
+	// Serve the MetaService over a pipe, instead of a virtio-serial port
+	guest, host := net.Pipe()
+	session := serialmux.Server(host)
+	defer session.Close()
+	go http.Serve(session, s)
+
+	// Create guest-tools using the pipe
+	g := new(config{}, "metaservice", mocks.NewMockMonitor(true))
+	g.useSerialPort(func() (io.ReadWriteCloser, error) { return guest, nil })
+	go g.ProcessActions()
+	defer g.StopProcessingActions()
+	g.Run()
+
+	// Check the state
+	resolved.Wait()
+	assert(t, result, "Expected the metadata to get successful result")
+	assert(t, strings.Contains(logTask.String(), "Hello world"),
+		"Got unexpected taskLog: '", logTask.String(), "'")
+
+	debug("### Test meta.GetArtifact over serial port")
+	f, err := storage.NewFolder()
+	nilOrFatal(t, err, "Failed to create temp folder")
+	defer f.Remove()
+	testFile := filepath.Join(f.Path(), "hello.txt")
+	err = ioutil.WriteFile(testFile, []byte("hello-world"), 0777)
+	nilOrFatal(t, err, "Failed to create testFile: ", testFile)
+
+	r, err := s.GetArtifact(testFile)
+	nilOrFatal(t, err, "meta.GetArtifact failed, error: ", err)
+	data, err := ioutil.ReadAll(r)
+	nilOrFatal(t, err, "Failed to read testFile")
+	assert(t, string(data) == "hello-world", "Wrong payload: ", string(data))
+
+	debug("### Test meta.ExecShell over serial port")
+	shell, err := s.ExecShell(nil, false)
+	nilOrFatal(t, err, "Failed to call meta.ExecShell()")
+	go io.Copy(ioutil.Discard, shell.StderrPipe())
+	go func() {
+		shell.StdinPipe().Write([]byte("echo HELLO\n"))
+		shell.StdinPipe().Close()
+	}()
+	output, err := ioutil.ReadAll(shell.StdoutPipe())
+	nilOrFatal(t, err, "Got error from stdout pipe")
+	assert(t, strings.Contains(string(output), "HELLO"), "Expected HELLO in output: ", string(output))
+	success, err := shell.Wait()
+	nilOrFatal(t, err, "Failed to run shell")
+	assert(t, success, "Expected shell to exit successfully")
+}
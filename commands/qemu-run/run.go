@@ -122,7 +122,7 @@ func (cmd) Execute(arguments map[string]interface{}) bool {
 	monitor.Info("Creating virtual machine")
 	vm, err := vm.NewVirtualMachine(
 		image.Machine().DeriveLimits(), image, net, tempFolder,
-		"", "", vm.LinuxBootOptions{},
+		"", "", nil, vm.LinuxBootOptions{},
 		monitor.WithTag("component", "vm"),
 	)
 	if err != nil {
@@ -3,12 +3,14 @@ package shell
 import (
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
 
 	"github.com/gorilla/websocket"
 	isatty "github.com/mattn/go-isatty"
 	"github.com/taskcluster/taskcluster-worker/commands"
+	"github.com/taskcluster/taskcluster-worker/plugins/interactive"
 	"github.com/taskcluster/taskcluster-worker/plugins/interactive/shellclient"
 	"github.com/taskcluster/taskcluster-worker/plugins/interactive/shellconsts"
 	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
@@ -68,8 +70,19 @@ func (cmd) Execute(arguments map[string]interface{}) bool {
 	// Update query string
 	u.RawQuery = qs.Encode()
 
+	// Sign request with credentials from environment, if any are present
+	authorization, err := interactive.AuthorizationHeader(http.MethodGet, u)
+	if err != nil {
+		fmt.Println("Failed to sign request, error: ", err)
+		return false
+	}
+	header := http.Header{}
+	if authorization != "" {
+		header.Set("Authorization", authorization)
+	}
+
 	// Connect to remove websocket
-	ws, res, err := dialer.Dial(u.String(), nil)
+	ws, res, err := dialer.Dial(u.String(), header)
 	if err == websocket.ErrBadHandshake {
 		fmt.Println("Failed to connect, status: ", res.StatusCode)
 		return false
@@ -6,6 +6,7 @@ import (
 
 	schematypes "github.com/taskcluster/go-schematypes"
 	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/util"
 	"github.com/taskcluster/taskcluster-worker/worker"
 
 	yaml "gopkg.in/yaml.v2"
@@ -46,7 +47,7 @@ func Load(data []byte, monitor runtime.Monitor) (map[string]interface{}, error)
 	// This fixes obscurities in yaml.Unmarshal where it generates
 	// map[interface{}]interface{} instead of map[string]interface{}
 	// credits: https://github.com/go-yaml/yaml/issues/139#issuecomment-220072190
-	config = convertSimpleJSONTypes(config)
+	config = util.ConvertSimpleJSONTypes(config)
 
 	// Extract transforms and config
 	c, ok := config.(map[string]interface{})
@@ -115,28 +116,3 @@ func LoadFromFile(filename string, monitor runtime.Monitor) (interface{}, error)
 
 	return Load(configFile, monitor)
 }
-
-func convertSimpleJSONTypes(val interface{}) interface{} {
-	switch val := val.(type) {
-	case []interface{}:
-		r := make([]interface{}, len(val))
-		for i, v := range val {
-			r[i] = convertSimpleJSONTypes(v)
-		}
-		return r
-	case map[interface{}]interface{}:
-		r := make(map[string]interface{})
-		for k, v := range val {
-			s, ok := k.(string)
-			if !ok {
-				s = fmt.Sprintf("%v", k)
-			}
-			r[s] = convertSimpleJSONTypes(v)
-		}
-		return r
-	case int:
-		return float64(val)
-	default:
-		return val
-	}
-}
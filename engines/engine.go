@@ -123,6 +123,12 @@ type Engine interface {
 type Capabilities struct {
 	// Maximum number of parallel sandboxes, leave 0 if unbounded.
 	MaxConcurrency int
+	// True, if stderr from the task is written to TaskContext.StderrLogDrain()
+	// rather than TaskContext.LogDrain().
+	SeparateStderr bool
+	// True, if ResultSet.NewShell() can create shells after the task command
+	// has exited.
+	ShellsAfterExit bool
 	// Note: the zero value of Capabilities should always indicate the sane
 	// defaults, typically that a feature isn't supported.
 }
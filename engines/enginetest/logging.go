@@ -19,6 +19,9 @@ type LoggingTestCase struct {
 	FailingPayload string
 	// A task.payload which won't write Target to the log, but will by successful.
 	SilentPayload string
+	// A task.payload which will write Target to stderr and exit successfully.
+	// Only tested if the engine declares SeparateStderr in its capabilities.
+	StderrPayload string
 }
 
 func (c *LoggingTestCase) grepLogFromPayload(payload string, needle string, success, match bool) bool {
@@ -67,9 +70,37 @@ func (c *LoggingTestCase) TestSilentTask() {
 	}
 }
 
+// TestStderrTarget checks that Target is written to stderr, but not stdout, by
+// StderrPayload, if the engine declares SeparateStderr in its capabilities.
+func (c *LoggingTestCase) TestStderrTarget() {
+	debug("## TestStderrTarget")
+	r := c.newRun()
+	defer r.Dispose()
+	if !r.provider.engine.Capabilities().SeparateStderr {
+		debug("Skipping TestStderrTarget, engine doesn't separate stderr")
+		return
+	}
+	r.NewSandboxBuilder(c.StderrPayload)
+	if !r.buildRunSandbox() {
+		log.Panic("Task with payload: ", c.StderrPayload, " wasn't successful")
+	}
+	if !strings.Contains(r.ReadLog(), c.Target) {
+		log.Panic("Couldn't find target: ", c.Target, " in logs from StderrPayload")
+	}
+	if !strings.Contains(r.ReadStderrLog(), c.Target) {
+		log.Panic("Couldn't find target: ", c.Target, " in stderr from StderrPayload")
+	}
+	if strings.Contains(r.ReadStdoutLog(), c.Target) {
+		log.Panic("Found target: ", c.Target, " in stdout from StderrPayload")
+	}
+}
+
 // Test will run all logging tests
 func (c *LoggingTestCase) Test() {
 	c.TestLogTarget()
 	c.TestLogTargetWhenFailing()
 	c.TestSilentTask()
+	if c.StderrPayload != "" {
+		c.TestStderrTarget()
+	}
 }
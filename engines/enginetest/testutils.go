@@ -119,6 +119,8 @@ func (p *EngineProvider) releaseEngine() {
 func (p *EngineProvider) newTestTaskContext() (*runtime.TaskContext, *runtime.TaskContextController) {
 	ctx, control, err := runtime.NewTaskContext(p.environment.TemporaryStorage.NewFilePath(), runtime.TaskInfo{})
 	nilOrPanic(err, "Failed to create new TaskContext")
+	// Capture stdout and stderr, so tests can check what was written to each
+	nilOrPanic(ctx.CaptureLogStreams(), "Failed to capture log streams")
 	return ctx, control
 }
 
@@ -230,6 +232,24 @@ func (r *run) OpenLogReader() {
 	r.logReader = logReader
 }
 
+func (r *run) ReadStdoutLog() string {
+	reader, err := r.context.ExtractStdoutLog()
+	nilOrPanic(err, "Failed to open stdout log")
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	nilOrPanic(err, "Failed to read stdout log")
+	return string(data)
+}
+
+func (r *run) ReadStderrLog() string {
+	reader, err := r.context.ExtractStderrLog()
+	nilOrPanic(err, "Failed to open stderr log")
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	nilOrPanic(err, "Failed to read stderr log")
+	return string(data)
+}
+
 func (r *run) ReadLog() string {
 	reader, err := r.context.NewLogReader()
 	nilOrPanic(err, "Failed to open log reader")
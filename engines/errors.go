@@ -49,6 +49,10 @@ var ErrShellAborted = errors.New("The shell was aborted")
 // ErrNoSuchDisplay is used to indicate that a requested display doesn't exist.
 var ErrNoSuchDisplay = errors.New("No such display exists")
 
+// ErrNoSuchPort is used to indicate that nothing is listening on a requested
+// port inside the sandbox.
+var ErrNoSuchPort = errors.New("No service is listening on the given port")
+
 // ErrNamingConflict is used to indicate that a name is already in use.
 var ErrNamingConflict = errors.New("Conflicting name is already in use")
 
@@ -48,6 +48,13 @@ func (e engineProvider) ConfigSchema() schematypes.Schema {
 	return schematypes.Object{}
 }
 
+func (e engine) Capabilities() engines.Capabilities {
+	return engines.Capabilities{
+		SeparateStderr:  true,
+		ShellsAfterExit: true,
+	}
+}
+
 func (e engine) PayloadSchema() schematypes.Object {
 	return payloadSchema
 }
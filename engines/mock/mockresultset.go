@@ -0,0 +1,61 @@
+package mockengine
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/taskcluster/taskcluster-worker/engines"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+)
+
+// resultSet wraps the sandbox, as the sandbox implements the ResultSet
+// interface except for NewShell, because Sandbox.NewShell must return
+// ErrSandboxTerminated once the task has finished.
+type resultSet struct {
+	*sandbox
+	m        sync.Mutex
+	disposed bool
+	shells   []engines.Shell
+}
+
+func (r *resultSet) NewShell(command []string, tty bool) (engines.Shell, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if len(command) > 0 || tty {
+		return nil, engines.ErrFeatureNotSupported
+	}
+	if r.disposed {
+		return nil, engines.ErrSandboxTerminated
+	}
+	shell := newShell()
+	r.shells = append(r.shells, shell)
+	return shell, nil
+}
+
+func (r *resultSet) Diagnostics() (map[string]ioext.ReadSeekCloser, error) {
+	if r.Success() {
+		return nil, nil
+	}
+	return map[string]ioext.ReadSeekCloser{
+		"mock/diagnostics.txt": ioext.NopCloser(strings.NewReader("mock diagnostics\n")),
+	}, nil
+}
+
+func (r *resultSet) Usage() (map[string]float64, error) {
+	return map[string]float64{
+		"cpuTime": float64(r.payload.Delay) / 1000,
+	}, nil
+}
+
+func (r *resultSet) Dispose() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.disposed = true
+	for _, shell := range r.shells {
+		shell.Abort()
+	}
+	r.shells = nil
+	return nil
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -41,10 +43,12 @@ type sandbox struct {
 	sessions    atomics.WaitGroup
 	shells      []engines.Shell
 	displays    []io.ReadWriteCloser
+	ports       []io.ReadWriteCloser
 	resolve     atomics.Once
 	result      bool
 	resultErr   error
 	abortErr    error
+	resultSet   *resultSet
 }
 
 ///////////////////////////// Implementation of SandboxBuilder interface
@@ -60,6 +64,9 @@ func (s *sandbox) abortSessions() {
 	for _, display := range s.displays {
 		display.Close()
 	}
+	for _, port := range s.ports {
+		port.Close()
+	}
 }
 
 func (s *sandbox) StartSandbox() (engines.Sandbox, error) {
@@ -203,12 +210,38 @@ var functions = map[string]func(*sandbox, string) (bool, error){
 		time.Sleep(500 * time.Millisecond)
 		return true, nil
 	},
+	"write-stdout": func(s *sandbox, arg string) (bool, error) {
+		fmt.Fprintln(s.context.LogDrain(), arg)
+		return true, nil
+	},
+	"write-stdout-sleep": func(s *sandbox, arg string) (bool, error) {
+		fmt.Fprintln(s.context.LogDrain(), arg)
+		time.Sleep(500 * time.Millisecond)
+		return true, nil
+	},
+	"write-stderr": func(s *sandbox, arg string) (bool, error) {
+		fmt.Fprintln(s.context.StderrLogDrain(), arg)
+		return true, nil
+	},
 	"write-files": func(s *sandbox, arg string) (bool, error) {
+		s.Lock()
+		defer s.Unlock()
 		for _, path := range strings.Split(arg, " ") {
 			s.files[path] = []byte("Hello World")
 		}
 		return true, nil
 	},
+	"write-file": func(s *sandbox, arg string) (bool, error) {
+		// Argument on the form '<path>:<content>'
+		parts := strings.SplitN(arg, ":", 2)
+		if len(parts) != 2 {
+			return false, nil
+		}
+		s.Lock()
+		defer s.Unlock()
+		s.files[parts[0]] = []byte(parts[1])
+		return true, nil
+	},
 	"print-env-var": func(s *sandbox, arg string) (bool, error) {
 		val, ok := s.env[arg]
 		s.context.Log(val)
@@ -240,7 +273,12 @@ func (s *sandbox) WaitForResult() (engines.ResultSet, error) {
 	if s.resultErr != nil {
 		return nil, s.resultErr
 	}
-	return s, nil
+	s.Lock()
+	defer s.Unlock()
+	if s.resultSet == nil {
+		s.resultSet = &resultSet{sandbox: s}
+	}
+	return s.resultSet, nil
 }
 
 func (s *sandbox) Kill() error {
@@ -310,10 +348,58 @@ func (s *sandbox) OpenDisplay(name string) (io.ReadWriteCloser, error) {
 	return d, nil
 }
 
+// mockEchoPort is the only port inside the mock sandbox that can be dialed,
+// it runs an echo service, similar to the echo protocol from RFC 862.
+const mockEchoPort = 7
+
+func (s *sandbox) DialPort(port int) (io.ReadWriteCloser, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if port != mockEchoPort {
+		return nil, engines.ErrNoSuchPort
+	}
+	if s.sessions.Add(1) != nil {
+		return nil, engines.ErrSandboxTerminated
+	}
+
+	// Create a pipe and echo everything written to the service side
+	conn, service := net.Pipe()
+	go func() {
+		io.Copy(service, service)
+		service.Close()
+	}()
+	c := ioext.WatchPipe(conn, func(error) {
+		s.sessions.Done()
+	})
+	s.ports = append(s.ports, c)
+	return c, nil
+}
+
+func (s *sandbox) WriteFile(path string, stream io.Reader) error {
+	data, err := ioutil.ReadAll(stream)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.resolve.IsDone() {
+		return engines.ErrSandboxTerminated
+	}
+	s.files[path] = data
+	return nil
+}
+
 ///////////////////////////// Implementation of ResultSet interface
 
+// ExtractFile implements both ResultSet.ExtractFile and Sandbox.ExtractFile,
+// as the mock engine has no need to distinguish.
 func (s *sandbox) ExtractFile(path string) (ioext.ReadSeekCloser, error) {
+	s.Lock()
 	data := s.files[path]
+	s.Unlock()
 	if len(data) == 0 {
 		return nil, engines.ErrResourceNotFound
 	}
@@ -328,6 +414,7 @@ func (s *sandbox) ExtractFolder(folder string, handler engines.FileHandler) erro
 	m := sync.Mutex{}
 	handlerError := false
 	foundFolder := false
+	s.Lock()
 	for p, data := range s.files {
 		if strings.HasPrefix(p, folder) {
 			foundFolder = true
@@ -344,6 +431,7 @@ func (s *sandbox) ExtractFolder(folder string, handler engines.FileHandler) erro
 			}(p, data)
 		}
 	}
+	s.Unlock()
 	wg.Wait()
 	if !foundFolder {
 		return engines.ErrResourceNotFound
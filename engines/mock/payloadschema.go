@@ -35,7 +35,11 @@ var payloadSchema = schematypes.Object{
 				"write-log",
 				"write-error-log",
 				"write-log-sleep",
+				"write-stdout",
+				"write-stdout-sleep",
+				"write-stderr",
 				"write-files",
+				"write-file",
 				"print-env-var",
 				"malformed-payload-initial",
 				"malformed-payload-after-start",
@@ -54,6 +54,13 @@ func (engineProvider) NewEngine(options engines.EngineOptions) (engines.Engine,
 	}, nil
 }
 
+func (e *engine) Capabilities() engines.Capabilities {
+	return engines.Capabilities{
+		SeparateStderr:  true,
+		ShellsAfterExit: true,
+	}
+}
+
 func (e *engine) PayloadSchema() schematypes.Object {
 	return payloadSchema
 }
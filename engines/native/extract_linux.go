@@ -0,0 +1,131 @@
+package nativeengine
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/taskcluster/taskcluster-worker/engines"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+)
+
+// openFlags are used to open each path component when extracting files, see
+// openInHome(). O_NONBLOCK ensures that opening a named pipe doesn't block.
+const openFlags = unix.O_RDONLY | unix.O_NOFOLLOW | unix.O_NONBLOCK | unix.O_CLOEXEC
+
+// openInHome opens the file or folder at path relative to the home folder.
+//
+// The task can modify the home folder while this is running, so each path
+// component is opened relative to its parent with O_NOFOLLOW, instead of
+// checking the path and then opening it. Hence, symlinks are never followed,
+// and the result is always inside the home folder.
+func openInHome(home, p string) (*os.File, error) {
+	home = filepath.Clean(home)
+	p = filepath.Join(home, p)
+	if p != home && !strings.HasPrefix(p, home+string(filepath.Separator)) {
+		return nil, engines.ErrResourceNotFound
+	}
+
+	// Open the home folder, this is created by the worker, so we follow symlinks
+	f, err := os.Open(home)
+	if err != nil {
+		return nil, engines.ErrResourceNotFound
+	}
+	if p == home {
+		return f, nil
+	}
+	for _, name := range strings.Split(p[len(home)+1:], string(filepath.Separator)) {
+		fd, err := unix.Openat(int(f.Fd()), name, openFlags, 0)
+		f.Close()
+		if err != nil {
+			return nil, engines.ErrResourceNotFound
+		}
+		f = os.NewFile(uintptr(fd), filepath.Join(f.Name(), name))
+	}
+	return f, nil
+}
+
+// fileType returns the file type bits from the mode of f
+func fileType(f *os.File) (uint32, error) {
+	var st unix.Stat_t
+	if err := unix.Fstat(int(f.Fd()), &st); err != nil {
+		return 0, err
+	}
+	return st.Mode & unix.S_IFMT, nil
+}
+
+// extractFile opens a plain file at path relative to the home folder, symlinks
+// are not followed, see openInHome().
+func extractFile(home, path string) (ioext.ReadSeekCloser, error) {
+	f, err := openInHome(home, path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Don't allow anything that isn't a plain file
+	if t, err := fileType(f); err != nil || t != unix.S_IFREG {
+		f.Close()
+		return nil, engines.ErrResourceNotFound
+	}
+	if err = unix.SetNonblock(int(f.Fd()), false); err != nil {
+		f.Close()
+		return nil, runtime.ErrNonFatalInternalError
+	}
+	return f, nil
+}
+
+// extractFolder calls handler for each plain file in the folder at path
+// relative to the home folder, symlinks are not followed, see openInHome().
+func extractFolder(home, p string, handler engines.FileHandler, monitor runtime.Monitor) error {
+	dir, err := openInHome(home, p)
+	if err != nil {
+		return err
+	}
+	if t, terr := fileType(dir); terr != nil || t != unix.S_IFDIR {
+		dir.Close()
+		return engines.ErrResourceNotFound
+	}
+	return walkFolder(dir, "", handler)
+}
+
+// walkFolder calls handler for each plain file in dir and sub-folders, with
+// paths relative to dir prefixed with prefix, dir is closed when done.
+func walkFolder(dir *os.File, prefix string, handler engines.FileHandler) error {
+	defer dir.Close()
+
+	// Ignore folders we can't read (probably a permission issue)
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fd, err := unix.Openat(int(dir.Fd()), name, openFlags, 0)
+		if err != nil {
+			continue // Skip symlinks and files deleted while we're walking
+		}
+		f := os.NewFile(uintptr(fd), filepath.Join(dir.Name(), name))
+		t, err := fileType(f)
+		switch {
+		case err == nil && t == unix.S_IFDIR:
+			if err = walkFolder(f, path.Join(prefix, name), handler); err != nil {
+				return err
+			}
+		case err == nil && t == unix.S_IFREG && unix.SetNonblock(fd, false) == nil:
+			// If handler returns an error we return ErrHandlerInterrupt
+			if handler(path.Join(prefix, name), f) != nil {
+				return engines.ErrHandlerInterrupt
+			}
+		default:
+			// Skip anything that isn't a plain file
+			f.Close()
+		}
+	}
+	return nil
+}
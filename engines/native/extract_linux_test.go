@@ -0,0 +1,62 @@
+// +build linux
+
+package nativeengine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/taskcluster/taskcluster-worker/engines"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+)
+
+func TestExtractNoFollow(t *testing.T) {
+	home, err := ioutil.TempDir("", "native-home-")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	outside, err := ioutil.TempDir("", "native-outside-")
+	require.NoError(t, err)
+	defer os.RemoveAll(outside)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(home, "a", "b"), 0777))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(home, "a", "b", "c.txt"), []byte("hello"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(home, "a", "d.txt"), []byte("world"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644))
+
+	// Symlinks pointing outside home and named pipes must not be followed
+	require.NoError(t, os.Symlink(outside, filepath.Join(home, "link")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(home, "a", "secret.txt")))
+	require.NoError(t, syscall.Mkfifo(filepath.Join(home, "a", "fifo"), 0666))
+
+	f, err := extractFile(home, "a/b/c.txt")
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	for _, p := range []string{"link/secret.txt", "a/secret.txt", "a/fifo", "a", "../secret.txt", "missing.txt"} {
+		_, err = extractFile(home, p)
+		require.Equal(t, engines.ErrResourceNotFound, err, "path: %s", p)
+	}
+
+	var files []string
+	err = extractFolder(home, "", func(p string, f ioext.ReadSeekCloser) error {
+		files = append(files, p)
+		return f.Close()
+	}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/b/c.txt", "a/d.txt"}, files, "symlinks must be skipped")
+
+	for _, p := range []string{"link", "a/d.txt", "../" + filepath.Base(outside)} {
+		err = extractFolder(home, p, func(string, ioext.ReadSeekCloser) error {
+			return nil
+		}, nil)
+		require.Equal(t, engines.ErrResourceNotFound, err, "path: %s", p)
+	}
+}
@@ -0,0 +1,141 @@
+// +build !linux
+
+package nativeengine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/taskcluster/taskcluster-worker/engines"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+)
+
+// extractFile opens a plain file at path relative to the home folder, symlinks
+// are evaluated and must point to a file inside the home folder.
+//
+// The path is checked before the file is opened, so a task replacing a folder
+// with a symlink while this is running may redirect the read. On linux this
+// is prevented by resolving the path with openat(), see extract_linux.go.
+func extractFile(home, path string) (ioext.ReadSeekCloser, error) {
+	// Evaluate symlinks
+	p, err := filepath.EvalSymlinks(filepath.Join(home, path))
+	if err != nil {
+		if _, ok := err.(*os.PathError); ok {
+			return nil, engines.ErrResourceNotFound
+		}
+		return nil, runtime.NewMalformedPayloadError(
+			"Unable to evaluate path: ", path,
+		)
+	}
+
+	// Cleanup the path
+	p = filepath.Clean(p)
+
+	prefix, err := filepath.EvalSymlinks(home + string(filepath.Separator))
+	if err != nil {
+		panic(err)
+	}
+
+	// Check that p is inside workingFolder
+	if !strings.HasPrefix(p, prefix) {
+		return nil, engines.ErrResourceNotFound
+	}
+
+	// Stat the file to make sure it's a file
+	info, err := os.Lstat(p)
+	if err != nil {
+		return nil, engines.ErrResourceNotFound
+	}
+	// Don't allow anything that isn't a plain file
+	if !ioext.IsPlainFileInfo(info) {
+		return nil, engines.ErrResourceNotFound
+	}
+
+	// Open file
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, engines.ErrResourceNotFound
+	}
+
+	return f, nil
+}
+
+// extractFolder calls handler for each plain file in the folder at path
+// relative to the home folder, symlinks are evaluated and the folder must be
+// inside the home folder.
+func extractFolder(home, path string, handler engines.FileHandler, monitor runtime.Monitor) error {
+	// Evaluate symlinks
+	p, err := filepath.EvalSymlinks(filepath.Join(home, path))
+	if err != nil {
+		if _, ok := err.(*os.PathError); ok {
+			return engines.ErrResourceNotFound
+		}
+		return runtime.NewMalformedPayloadError(
+			"Unable to evaluate path: ", path,
+		)
+	}
+
+	// Cleanup the path
+	p = filepath.Clean(p)
+
+	prefix, err := filepath.EvalSymlinks(home + string(filepath.Separator))
+	if err != nil {
+		panic(err)
+	}
+
+	// Check that p is inside workingFolder
+	if !strings.HasPrefix(p, prefix) {
+		return engines.ErrResourceNotFound
+	}
+
+	first := true
+	return filepath.Walk(p, func(abspath string, info os.FileInfo, err error) error {
+		// If there is a path error, on the first call then the folder is missing
+		if _, ok := err.(*os.PathError); ok && first {
+			return engines.ErrResourceNotFound
+		}
+		// If first path is what we're walking and it's not a directory, then we
+		// didn't find folder at the given path.
+		if first && p == abspath && !info.IsDir() {
+			return engines.ErrResourceNotFound
+		}
+		first = false
+
+		// Ignore folder we can't walk (probably a permission issues)
+		if err != nil {
+			return nil
+		}
+
+		// Skip anything that isn't a plain file
+		if !ioext.IsPlainFileInfo(info) {
+			return nil
+		}
+
+		// If we can't construct relative file path this internal error, we'll skip
+		relpath, err := filepath.Rel(p, abspath)
+		if err != nil {
+			// TODO: Send error to sentry
+			monitor.ReportError(err, fmt.Sprintf(
+				"ExtractFolder from %s, filepath.Rel('%s', '%s') returns error: %s",
+				path, p, abspath, err,
+			))
+			return nil
+		}
+
+		f, err := os.Open(abspath)
+		if err != nil {
+			// file must have been deleted as we tried to open it
+			// that makes no sense, but who knows...
+			return nil
+		}
+
+		// If handler returns an error we return ErrHandlerInterrupt
+		if handler(filepath.ToSlash(relpath), f) != nil {
+			return engines.ErrHandlerInterrupt
+		}
+		return nil
+	})
+}
@@ -32,6 +32,9 @@ func TestLogging(t *testing.T) {
 		SilentPayload: `{
 			"command": ["sh", "-c", "echo 'no hello' && true"]
 		}`,
+		StderrPayload: `{
+			"command": ["sh", "-c", "echo 'hello-world' >&2 && true"]
+		}`,
 	}
 
 	c.TestLogTarget()
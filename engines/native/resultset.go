@@ -1,10 +1,7 @@
 package nativeengine
 
 import (
-	"fmt"
-	"os"
-	"path/filepath"
-	"strings"
+	"sync"
 
 	"github.com/taskcluster/taskcluster-worker/engines"
 	"github.com/taskcluster/taskcluster-worker/engines/native/system"
@@ -19,7 +16,11 @@ type resultSet struct {
 	monitor       runtime.Monitor
 	workingFolder runtime.TemporaryFolder
 	user          *system.User
+	env           map[string]string
 	success       bool
+	mShells       sync.Mutex // Guards shells and disposed
+	shells        []*shell
+	disposed      bool
 }
 
 func (r *resultSet) Success() bool {
@@ -27,126 +28,52 @@ func (r *resultSet) Success() bool {
 }
 
 func (r *resultSet) ExtractFile(path string) (ioext.ReadSeekCloser, error) {
-	// Evaluate symlinks
-	p, err := filepath.EvalSymlinks(filepath.Join(r.user.Home(), path))
-	if err != nil {
-		if _, ok := err.(*os.PathError); ok {
-			return nil, engines.ErrResourceNotFound
-		}
-		return nil, runtime.NewMalformedPayloadError(
-			"Unable to evaluate path: ", path,
-		)
-	}
-
-	// Cleanup the path
-	p = filepath.Clean(p)
-
-	prefix, err := filepath.EvalSymlinks(r.user.Home() + string(filepath.Separator))
-	if err != nil {
-		panic(err)
-	}
+	return extractFile(r.user.Home(), path)
+}
 
-	// Check that p is inside workingFolder
-	if !strings.HasPrefix(p, prefix) {
-		return nil, engines.ErrResourceNotFound
-	}
+func (r *resultSet) ExtractFolder(path string, handler engines.FileHandler) error {
+	return extractFolder(r.user.Home(), path, handler, r.monitor)
+}
 
-	// Stat the file to make sure it's a file
-	info, err := os.Lstat(p)
-	if err != nil {
-		return nil, engines.ErrResourceNotFound
-	}
-	// Don't allow anything that isn't a plain file
-	if !ioext.IsPlainFileInfo(info) {
-		return nil, engines.ErrResourceNotFound
-	}
+func (r *resultSet) NewShell(command []string, tty bool) (engines.Shell, error) {
+	r.mShells.Lock()
+	defer r.mShells.Unlock()
 
-	// Open file
-	f, err := os.Open(p)
-	if err != nil {
-		return nil, engines.ErrResourceNotFound
+	if r.disposed {
+		return nil, engines.ErrSandboxTerminated
 	}
 
-	return f, nil
-}
-
-func (r *resultSet) ExtractFolder(path string, handler engines.FileHandler) error {
-	// Evaluate symlinks
-	p, err := filepath.EvalSymlinks(filepath.Join(r.user.Home(), path))
+	debug("NewShell after task exit with: %v", command)
+	S, err := newShell(r.user, r.env, command, tty)
 	if err != nil {
-		if _, ok := err.(*os.PathError); ok {
-			return engines.ErrResourceNotFound
-		}
-		return runtime.NewMalformedPayloadError(
-			"Unable to evaluate path: ", path,
+		debug("Failed to start shell, error: %s", err)
+		return nil, runtime.NewMalformedPayloadError(
+			"Unable to spawn command: ", command, " error: ", err,
 		)
 	}
+	r.shells = append(r.shells, S)
 
-	// Cleanup the path
-	p = filepath.Clean(p)
+	return S, nil
+}
 
-	prefix, err := filepath.EvalSymlinks(r.user.Home() + string(filepath.Separator))
-	if err != nil {
-		panic(err)
-	}
+// abortShells prevents new shells and aborts all shells
+func (r *resultSet) abortShells() {
+	r.mShells.Lock()
+	defer r.mShells.Unlock()
 
-	// Check that p is inside workingFolder
-	if !strings.HasPrefix(p, prefix) {
-		return engines.ErrResourceNotFound
+	r.disposed = true
+	for _, S := range r.shells {
+		S.Abort()
 	}
-
-	first := true
-	return filepath.Walk(p, func(abspath string, info os.FileInfo, err error) error {
-		// If there is a path error, on the first call then the folder is missing
-		if _, ok := err.(*os.PathError); ok && first {
-			return engines.ErrResourceNotFound
-		}
-		// If first path is what we're walking and it's not a directory, then we
-		// didn't find folder at the given path.
-		if first && p == abspath && !info.IsDir() {
-			return engines.ErrResourceNotFound
-		}
-		first = false
-
-		// Ignore folder we can't walk (probably a permission issues)
-		if err != nil {
-			return nil
-		}
-
-		// Skip anything that isn't a plain file
-		if !ioext.IsPlainFileInfo(info) {
-			return nil
-		}
-
-		// If we can't construct relative file path this internal error, we'll skip
-		relpath, err := filepath.Rel(p, abspath)
-		if err != nil {
-			// TODO: Send error to sentry
-			r.monitor.ReportError(err, fmt.Sprintf(
-				"ExtractFolder from %s, filepath.Rel('%s', '%s') returns error: %s",
-				path, p, abspath, err,
-			))
-			return nil
-		}
-
-		f, err := os.Open(abspath)
-		if err != nil {
-			// file must have been deleted as we tried to open it
-			// that makes no sense, but who knows...
-			return nil
-		}
-
-		// If handler returns an error we return ErrHandlerInterrupt
-		if handler(filepath.ToSlash(relpath), f) != nil {
-			return engines.ErrHandlerInterrupt
-		}
-		return nil
-	})
+	r.shells = nil
 }
 
 func (r *resultSet) Dispose() error {
 	var err error
 
+	// Abort shells created after task exit
+	r.abortShells()
+
 	if r.engine.config.CreateUser {
 		// Halt all other sub-processes owned by this user
 		err = system.KillByOwner(r.user)
@@ -2,9 +2,12 @@ package nativeengine
 
 import (
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/taskcluster/taskcluster-worker/engines"
 	"github.com/taskcluster/taskcluster-worker/engines/native/system"
@@ -30,6 +33,7 @@ type sandbox struct {
 	sessions      atomics.WaitGroup
 	mShells       sync.Mutex
 	shells        []*shell
+	ports         []io.ReadWriteCloser
 }
 
 func newSandbox(b *sandboxBuilder) (engines.Sandbox, error) {
@@ -96,7 +100,7 @@ func newSandbox(b *sandboxBuilder) (engines.Sandbox, error) {
 		WorkingFolder: user.Home(),
 		Owner:         user,
 		Stdout:        ioext.WriteNopCloser(b.context.LogDrain()),
-		// Stderr defaults to Stdout when not specified
+		Stderr:        ioext.WriteNopCloser(b.context.StderrLogDrain()),
 	})
 	if err != nil {
 		// StartProcess provides human-readable error messages (see docs)
@@ -171,7 +175,7 @@ func (s *sandbox) NewShell(command []string, tty bool) (engines.Shell, error) {
 	}
 
 	debug("NewShell with: %v", command)
-	S, err := newShell(s, command, tty)
+	S, err := newShell(s.user, s.env, command, tty)
 	if err != nil {
 		debug("Failed to start shell, error: %s", err)
 		s.sessions.Done()
@@ -207,6 +211,72 @@ func (s *sandbox) NewShell(command []string, tty bool) (engines.Shell, error) {
 	return S, nil
 }
 
+func (s *sandbox) ExtractFile(path string) (ioext.ReadSeekCloser, error) {
+	if s.resolve.IsDone() {
+		return nil, engines.ErrSandboxTerminated
+	}
+	return extractFile(s.user.Home(), path)
+}
+
+func (s *sandbox) DialPort(port int) (io.ReadWriteCloser, error) {
+	s.mShells.Lock()
+	defer s.mShells.Unlock()
+
+	// Increment session counter, if draining we don't allow new connections
+	if s.sessions.Add(1) != nil {
+		return nil, engines.ErrSandboxTerminated
+	}
+
+	debug("DialPort to port: %d", port)
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), 15*time.Second)
+	if err != nil {
+		debug("Failed to dial port: %d, error: %s", port, err)
+		s.sessions.Done()
+		return nil, engines.ErrNoSuchPort
+	}
+
+	// Wrap conn, so we can remove it from s.ports when closed
+	var c io.ReadWriteCloser
+	c = ioext.WatchPipe(conn, func(error) {
+		s.mShells.Lock()
+		defer s.mShells.Unlock()
+
+		// remove c from s.ports
+		ports := make([]io.ReadWriteCloser, 0, len(s.ports))
+		for _, p := range s.ports {
+			if p != c {
+				ports = append(ports, p)
+			}
+		}
+		s.ports = ports
+
+		// Mark as done
+		s.sessions.Done()
+	})
+	s.ports = append(s.ports, c)
+
+	return c, nil
+}
+
+func (s *sandbox) ExtractFolder(path string, handler engines.FileHandler) error {
+	if s.resolve.IsDone() {
+		return engines.ErrSandboxTerminated
+	}
+	return extractFolder(s.user.Home(), path, handler, s.monitor)
+}
+
+func (s *sandbox) WriteFile(path string, stream io.Reader) error {
+	if s.resolve.IsDone() {
+		return engines.ErrSandboxTerminated
+	}
+	// Files written must be owned by the task user, if we created one
+	var owner *system.User
+	if s.engine.config.CreateUser {
+		owner = s.user
+	}
+	return writeFile(s.user.Home(), path, stream, owner)
+}
+
 // abortShells prevents new shells and aborts all existing skells
 func (s *sandbox) abortShells() {
 	s.mShells.Lock()
@@ -220,6 +290,12 @@ func (s *sandbox) abortShells() {
 	}
 	s.shells = nil
 
+	// Close all forwarded ports
+	for _, p := range s.ports {
+		go p.Close()
+	}
+	s.ports = nil
+
 	// can't hold lock while waiting for session to finish
 	s.mShells.Unlock()
 
@@ -249,6 +325,7 @@ func (s *sandbox) waitForTermination() {
 			monitor:       s.monitor,
 			workingFolder: s.workingFolder,
 			user:          s.user,
+			env:           s.env,
 			success:       success,
 		}
 		s.abortErr = engines.ErrSandboxTerminated
@@ -283,6 +360,7 @@ func (s *sandbox) Kill() error {
 			monitor:       s.monitor,
 			workingFolder: s.workingFolder,
 			user:          s.user,
+			env:           s.env,
 			success:       false,
 		}
 		s.abortErr = engines.ErrSandboxTerminated
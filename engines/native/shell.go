@@ -24,7 +24,7 @@ type shell struct {
 	terminated atomics.Bool
 }
 
-func newShell(s *sandbox, command []string, tty bool) (*shell, error) {
+func newShell(user *system.User, env map[string]string, command []string, tty bool) (*shell, error) {
 	// Setup some pipes
 	pipein, stdin := io.Pipe()
 	stdout, pipeout := io.Pipe()
@@ -40,9 +40,9 @@ func newShell(s *sandbox, command []string, tty bool) (*shell, error) {
 
 	process, err := system.StartProcess(system.ProcessOptions{
 		Arguments:     command,
-		Environment:   s.env,
-		WorkingFolder: s.user.Home(),
-		Owner:         s.user,
+		Environment:   env,
+		WorkingFolder: user.Home(),
+		Owner:         user,
 		Stdin:         pipein,
 		Stdout:        pipeout,
 		Stderr:        pipeerr,
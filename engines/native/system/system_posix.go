@@ -11,21 +11,44 @@ import (
 
 // ChangeOwner changes the owner of filepath to the given user
 func ChangeOwner(filepath string, user *User) error {
+	uid, gid, err := lookupOwner(user)
+	if err != nil {
+		return err
+	}
+	if err = os.Chown(filepath, uid, gid); err != nil {
+		return fmt.Errorf("Can't change owner of %s: %v", filepath, err)
+	}
+
+	return nil
+}
+
+// ChangeFileOwner changes the owner of an open file to the given user, unlike
+// ChangeOwner this doesn't resolve the path again.
+func ChangeFileOwner(file *os.File, user *User) error {
+	uid, gid, err := lookupOwner(user)
+	if err != nil {
+		return err
+	}
+	if err = file.Chown(uid, gid); err != nil {
+		return fmt.Errorf("Can't change owner of %s: %v", file.Name(), err)
+	}
+
+	return nil
+}
+
+// lookupOwner returns uid and gid of user
+func lookupOwner(user *User) (int, int, error) {
 	u, err := osuser.Lookup(user.Name())
 	if err != nil {
-		return fmt.Errorf("Cannot lookup user %s: %v", user.Name(), err)
+		return 0, 0, fmt.Errorf("Cannot lookup user %s: %v", user.Name(), err)
 	}
 	uid, err := strconv.Atoi(u.Uid)
 	if err != nil {
-		return fmt.Errorf("Cannot convert uid(%s) to int: %v", u.Uid, err)
+		return 0, 0, fmt.Errorf("Cannot convert uid(%s) to int: %v", u.Uid, err)
 	}
 	gid, err := strconv.Atoi(u.Gid)
 	if err != nil {
-		return fmt.Errorf("Cannot convert gid(%s) to int: %v", u.Gid, err)
+		return 0, 0, fmt.Errorf("Cannot convert gid(%s) to int: %v", u.Gid, err)
 	}
-	if err = os.Chown(filepath, uid, gid); err != nil {
-		return fmt.Errorf("Can't change owner of %s: %v", filepath, err)
-	}
-
-	return nil
+	return uid, gid, nil
 }
@@ -0,0 +1,101 @@
+package nativeengine
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/taskcluster/taskcluster-worker/engines/native/system"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+)
+
+// writeFile writes stream to a plain file at path relative to the home folder,
+// creating parent folders as needed. If owner isn't nil, files and folders
+// created are owned by owner.
+//
+// The task can modify the home folder while this is running, so each path
+// component is opened relative to its parent with O_NOFOLLOW, instead of
+// checking the path and then opening it. Hence, symlinks are never followed,
+// and the file is always inside the home folder.
+func writeFile(home, path string, stream io.Reader, owner *system.User) error {
+	p := filepath.Join(home, path)
+	if !strings.HasPrefix(p, filepath.Clean(home)+string(filepath.Separator)) {
+		return runtime.NewMalformedPayloadError("Path: ", path, " is outside the home folder")
+	}
+	parts := strings.Split(p[len(filepath.Clean(home))+1:], string(filepath.Separator))
+
+	// Open the home folder, this is created by the worker, so we follow symlinks
+	dir, err := os.Open(home)
+	if err != nil {
+		return err
+	}
+	defer func() { dir.Close() }()
+
+	// Open each folder relative to its parent, creating it if missing
+	const dirFlags = unix.O_RDONLY | unix.O_DIRECTORY | unix.O_NOFOLLOW | unix.O_CLOEXEC
+	for _, name := range parts[:len(parts)-1] {
+		created := false
+		fd, err := unix.Openat(int(dir.Fd()), name, dirFlags, 0)
+		if err == unix.ENOENT {
+			if err = unix.Mkdirat(int(dir.Fd()), name, 0777); err != nil && err != unix.EEXIST {
+				return runtime.NewMalformedPayloadError("Unable to create folder for path: ", path, " error: ", err)
+			}
+			created = err == nil
+			fd, err = unix.Openat(int(dir.Fd()), name, dirFlags, 0)
+		}
+		if err == unix.ELOOP || err == unix.ENOTDIR {
+			return runtime.NewMalformedPayloadError("Path: ", path, " has a parent that isn't a folder")
+		}
+		if err != nil {
+			return runtime.NewMalformedPayloadError("Unable to open folder for path: ", path, " error: ", err)
+		}
+		child := os.NewFile(uintptr(fd), filepath.Join(dir.Name(), name))
+		dir.Close()
+		dir = child
+		if created && owner != nil {
+			if err = system.ChangeFileOwner(dir, owner); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Open the file without truncating, as we must check that it's a plain file
+	// first. O_NONBLOCK ensures that opening a named pipe doesn't block.
+	name := parts[len(parts)-1]
+	const fileFlags = unix.O_WRONLY | unix.O_CREAT | unix.O_NOFOLLOW | unix.O_NONBLOCK | unix.O_CLOEXEC
+	fd, err := unix.Openat(int(dir.Fd()), name, fileFlags, 0666)
+	if err == unix.ELOOP || err == unix.ENXIO {
+		return runtime.NewMalformedPayloadError("Path: ", path, " exists and isn't a plain file")
+	}
+	if err != nil {
+		return runtime.NewMalformedPayloadError("Unable to write file: ", path, " error: ", err)
+	}
+
+	// Don't overwrite anything that isn't a plain file
+	var st unix.Stat_t
+	if err = unix.Fstat(fd, &st); err != nil || st.Mode&unix.S_IFMT != unix.S_IFREG {
+		unix.Close(fd)
+		return runtime.NewMalformedPayloadError("Path: ", path, " exists and isn't a plain file")
+	}
+	if err = unix.SetNonblock(fd, false); err != nil {
+		unix.Close(fd)
+		return err
+	}
+	f := os.NewFile(uintptr(fd), p)
+	defer f.Close()
+	if err = f.Truncate(0); err != nil {
+		return err
+	}
+	if owner != nil {
+		if err = system.ChangeFileOwner(f, owner); err != nil {
+			return err
+		}
+	}
+
+	// Errors here are most likely from reading stream, so we return them as is
+	_, err = io.Copy(f, stream)
+	return err
+}
@@ -0,0 +1,41 @@
+// +build linux
+
+package nativeengine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileNoFollow(t *testing.T) {
+	home, err := ioutil.TempDir("", "native-home-")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	outside, err := ioutil.TempDir("", "native-outside-")
+	require.NoError(t, err)
+	defer os.RemoveAll(outside)
+
+	// Write and overwrite a file in a new folder
+	require.NoError(t, writeFile(home, "a/b/c.txt", strings.NewReader("hello-world"), nil))
+	require.NoError(t, writeFile(home, "a/b/c.txt", strings.NewReader("hello"), nil))
+	data, err := ioutil.ReadFile(filepath.Join(home, "a", "b", "c.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	// Symlinks, named pipes and paths outside home must be rejected
+	require.NoError(t, os.Symlink(outside, filepath.Join(home, "link")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "file.txt"), filepath.Join(home, "file.txt")))
+	require.NoError(t, syscall.Mkfifo(filepath.Join(home, "fifo"), 0666))
+	for _, p := range []string{"link/file.txt", "file.txt", "fifo", "../file.txt"} {
+		require.Error(t, writeFile(home, p, strings.NewReader("bad"), nil), "path: %s", p)
+	}
+	files, err := ioutil.ReadDir(outside)
+	require.NoError(t, err)
+	require.Empty(t, files, "nothing must be written outside home")
+}
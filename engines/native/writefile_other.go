@@ -0,0 +1,81 @@
+// +build !linux
+
+package nativeengine
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/taskcluster/taskcluster-worker/engines/native/system"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+)
+
+// writeFile writes stream to a plain file at path relative to the home folder,
+// creating parent folders as needed. Symlinks are evaluated and the file must
+// be inside the home folder. If owner isn't nil, files and folders created are
+// owned by owner.
+//
+// The path is checked before the file is written, so a task replacing a folder
+// with a symlink while this is running may redirect the write. On linux this
+// is prevented by resolving the path with openat(), see writefile_linux.go.
+func writeFile(home, path string, stream io.Reader, owner *system.User) error {
+	prefix, err := filepath.EvalSymlinks(home + string(filepath.Separator))
+	if err != nil {
+		panic(err)
+	}
+	p := filepath.Join(home, path)
+	if !strings.HasPrefix(p, filepath.Clean(home)+string(filepath.Separator)) {
+		return runtime.NewMalformedPayloadError("Path: ", path, " is outside the home folder")
+	}
+
+	// Find the parent folders that must be created, and check that the deepest
+	// existing folder is inside the home folder when symlinks are evaluated
+	var missing []string
+	dir := filepath.Dir(p)
+	for {
+		if _, err = os.Lstat(dir); err == nil {
+			break
+		}
+		missing = append(missing, dir)
+		dir = filepath.Dir(dir)
+	}
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil || !strings.HasPrefix(dir+string(filepath.Separator), prefix) {
+		return runtime.NewMalformedPayloadError("Path: ", path, " is outside the home folder")
+	}
+
+	// Create missing folders starting with the outermost folder
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err = os.Mkdir(missing[i], 0777); err != nil {
+			return runtime.NewMalformedPayloadError("Unable to create folder for path: ", path, " error: ", err)
+		}
+		if owner != nil {
+			if err = system.ChangeOwner(missing[i], owner); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Don't overwrite anything that isn't a plain file
+	if info, lerr := os.Lstat(p); lerr == nil && !ioext.IsPlainFileInfo(info) {
+		return runtime.NewMalformedPayloadError("Path: ", path, " exists and isn't a plain file")
+	}
+
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return runtime.NewMalformedPayloadError("Unable to write file: ", path, " error: ", err)
+	}
+	defer f.Close()
+	if owner != nil {
+		if err = system.ChangeOwner(p, owner); err != nil {
+			return err
+		}
+	}
+
+	// Errors here are most likely from reading stream, so we return them as is
+	_, err = io.Copy(f, stream)
+	return err
+}
@@ -0,0 +1,133 @@
+package qemuengine
+
+import (
+	"archive/tar"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/taskcluster/taskcluster-worker/engines"
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/metaservice"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+)
+
+// extractFolder calls handler for each file in path, files are fetched from
+// the virtual machine as a tar archive using the metaservice. If guest-tools
+// doesn't support archives, files are listed and fetched one at the time.
+func extractFolder(m *metaservice.MetaService, path string, handler engines.FileHandler) error {
+	archive, err := m.GetArchive(path)
+	if err == engines.ErrFeatureNotSupported {
+		return extractFolderFiles(m, path, handler)
+	}
+	if err != nil {
+		return err
+	}
+	return extractArchive(archive, handler)
+}
+
+// extractFolderFiles lists files in path and calls handler for each file, files
+// are fetched from the virtual machine using the metaservice.
+func extractFolderFiles(m *metaservice.MetaService, path string, handler engines.FileHandler) error {
+	files, err := m.ListFolder(path)
+	if err != nil {
+		return err
+	}
+
+	// TODO: Consider some level of parallelism, but not too many files in parallel
+	for _, p := range files {
+		f, err := m.GetArtifact(p)
+		if err != nil {
+			return err
+		}
+		// If guest uses backslashes our input paths should have that, but the ones
+		// we return should be intepreted as names.
+		p = strings.Replace(p[len(path):], "\\", "/", -1)
+		if len(p) > 0 && p[0] == '\\' {
+			p = p[1:]
+		}
+		if handler(p, f) != nil {
+			return engines.ErrHandlerInterrupt
+		}
+	}
+
+	return nil
+}
+
+// extractArchive calls handler for each plain file in archive, the archive is
+// closed when all files given to handler have been closed.
+//
+// Instead of copying each file out of the archive, files are given to handler
+// as sections of the archive, which is possible because the archive is stored
+// in a temporary file.
+func extractArchive(archive runtime.TemporaryFile, handler engines.FileHandler) error {
+	a := &sharedArchive{file: archive, refs: 1}
+	defer a.release()
+
+	f, ok := archive.(io.ReaderAt)
+	if !ok {
+		// This shouldn't happen, temporary files are files on disk
+		debug("temporary file for archive doesn't implement io.ReaderAt")
+		return runtime.ErrNonFatalInternalError
+	}
+
+	// Count bytes read, as file data is located immediately after the header
+	r := &ioext.TellReader{Reader: archive}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			debug("failed to read archive from guest-tools, error: %s", err)
+			return runtime.ErrNonFatalInternalError
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue // Only plain files are extracted
+		}
+
+		a.acquire()
+		file := &archiveFile{
+			SectionReader: io.NewSectionReader(f, r.Tell(), hdr.Size),
+			archive:       a,
+		}
+		if handler(strings.TrimPrefix(hdr.Name, "./"), file) != nil {
+			return engines.ErrHandlerInterrupt
+		}
+	}
+}
+
+// sharedArchive closes file when all references have been released.
+type sharedArchive struct {
+	m    sync.Mutex
+	file runtime.TemporaryFile
+	refs int
+}
+
+func (a *sharedArchive) acquire() {
+	a.m.Lock()
+	defer a.m.Unlock()
+	a.refs++
+}
+
+func (a *sharedArchive) release() {
+	a.m.Lock()
+	defer a.m.Unlock()
+	a.refs--
+	if a.refs == 0 {
+		a.file.Close()
+	}
+}
+
+// archiveFile is a file in a sharedArchive, implementing ReadSeekCloser.
+type archiveFile struct {
+	*io.SectionReader
+	archive *sharedArchive
+	once    sync.Once
+}
+
+func (f *archiveFile) Close() error {
+	f.once.Do(f.archive.release)
+	return nil
+}
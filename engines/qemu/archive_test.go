@@ -0,0 +1,79 @@
+package qemuengine
+
+import (
+	"archive/tar"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/taskcluster/taskcluster-worker/engines"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+)
+
+// createArchive returns a temporary file with a tar archive of files
+func createArchive(t *testing.T, files map[string]string) runtime.TemporaryFile {
+	storage, err := runtime.NewTemporaryStorage(os.TempDir())
+	nilOrFatal(t, err, "failed to create temporary storage")
+	f, err := storage.NewFile()
+	nilOrFatal(t, err, "failed to create temporary file")
+
+	tw := tar.NewWriter(f)
+	tw.WriteHeader(&tar.Header{Name: "folder/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "link.txt", Typeflag: tar.TypeSymlink, Linkname: "a.txt"})
+	for _, name := range []string{"a.txt", "folder/b.txt"} {
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(files[name]))})
+		tw.Write([]byte(files[name]))
+	}
+	nilOrFatal(t, tw.Close(), "failed to write archive")
+	_, err = f.Seek(0, 0)
+	nilOrFatal(t, err, "failed to seek")
+	return f
+}
+
+func TestExtractArchive(t *testing.T) {
+	files := map[string]string{
+		"a.txt":        "hello-world",
+		"folder/b.txt": "hello-again",
+	}
+	archive := createArchive(t, files)
+
+	// Keep files open until all files have been extracted, to check that they
+	// can be read after the archive has been read further.
+	var extracted []ioext.ReadSeekCloser
+	var names []string
+	err := extractArchive(archive, func(name string, f ioext.ReadSeekCloser) error {
+		names = append(names, name)
+		extracted = append(extracted, f)
+		return nil
+	})
+	nilOrFatal(t, err, "failed to extract archive")
+	assert(t, len(names) == 2, "expected 2 files, got: ", names)
+
+	for i, f := range extracted {
+		data, err := ioutil.ReadAll(f)
+		nilOrFatal(t, err, "failed to read: ", names[i])
+		assert(t, string(data) == files[names[i]], "unexpected content in ", names[i], ": ", string(data))
+
+		// Files must be seekable
+		_, err = f.Seek(0, 0)
+		nilOrFatal(t, err, "failed to seek: ", names[i])
+		data, _ = ioutil.ReadAll(f)
+		assert(t, string(data) == files[names[i]], "unexpected content after seek: ", string(data))
+		f.Close()
+	}
+
+	// The archive is removed when all files are closed
+	_, err = os.Stat(archive.Path())
+	assert(t, os.IsNotExist(err), "expected archive to be removed, error: ", err)
+}
+
+func TestExtractArchiveHandlerInterrupt(t *testing.T) {
+	archive := createArchive(t, map[string]string{"a.txt": "a", "folder/b.txt": "b"})
+	err := extractArchive(archive, func(name string, f ioext.ReadSeekCloser) error {
+		f.Close()
+		return errors.New("interrupt")
+	})
+	assert(t, err == engines.ErrHandlerInterrupt, "expected ErrHandlerInterrupt, got: ", err)
+}
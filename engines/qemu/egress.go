@@ -0,0 +1,134 @@
+package qemuengine
+
+import (
+	"net"
+	"strings"
+
+	schematypes "github.com/taskcluster/go-schematypes"
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/network"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/util"
+)
+
+// egressScopePrefix is the prefix for scopes required to relax the egress
+// policy configured for the engine.
+const egressScopePrefix = "worker:qemu-egress:"
+
+// egressPolicies ordered from most to least restrictive
+var egressPolicies = []string{
+	network.EgressDeny,
+	network.EgressProxyOnly,
+	network.EgressAllowlist,
+	network.EgressAllow,
+}
+
+type egressPayload struct {
+	Policy string   `json:"policy"`
+	Allow  []string `json:"allow,omitempty"`
+}
+
+var egressPolicySchema = schematypes.StringEnum{
+	Title: "Egress Policy",
+	Description: util.Markdown(`
+		Policy for outgoing network connections from the virtual machine.
+
+		 * 'allow', permits connections to the internet,
+		 * 'allowlist', permits connections to networks and hosts in 'allow',
+		   including private networks (this requires scopes),
+		 * 'proxy-only', permits connections only through proxies and DNS, and
+		 * 'deny', permits no connections, proxies and DNS are also unreachable.
+
+		The meta-data service and DHCP are always reachable.
+	`),
+	Options: egressPolicies,
+}
+
+var egressPayloadSchema = schematypes.Object{
+	Title: "Network Egress",
+	Description: util.Markdown(`
+		Restrictions on outgoing network connections from the virtual machine.
+
+		Policies less restrictive than the egress policy configured for the
+		worker requires the scope '` + egressScopePrefix + `<policy>'. When
+		relaxing to 'allowlist' each entry requires the scope
+		'` + egressScopePrefix + `allowlist:<entry>'. Entries in private or
+		link-local networks, or in IPv6 ranges rejected by the worker, always
+		require this scope, regardless of the configured policy.
+	`),
+	Properties: schematypes.Properties{
+		"policy": egressPolicySchema,
+		"allow": schematypes.Array{
+			Title: "Allowlist",
+			Description: util.Markdown(`
+				Networks in CIDR notation, IP addresses and hostnames connections
+				are permitted to, when 'policy' is 'allowlist'. Hostnames are
+				resolved when the task starts.
+			`),
+			Items: schematypes.String{},
+		},
+	},
+	Required: []string{"policy"},
+}
+
+// egressRank returns the index of policy in egressPolicies
+func egressRank(policy string) int {
+	for i, p := range egressPolicies {
+		if p == policy {
+			return i
+		}
+	}
+	panic("unknown egress policy: " + policy)
+}
+
+// egressPolicy returns the network.EgressPolicy for a task, given the egress
+// property from the payload (may be nil), the policy configured for the engine
+// and the networks restricted regardless of policy.
+//
+// Returns a MalformedPayloadError if task.scopes doesn't permit the policy
+// or hostnames in the allowlist can't be resolved.
+func egressPolicy(egress *egressPayload, defaultPolicy string, restricted []*net.IPNet, c *runtime.TaskContext) (network.EgressPolicy, error) {
+	if egress == nil {
+		egress = &egressPayload{Policy: defaultPolicy}
+	}
+	if egress.Policy != network.EgressAllowlist && len(egress.Allow) > 0 {
+		return network.EgressPolicy{}, runtime.NewMalformedPayloadError(
+			"egress.allow can only be used with egress policy 'allowlist'",
+		)
+	}
+
+	// Scopes are required, if the policy is less restrictive than the default
+	relaxed := egressRank(egress.Policy) > egressRank(defaultPolicy)
+	var scopes []string
+	policy := network.EgressPolicy{Policy: egress.Policy}
+	if egress.Policy == network.EgressAllowlist {
+		for _, entry := range egress.Allow {
+			nets, err := network.ResolveEgressAllowlist([]string{entry})
+			if err != nil {
+				return network.EgressPolicy{}, runtime.NewMalformedPayloadError(
+					"invalid entry in egress.allow, ", err,
+				)
+			}
+			policy.Allow = append(policy.Allow, nets...)
+
+			// Restricted networks are unreachable under any other policy, so
+			// entries reaching these always require scopes
+			needScope := relaxed
+			for _, ipnet := range nets {
+				needScope = needScope || network.Overlaps(ipnet, restricted)
+			}
+			if needScope {
+				scopes = append(scopes, egressScopePrefix+"allowlist:"+entry)
+			}
+		}
+	} else if relaxed {
+		scopes = []string{egressScopePrefix + egress.Policy}
+	}
+
+	if len(scopes) > 0 && !c.HasScopes(scopes) {
+		return network.EgressPolicy{}, runtime.NewMalformedPayloadError(
+			"task.scopes must satisfy: ", strings.Join(scopes, ", "),
+			" in order to use egress policy '", egress.Policy, "'",
+		)
+	}
+	return policy, nil
+}
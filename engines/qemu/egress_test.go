@@ -0,0 +1,85 @@
+package qemuengine
+
+import (
+	"net"
+	"testing"
+
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/network"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+)
+
+// restricted are the networks restricted by a network.Pool with the default
+// IPv6 reject ranges
+var restricted = (func() []*net.IPNet {
+	var result []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "169.254.0.0/16", "192.168.0.0/16", "fe80::/10", "fc00::/7"} {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		result = append(result, ipnet)
+	}
+	return result
+})()
+
+func TestEgressPolicy(t *testing.T) {
+	c := &runtime.TaskContext{TaskInfo: runtime.TaskInfo{
+		Scopes: []string{egressScopePrefix + "allowlist:10.0.0.0/8"},
+	}}
+
+	// Default policy applies when not specified
+	policy, err := egressPolicy(nil, network.EgressProxyOnly, restricted, c)
+	nilOrFatal(t, err, "expected default policy")
+	assert(t, policy.Policy == network.EgressProxyOnly, "unexpected policy: ", policy.Policy)
+
+	// Restricting the policy doesn't require scopes
+	policy, err = egressPolicy(&egressPayload{Policy: network.EgressDeny}, network.EgressAllow, restricted, c)
+	nilOrFatal(t, err, "expected deny to be permitted")
+	assert(t, policy.Policy == network.EgressDeny, "unexpected policy: ", policy.Policy)
+
+	// Relaxing the policy requires scopes
+	_, err = egressPolicy(&egressPayload{Policy: network.EgressAllow}, network.EgressDeny, restricted, c)
+	_, malformed := runtime.IsMalformedPayloadError(err)
+	assert(t, malformed, "expected MalformedPayloadError, got: ", err)
+
+	policy, err = egressPolicy(&egressPayload{
+		Policy: network.EgressAllowlist,
+		Allow:  []string{"10.0.0.0/8"},
+	}, network.EgressDeny, restricted, c)
+	nilOrFatal(t, err, "expected allowlist to be permitted by scopes")
+	assert(t, len(policy.Allow) == 1, "expected one network, got: ", policy.Allow)
+
+	_, err = egressPolicy(&egressPayload{
+		Policy: network.EgressAllowlist,
+		Allow:  []string{"192.168.0.0/16"},
+	}, network.EgressDeny, restricted, c)
+	_, malformed = runtime.IsMalformedPayloadError(err)
+	assert(t, malformed, "expected MalformedPayloadError, got: ", err)
+}
+
+func TestEgressPolicyAllowlistFromAllow(t *testing.T) {
+	c := &runtime.TaskContext{TaskInfo: runtime.TaskInfo{
+		Scopes: []string{egressScopePrefix + "allowlist:10.0.0.0/8"},
+	}}
+
+	// Restricting to public networks doesn't require scopes
+	policy, err := egressPolicy(&egressPayload{
+		Policy: network.EgressAllowlist,
+		Allow:  []string{"1.2.3.4", "2001:db8::/32"},
+	}, network.EgressAllow, restricted, c)
+	nilOrFatal(t, err, "expected allowlist of public networks to be permitted")
+	assert(t, len(policy.Allow) == 2, "expected two networks, got: ", policy.Allow)
+
+	// Private networks requires scopes, even if the default policy is allow
+	_, err = egressPolicy(&egressPayload{
+		Policy: network.EgressAllowlist,
+		Allow:  []string{"10.0.0.0/8"},
+	}, network.EgressAllow, restricted, c)
+	nilOrFatal(t, err, "expected allowlist to be permitted by scopes")
+
+	for _, entry := range []string{"10.1.2.3", "192.168.0.0/16", "169.254.169.254", "0.0.0.0/0", "fd00::1", "fe80::/64"} {
+		_, err = egressPolicy(&egressPayload{
+			Policy: network.EgressAllowlist,
+			Allow:  []string{"1.2.3.4", entry},
+		}, network.EgressAllow, restricted, c)
+		_, malformed := runtime.IsMalformedPayloadError(err)
+		assert(t, malformed, "expected MalformedPayloadError for ", entry, ", got: ", err)
+	}
+}
@@ -1,6 +1,8 @@
 package qemuengine
 
 import (
+	"math"
+
 	"github.com/pkg/errors"
 	schematypes "github.com/taskcluster/go-schematypes"
 	"github.com/taskcluster/taskcluster-worker/engines"
@@ -8,6 +10,7 @@ import (
 	"github.com/taskcluster/taskcluster-worker/engines/qemu/network"
 	"github.com/taskcluster/taskcluster-worker/engines/qemu/vm"
 	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/util"
 )
 
 type engine struct {
@@ -16,7 +19,7 @@ type engine struct {
 	defaultMachine vm.Machine
 	monitor        runtime.Monitor
 	imageManager   *image.Manager
-	networkPool    *network.Pool
+	networkPool    *network.Pool // nil, if userNetwork is used
 	Environment    *runtime.Environment
 	maxConcurrency int
 	socketFolder   runtime.TemporaryFolder
@@ -27,19 +30,89 @@ type engineProvider struct {
 }
 
 type configType struct {
-	Network       interface{}      `json:"network"`
-	MachineLimits vm.MachineLimits `json:"limits"`
-	Machine       interface{}      `json:"machine"`
+	Network        interface{}      `json:"network"`
+	UserNetwork    bool             `json:"userNetwork"`
+	MachineLimits  vm.MachineLimits `json:"limits"`
+	Machine        interface{}      `json:"machine"`
+	VolumeType     string           `json:"volumeType"`
+	VolumeDiskSize int              `json:"volumeDiskSize"`
+	MemoryDiskSize int              `json:"memoryDiskSize"`
+	EgressPolicy   string           `json:"egressPolicy"`
 }
 
+// defaultVolumeDiskSize is the default virtual size of disk volumes in MiB
+const defaultVolumeDiskSize = 10 * 1024
+
+// defaultMemoryDiskSize is the default size of memory disks in MiB
+const defaultMemoryDiskSize = 1024
+
 var configSchema = schematypes.Object{
 	Properties: schematypes.Properties{
 		"network": network.PoolConfigSchema,
+		"userNetwork": schematypes.Boolean{
+			Title: "User-Mode Network",
+			Description: util.Markdown(`
+				Use the QEMU user-space network stack instead of creating a TAP
+				device for each virtual machine, in which case 'network' is ignored.
+				This doesn't require root, but virtual machines aren't isolated from
+				the host network, and 'egressPolicy' must be 'allow', so this is only
+				intended for tests and local development.
+
+				The meta-data service is forwarded to a unix socket using 'netcat -U',
+				so this requires a 'netcat' supporting unix sockets on the host.
+			`),
+		},
 		"limits":  vm.MachineLimitsSchema,
 		"machine": vm.MachineSchema,
+		"volumeType": schematypes.StringEnum{
+			Title: "Volume Type",
+			Description: util.Markdown(`
+				Type of volumes used for cache folders and memory disks.
+
+				A 'folder' volume is a folder on the host shared with the guest
+				using virtio-9p, this requires 9p support in the guest kernel.
+				A 'disk' volume is a sparse qcow2 disk image attached as a virtio
+				block device, it is formatted as ext4 when created. Defaults to
+				'folder'.
+
+				Memory disks are always 'tmpfs' file systems mounted in the guest.
+			`),
+			Options: []string{volumeTypeFolder, volumeTypeDisk},
+		},
+		"volumeDiskSize": schematypes.Integer{
+			Title: "Volume Disk Size",
+			Description: util.Markdown(`
+				Virtual size of 'disk' volumes in MiB, disk images are sparse so
+				disk space is only used as data is written. Defaults to 10 GiB.
+			`),
+			Minimum: 1,
+			Maximum: math.MaxInt32,
+		},
+		"memoryDiskSize": schematypes.Integer{
+			Title: "Memory Disk Size",
+			Description: util.Markdown(`
+				Maximum size of memory disks in MiB, memory disks are 'tmpfs' file
+				systems in the guest, so memory is only used as data is written,
+				and counts against the memory of the virtual machine. Defaults to
+				1 GiB.
+			`),
+			Minimum: 1,
+			Maximum: math.MaxInt32,
+		},
+		"egressPolicy": schematypes.StringEnum{
+			Title: "Egress Policy",
+			Description: util.Markdown(`
+				Policy for outgoing network connections from virtual machines, for
+				tasks that doesn't specify 'egress' in the payload. Defaults to
+				'allow'.
+
+				Tasks may specify a more restrictive policy, but relaxing the policy
+				requires scopes, see 'egress' in the payload schema.
+			`),
+			Options: []string{network.EgressAllow, network.EgressProxyOnly, network.EgressDeny},
+		},
 	},
 	Required: []string{
-		"network",
 		"limits",
 	},
 }
@@ -51,6 +124,24 @@ func (p engineProvider) ConfigSchema() schematypes.Schema {
 func (p engineProvider) NewEngine(options engines.EngineOptions) (engines.Engine, error) {
 	var c configType
 	schematypes.MustValidateAndMap(configSchema, options.Config, &c)
+	if c.VolumeType == "" {
+		c.VolumeType = volumeTypeFolder
+	}
+	if c.VolumeDiskSize == 0 {
+		c.VolumeDiskSize = defaultVolumeDiskSize
+	}
+	if c.MemoryDiskSize == 0 {
+		c.MemoryDiskSize = defaultMemoryDiskSize
+	}
+	if c.EgressPolicy == "" {
+		c.EgressPolicy = network.EgressAllow
+	}
+	if c.UserNetwork && c.EgressPolicy != network.EgressAllow {
+		return nil, errors.New("egressPolicy must be 'allow' when userNetwork is used")
+	}
+	if !c.UserNetwork && c.Network == nil {
+		return nil, errors.New("network must be configured unless userNetwork is used")
+	}
 
 	// Create socket folder
 	socketFolder, err := options.Environment.TemporaryStorage.NewFolder()
@@ -68,14 +159,19 @@ func (p engineProvider) NewEngine(options engines.EngineOptions) (engines.Engine
 		return nil, errors.Wrap(err, "failed to create image manager")
 	}
 
-	// Create network pool
-	networkPool, err := network.NewPool(network.PoolOptions{
-		Config:           c.Network,
-		Monitor:          options.Monitor.WithPrefix("network"),
-		TemporaryStorage: options.Environment.TemporaryStorage,
-	})
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create network pool")
+	// Create network pool, unless user-space networks are created per sandbox
+	var networkPool *network.Pool
+	maxConcurrency := 0
+	if !c.UserNetwork {
+		networkPool, err = network.NewPool(network.PoolOptions{
+			Config:           c.Network,
+			Monitor:          options.Monitor.WithPrefix("network"),
+			TemporaryStorage: options.Environment.TemporaryStorage,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create network pool")
+		}
+		maxConcurrency = networkPool.Size()
 	}
 
 	// Create defaultMachine machine from config
@@ -91,7 +187,7 @@ func (p engineProvider) NewEngine(options engines.EngineOptions) (engines.Engine
 		monitor:        options.Monitor,
 		imageManager:   imageManager,
 		networkPool:    networkPool,
-		maxConcurrency: networkPool.Size(),
+		maxConcurrency: maxConcurrency,
 		Environment:    options.Environment,
 		socketFolder:   socketFolder,
 	}, nil
@@ -99,14 +195,16 @@ func (p engineProvider) NewEngine(options engines.EngineOptions) (engines.Engine
 
 func (e *engine) Capabilities() engines.Capabilities {
 	return engines.Capabilities{
-		MaxConcurrency: e.maxConcurrency,
+		MaxConcurrency:  e.maxConcurrency,
+		ShellsAfterExit: true,
 	}
 }
 
 type payloadType struct {
-	Image   interface{} `json:"image"`
-	Command []string    `json:"command"`
-	Machine interface{} `json:"machine,omitempty"`
+	Image   interface{}    `json:"image"`
+	Command []string       `json:"command"`
+	Machine interface{}    `json:"machine,omitempty"`
+	Egress  *egressPayload `json:"egress,omitempty"`
 }
 
 var payloadSchema = schematypes.Object{
@@ -118,6 +216,7 @@ var payloadSchema = schematypes.Object{
 			Items:       schematypes.String{},
 		},
 		"machine": vm.MachineSchema,
+		"egress":  egressPayloadSchema,
 	},
 	Required: []string{"command", "image"},
 }
@@ -130,6 +229,28 @@ func (e *engine) NewSandboxBuilder(options engines.SandboxOptions) (engines.Sand
 	var p payloadType
 	schematypes.MustValidateAndMap(payloadSchema, options.Payload, &p)
 
+	// Without a network pool, create a user-space network for the sandbox
+	if e.networkPool == nil {
+		if p.Egress != nil {
+			return nil, runtime.NewMalformedPayloadError(
+				"task.payload.egress isn't supported by this worker, as it uses userNetwork",
+			)
+		}
+		net, err := network.NewUserNetwork(e.socketFolder.Path())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create user-space network")
+		}
+		return newSandboxBuilder(&p, net, network.EgressAllow, options.TaskContext, e, options.Monitor), nil
+	}
+
+	// Check that the egress policy is permitted
+	egress, err := egressPolicy(
+		p.Egress, e.engineConfig.EgressPolicy, e.networkPool.RestrictedNetworks(), options.TaskContext,
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get an idle network
 	net, err := e.networkPool.Network()
 	if err == network.ErrAllNetworksInUse {
@@ -139,11 +260,31 @@ func (e *engine) NewSandboxBuilder(options engines.SandboxOptions) (engines.Sand
 		return nil, err
 	}
 
+	// Restrict outgoing connections
+	if err = net.SetEgressPolicy(egress); err != nil {
+		net.Release()
+		return nil, err
+	}
+
 	// Create sandboxBuilder, it'll handle image downloading
-	return newSandboxBuilder(&p, net, options.TaskContext, e, options.Monitor), nil
+	return newSandboxBuilder(&p, net, egress.Policy, options.TaskContext, e, options.Monitor), nil
+}
+
+func (e *engine) NewCacheFolder() (engines.Volume, error) {
+	return newVolume(
+		e.Environment.TemporaryStorage, e.engineConfig.VolumeType,
+		e.engineConfig.VolumeDiskSize,
+	)
+}
+
+func (e *engine) NewMemoryDisk() (engines.Volume, error) {
+	return newMemoryDisk(e.engineConfig.MemoryDiskSize), nil
 }
 
 func (e *engine) Dispose() error {
+	if e.networkPool == nil {
+		return nil
+	}
 	err := e.networkPool.Dispose()
 	e.networkPool = nil
 	return err
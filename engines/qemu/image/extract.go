@@ -4,8 +4,10 @@ import (
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
 	schematypes "github.com/taskcluster/go-schematypes"
@@ -22,6 +24,11 @@ const (
 
 const maxImageSize = int64(50 * 1024 * 1024 * 1024) // Use int64 for i386 builds
 
+// StateFile is the name of the optional file in an image with virtual machine
+// state saved by vm.VirtualMachine.SaveSnapshot(), if present virtual machines
+// are restored from this state with disk state from 'layer.qcow2'.
+const StateFile = "vmstate"
+
 // RandomMAC generates a new random MAC with the local bit set.
 func RandomMAC() string {
 	// Credits: http://stackoverflow.com/a/21027407/68333
@@ -36,8 +43,8 @@ func RandomMAC() string {
 }
 
 // extractImage will extract the "disk.img", "layer.qcow2" and "machine.json"
-// files from a tar archive using GNU tar ensuring that sparse entries will be
-// extracted as sparse files.
+// files, and StateFile if present, from a tar archive using GNU tar ensuring
+// that sparse entries will be extracted as sparse files.
 //
 // This also validates that files aren't symlinks and are in correct format,
 // with legal backing_file parameters.
@@ -56,10 +63,10 @@ func extractImage(imageFile, imageFolder string) (*vm.Machine, error) {
 	// Using zstd | tar so we get sparse files (sh to get OS pipes)
 	tar := exec.Command("sh", "-fec", "zstd -dqc '"+imageFile+"' | "+
 		"tar -xoC '"+imageFolder+"' --no-same-permissions -- "+
-		"disk.img layer.qcow2 machine.json",
+		"disk.img layer.qcow2 machine.json "+StateFile,
 	)
 	_, err := tar.Output()
-	if err != nil {
+	if err != nil && !onlyNotFoundErrors(err) {
 		if ee, ok := err.(*exec.ExitError); ok {
 			return nil, runtime.NewMalformedPayloadError(
 				"Failed to extract image archive, error: ", string(ee.Stderr),
@@ -71,8 +78,11 @@ func extractImage(imageFile, imageFolder string) (*vm.Machine, error) {
 	}
 
 	// Check files exist, are plain files and not larger than maxImageSize
-	for _, name := range []string{"disk.img", "layer.qcow2", "machine.json"} {
+	for _, name := range []string{"disk.img", "layer.qcow2", "machine.json", StateFile} {
 		f := filepath.Join(imageFolder, name)
+		if name == StateFile && !fileExists(f) {
+			continue // StateFile is optional
+		}
 		if !ioext.IsPlainFile(f) {
 			return nil, runtime.NewMalformedPayloadError("Image file is missing '", name, "'")
 		}
@@ -136,6 +146,29 @@ func extractImage(imageFile, imageFolder string) (*vm.Machine, error) {
 	return machine, nil
 }
 
+// onlyNotFoundErrors returns true, if err is GNU tar exiting because some of
+// the files to be extracted were not found in the archive. Missing files are
+// detected when checking the extracted files.
+func onlyNotFoundErrors(err error) bool {
+	ee, ok := err.(*exec.ExitError)
+	if !ok {
+		return false
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(ee.Stderr)), "\n") {
+		if !strings.HasSuffix(line, ": Not found in archive") &&
+			!strings.HasSuffix(line, ": Exiting with failure status due to previous errors") {
+			return false
+		}
+	}
+	return true
+}
+
+// fileExists returns true, if file exists, symlinks are not followed
+func fileExists(file string) bool {
+	_, err := os.Lstat(file)
+	return err == nil
+}
+
 // load vm.Machine from file with migration of machine definition
 func newMachineFromFile(machineFile string) (*vm.Machine, error) {
 	// Read machine.json
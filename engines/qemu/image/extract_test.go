@@ -0,0 +1,33 @@
+package image
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnlyNotFoundErrors(t *testing.T) {
+	folder, err := ioutil.TempDir("", "qemu-image-extract-")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(folder, "disk.img"), []byte("data"), 0644))
+	require.NoError(t, exec.Command("tar", "-C", folder, "-cf", filepath.Join(folder, "image.tar"), "disk.img").Run())
+
+	// Extracting a missing optional file gives an error we can ignore
+	tar := exec.Command("tar", "-xoC", folder, "-f", filepath.Join(folder, "image.tar"), "--", "disk.img", StateFile)
+	_, err = tar.Output()
+	require.Error(t, err)
+	require.True(t, onlyNotFoundErrors(err), "expected only not found errors")
+
+	// Extracting a corrupt archive gives an error we must not ignore
+	require.NoError(t, ioutil.WriteFile(filepath.Join(folder, "bad.tar"), []byte("not a tar archive"), 0644))
+	tar = exec.Command("tar", "-xoC", folder, "-f", filepath.Join(folder, "bad.tar"), "--", "disk.img", StateFile)
+	_, err = tar.Output()
+	require.Error(t, err)
+	require.False(t, onlyNotFoundErrors(err), "expected other errors")
+}
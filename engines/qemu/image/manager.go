@@ -3,6 +3,7 @@ package image
 import (
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"sync"
 
@@ -30,12 +31,14 @@ type Downloader func(imageFile *os.File) error
 // image represents an image of which multiple instances can be created
 type image struct {
 	gc.DisposableResource
-	imageID string
-	folder  string
-	machine *vm.Machine
-	done    <-chan struct{}
-	manager *Manager
-	err     error
+	imageID  string
+	hash     string // hex encoded sha256 of the image file
+	snapshot string // path to StateFile, if the image has one
+	folder   string
+	machine  *vm.Machine
+	done     <-chan struct{}
+	manager  *Manager
+	err      error
 }
 
 // Instance represents an instance of an image.
@@ -130,6 +133,12 @@ func (img *image) loadImage(download Downloader, done chan<- struct{}) {
 		goto cleanup
 	}
 
+	// Hash the image file, so we can report what image was used
+	img.hash, err = hashFile(imageFile)
+	if err != nil {
+		goto cleanup
+	}
+
 	// close image file
 	err = imageFile.Close()
 	imageFile = nil // don't close twice
@@ -144,6 +153,12 @@ func (img *image) loadImage(download Downloader, done chan<- struct{}) {
 		goto cleanup
 	}
 
+	// Find virtual machine state to restore from, if any
+	if fileExists(filepath.Join(img.folder, StateFile)) {
+		img.snapshot = filepath.Join(img.folder, StateFile)
+		debug("image %s has saved state: %s", img.imageID, img.snapshot)
+	}
+
 	// Clean up if there is any error
 cleanup:
 	// Close image file, if still open
@@ -204,12 +219,24 @@ func (img *image) Dispose() error {
 // instance returns a new instance of the image for use in a virtual machine.
 // You must have called image.Acquire() first to prevent garbage collection.
 func (img *image) instance() (*Instance, error) {
-	// Create a copy of layer.qcow2
-	diskFile := filepath.Join(img.folder, slugid.Nice()+".qcow2")
-	err := copyFile(filepath.Join(img.folder, "layer.qcow2"), diskFile)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to make copy of layer.qcow2, error: %s", err)
+	// Create a qcow2 overlay backed by layer.qcow2, so that layer.qcow2 is never
+	// modified and always matches the saved state, if any.
+	diskFile := slugid.Nice() + ".qcow2"
+	overlay := exec.Command(
+		"qemu-img", "create",
+		"-f", "qcow2",
+		"-o", "backing_file=layer.qcow2,backing_fmt=qcow2,lazy_refcounts=on",
+		diskFile,
+	)
+	overlay.Dir = img.folder
+	if _, err := overlay.Output(); err != nil {
+		msg := err.Error()
+		if ee, ok := err.(*exec.ExitError); ok {
+			msg = string(ee.Stderr)
+		}
+		return nil, fmt.Errorf("Failed to create overlay for layer.qcow2, error: %s", msg)
 	}
+	diskFile = filepath.Join(img.folder, diskFile)
 
 	return &Instance{
 		image:    img,
@@ -237,6 +264,28 @@ func (i *Instance) DiskFile() string {
 	return i.diskFile
 }
 
+// Hash returns the hex encoded sha256 hash of the image file this instance
+// was created from.
+func (i *Instance) Hash() string {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if i.image == nil {
+		panic("Instance of image is already disposed")
+	}
+	return i.image.hash
+}
+
+// Snapshot returns the path to the saved virtual machine state to restore,
+// empty-string if the image doesn't have saved state.
+func (i *Instance) Snapshot() string {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if i.image == nil {
+		panic("Instance of image is already disposed")
+	}
+	return i.image.snapshot
+}
+
 // Format returns the image format: 'qcow2'
 func (i *Instance) Format() string {
 	return formatQCOW2
@@ -250,9 +299,9 @@ func (i *Instance) Release() {
 		panic("Instance of image is already disposed")
 	}
 
-	// Delete the layer.qcow2 copy
+	// Delete the layer.qcow2 overlay
 	if err := os.Remove(i.diskFile); err != nil {
-		i.image.manager.monitor.ReportError(err, "Failed to delete layer.qcow2 copy")
+		i.image.manager.monitor.ReportError(err, "Failed to delete layer.qcow2 overlay")
 	}
 
 	// Release the image
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -17,10 +18,12 @@ import (
 // in a single folder. This can be used for testing images and building new
 // images.
 type MutableImage struct {
-	m       sync.Mutex
-	inUse   bool
-	folder  string
-	machine *vm.Machine
+	m        sync.Mutex
+	inUse    bool
+	layered  bool // True, if disk.img is used through layer.qcow2
+	folder   string
+	machine  *vm.Machine
+	manifest []byte // manifest.json to be packaged with the image, if any
 }
 
 // NewMutableImage creates a new blank MutableImage of given size in GiB, and
@@ -61,6 +64,15 @@ func NewMutableImageFromFile(imageFile, imageFolder string) (*MutableImage, erro
 		return nil, err
 	}
 
+	// Remove saved state, as it's only valid for the current layer.qcow2
+	if err := os.Remove(filepath.Join(imageFolder, StateFile)); err != nil && !os.IsNotExist(err) {
+		// Delete image folder, ignoring errors
+		os.RemoveAll(imageFolder)
+
+		// Return the original error
+		return nil, fmt.Errorf("Failed to delete %s after extract, err: %s", StateFile, err)
+	}
+
 	// Remove layer.qcow2
 	if err := os.Remove(filepath.Join(imageFolder, "layer.qcow2")); err != nil {
 		// Delete image folder, ignoring errors
@@ -86,28 +98,49 @@ func (img *MutableImage) DiskFile() string {
 	}
 	img.inUse = true
 
+	if img.layered {
+		return filepath.Join(img.folder, "layer.qcow2")
+	}
 	return filepath.Join(img.folder, "disk.img")
 }
 
-// Format returns the image format: 'raw'.
+// Format returns the image format: 'raw', or 'qcow2' after CreateLayer().
 func (img *MutableImage) Format() string {
-	return "raw"
+	img.m.Lock()
+	defer img.m.Unlock()
+
+	if img.layered {
+		return formatQCOW2
+	}
+	return formatRaw
 }
 
-// Machine returns the vm.Machine definition of the virtual machine.
-func (img *MutableImage) Machine() vm.Machine {
+// Snapshot returns empty-string, as a MutableImage is never restored from a
+// snapshot.
+func (img *MutableImage) Snapshot() string {
+	return ""
+}
+
+// StateFile returns the path to which virtual machine state should be saved,
+// if the image is to be packaged with a snapshot. See vm.SaveSnapshot().
+func (img *MutableImage) StateFile() string {
 	img.m.Lock()
 	defer img.m.Unlock()
 	if img.folder == "" {
 		panic("MutableImage have been disposed")
 	}
 
-	return *img.machine
+	return filepath.Join(img.folder, StateFile)
 }
 
-// Package will write an zstd compressed tar archive of the image to targetFile.
-// This method cannot be called the image is in-use.
-func (img *MutableImage) Package(targetFile string) error {
+// CreateLayer creates the layer.qcow2 file on top of disk.img, after which
+// the image is used through layer.qcow2. This is necessary to save a snapshot
+// of the virtual machine, as the saved state is only valid for the disk state
+// at the time it was saved, and layer.qcow2 is never modified once packaged.
+//
+// The layer.qcow2 file will be packaged as is, along with the StateFile() if
+// present. This method cannot be called the image is in-use.
+func (img *MutableImage) CreateLayer() error {
 	img.m.Lock()
 	defer img.m.Unlock()
 	if img.folder == "" {
@@ -116,8 +149,42 @@ func (img *MutableImage) Package(targetFile string) error {
 	if img.inUse {
 		panic("MutableImage is currently in-use, Release() must be called first")
 	}
+	if img.layered {
+		return nil
+	}
+
+	if err := img.createLayer(); err != nil {
+		return err
+	}
+	img.layered = true
+	return nil
+}
+
+// SetMachine sets the vm.Machine definition of the virtual machine.
+func (img *MutableImage) SetMachine(machine vm.Machine) {
+	img.m.Lock()
+	defer img.m.Unlock()
+	if img.folder == "" {
+		panic("MutableImage have been disposed")
+	}
+
+	img.machine = &machine
+}
+
+// SetManifest sets the JSON manifest describing how the image was built, this
+// will be packaged as manifest.json in the image file.
+func (img *MutableImage) SetManifest(manifest []byte) {
+	img.m.Lock()
+	defer img.m.Unlock()
+	if img.folder == "" {
+		panic("MutableImage have been disposed")
+	}
 
-	// Create layer.qcow2 file
+	img.manifest = manifest
+}
+
+// createLayer creates the layer.qcow2 file, lock must be held
+func (img *MutableImage) createLayer() error {
 	layer := exec.Command(
 		"qemu-img", "create",
 		"-f", "qcow2",
@@ -133,6 +200,38 @@ func (img *MutableImage) Package(targetFile string) error {
 		}
 		return fmt.Errorf("Failed to create layer.qcow2 file, error: %s", msg)
 	}
+	return nil
+}
+
+// Machine returns the vm.Machine definition of the virtual machine.
+func (img *MutableImage) Machine() vm.Machine {
+	img.m.Lock()
+	defer img.m.Unlock()
+	if img.folder == "" {
+		panic("MutableImage have been disposed")
+	}
+
+	return *img.machine
+}
+
+// Package will write an zstd compressed tar archive of the image to targetFile.
+// This method cannot be called the image is in-use.
+func (img *MutableImage) Package(targetFile string) error {
+	img.m.Lock()
+	defer img.m.Unlock()
+	if img.folder == "" {
+		panic("MutableImage have been disposed")
+	}
+	if img.inUse {
+		panic("MutableImage is currently in-use, Release() must be called first")
+	}
+
+	// Create layer.qcow2 file, unless created by CreateLayer()
+	if !img.layered {
+		if err := img.createLayer(); err != nil {
+			return err
+		}
+	}
 
 	// Create machine.json file
 	data, err := json.Marshal(img.machine)
@@ -148,11 +247,28 @@ func (img *MutableImage) Package(targetFile string) error {
 		return fmt.Errorf("Failed to write machine.json, err: %s", err)
 	}
 	file.Close()
+	files := []string{"disk.img", "layer.qcow2", "machine.json"}
 
-	// Create tarball of everything
-	tar := exec.Command(
-		"tar", "-Scf", "image.tar", "disk.img", "layer.qcow2", "machine.json",
-	)
+	// Include saved virtual machine state, if any
+	if fileExists(filepath.Join(img.folder, StateFile)) {
+		files = append(files, StateFile)
+	}
+
+	// Create manifest.json file, if a manifest is given
+	if img.manifest != nil {
+		err = ioutil.WriteFile(filepath.Join(img.folder, "manifest.json"), img.manifest, 0644)
+		if err != nil {
+			return fmt.Errorf("Failed to write manifest.json, err: %s", err)
+		}
+		files = append(files, "manifest.json")
+	}
+
+	// Create tarball of everything, with fixed permissions, ownership and
+	// modification time, so packaging the same files gives the same image file.
+	tar := exec.Command("tar", append([]string{
+		"-Scf", "image.tar",
+		"--mode=0644", "--owner=0", "--group=0", "--numeric-owner", "--mtime=@0",
+	}, files...)...)
 	tar.Dir = img.folder
 	if _, err := tar.Output(); err != nil {
 		msg := err.Error()
@@ -179,10 +295,17 @@ func (img *MutableImage) Package(targetFile string) error {
 	if err := os.Remove(filepath.Join(img.folder, "layer.qcow2")); err != nil {
 		return fmt.Errorf("Failed to clean up after packaging, err: %s", err)
 	}
+	img.layered = false
 	// Remove machine.json
 	if err := os.Remove(filepath.Join(img.folder, "machine.json")); err != nil {
 		return fmt.Errorf("Failed to clean up after packaging, err: %s", err)
 	}
+	// Remove manifest.json
+	if img.manifest != nil {
+		if err := os.Remove(filepath.Join(img.folder, "manifest.json")); err != nil {
+			return fmt.Errorf("Failed to clean up after packaging, err: %s", err)
+		}
+	}
 	// Remove image.tar
 	if err := os.Remove(filepath.Join(img.folder, "image.tar")); err != nil {
 		return fmt.Errorf("Failed to clean up after packaging, err: %s", err)
@@ -1,6 +1,8 @@
 package image
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,36 +12,17 @@ import (
 	"github.com/taskcluster/taskcluster-worker/runtime"
 )
 
-// copyFile copies source to destination, and returns an error if one occurs
-// during opening input or output file, copying data from input to output, or
-// closing input or output file
-func copyFile(source, target string) (err error) {
-	var input *os.File
-	var output *os.File
-	input, err = os.Open(source)
-	if err != nil {
-		return
+// hashFile returns the hex encoded sha256 hash of file, reading it from the
+// beginning.
+func hashFile(file *os.File) (string, error) {
+	if _, err := file.Seek(0, 0); err != nil {
+		return "", fmt.Errorf("Failed to seek to start of file, error: %s", err)
 	}
-
-	closeFile := func(f *os.File) {
-		err2 := f.Close()
-		if err == nil {
-			err = err2
-		}
-	}
-
-	defer closeFile(input)
-
-	// Create target file
-	output, err = os.Create(target)
-	if err != nil {
-		return
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("Failed to read file for hashing, error: %s", err)
 	}
-	defer closeFile(output)
-
-	// Copy data
-	_, err = io.Copy(output, input)
-	return
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 const maxRetries = 7
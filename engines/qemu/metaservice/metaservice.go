@@ -6,12 +6,16 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/taskcluster/slugid-go/slugid"
 	"github.com/taskcluster/taskcluster-worker/engines"
+	"github.com/taskcluster/taskcluster-worker/plugins/interactive/displayclient"
+	"github.com/taskcluster/taskcluster-worker/plugins/interactive/displayconsts"
 	"github.com/taskcluster/taskcluster-worker/plugins/interactive/shellclient"
 	"github.com/taskcluster/taskcluster-worker/plugins/interactive/shellconsts"
 	"github.com/taskcluster/taskcluster-worker/runtime"
@@ -35,11 +39,13 @@ type MetaService struct {
 	m               sync.Mutex
 	command         []string
 	env             map[string]string
+	mounts          []Mount
 	logDrain        io.Writer
 	resultCallback  func(bool)
 	environment     *runtime.Environment
 	resolved        bool
-	result          bool // saved to support idempotency
+	result          bool            // saved to support idempotency
+	supports        map[string]bool // optional action types supported by guest-tools
 	mux             *http.ServeMux
 	actionOut       chan Action
 	pendingRecords  map[string]*asyncRecord
@@ -67,6 +73,7 @@ func New(
 		actionOut:      make(chan Action),
 		pendingRecords: make(map[string]*asyncRecord),
 		haltPolling:    make(chan struct{}),
+		supports:       make(map[string]bool),
 	}
 
 	s.mux.HandleFunc("/engine/v1/execute", s.handleExecute)
@@ -81,6 +88,14 @@ func New(
 	return s
 }
 
+// SetMounts sets the volumes the guest must mount before executing the
+// command, this must be called before the virtual machine is started.
+func (s *MetaService) SetMounts(mounts []Mount) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.mounts = mounts
+}
+
 // ServeHTTP handles request to the meta-data service.
 func (s *MetaService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.mux.ServeHTTP(w, r)
@@ -121,9 +136,14 @@ func (s *MetaService) handleExecute(w http.ResponseWriter, r *http.Request) {
 	}
 
 	debug("GET /engine/v1/execute")
+	s.m.Lock()
+	mounts := s.mounts
+	s.m.Unlock()
 	reply(w, http.StatusOK, Execute{
 		Command: s.command,
 		Env:     s.env,
+		Mounts:  mounts,
+		Time:    time.Now().UTC(),
 	})
 }
 
@@ -240,6 +260,16 @@ func (s *MetaService) handlePoll(w http.ResponseWriter, r *http.Request) {
 	}
 
 	debug("GET /engine/v1/poll")
+
+	// Record optional action types supported by guest-tools
+	if supports := r.URL.Query().Get("supports"); supports != "" {
+		s.m.Lock()
+		for _, actionType := range strings.Split(supports, ",") {
+			s.supports[actionType] = true
+		}
+		s.m.Unlock()
+	}
+
 	select {
 	case <-s.haltPolling:
 		reply(w, http.StatusOK, Action{
@@ -350,18 +380,20 @@ func (s *MetaService) handleReply(w http.ResponseWriter, r *http.Request) {
 	rec.Callback(w, r)
 }
 
-func (s *MetaService) getArtifactWithoutRetry(path string) (
-	ioext.ReadSeekCloser, error,
+// getFileWithoutRetry sends an action of the given type, which guest-tools
+// replies to with a file, and returns the file.
+func (s *MetaService) getFileWithoutRetry(actionType, path string) (
+	runtime.TemporaryFile, error,
 ) {
 	// Create result values to be set in the callback
 	var (
-		File ioext.ReadSeekCloser
+		File runtime.TemporaryFile
 		Err  error
 	)
 	Err = runtime.ErrNonFatalInternalError
 
 	s.asyncRequest(Action{
-		Type: "get-artifact",
+		Type: actionType,
 		Path: path,
 	}, func(w http.ResponseWriter, r *http.Request) {
 		if !forceMethod(w, r, http.MethodPost) {
@@ -413,11 +445,12 @@ func (s *MetaService) getArtifactWithoutRetry(path string) (
 	return File, Err
 }
 
-// GetArtifact will tell polling guest-tools to send a given artifact.
-func (s *MetaService) GetArtifact(path string) (ioext.ReadSeekCloser, error) {
+// getFile sends an action of the given type with retries, see
+// getFileWithoutRetry.
+func (s *MetaService) getFile(actionType, path string) (runtime.TemporaryFile, error) {
 	retries := 3
 	for {
-		f, err := s.getArtifactWithoutRetry(path)
+		f, err := s.getFileWithoutRetry(actionType, path)
 		retries--
 		if err == runtime.ErrNonFatalInternalError && retries > 0 {
 			continue
@@ -426,6 +459,32 @@ func (s *MetaService) GetArtifact(path string) (ioext.ReadSeekCloser, error) {
 	}
 }
 
+// GetArtifact will tell polling guest-tools to send a given artifact.
+func (s *MetaService) GetArtifact(path string) (ioext.ReadSeekCloser, error) {
+	f, err := s.getFile("get-artifact", path)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// GetArchive will tell polling guest-tools to send a tar archive of the folder
+// at path, or the working directory if path is empty. The archive is stored in
+// a temporary file, which is returned.
+//
+// Returns engines.ErrFeatureNotSupported, if guest-tools doesn't support the
+// get-archive action, and engines.ErrResourceNotFound, if the folder doesn't
+// exist.
+func (s *MetaService) GetArchive(path string) (runtime.TemporaryFile, error) {
+	s.m.Lock()
+	supported := s.supports[ActionGetArchive]
+	s.m.Unlock()
+	if !supported {
+		return nil, engines.ErrFeatureNotSupported
+	}
+	return s.getFile(ActionGetArchive, path)
+}
+
 func (s *MetaService) listFolderWithoutRetries(path string) ([]string, error) {
 	var (
 		Result []string
@@ -495,6 +554,76 @@ func (s *MetaService) ListFolder(path string) ([]string, error) {
 	}
 }
 
+func (s *MetaService) putFileWithoutRetry(path string, file io.ReadSeeker, size int64) error {
+	Err := runtime.ErrNonFatalInternalError
+
+	s.asyncRequest(Action{
+		Type: "put-file",
+		Path: path,
+	}, func(w http.ResponseWriter, r *http.Request) {
+		if !forceMethod(w, r, http.MethodPost) {
+			return
+		}
+
+		// Return a MalformedPayloadError, if the file could not be written
+		if r.Header.Get("X-Taskcluster-Worker-Error") == "cannot-write-file" {
+			reply(w, http.StatusOK, nil)
+			Err = runtime.NewMalformedPayloadError(
+				"Unable to write file: ", path, " inside the virtual machine",
+			)
+			return
+		}
+
+		// Seek to start of file
+		if _, err := file.Seek(0, 0); err != nil {
+			reply(w, http.StatusInternalServerError, Error{
+				Code:    ErrorCodeInternalError,
+				Message: "Unable to read file from disk",
+			})
+			return
+		}
+
+		// Reply with the file as body
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+		if _, err := io.Copy(w, file); err != nil {
+			debug("Failed to send file to guest-tools, error: %s", err)
+			return
+		}
+
+		Err = nil
+	})
+
+	return Err
+}
+
+// PutFile will tell polling guest-tools to fetch stream and write it to the
+// given path inside the virtual machine.
+func (s *MetaService) PutFile(path string, stream io.Reader) error {
+	// Write stream to a temporary file, so we can retry
+	f, err := s.environment.TemporaryStorage.NewFile()
+	if err != nil {
+		debug("Failed to create temporary file, error: %s", err)
+		return runtime.ErrNonFatalInternalError
+	}
+	defer f.Close()
+	size, err := io.Copy(f, stream)
+	if err != nil {
+		return err
+	}
+
+	retries := 3
+	for {
+		err := s.putFileWithoutRetry(path, f, size)
+		retries--
+		if err == runtime.ErrNonFatalInternalError && retries > 0 {
+			continue
+		}
+		return err
+	}
+}
+
 var upgrader = websocket.Upgrader{
 	HandshakeTimeout: shellconsts.ShellHandshakeTimeout,
 	ReadBufferSize:   shellconsts.ShellMaxMessageSize,
@@ -530,6 +659,55 @@ func (s *MetaService) ExecShell(command []string, tty bool) (engines.Shell, erro
 	return Shell, Err
 }
 
+var portUpgrader = websocket.Upgrader{
+	HandshakeTimeout: displayconsts.DisplayHandshakeTimeout,
+	ReadBufferSize:   displayconsts.DisplayMaxMessageSize,
+	WriteBufferSize:  displayconsts.DisplayMaxMessageSize,
+}
+
+// DialPort will send an action to guest-tools to connect to a TCP port inside
+// the virtual machine, then wait for guest-tools to callback and establish a
+// websocket that is exposed as an io.ReadWriteCloser.
+//
+// If guest-tools fails to connect to the port engines.ErrNoSuchPort is
+// returned.
+func (s *MetaService) DialPort(port int) (io.ReadWriteCloser, error) {
+	var (
+		Conn io.ReadWriteCloser
+		Err  error
+	)
+	Err = runtime.ErrNonFatalInternalError
+
+	s.asyncRequest(Action{
+		Type: "dial-port",
+		Port: port,
+	}, func(w http.ResponseWriter, r *http.Request) {
+		// If this isn't a websocket upgrade, guest-tools failed to connect
+		if !websocket.IsWebSocketUpgrade(r) {
+			if !forceMethod(w, r, http.MethodPost) {
+				return
+			}
+			reply(w, http.StatusOK, nil)
+			if r.Header.Get("X-Taskcluster-Worker-Error") == "port-not-found" {
+				Err = engines.ErrNoSuchPort
+			}
+			return
+		}
+
+		ws, err := portUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			debug("Failed to upgrade request to websocket, error: %s", err)
+			Err = runtime.ErrNonFatalInternalError
+			return
+		}
+
+		Conn = displayclient.New(ws)
+		Err = nil
+	})
+
+	return Conn, Err
+}
+
 func (s *MetaService) killProcessWithoutRetries() error {
 	Err := runtime.ErrNonFatalInternalError
 
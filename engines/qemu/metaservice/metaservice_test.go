@@ -348,3 +348,23 @@ func TestMetaServiceShell(t *testing.T) {
 	nilOrFatal(t, err, "Failed to readAll from stderr")
 	assert(t, string(b) == "", "Failed to read ''")
 }
+
+func TestMetaServiceMounts(t *testing.T) {
+	s := New([]string{"true"}, make(map[string]string), bytes.NewBuffer(nil), func(r bool) {}, &runtime.Environment{})
+	s.SetMounts([]Mount{{
+		Type:       MountTypeDisk,
+		Tag:        "volume-0",
+		Mountpoint: "/mnt/cache",
+	}})
+
+	req, err := http.NewRequest("GET", "http://169.254.169.254/engine/v1/execute", nil)
+	nilOrFatal(t, err)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	assert(t, w.Code == http.StatusOK)
+
+	var e Execute
+	nilOrFatal(t, json.Unmarshal(w.Body.Bytes(), &e))
+	assert(t, len(e.Mounts) == 1, "Expected one mount")
+	assert(t, e.Mounts[0].Tag == "volume-0" && e.Mounts[0].Mountpoint == "/mnt/cache")
+}
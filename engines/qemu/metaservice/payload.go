@@ -1,9 +1,36 @@
 package metaservice
 
+import "time"
+
+// SerialPortName is the name of the virtio-serial port over which the
+// meta-data service is also exposed, for guests without networking. Streams
+// are multiplexed over the port using serialmux.
+const SerialPortName = "org.taskcluster.metaservice"
+
 // Execute is the response payload for the /engine/v1/execute end-point.
 type Execute struct {
 	Env     map[string]string `json:"env"`
 	Command []string          `json:"command"`
+	Mounts  []Mount           `json:"mounts,omitempty"`
+	// Time on the host, guests restored from saved state must set their clock.
+	Time time.Time `json:"time"`
+}
+
+// Types of volumes for the Mount struct.
+const (
+	MountTypeFolder = "folder" // Host folder shared using virtio-9p
+	MountTypeDisk   = "disk"   // qcow2 disk attached as virtio-blk device
+	MountTypeMemory = "memory" // tmpfs in the guest, with a maximum size
+)
+
+// Mount is a volume that must be mounted in the guest before the command is
+// executed.
+type Mount struct {
+	Type       string `json:"type"`       // folder, disk or memory
+	Tag        string `json:"tag"`        // mount tag for folder, serial for disk
+	Mountpoint string `json:"mountpoint"` // absolute path in the guest
+	ReadOnly   bool   `json:"readOnly"`
+	Size       int    `json:"size,omitempty"` // maximum size in MiB for memory
 }
 
 // List of API error codes for using the Error struct.
@@ -25,12 +52,25 @@ type Error struct {
 // Action is the response payload for the /engine/v1/poll end-point.
 type Action struct {
 	ID      string   `json:"id"`      // id, to be used when replying
-	Type    string   `json:"type"`    // none, get-artifact, list-folder, exec-shell, kill-process
-	Path    string   `json:"path"`    // file path, if get-artifact/list-folder
+	Type    string   `json:"type"`    // none, get-artifact, get-archive, list-folder, put-file, exec-shell, dial-port, kill-process
+	Path    string   `json:"path"`    // file path, if get-artifact/get-archive/list-folder/put-file
 	Command []string `json:"command"` // Command for exec-shell
 	TTY     bool     `json:"tty"`     // TTY or not for exec-shell
+	Port    int      `json:"port"`    // TCP port for dial-port
 }
 
+// Optional action types, guest-tools must list the optional action types they
+// support in the 'supports' querystring parameter when polling, as in
+// /engine/v1/poll?supports=get-archive. This way the meta-data service won't
+// send actions that older guest-tools don't understand.
+const (
+	// ActionGetArchive asks guest-tools to reply with a tar archive of the folder
+	// at Path, or the working directory if Path is empty. The archive contains
+	// directories, plain files and symbolic links, with paths relative to the
+	// folder.
+	ActionGetArchive = "get-archive"
+)
+
 // Files is the request payload for the /engine/v1/list-folder end-point.
 type Files struct {
 	Files    []string `json:"files"`    // List of absolute file paths
@@ -0,0 +1,167 @@
+package network
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/network/openvpn"
+)
+
+// Egress policies restricting outgoing connections from a Network.
+const (
+	EgressAllow     = "allow"      // Internet and VPN routes, this is the default
+	EgressAllowlist = "allowlist"  // Only networks in EgressPolicy.Allow
+	EgressProxyOnly = "proxy-only" // Nothing, except DNS and the meta-data service
+	EgressDeny      = "deny"       // Nothing, proxies must also be disabled
+)
+
+// EgressPolicy restricts outgoing connections forwarded from a Network.
+//
+// Connections to the meta-data service and DHCP are always allowed, as these
+// are served by the worker. Hence, proxies exposed through the meta-data
+// service remain reachable unless disabled by the caller. DNS is allowed,
+// unless the policy is EgressDeny, as DNS queries are forwarded by the worker.
+//
+// Networks in the allowlist are allowed even if they are private networks,
+// which are otherwise always rejected, see Pool.RestrictedNetworks(). Callers
+// must ensure such networks are only allowed for trusted tasks.
+type EgressPolicy struct {
+	Policy string       // One of EgressAllow, EgressAllowlist, ...
+	Allow  []*net.IPNet // Networks allowed, when Policy is EgressAllowlist
+}
+
+// ResolveEgressAllowlist parses a list of CIDRs, IP addresses and hostnames
+// into a list of networks. Hostnames are resolved when this is called, so
+// hostnames with frequently changing IP addresses can't be reliably allowed.
+func ResolveEgressAllowlist(entries []string) ([]*net.IPNet, error) {
+	var result []*net.IPNet
+	for _, entry := range entries {
+		if _, ipnet, err := net.ParseCIDR(entry); err == nil {
+			result = append(result, ipnet)
+			continue
+		}
+		ips := []net.IP{net.ParseIP(entry)}
+		if ips[0] == nil {
+			var err error
+			if ips, err = net.LookupIP(entry); err != nil {
+				return nil, fmt.Errorf("unable to resolve hostname '%s', error: %s", entry, err)
+			}
+		}
+		for _, ip := range ips {
+			if ipv4 := ip.To4(); ipv4 != nil {
+				result = append(result, &net.IPNet{IP: ipv4, Mask: net.CIDRMask(32, 32)})
+			} else {
+				result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+			}
+		}
+	}
+	return result, nil
+}
+
+// privateNetworks are the IPv4 networks rejected by ipTableRules()
+var privateNetworks = []string{"10.0.0.0/8", "172.16.0.0/12", "169.254.0.0/16", "192.168.0.0/16"}
+
+// linkLocalIPv6 is the IPv6 range always rejected by ip6TableRules()
+const linkLocalIPv6 = "fe80::/10"
+
+// RestrictedNetworks returns the networks virtual machines can't connect to,
+// unless the network is in the allowlist of an EgressAllowlist policy. These
+// are private and link-local networks, and the IPv6 ranges rejected by the
+// pool configuration.
+func (p *Pool) RestrictedNetworks() []*net.IPNet {
+	var result []*net.IPNet
+	for _, cidr := range append(append(append([]string{}, privateNetworks...), linkLocalIPv6), p.reject6...) {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(fmt.Sprintf("invalid restricted network: %s, error: %s", cidr, err))
+		}
+		result = append(result, ipnet)
+	}
+	return result
+}
+
+// Overlaps returns true, if ipnet has addresses in common with any of the
+// networks given.
+func Overlaps(ipnet *net.IPNet, networks []*net.IPNet) bool {
+	for _, n := range networks {
+		if n.Contains(ipnet.IP) || ipnet.Contains(n.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// egressChain returns the name of the iptables chain with egress rules for
+// traffic forwarded from tapDevice.
+func egressChain(tapDevice string) string {
+	return "egress_" + tapDevice
+}
+
+// egressInputChain returns the name of the iptables chain with egress rules for
+// traffic from tapDevice to the worker.
+func egressInputChain(tapDevice string) string {
+	return "egress_in_" + tapDevice
+}
+
+// egressRules returns commands that replace the rules in the egress chains for
+// tapDevice, such that policy is enforced. If ipv6 is true, rules are also
+// created for the ip6tables egress chains.
+//
+// Networks in the allowlist are accepted before the private networks rejected
+// by ipTableRules(), but only when forwarded to eth0 or one of the vpns (IPv4
+// only). All other allowed traffic returns to the forwarding chain, so the
+// restrictions from ipTableRules() still apply.
+func egressRules(tapDevice string, policy EgressPolicy, vpns []*openvpn.VPN, ipv6 bool) [][]string {
+	chain := egressChain(tapDevice)
+	inputChain := egressInputChain(tapDevice)
+	iptables := []string{"iptables", "-w", xtableLockWait}
+	ip6tables := []string{"ip6tables", "-w", xtableLockWait}
+	cmds := [][]string{
+		append(iptables, "-F", chain),
+		append(iptables, "-F", inputChain),
+	}
+	if ipv6 {
+		cmds = append(cmds, append(ip6tables, "-F", chain))
+		cmds = append(cmds, append(ip6tables, "-F", inputChain))
+	}
+	if policy.Policy == EgressAllow {
+		return cmds
+	}
+	if policy.Policy == EgressDeny {
+		// Reject DNS, as queries are forwarded by the worker
+		for _, proto := range []string{"udp", "tcp"} {
+			cmds = append(cmds, append(iptables,
+				"-A", inputChain, "-p", proto, "-m", proto, "--dport", "53",
+				"-j", "REJECT", "--reject-with", "icmp-port-unreachable",
+			))
+			if ipv6 {
+				cmds = append(cmds, append(ip6tables,
+					"-A", inputChain, "-p", proto, "-m", proto, "--dport", "53",
+					"-j", "REJECT", "--reject-with", "icmp6-port-unreachable",
+				))
+			}
+		}
+	}
+	if policy.Policy == EgressAllowlist {
+		devices := []string{"eth0"}
+		for _, vpn := range vpns {
+			devices = append(devices, vpn.DeviceName())
+		}
+		for _, ipnet := range policy.Allow {
+			if ipnet.IP.To4() != nil {
+				for _, device := range devices {
+					cmds = append(cmds, append(iptables, "-A", chain, "-d", ipnet.String(), "-o", device, "-j", "ACCEPT"))
+				}
+			} else if ipv6 {
+				cmds = append(cmds, append(ip6tables, "-A", chain, "-d", ipnet.String(), "-o", "eth0", "-j", "ACCEPT"))
+			} else {
+				debug("Skipping IPv6 network in egress allowlist: %s", ipnet.String())
+			}
+		}
+	}
+	cmds = append(cmds, append(iptables, "-A", chain, "-j", "REJECT", "--reject-with", "icmp-net-prohibited"))
+	if ipv6 {
+		cmds = append(cmds, append(ip6tables, "-A", chain, "-j", "REJECT", "--reject-with", "icmp6-adm-prohibited"))
+	}
+	return cmds
+}
@@ -0,0 +1,128 @@
+package network
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestResolveEgressAllowlist(t *testing.T) {
+	nets, err := ResolveEgressAllowlist([]string{"10.0.0.0/8", "1.2.3.4", "localhost"})
+	nilOrFatal(t, err, "failed to resolve allowlist")
+	assert(t, len(nets) >= 3, "expected at-least 3 networks, got: ", nets)
+	assert(t, nets[0].String() == "10.0.0.0/8", "unexpected network: ", nets[0])
+	assert(t, nets[1].String() == "1.2.3.4/32", "unexpected network: ", nets[1])
+	hasLocalhost := false
+	for _, ipnet := range nets[2:] {
+		hasLocalhost = hasLocalhost || ipnet.String() == "127.0.0.1/32"
+	}
+	assert(t, hasLocalhost, "expected 127.0.0.1/32 in networks, got: ", nets)
+
+	nets, err = ResolveEgressAllowlist([]string{"2001:db8::/32", "2001:db8::1"})
+	nilOrFatal(t, err, "failed to resolve allowlist")
+	assert(t, nets[0].String() == "2001:db8::/32", "unexpected network: ", nets[0])
+	assert(t, nets[1].String() == "2001:db8::1/128", "unexpected network: ", nets[1])
+
+	_, err = ResolveEgressAllowlist([]string{"no-such-host.invalid"})
+	assert(t, err != nil, "expected error for unresolvable hostname")
+}
+
+func TestEgressRules(t *testing.T) {
+	iptables := []string{"iptables", "-w", xtableLockWait}
+	ip6tables := []string{"ip6tables", "-w", xtableLockWait}
+	rule := func(prefix []string, args ...string) []string {
+		return append(append([]string{}, prefix...), args...)
+	}
+	flush := [][]string{
+		rule(iptables, "-F", "egress_tap0"),
+		rule(iptables, "-F", "egress_in_tap0"),
+	}
+	reject := rule(iptables, "-A", "egress_tap0", "-j", "REJECT", "--reject-with", "icmp-net-prohibited")
+
+	cmds := egressRules("tap0", EgressPolicy{Policy: EgressAllow}, nil, false)
+	assert(t, reflect.DeepEqual(cmds, flush), "unexpected rules: ", cmds)
+
+	cmds = egressRules("tap0", EgressPolicy{Policy: EgressProxyOnly}, nil, false)
+	assert(t, reflect.DeepEqual(cmds, append(flush, reject)), "unexpected rules: ", cmds)
+
+	cmds = egressRules("tap0", EgressPolicy{Policy: EgressDeny}, nil, false)
+	assert(t, reflect.DeepEqual(cmds, append(flush,
+		rule(iptables, "-A", "egress_in_tap0", "-p", "udp", "-m", "udp", "--dport", "53", "-j", "REJECT", "--reject-with", "icmp-port-unreachable"),
+		rule(iptables, "-A", "egress_in_tap0", "-p", "tcp", "-m", "tcp", "--dport", "53", "-j", "REJECT", "--reject-with", "icmp-port-unreachable"),
+		reject,
+	)), "unexpected rules: ", cmds)
+
+	_, ipnet, _ := net.ParseCIDR("10.1.0.0/16")
+	_, ipnet6, _ := net.ParseCIDR("2001:db8::/32")
+	allowlist := EgressPolicy{Policy: EgressAllowlist, Allow: []*net.IPNet{ipnet, ipnet6}}
+	cmds = egressRules("tap0", allowlist, nil, false)
+	assert(t, reflect.DeepEqual(cmds, append(flush,
+		rule(iptables, "-A", "egress_tap0", "-d", "10.1.0.0/16", "-o", "eth0", "-j", "ACCEPT"),
+		reject,
+	)), "unexpected rules: ", cmds)
+
+	cmds = egressRules("tap0", allowlist, nil, true)
+	assert(t, reflect.DeepEqual(cmds, [][]string{
+		flush[0],
+		flush[1],
+		rule(ip6tables, "-F", "egress_tap0"),
+		rule(ip6tables, "-F", "egress_in_tap0"),
+		rule(iptables, "-A", "egress_tap0", "-d", "10.1.0.0/16", "-o", "eth0", "-j", "ACCEPT"),
+		rule(ip6tables, "-A", "egress_tap0", "-d", "2001:db8::/32", "-o", "eth0", "-j", "ACCEPT"),
+		reject,
+		rule(ip6tables, "-A", "egress_tap0", "-j", "REJECT", "--reject-with", "icmp6-adm-prohibited"),
+	}), "unexpected rules: ", cmds)
+}
+
+func TestRestrictedNetworks(t *testing.T) {
+	p := &Pool{reject6: []string{"fc00::/7"}}
+	restricted := p.RestrictedNetworks()
+	for _, cidr := range []string{"10.1.0.0/16", "192.168.1.1/32", "169.254.169.254/32", "0.0.0.0/0", "fd00::/8", "fe80::1/128"} {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		assert(t, Overlaps(ipnet, restricted), "expected ", cidr, " to be restricted")
+	}
+	for _, cidr := range []string{"1.2.3.4/32", "8.8.0.0/16", "2001:db8::/32"} {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		assert(t, !Overlaps(ipnet, restricted), "expected ", cidr, " not to be restricted")
+	}
+}
+
+func TestIPTableRulesAllowlistBeforePrivateSubnets(t *testing.T) {
+	// The egress chain must be applied before private subnets are rejected, so
+	// that networks in the allowlist are accepted.
+	egress, private := -1, -1
+	for i, cmd := range ipTableRules("tap0", "192.168.150", nil, false) {
+		if egress == -1 && reflect.DeepEqual(cmd[len(cmd)-2:], []string{"-j", "egress_tap0"}) {
+			egress = i
+		}
+		if private == -1 && len(cmd) > 6 && cmd[5] == "-d" && cmd[6] == "10.0.0.0/8" {
+			private = i
+		}
+	}
+	assert(t, egress != -1 && private != -1, "expected egress and private subnet rules")
+	assert(t, egress < private, "expected egress chain before private subnet rules")
+}
+
+func TestIP6TableRulesRejectRanges(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("fd42::/64")
+	hasRule := func(cmds [][]string, rule ...string) bool {
+		for _, cmd := range cmds {
+			if len(cmd) >= len(rule) && reflect.DeepEqual(cmd[len(cmd)-len(rule):], rule) {
+				return true
+			}
+		}
+		return false
+	}
+
+	cmds := ip6TableRules("tap0", subnet, []string{"fc00::/7"}, false)
+	assert(t, hasRule(cmds, "fwd_input_tap0", "-d", "fc00::/7", "-j", "REJECT", "--reject-with", "icmp6-no-route"),
+		"expected fc00::/7 to be rejected")
+	assert(t, hasRule(cmds, "fwd_output_tap0", "-s", "fc00::/7", "-j", "DROP"),
+		"expected fc00::/7 to be dropped")
+
+	cmds = ip6TableRules("tap0", subnet, nil, false)
+	assert(t, !hasRule(cmds, "fwd_input_tap0", "-d", "fc00::/7", "-j", "REJECT", "--reject-with", "icmp6-no-route"),
+		"expected fc00::/7 not to be rejected")
+	assert(t, hasRule(cmds, "fwd_input_tap0", "-d", "fe80::/10", "-j", "REJECT", "--reject-with", "icmp6-no-route"),
+		"expected link-local addresses to be rejected")
+}
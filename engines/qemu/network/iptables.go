@@ -1,6 +1,10 @@
 package network
 
-import "github.com/taskcluster/taskcluster-worker/engines/qemu/network/openvpn"
+import (
+	"net"
+
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/network/openvpn"
+)
 
 // Maximum time to wait for the xtables lock when using iptables
 const xtableLockWait = "3"
@@ -15,6 +19,8 @@ const xtableLockWait = "3"
 // * DHCP server (dnsmasq)
 // * Routes connected through VPN
 // * The public IPv4 internet address
+// Traffic from the VM is first passed through the egress chains, which are
+// empty unless restricted by Network.SetEgressPolicy().
 // In particular we wish to forbid access to other VMs, IP spoofing, and
 // connections other resources within the private network the worker is
 // deployed in.
@@ -42,6 +48,8 @@ func ipTableRules(tapDevice string, ipPrefix string, vpns []*openvpn.VPN, delete
 		{"output_" + tapDevice},
 		{"fwd_input_" + tapDevice},
 		{"fwd_output_" + tapDevice},
+		{egressChain(tapDevice)},
+		{egressInputChain(tapDevice)},
 	})
 
 	// Rules for jumping to custom chains for this tap device
@@ -59,6 +67,8 @@ func ipTableRules(tapDevice string, ipPrefix string, vpns []*openvpn.VPN, delete
 
 	// Rules for filtering INPUT from this tap device
 	inputRules := prefixCommands([]string{"iptables", "-w", xtableLockWait, ruleAction, "input_" + tapDevice}, [][]string{
+		// Apply egress policy first, this may reject or return
+		{"-s", subnet, "-j", egressInputChain(tapDevice)},
 		// Allow requests to meta-data service (from subnet only)
 		{"-p", "tcp", "-s", subnet, "-d", metaDataIP, "-m", "tcp", "--dport", "80", "-m", "state", "--state", "NEW,ESTABLISHED", "-j", "ACCEPT"},
 		// Allow DNS requests
@@ -108,16 +118,23 @@ func ipTableRules(tapDevice string, ipPrefix string, vpns []*openvpn.VPN, delete
 		}
 	}
 
+	// Rules rejecting traffic to and from private subnets
+	rejectRules := [][]string{} // Will be inserted in fwd_input_...
+	dropRules := [][]string{}   // Will be inserted in fwd_output_...
+	for _, r := range privateNetworks {
+		rejectRules = append(rejectRules, []string{"-d", r, "-j", "REJECT", "--reject-with", "icmp-net-unreachable"})
+		dropRules = append(dropRules, []string{"-s", r, "-j", "DROP"})
+	}
+
 	// Rules for filtering FORWARD from this tap device
-	forwardInputRules := prefixCommands([]string{"iptables", "-w", xtableLockWait, ruleAction, "fwd_input_" + tapDevice}, append(
+	forwardInputRules := prefixCommands([]string{"iptables", "-w", xtableLockWait, ruleAction, "fwd_input_" + tapDevice}, append(append(append(
+		// Apply egress policy first, this may accept, reject or return
+		[][]string{{"-s", subnet, "-j", egressChain(tapDevice)}},
 		// Allow tap device -> VPN
-		forwardVPNInputRules,
+		forwardVPNInputRules...),
+		// Reject out-going from this tap device to private subnets
+		rejectRules...),
 		[][]string{
-			// Reject out-going from this tap device to private subnets
-			{"-d", "10.0.0.0/8", "-j", "REJECT", "--reject-with", "icmp-net-unreachable"},
-			{"-d", "172.16.0.0/12", "-j", "REJECT", "--reject-with", "icmp-net-unreachable"},
-			{"-d", "169.254.0.0/16", "-j", "REJECT", "--reject-with", "icmp-net-unreachable"},
-			{"-d", "192.168.0.0/16", "-j", "REJECT", "--reject-with", "icmp-net-unreachable"},
 			// Allow out-going from this tap device with correct source subnet
 			{"-o", "eth0", "-s", subnet, "-j", "ACCEPT"},
 			// Allow tap device -> tap device within allowed subnet
@@ -128,15 +145,15 @@ func ipTableRules(tapDevice string, ipPrefix string, vpns []*openvpn.VPN, delete
 	))
 
 	// Rules for filtering FORWARD to this tap device
-	forwardOutputRules := prefixCommands([]string{"iptables", "-w", xtableLockWait, ruleAction, "fwd_output_" + tapDevice}, append(
+	forwardOutputRules := prefixCommands([]string{"iptables", "-w", xtableLockWait, ruleAction, "fwd_output_" + tapDevice}, append(append(append(
+		// Allow replies to connections accepted by fwd_input_..., including
+		// connections to private networks allowed by the egress policy
+		[][]string{{"-d", subnet, "-m", "state", "--state", "ESTABLISHED", "-j", "ACCEPT"}},
 		// Allow VPN -> tap device, if already established
-		forwardVPNOutputRules,
+		forwardVPNOutputRules...),
+		// Reject incoming from private subnets to this tap device
+		dropRules...),
 		[][]string{
-			// Reject incoming from private subnets to this tap device
-			{"-s", "10.0.0.0/8", "-j", "DROP"},
-			{"-s", "172.16.0.0/12", "-j", "DROP"},
-			{"-s", "169.254.0.0/16", "-j", "DROP"},
-			{"-s", "192.168.0.0/16", "-j", "DROP"},
 			// Allow incoming from this tap device with correct destination (if already established)
 			{"-i", "eth0", "-d", subnet, "-m", "state", "--state", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
 			// Allow tap device -> tap device within allowed subnet
@@ -157,7 +174,161 @@ func ipTableRules(tapDevice string, ipPrefix string, vpns []*openvpn.VPN, delete
 		cmds = append(cmds, forwardInputRules...)
 	} else {
 		// Reverse order when deleting, because we can't delete chains that are
-		// referenced by a rule
+		// referenced by a rule, nor chains that aren't empty
+		cmds = append(cmds, []string{"iptables", "-w", xtableLockWait, "-F", egressChain(tapDevice)})
+		cmds = append(cmds, []string{"iptables", "-w", xtableLockWait, "-F", egressInputChain(tapDevice)})
+		cmds = append(cmds, forwardInputRules...)
+		cmds = append(cmds, forwardOutputRules...)
+		cmds = append(cmds, outputRules...)
+		cmds = append(cmds, inputRules...)
+		cmds = append(cmds, rules...)
+		cmds = append(cmds, chains...)
+		cmds = append(cmds, nat...)
+	}
+
+	return cmds
+}
+
+// ip6TableRules returns a list of commands to append rules for tapDevice, with
+// subnet being the IPv6 subnet for the VM and reject the ranges the VM can't
+// connect to. If delete=true, this returns the commands to delete the rules.
+//
+// These rules mirror the rules from ipTableRules, such that a VM exposed on
+// tapDevice is restricted to IPs from subnet and can access:
+// * Metadata service at metaDataIPv6 on port 80
+// * DNS server (dnsmasq)
+// * DHCPv6 server and router advertisements (dnsmasq)
+// * The public IPv6 internet
+// Ranges in reject (unique local addresses by default) and link-local addresses
+// are forbidden, as are routes through VPNs as these are only supported for
+// IPv4.
+func ip6TableRules(tapDevice string, subnet *net.IPNet, reject []string, delete bool) [][]string {
+	sub := subnet.String()
+	gateway := ipv6Gateway(subnet).String()
+	prefixCommands := func(prefix []string, rules [][]string) [][]string {
+		cmds := [][]string{}
+		for _, rule := range rules {
+			cmds = append(cmds, append(prefix, rule...))
+		}
+		return cmds
+	}
+
+	ruleAction := "-A"
+	chainAction := "-N"
+	if delete {
+		ruleAction = "-D"
+		chainAction = "-X"
+	}
+
+	// Create/delete custom chains for this tap device
+	chains := prefixCommands([]string{"ip6tables", "-w", xtableLockWait, chainAction}, [][]string{
+		{"input_" + tapDevice},
+		{"output_" + tapDevice},
+		{"fwd_input_" + tapDevice},
+		{"fwd_output_" + tapDevice},
+		{egressChain(tapDevice)},
+		{egressInputChain(tapDevice)},
+	})
+
+	// Rules for jumping to custom chains for this tap device
+	rules := prefixCommands([]string{"ip6tables", "-w", xtableLockWait, ruleAction}, [][]string{
+		{"INPUT", "-i", tapDevice, "-j", "input_" + tapDevice},
+		{"OUTPUT", "-o", tapDevice, "-j", "output_" + tapDevice},
+		{"FORWARD", "-i", tapDevice, "-j", "fwd_input_" + tapDevice},
+		{"FORWARD", "-o", tapDevice, "-j", "fwd_output_" + tapDevice},
+	})
+
+	// Rules for nat from this subnet
+	nat := prefixCommands([]string{"ip6tables", "-w", xtableLockWait, "-t", "nat", ruleAction}, [][]string{
+		{"POSTROUTING", "-o", "eth0", "-s", sub, "-j", "MASQUERADE"},
+	})
+
+	// Rules for filtering INPUT from this tap device
+	inputRules := prefixCommands([]string{"ip6tables", "-w", xtableLockWait, ruleAction, "input_" + tapDevice}, [][]string{
+		// Allow neighbor discovery and router solicitation
+		{"-p", "icmpv6", "-j", "ACCEPT"},
+		// Apply egress policy, this may reject or return
+		{"-s", sub, "-j", egressInputChain(tapDevice)},
+		// Allow requests to meta-data service (from subnet only)
+		{"-p", "tcp", "-s", sub, "-d", metaDataIPv6, "-m", "tcp", "--dport", "80", "-m", "state", "--state", "NEW,ESTABLISHED", "-j", "ACCEPT"},
+		// Allow DNS requests
+		{"-p", "tcp", "-s", sub, "-d", gateway, "-m", "tcp", "--dport", "53", "-m", "state", "--state", "NEW,ESTABLISHED", "-j", "ACCEPT"},
+		{"-p", "udp", "-s", sub, "-d", gateway, "-m", "udp", "--dport", "53", "-m", "state", "--state", "NEW,ESTABLISHED", "-j", "ACCEPT"},
+		// Allow DHCPv6 requests
+		{"-p", "udp", "-m", "udp", "--sport", "546", "--dport", "547", "-j", "ACCEPT"},
+		// Reject all other input (with special case for wrong port on meta-data service)
+		{"-s", sub, "-d", metaDataIPv6, "-j", "REJECT", "--reject-with", "icmp6-port-unreachable"},
+		{"-j", "REJECT", "--reject-with", "icmp6-addr-unreachable"},
+	})
+
+	// Rules for filtering OUTPUT to this tap device
+	outputRules := prefixCommands([]string{"ip6tables", "-w", xtableLockWait, ruleAction, "output_" + tapDevice}, [][]string{
+		// Allow neighbor discovery and router advertisements
+		{"-p", "icmpv6", "-j", "ACCEPT"},
+		// Allow meta-data replies (to subnet only)
+		{"-p", "tcp", "-s", metaDataIPv6, "-d", sub, "-m", "tcp", "--sport", "80", "-m", "state", "--state", "ESTABLISHED", "-j", "ACCEPT"},
+		// Allow DNS replies from dnsmasq (to subnet only)
+		{"-p", "udp", "-s", gateway, "-d", sub, "-m", "udp", "--sport", "53", "-m", "state", "--state", "ESTABLISHED", "-j", "ACCEPT"},
+		{"-p", "tcp", "-s", gateway, "-d", sub, "-m", "tcp", "--sport", "53", "-m", "state", "--state", "ESTABLISHED", "-j", "ACCEPT"},
+		// Allow DHCPv6 replies
+		{"-p", "udp", "-m", "udp", "--sport", "547", "--dport", "546", "-j", "ACCEPT"},
+		// Reject all other output
+		{"-j", "REJECT", "--reject-with", "icmp6-adm-prohibited"},
+	})
+
+	// Rules rejecting traffic to and from rejected ranges
+	rejectRules := [][]string{} // Will be inserted in fwd_input_...
+	dropRules := [][]string{}   // Will be prepended fwd_output_...
+	for _, r := range reject {
+		rejectRules = append(rejectRules, []string{"-d", r, "-j", "REJECT", "--reject-with", "icmp6-no-route"})
+		dropRules = append(dropRules, []string{"-s", r, "-j", "DROP"})
+	}
+
+	// Rules for filtering FORWARD from this tap device
+	forwardInputRules := prefixCommands([]string{"ip6tables", "-w", xtableLockWait, ruleAction, "fwd_input_" + tapDevice}, append(append(
+		// Apply egress policy first, this may accept, reject or return
+		[][]string{{"-s", sub, "-j", egressChain(tapDevice)}},
+		// Reject out-going from this tap device to rejected ranges
+		rejectRules...),
+		[][]string{
+			// Reject out-going from this tap device to link-local addresses
+			{"-d", linkLocalIPv6, "-j", "REJECT", "--reject-with", "icmp6-no-route"},
+			// Allow out-going from this tap device with correct source subnet
+			{"-o", "eth0", "-s", sub, "-j", "ACCEPT"},
+			// Reject all other input for forwarding from tap-device
+			{"-j", "REJECT", "--reject-with", "icmp6-adm-prohibited"},
+		}...,
+	))
+
+	// Rules for filtering FORWARD to this tap device
+	forwardOutputRules := prefixCommands([]string{"ip6tables", "-w", xtableLockWait, ruleAction, "fwd_output_" + tapDevice}, append(append(
+		// Allow replies to connections accepted by fwd_input_..., including
+		// connections to rejected ranges allowed by the egress policy
+		[][]string{{"-d", sub, "-m", "state", "--state", "ESTABLISHED", "-j", "ACCEPT"}},
+		// Reject incoming from rejected ranges to this tap device
+		dropRules...),
+		[][]string{
+			// Allow incoming to this tap device with correct destination (if already established)
+			{"-i", "eth0", "-d", sub, "-m", "state", "--state", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+			// Reject all other output from forwarding to tap-device
+			{"-j", "DROP"},
+		}...,
+	))
+
+	cmds := [][]string{}
+	if !delete {
+		cmds = append(cmds, nat...)
+		cmds = append(cmds, chains...)
+		cmds = append(cmds, rules...)
+		cmds = append(cmds, inputRules...)
+		cmds = append(cmds, outputRules...)
+		cmds = append(cmds, forwardOutputRules...)
+		cmds = append(cmds, forwardInputRules...)
+	} else {
+		// Reverse order when deleting, because we can't delete chains that are
+		// referenced by a rule, nor chains that aren't empty
+		cmds = append(cmds, []string{"ip6tables", "-w", xtableLockWait, "-F", egressChain(tapDevice)})
+		cmds = append(cmds, []string{"ip6tables", "-w", xtableLockWait, "-F", egressInputChain(tapDevice)})
 		cmds = append(cmds, forwardInputRules...)
 		cmds = append(cmds, forwardOutputRules...)
 		cmds = append(cmds, outputRules...)
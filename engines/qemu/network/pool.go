@@ -3,10 +3,11 @@ package network
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os/exec"
-	"regexp"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -23,28 +24,34 @@ import (
 
 const metaDataIP = "169.254.169.254"
 
-var remoteAddrPattern = regexp.MustCompile(`^(192\.168\.\d{1,3})\.\d{1,3}:\d{1,5}$`)
+// metaDataIPv6 is the address of the meta-data service over IPv6, this is the
+// same unique local address as used by EC2.
+const metaDataIPv6 = "fd00:ec2::254"
 
 // Pool manages a static set of networks (TAP devices).
 type Pool struct {
-	m          sync.Mutex
-	networks   map[string]*entry // mapping from ip-prefix to entry
-	server     *graceful.Server
-	serverDone <-chan struct{} // closed when server is stopped
-	vpns       []*openvpn.VPN
-	dnsmasq    *exec.Cmd
-	disposing  atomics.Bool   // Set when we're disposing, before killing dnsmasq
-	disposed   sync.WaitGroup // Counts subprocesses, dnsmasq and vpns
+	m           sync.Mutex
+	networks    map[string]*entry // mapping from ip-prefix to entry
+	server      *graceful.Server
+	serverDone  <-chan struct{}  // closed when server is stopped
+	server6     *graceful.Server // meta-data server for IPv6, nil if disabled
+	server6Done <-chan struct{}  // closed when server6 is stopped
+	vpns        []*openvpn.VPN
+	reject6     []string // IPv6 ranges rejected by ip6TableRules()
+	dnsmasq     *exec.Cmd
+	disposing   atomics.Bool   // Set when we're disposing, before killing dnsmasq
+	disposed    sync.WaitGroup // Counts subprocesses, dnsmasq and vpns
 }
 
 // entry is a strictly internal presentation of a TAP device network.
 type entry struct {
-	tapDevice string
-	ipPrefix  string // 192.168.xxx (subnet without the last ".0")
-	m         sync.RWMutex
-	handler   http.Handler
-	pool      *Pool
-	inUse     bool
+	tapDevice  string
+	ipPrefix   string     // 192.168.xxx (subnet without the last ".0")
+	ipv6Subnet *net.IPNet // IPv6 /64 subnet, nil if IPv6 is disabled
+	m          sync.RWMutex
+	handler    http.Handler
+	pool       *Pool
+	inUse      bool
 }
 
 // PoolOptions specifies options required by NewPool
@@ -66,6 +73,29 @@ func NewPool(options PoolOptions) (*Pool, error) {
 		networks: make(map[string]*entry),
 	}
 
+	// Allocate subnets for the networks
+	if C.IPv4Range == "" {
+		C.IPv4Range = defaultIPv4Range
+	}
+	ipPrefixes, err := ipv4Subnets(C.IPv4Range, C.Subnets)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid network configuration")
+	}
+	subnets6 := make([]*net.IPNet, C.Subnets)
+	if C.IPv6Range != "" {
+		subnets6, err = ipv6Subnets(C.IPv6Range, C.Subnets)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid network configuration")
+		}
+	}
+	if C.IPv6Reject == nil {
+		C.IPv6Reject = defaultIPv6RejectRanges
+	}
+	p.reject6, err = ipv6RejectRanges(C.IPv6Reject)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid network configuration")
+	}
+
 	// Start VPN connections
 	p.vpns = make([]*openvpn.VPN, len(C.VPNs))
 	for i, cfg := range C.VPNs {
@@ -95,7 +125,7 @@ func NewPool(options PoolOptions) (*Pool, error) {
 	// Create a number of networks
 	for i := 0; i < C.Subnets; i++ {
 		// Construct the network object
-		n, err := createNetwork(i, p)
+		n, err := createNetwork(i, ipPrefixes[i], subnets6[i], p)
 		if err != nil {
 			return nil, err
 		}
@@ -103,13 +133,31 @@ func NewPool(options PoolOptions) (*Pool, error) {
 	}
 
 	// Enable IPv4 forwarding
-	err := script([][]string{
+	err = script([][]string{
 		{"sysctl", "-w", "net.ipv4.ip_forward=1"},
 	}, true)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to enable ipv4 forwarding: %s", err)
 	}
 
+	// Enable IPv6 forwarding, this disables router advertisements on eth0 unless
+	// accept_ra=2, so we set that to keep the default route for eth0
+	if C.IPv6Range != "" {
+		err = script([][]string{
+			{"sysctl", "-w", "net.ipv6.conf.eth0.accept_ra=2"},
+			{"sysctl", "-w", "net.ipv6.conf.all.forwarding=1"},
+		}, true)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to enable ipv6 forwarding: %s", err)
+		}
+	}
+
+	// Host record for the meta-data service
+	metaDataRecord := "host-record=taskcluster," + metaDataIP
+	if C.IPv6Range != "" {
+		metaDataRecord += "," + metaDataIPv6
+	}
+
 	// Create dnsmasq configuration
 	dnsmasqConfig := []string{
 		"strict-order",
@@ -117,7 +165,7 @@ func NewPool(options PoolOptions) (*Pool, error) {
 		"except-interface=lo",
 		"conf-file=\"\"",
 		"dhcp-no-override",
-		"host-record=taskcluster," + metaDataIP,
+		metaDataRecord,
 		"keep-in-foreground",
 		"bogus-priv",
 		"domain-needed",
@@ -139,7 +187,23 @@ func NewPool(options PoolOptions) (*Pool, error) {
 			}, ","),
 		)
 	}
+	if C.IPv6Range != "" {
+		// Send router advertisements
+		dnsmasqConfig = append(dnsmasqConfig, "enable-ra")
+	}
 	for _, n := range p.networks {
+		if n.ipv6Subnet != nil {
+			// Stateless DHCPv6, addresses are assigned using SLAAC
+			dnsmasqConfig = append(dnsmasqConfig,
+				"dhcp-range="+strings.Join([]string{
+					"tag:" + n.tapDevice,
+					n.ipv6Subnet.IP.String(),
+					"ra-stateless",
+					"64",
+					"20m",
+				}, ","),
+			)
+		}
 		dnsmasqConfig = append(dnsmasqConfig,
 			"interface="+n.tapDevice,
 			"dhcp-range="+strings.Join([]string{
@@ -191,37 +255,62 @@ func NewPool(options PoolOptions) (*Pool, error) {
 	}
 
 	// Create the server
-	serverDone := make(chan struct{})
-	p.serverDone = serverDone
-	p.server = &graceful.Server{
+	p.server, p.serverDone, err = p.serve(metaDataIP + ":80")
+	if err != nil {
+		// If this happens ensure that we have configured the loopback device with:
+		// sudo ip addr add 169.254.169.254/24 scope link dev lo
+		return nil, err
+	}
+
+	// Expose the meta-data service over IPv6, if enabled
+	if C.IPv6Range != "" {
+		// Address must not be tentative when we listen, hence, nodad
+		err = script([][]string{
+			{"ip", "-6", "addr", "add", metaDataIPv6 + "/128", "dev", "lo", "nodad"},
+		}, true)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to add: %s to the loopback device", metaDataIPv6)
+		}
+		p.server6, p.server6Done, err = p.serve("[" + metaDataIPv6 + "]:80")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// serve starts a meta-data server listening on addr, returns the server and a
+// channel that is closed when the server is stopped.
+func (p *Pool) serve(addr string) (*graceful.Server, <-chan struct{}, error) {
+	server := &graceful.Server{
 		Timeout: 30 * time.Second,
 		Server: &http.Server{
-			Addr:    metaDataIP + ":80",
+			Addr:    addr,
 			Handler: http.HandlerFunc(p.dispatchRequest),
 		},
 		NoSignalHandling: true,
 	}
 
 	// Start listening (we handle listener error as a special thing)
-	listener, err := net.Listen("tcp", p.server.Addr)
+	listener, err := net.Listen("tcp", addr)
 	if err != nil {
-		// If this happens ensure that we have configured the loopback device with:
-		// sudo ip addr add 169.254.169.254/24 scope link dev lo
-		return nil, errors.Wrapf(err, "Failed to listen on %s", p.server.Addr)
+		return nil, nil, errors.Wrapf(err, "Failed to listen on %s", addr)
 	}
 
 	// Start the server
-	go (func(p *Pool, done chan<- struct{}) {
-		err := p.server.Serve(listener)
+	done := make(chan struct{})
+	go (func() {
+		err := server.Serve(listener)
 		close(done)
 		if err != nil {
 			// TODO: We could communicate this to all sandboxes and shut them down
 			// gracefully. But I honestly doubt this will ever happen, why should it?
 			panic(fmt.Sprint("Fatal: meta-data service listener failed, error: ", err))
 		}
-	})(p, serverDone)
+	})()
 
-	return p, nil
+	return server, done, nil
 }
 
 // Size returns the number of networks in the network Pool
@@ -230,24 +319,14 @@ func (p *Pool) Size() int {
 }
 
 func (p *Pool) dispatchRequest(w http.ResponseWriter, r *http.Request) {
-	// Match remote address to find ipPrefix
-	match := remoteAddrPattern.FindStringSubmatch(r.RemoteAddr)
-	if len(match) != 2 {
+	// Find network from the remote address
+	n := p.lookupNetwork(r.RemoteAddr)
+	if n == nil {
 		debug("request from forbidden remote address: %s - %s %s",
 			r.RemoteAddr, r.Method, r.URL.String())
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
-	ipPrefix := match[1]
-
-	// Find network from the ipPrefix
-	n := p.networks[ipPrefix]
-	if n == nil {
-		debug("Request from ipPrefix: %s, not matching any network - %s %s",
-			ipPrefix, r.Method, r.URL.String())
-		w.WriteHeader(http.StatusForbidden)
-		return
-	}
 
 	// Read lock the network, so the handler can't be cleared while we do this
 	n.m.RLock()
@@ -264,6 +343,33 @@ func (p *Pool) dispatchRequest(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// lookupNetwork returns the network entry for a remote address, or nil if the
+// remote address isn't from any of the networks.
+func (p *Pool) lookupNetwork(remoteAddr string) *entry {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+
+	// Find IPv4 network from the ipPrefix
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ipPrefix := fmt.Sprintf("%d.%d.%d", ipv4[0], ipv4[1], ipv4[2])
+		return p.networks[ipPrefix]
+	}
+
+	// Find IPv6 network containing the address
+	for _, n := range p.networks {
+		if n.ipv6Subnet != nil && n.ipv6Subnet.Contains(ip) {
+			return n
+		}
+	}
+	return nil
+}
+
 // Network is provides the interface for using a TAP device, and releasing it.
 type Network struct {
 	m     sync.Mutex
@@ -293,6 +399,54 @@ func (n *Network) NetDev(ID string) string {
 	return "tap,id=" + ID + ",ifname=" + n.entry.tapDevice + ",script=no,downscript=no"
 }
 
+// SetEgressPolicy restricts outgoing connections from this network, until the
+// network is released.
+func (n *Network) SetEgressPolicy(policy EgressPolicy) error {
+	n.m.Lock()
+	defer n.m.Unlock()
+	if n.entry == nil {
+		panic("Network.SetEgressPolicy() called after Network.Release()")
+	}
+
+	err := script(egressRules(n.entry.tapDevice, policy, n.entry.pool.vpns, n.entry.ipv6Subnet != nil), false)
+	if err != nil {
+		return errors.Wrap(err, "failed to configure egress policy")
+	}
+	return nil
+}
+
+// Counters returns the number of bytes received and transmitted by the guest,
+// read from the statistics of the tap-device. Counters are never reset, so
+// callers must subtract the values read when the network was acquired.
+func (n *Network) Counters() (received, transmitted int64, err error) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	if n.entry == nil {
+		return 0, 0, errors.New("Network.Counters() called after Network.Release()")
+	}
+
+	// Bytes transmitted by the tap-device are received by the guest, and
+	// vice versa.
+	received, err = readStatistic(n.entry.tapDevice, "tx_bytes")
+	if err != nil {
+		return 0, 0, err
+	}
+	transmitted, err = readStatistic(n.entry.tapDevice, "rx_bytes")
+	if err != nil {
+		return 0, 0, err
+	}
+	return received, transmitted, nil
+}
+
+// readStatistic reads a statistics counter for the given network interface.
+func readStatistic(device, name string) (int64, error) {
+	data, err := ioutil.ReadFile(filepath.Join("/sys/class/net", device, "statistics", name))
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to read %s for %s", name, device)
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
 // Release returns this network to the Pool
 func (n *Network) Release() {
 	// Lock the wrapper
@@ -309,6 +463,12 @@ func (n *Network) Release() {
 	n.entry.handler = nil
 	n.entry.m.Unlock()
 
+	// Remove egress policy, so the network is unrestricted when reused
+	err := script(egressRules(n.entry.tapDevice, EgressPolicy{Policy: EgressAllow}, n.entry.pool.vpns, n.entry.ipv6Subnet != nil), false)
+	if err != nil {
+		debug("Failed to reset egress policy for %s, error: %s", n.entry.tapDevice, err)
+	}
+
 	// Set entry as idle
 	n.entry.pool.m.Lock()
 	n.entry.inUse = false
@@ -349,9 +509,13 @@ func (p *Pool) Dispose() error {
 		panic("networkPool.Dispose() cannot be called while a network is in use")
 	}
 
-	// Gracefully stop the server
+	// Gracefully stop the servers
 	p.server.Stop(500 * time.Millisecond)
 	<-p.serverDone
+	if p.server6 != nil {
+		p.server6.Stop(500 * time.Millisecond)
+		<-p.server6Done
+	}
 
 	// Indicate that error exit is expected, from dnsmasq
 	p.disposing.Set(true)
@@ -380,9 +544,13 @@ func (p *Pool) Dispose() error {
 	}
 
 	// Remove meta-data IP from loopback device
-	err := script([][]string{
+	cmds := [][]string{
 		{"ip", "addr", "del", metaDataIP, "dev", "lo"},
-	}, true)
+	}
+	if p.server6 != nil {
+		cmds = append(cmds, []string{"ip", "-6", "addr", "del", metaDataIPv6 + "/128", "dev", "lo"})
+	}
+	err := script(cmds, true)
 
 	return err
 }
@@ -390,11 +558,11 @@ func (p *Pool) Dispose() error {
 // createNetwork creates a tap device and related ip-tables configuration.
 // This does not start dnsmasq, use newNetworkPool() to create a set of
 // networks with dnsmasq running.
-func createNetwork(index int, parent *Pool) (*entry, error) {
-	// Each network has a name and an ip-prefix, we use the 192.168.0.0/16
-	// subnet starting from 192.168.150.0
+//
+// The ipv6Subnet may be nil, if IPv6 isn't enabled.
+func createNetwork(index int, ipPrefix string, ipv6Subnet *net.IPNet, parent *Pool) (*entry, error) {
+	// Each network has a name and an ip-prefix
 	tapDevice := "tctap" + strconv.Itoa(index)
-	ipPrefix := "192.168." + strconv.Itoa(index+150)
 
 	//err := createTAPDevice(tapDevice)
 	//if err != nil {
@@ -421,12 +589,28 @@ func createNetwork(index int, parent *Pool) (*entry, error) {
 		return nil, fmt.Errorf("Failed to setup ip-tables for tap device: %s error: %s", tapDevice, err)
 	}
 
+	// Assign IPv6 address and create ip6tables rules and chains
+	if ipv6Subnet != nil {
+		ones, _ := ipv6Subnet.Mask.Size()
+		err = script([][]string{
+			{"ip", "-6", "addr", "add", ipv6Gateway(ipv6Subnet).String() + "/" + strconv.Itoa(ones), "dev", tapDevice},
+		}, true)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to setup IPv6 for tap device: %s, error: %s", tapDevice, err)
+		}
+		err = script(ip6TableRules(tapDevice, ipv6Subnet, parent.reject6, false), false)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to setup ip6-tables for tap device: %s error: %s", tapDevice, err)
+		}
+	}
+
 	// Construct the network object
 	return &entry{
-		tapDevice: tapDevice,
-		ipPrefix:  ipPrefix,
-		handler:   nil,
-		pool:      parent,
+		tapDevice:  tapDevice,
+		ipPrefix:   ipPrefix,
+		ipv6Subnet: ipv6Subnet,
+		handler:    nil,
+		pool:       parent,
 	}, nil
 }
 
@@ -443,6 +627,12 @@ func destroyNetwork(n *entry) error {
 	if err != nil {
 		return fmt.Errorf("Failed to remove ip-tables for tap device: %s, error: %s", n.tapDevice, err)
 	}
+	if n.ipv6Subnet != nil {
+		err = script(ip6TableRules(n.tapDevice, n.ipv6Subnet, n.pool.reject6, true), false)
+		if err != nil {
+			return fmt.Errorf("Failed to remove ip6-tables for tap device: %s, error: %s", n.tapDevice, err)
+		}
+	}
 
 	err = script([][]string{
 		// Remove route for the network subnet
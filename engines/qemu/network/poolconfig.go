@@ -8,6 +8,9 @@ import (
 
 type poolConfig struct {
 	Subnets     int           `json:"subnets"`
+	IPv4Range   string        `json:"ipv4Range,omitempty"`
+	IPv6Range   string        `json:"ipv6Range,omitempty"`
+	IPv6Reject  []string      `json:"ipv6RejectRanges,omitempty"`
 	VPNs        []interface{} `json:"vpnConnections,omitempty"`
 	SRVRecords  []srvRecord   `json:"srvRecords,omitempty"`
 	HostRecords []hostRecord  `json:"hostRecords,omitempty"`
@@ -45,6 +48,48 @@ var PoolConfigSchema schematypes.Schema = schematypes.Object{
 			Minimum: 1,
 			Maximum: 100,
 		},
+		"ipv4Range": schematypes.String{
+			Title: "IPv4 Range",
+			Description: util.Markdown(`
+				Range from which a '/24' IPv4 subnet is allocated for each virtual
+				machine, given as '<first-subnet>/<prefix-length>'. Subnets are
+				allocated consecutively from the first subnet and must fit within the
+				prefix.
+
+				Defaults to '` + defaultIPv4Range + `', which allows for at most 106
+				subnets. The range should be private and must not overlap with
+				networks the worker is connected to.
+			`),
+			Pattern: `^(\d{1,3}\.){3}0/([0-9]|1[0-9]|2[0-4])$`,
+		},
+		"ipv6Range": schematypes.String{
+			Title: "IPv6 Range",
+			Description: util.Markdown(`
+				Range from which a '/64' IPv6 subnet is allocated for each virtual
+				machine, given as '<first-subnet>/<prefix-length>', for example
+				'fd5c:7a3b:16f0::/48'. Subnets are allocated consecutively from the
+				first subnet and must fit within the prefix.
+
+				If given, virtual machines are configured dual-stack using router
+				advertisements and stateless DHCPv6, and the meta-data service is
+				also reachable at '` + metaDataIPv6 + `'. Outgoing traffic is
+				masqueraded, so this requires IPv6 NAT support in the kernel.
+				If not given, virtual machines will only have IPv4 connectivity.
+			`),
+		},
+		"ipv6RejectRanges": schematypes.Array{
+			Title: "IPv6 Reject Ranges",
+			Description: util.Markdown(`
+				IPv6 ranges in CIDR notation virtual machines can't connect to, unless
+				allowed by the egress policy for the task. Link-local addresses are
+				always rejected.
+
+				Defaults to the unique local addresses 'fc00::/7', specify a list
+				without the ranges of internal services virtual machines should be
+				able to reach.
+			`),
+			Items: schematypes.String{},
+		},
 		"vpnConnections": schematypes.Array{
 			Title: "VPN Connections",
 			Description: util.Markdown(`
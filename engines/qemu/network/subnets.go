@@ -0,0 +1,103 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// defaultIPv4Range is the default range for IPv4 subnets, the 192.168.0.0/16
+// subnet starting from 192.168.150.0
+const defaultIPv4Range = "192.168.150.0/16"
+
+// defaultIPv6RejectRanges are the IPv6 ranges virtual machines can't connect
+// to by default, these are the unique local addresses.
+var defaultIPv6RejectRanges = []string{"fc00::/7"}
+
+// ipv4Subnets returns count '/24' subnets allocated from ipRange, as ip-prefixes
+// on the form 'a.b.c' (subnet without the last '.0').
+//
+// The ipRange is given as '<first-subnet>/<prefix-length>', subnets are
+// allocated consecutively from the first subnet and must be within the prefix.
+func ipv4Subnets(ipRange string, count int) ([]string, error) {
+	first, ipnet, err := net.ParseCIDR(ipRange)
+	if err != nil || first.To4() == nil {
+		return nil, fmt.Errorf("invalid IPv4 range: '%s'", ipRange)
+	}
+	if ones, _ := ipnet.Mask.Size(); ones > 24 {
+		return nil, fmt.Errorf("IPv4 range: '%s' is smaller than a /24 subnet", ipRange)
+	}
+
+	prefixes := make([]string, count)
+	ip := first.To4().Mask(net.CIDRMask(24, 32))
+	for i := 0; i < count; i++ {
+		if !ipnet.Contains(ip) {
+			return nil, fmt.Errorf("IPv4 range: '%s' doesn't have room for %d subnets", ipRange, count)
+		}
+		prefixes[i] = strconv.Itoa(int(ip[0])) + "." + strconv.Itoa(int(ip[1])) + "." + strconv.Itoa(int(ip[2]))
+		ip = nextSubnet(ip, 3)
+	}
+	return prefixes, nil
+}
+
+// ipv6RejectRanges returns the IPv6 ranges given in CIDR notation, normalized
+// such that they can be used in ip6tables rules.
+func ipv6RejectRanges(ranges []string) ([]string, error) {
+	result := make([]string, len(ranges))
+	for i, r := range ranges {
+		_, ipnet, err := net.ParseCIDR(r)
+		if err != nil || ipnet.IP.To4() != nil {
+			return nil, fmt.Errorf("invalid IPv6 range: '%s'", r)
+		}
+		result[i] = ipnet.String()
+	}
+	return result, nil
+}
+
+// ipv6Subnets returns count '/64' subnets allocated from ipRange.
+//
+// The ipRange is given as '<first-subnet>/<prefix-length>', subnets are
+// allocated consecutively from the first subnet and must be within the prefix.
+func ipv6Subnets(ipRange string, count int) ([]*net.IPNet, error) {
+	first, ipnet, err := net.ParseCIDR(ipRange)
+	if err != nil || first.To4() != nil {
+		return nil, fmt.Errorf("invalid IPv6 range: '%s'", ipRange)
+	}
+	if ones, _ := ipnet.Mask.Size(); ones > 64 {
+		return nil, fmt.Errorf("IPv6 range: '%s' is smaller than a /64 subnet", ipRange)
+	}
+
+	subnets := make([]*net.IPNet, count)
+	mask := net.CIDRMask(64, 128)
+	ip := first.Mask(mask)
+	for i := 0; i < count; i++ {
+		if !ipnet.Contains(ip) {
+			return nil, fmt.Errorf("IPv6 range: '%s' doesn't have room for %d subnets", ipRange, count)
+		}
+		subnets[i] = &net.IPNet{IP: ip, Mask: mask}
+		ip = nextSubnet(ip, 8)
+	}
+	return subnets, nil
+}
+
+// nextSubnet returns a copy of ip incremented by one at the given byte offset,
+// carrying into preceding bytes.
+func nextSubnet(ip net.IP, offset int) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := offset - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// ipv6Gateway returns the address of the host in an IPv6 subnet.
+func ipv6Gateway(subnet *net.IPNet) net.IP {
+	gateway := make(net.IP, len(subnet.IP))
+	copy(gateway, subnet.IP)
+	gateway[len(gateway)-1] = 1
+	return gateway
+}
@@ -0,0 +1,57 @@
+package network
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIPv4Subnets(t *testing.T) {
+	prefixes, err := ipv4Subnets(defaultIPv4Range, 3)
+	nilOrFatal(t, err, "failed to allocate subnets from default range")
+	assert(t, reflect.DeepEqual(prefixes, []string{
+		"192.168.150", "192.168.151", "192.168.152",
+	}), "unexpected prefixes: ", prefixes)
+
+	prefixes, err = ipv4Subnets("10.0.255.0/8", 2)
+	nilOrFatal(t, err, "failed to allocate subnets from 10.0.255.0/8")
+	assert(t, reflect.DeepEqual(prefixes, []string{
+		"10.0.255", "10.1.0",
+	}), "unexpected prefixes: ", prefixes)
+
+	_, err = ipv4Subnets("192.168.254.0/16", 3)
+	assert(t, err != nil, "expected error when range is exhausted")
+	_, err = ipv4Subnets("192.168.0.0/25", 1)
+	assert(t, err != nil, "expected error when range is smaller than /24")
+	_, err = ipv4Subnets("fd00::/48", 1)
+	assert(t, err != nil, "expected error for IPv6 range")
+}
+
+func TestIPv6Subnets(t *testing.T) {
+	subnets, err := ipv6Subnets("fd42:0:0:ffff::/32", 2)
+	nilOrFatal(t, err, "failed to allocate subnets")
+	assert(t, len(subnets) == 2, "expected 2 subnets, got: ", subnets)
+	assert(t, subnets[0].String() == "fd42:0:0:ffff::/64", "unexpected subnet: ", subnets[0])
+	assert(t, subnets[1].String() == "fd42:0:1::/64", "unexpected subnet: ", subnets[1])
+	gateway := ipv6Gateway(subnets[0])
+	assert(t, gateway.String() == "fd42:0:0:ffff::1", "unexpected gateway: ", gateway)
+
+	_, err = ipv6Subnets("fd42:0:0:ffff::/64", 2)
+	assert(t, err != nil, "expected error when range is exhausted")
+	_, err = ipv6Subnets("192.168.0.0/16", 1)
+	assert(t, err != nil, "expected error for IPv4 range")
+}
+
+func TestIPv6RejectRanges(t *testing.T) {
+	ranges, err := ipv6RejectRanges(defaultIPv6RejectRanges)
+	nilOrFatal(t, err, "failed to parse default reject ranges")
+	assert(t, reflect.DeepEqual(ranges, []string{"fc00::/7"}), "unexpected ranges: ", ranges)
+
+	ranges, err = ipv6RejectRanges([]string{"fd00::1/8", "2001:db8::/32"})
+	nilOrFatal(t, err, "failed to parse reject ranges")
+	assert(t, reflect.DeepEqual(ranges, []string{"fd00::/8", "2001:db8::/32"}), "unexpected ranges: ", ranges)
+
+	_, err = ipv6RejectRanges([]string{"10.0.0.0/8"})
+	assert(t, err != nil, "expected error for IPv4 range")
+	_, err = ipv6RejectRanges([]string{"fd00::"})
+	assert(t, err != nil, "expected error for address without prefix length")
+}
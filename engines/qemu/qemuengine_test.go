@@ -13,6 +13,7 @@ import (
 
 	"github.com/taskcluster/taskcluster-worker/engines"
 	"github.com/taskcluster/taskcluster-worker/engines/enginetest"
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/vm"
 )
 
 const testImageFile = "./test-image/tinycore-worker.tar.zst"
@@ -54,16 +55,27 @@ func TestMain(m *testing.M) {
 	os.Exit(result)
 }
 
+// testNetworkConfig returns the network configuration for the tests, when not
+// running as root we use the user-space network stack, as we can't create TAP
+// devices.
+func testNetworkConfig() string {
+	if os.Geteuid() != 0 {
+		return `"userNetwork": true`
+	}
+	return `"network": {
+			"subnets": 5
+		}`
+}
+
 var provider = &enginetest.EngineProvider{
 	Engine: "qemu",
 	Config: `{
-		"network": {
-			"subnets": 5
-		},
+		` + testNetworkConfig() + `,
 		"limits": {
 			"maxMemory": 256,
 			"maxCPUs": 1,
-			"defaultThreads": 1
+			"defaultThreads": 1,
+			"accelerator": "` + vm.DetectAccelerator() + `"
 		}
 	}`,
 }
@@ -131,6 +143,27 @@ func TestAttachProxy(t *testing.T) {
 	c.Test()
 }
 
+func TestVolumes(t *testing.T) {
+	c := enginetest.VolumeTestCase{
+		EngineProvider: provider,
+		Mountpoint:     "/mnt/cache",
+		WriteVolumePayload: `{
+			"image": "` + s.URL + `",
+			"command": ["sh", "-ec", "echo 'hello-cache' > /mnt/cache/test.txt"]
+		}`,
+		CheckVolumePayload: `{
+			"image": "` + s.URL + `",
+			"command": ["sh", "-ec", "grep 'hello-cache' /mnt/cache/test.txt"]
+		}`,
+	}
+
+	c.TestWriteReadVolume()
+	c.TestReadEmptyVolume()
+	c.TestWriteToReadOnlyVolume()
+	c.TestReadToReadOnlyVolume()
+	c.Test()
+}
+
 func TestArtifacts(t *testing.T) {
 	c := enginetest.ArtifactTestCase{
 		EngineProvider:     provider,
@@ -1,7 +1,8 @@
 package qemuengine
 
 import (
-	"strings"
+	"bytes"
+	"image/png"
 
 	"github.com/taskcluster/taskcluster-worker/engines"
 	"github.com/taskcluster/taskcluster-worker/engines/qemu/metaservice"
@@ -14,15 +15,24 @@ type resultSet struct {
 	success     bool
 	vm          *vm.VirtualMachine
 	metaService *metaservice.MetaService
+	imageHash   string
+	egress      string
+	usage       vm.Usage
 }
 
-func newResultSet(success bool, vm *vm.VirtualMachine, m *metaservice.MetaService) *resultSet {
+func newResultSet(
+	success bool, vm *vm.VirtualMachine, m *metaservice.MetaService,
+	imageHash, egress string, usage vm.Usage,
+) *resultSet {
 	// Set metaService as handler (this will make proxies unreachable)
 	vm.SetHTTPHandler(m)
 	return &resultSet{
 		success:     success,
 		vm:          vm,
 		metaService: m,
+		imageHash:   imageHash,
+		egress:      egress,
+		usage:       usage,
 	}
 }
 
@@ -35,29 +45,70 @@ func (r *resultSet) ExtractFile(path string) (ioext.ReadSeekCloser, error) {
 }
 
 func (r *resultSet) ExtractFolder(path string, handler engines.FileHandler) error {
-	files, err := r.metaService.ListFolder(path)
+	return extractFolder(r.metaService, path, handler)
+}
+
+func (r *resultSet) ArchiveSandbox() (ioext.ReadSeekCloser, error) {
+	// Archive the working directory of guest-tools
+	archive, err := r.metaService.GetArchive("")
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return archive, nil
+}
 
-	// TODO: Consider some level of parallelism, but not too many files in parallel
-	for _, p := range files {
-		f, err := r.metaService.GetArtifact(p)
-		if err != nil {
-			return err
-		}
-		// If guest uses backslashes our input paths should have that, but the ones
-		// we return should be intepreted as names.
-		p = strings.Replace(p[len(path):], "\\", "/", -1)
-		if len(p) > 0 && p[0] == '\\' {
-			p = p[1:]
-		}
-		if handler(p, f) != nil {
-			return engines.ErrHandlerInterrupt
+func (r *resultSet) NewShell(command []string, tty bool) (engines.Shell, error) {
+	// Shells are aborted when the VM is killed in Dispose()
+	return r.metaService.ExecShell(command, tty)
+}
+
+func (r *resultSet) Metadata() map[string]string {
+	return map[string]string{
+		"imageHash":    "sha256:" + r.imageHash,
+		"egressPolicy": r.egress,
+	}
+}
+
+func (r *resultSet) Diagnostics() (map[string]ioext.ReadSeekCloser, error) {
+	// Only capture diagnostics for failed tasks, it's slow and rarely useful
+	if r.success {
+		return nil, nil
+	}
+
+	files := make(map[string]ioext.ReadSeekCloser)
+	img, err := r.vm.Screenshot()
+	if err == nil {
+		var b bytes.Buffer
+		if err = png.Encode(&b, img); err == nil {
+			files["qemu/screenshot.png"] = ioext.NopCloser(bytes.NewReader(b.Bytes()))
 		}
 	}
+	if err != nil {
+		debug("failed to capture screenshot for diagnostics, error: %s", err)
+	}
 
-	return nil
+	report, err := r.vm.Diagnostics()
+	if err != nil {
+		debug("failed to capture diagnostics, error: %s", err)
+		report = "Failed to capture diagnostics, error: " + err.Error() + "\n"
+	}
+	files["qemu/diagnostics.txt"] = ioext.NopCloser(bytes.NewReader([]byte(report)))
+
+	return files, nil
+}
+
+func (r *resultSet) Usage() (map[string]float64, error) {
+	return map[string]float64{
+		"cpuTime":                 r.usage.CPUTime,
+		"memory":                  float64(r.usage.Memory),
+		"memoryPeak":              float64(r.usage.MemoryPeak),
+		"diskReadBytes":           float64(r.usage.DiskReadBytes),
+		"diskWriteBytes":          float64(r.usage.DiskWriteBytes),
+		"diskReadOperations":      float64(r.usage.DiskReadOperations),
+		"diskWriteOperations":     float64(r.usage.DiskWriteOperations),
+		"networkReceivedBytes":    float64(r.usage.NetworkReceivedBytes),
+		"networkTransmittedBytes": float64(r.usage.NetworkTransmittedBytes),
+	}, nil
 }
 
 func (r *resultSet) Dispose() error {
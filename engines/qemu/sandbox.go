@@ -12,6 +12,7 @@ import (
 	"github.com/taskcluster/taskcluster-worker/engines/qemu/vm"
 	"github.com/taskcluster/taskcluster-worker/runtime"
 	"github.com/taskcluster/taskcluster-worker/runtime/atomics"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
 )
 
 type sandbox struct {
@@ -21,6 +22,8 @@ type sandbox struct {
 	engine      *engine
 	proxies     map[string]http.Handler
 	metaService *metaservice.MetaService
+	imageHash   string            // sha256 of the image file, for Metadata()
+	egress      string            // egress policy, for Metadata()
 	resolve     atomics.Once      // Must wrap access mutation of resultXXX/done
 	resultSet   engines.ResultSet // ResultSet for WaitForResult
 	resultError error             // Error for WaitForResult
@@ -34,9 +37,13 @@ func newSandbox(
 	command []string,
 	env map[string]string,
 	proxies map[string]http.Handler,
+	volumes []vm.Volume,
+	mounts []metaservice.Mount,
 	machine vm.Machine,
 	image vm.Image,
+	imageHash string,
 	network vm.Network,
+	egress string,
 	c *runtime.TaskContext,
 	e *engine,
 	monitor runtime.Monitor,
@@ -49,7 +56,7 @@ func newSandbox(
 		//  - machine from engine config
 		//  - default machine (hardcoded into vm.NewVirtualMachine)
 		vm.OverwriteMachine(image, machine.WithDefaults(image.Machine()).WithDefaults(e.defaultMachine)),
-		network, e.socketFolder.Path(), "", "", vm.LinuxBootOptions{},
+		network, e.socketFolder.Path(), "", "", volumes, vm.LinuxBootOptions{},
 		monitor.WithTag("component", "vm"),
 	)
 	if err != nil {
@@ -58,15 +65,18 @@ func newSandbox(
 
 	// Create sandbox
 	s := &sandbox{
-		vm:      instance,
-		context: c,
-		engine:  e,
-		proxies: proxies,
-		monitor: monitor,
+		vm:        instance,
+		context:   c,
+		engine:    e,
+		proxies:   proxies,
+		imageHash: imageHash,
+		egress:    egress,
+		monitor:   monitor,
 	}
 
 	// Setup meta-data service
 	s.metaService = metaservice.New(command, env, c.LogDrain(), s.result, e.Environment)
+	s.metaService.SetMounts(mounts)
 
 	// Create session manager
 	s.sessions = newSessionManager(s.metaService, s.vm)
@@ -135,7 +145,7 @@ func (s *sandbox) result(success bool) {
 	s.sessions.WaitAndTerminate()
 
 	s.resolve.Do(func() {
-		s.resultSet = newResultSet(success, s.vm, s.metaService)
+		s.resultSet = newResultSet(success, s.vm, s.metaService, s.imageHash, s.egress, s.reportUsage())
 		s.resultAbort = engines.ErrSandboxTerminated
 	})
 }
@@ -144,26 +154,56 @@ func (s *sandbox) Kill() error {
 	s.resolve.Do(func() {
 		s.sessions.KillSessions()
 		s.metaService.KillProcess()
-		s.resultSet = newResultSet(false, s.vm, s.metaService)
+		s.resultSet = newResultSet(false, s.vm, s.metaService, s.imageHash, s.egress, s.reportUsage())
 		s.resultAbort = engines.ErrSandboxTerminated
 	})
 	s.resolve.Wait()
 	return s.resultError
 }
 
-// waitForCrash will wait for a VM crash and resolve
+// waitForCrash will wait for a VM crash or guest panic and resolve
 func (s *sandbox) waitForCrash() {
-	// Wait for the VM to finish
-	<-s.vm.Done
+	select {
+	case <-s.vm.Panicked:
+		// Resolve as failed, leaving the VM alive so diagnostics can be captured
+		s.resolve.Do(func() {
+			s.context.LogError("Virtual machine reported a guest kernel panic")
+			s.sessions.KillSessions()
+			s.metaService.KillProcess()
+			s.resultSet = newResultSet(false, s.vm, s.metaService, s.imageHash, s.egress, s.reportUsage())
+			s.resultAbort = engines.ErrSandboxTerminated
+		})
+	case <-s.vm.Done:
+		s.resolve.Do(func() {
+			// Kill all sessions
+			s.sessions.AbortSessions()
+			s.reportUsage()
 
-	s.resolve.Do(func() {
-		// Kill all sessions
-		s.sessions.AbortSessions()
+			if s.vm.Error != nil {
+				s.context.LogError("QEMU crashed unexpectedly, error: ", s.vm.Error)
+				s.resultError = errors.Wrap(s.vm.Error, "QEMU crashed unexpected")
+			} else {
+				s.resultError = errors.New("QEMU crashed unexpected")
+			}
+			s.resultAbort = engines.ErrSandboxTerminated
+		})
+	}
+}
 
-		// TODO: Read s.vm.Error and handle the error
-		s.resultError = errors.New("QEMU crashed unexpected")
-		s.resultAbort = engines.ErrSandboxTerminated
-	})
+// reportUsage sends resource usage totals for the virtual machine to the
+// monitor, and returns them, so they can be exposed by the ResultSet.
+func (s *sandbox) reportUsage() vm.Usage {
+	usage := s.vm.Usage()
+	s.monitor.Measure("usage.cpuTime", usage.CPUTime)
+	s.monitor.Measure("usage.memory", float64(usage.Memory))
+	s.monitor.Measure("usage.memoryPeak", float64(usage.MemoryPeak))
+	s.monitor.Measure("usage.diskReadBytes", float64(usage.DiskReadBytes))
+	s.monitor.Measure("usage.diskWriteBytes", float64(usage.DiskWriteBytes))
+	s.monitor.Measure("usage.diskReadOperations", float64(usage.DiskReadOperations))
+	s.monitor.Measure("usage.diskWriteOperations", float64(usage.DiskWriteOperations))
+	s.monitor.Measure("usage.networkReceivedBytes", float64(usage.NetworkReceivedBytes))
+	s.monitor.Measure("usage.networkTransmittedBytes", float64(usage.NetworkTransmittedBytes))
+	return usage
 }
 
 func (s *sandbox) WaitForResult() (engines.ResultSet, error) {
@@ -189,6 +229,31 @@ func (s *sandbox) NewShell(command []string, tty bool) (engines.Shell, error) {
 	return s.sessions.NewShell(command, tty)
 }
 
+func (s *sandbox) DialPort(port int) (io.ReadWriteCloser, error) {
+	return s.sessions.DialPort(port)
+}
+
+func (s *sandbox) ExtractFile(path string) (ioext.ReadSeekCloser, error) {
+	if s.resolve.IsDone() {
+		return nil, engines.ErrSandboxTerminated
+	}
+	return s.metaService.GetArtifact(path)
+}
+
+func (s *sandbox) ExtractFolder(path string, handler engines.FileHandler) error {
+	if s.resolve.IsDone() {
+		return engines.ErrSandboxTerminated
+	}
+	return extractFolder(s.metaService, path, handler)
+}
+
+func (s *sandbox) WriteFile(path string, stream io.Reader) error {
+	if s.resolve.IsDone() {
+		return engines.ErrSandboxTerminated
+	}
+	return s.metaService.PutFile(path, stream)
+}
+
 const qemuDisplayName = "screen"
 
 func (s *sandbox) ListDisplays() ([]engines.Display, error) {
@@ -1,14 +1,17 @@
 package qemuengine
 
 import (
+	"fmt"
 	"net/http"
 	"os"
+	"path"
 	"regexp"
 	"strings"
 	"sync"
 
 	"github.com/taskcluster/taskcluster-worker/engines"
 	"github.com/taskcluster/taskcluster-worker/engines/qemu/image"
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/metaservice"
 	"github.com/taskcluster/taskcluster-worker/engines/qemu/network"
 	"github.com/taskcluster/taskcluster-worker/engines/qemu/vm"
 	"github.com/taskcluster/taskcluster-worker/runtime"
@@ -19,7 +22,8 @@ type sandboxBuilder struct {
 	engines.SandboxBuilderBase
 	m          sync.Mutex
 	discarded  bool
-	network    *network.Network
+	network    vm.Network
+	egress     string // Egress policy applied to network
 	command    []string
 	machine    vm.Machine
 	image      *image.Instance
@@ -27,6 +31,8 @@ type sandboxBuilder struct {
 	imageDone  <-chan struct{}
 	proxies    map[string]http.Handler
 	env        map[string]string
+	volumes    []vm.Volume
+	mounts     []metaservice.Mount
 	context    *runtime.TaskContext
 	engine     *engine
 	monitor    runtime.Monitor
@@ -35,12 +41,13 @@ type sandboxBuilder struct {
 // newSandboxBuilder creates a new sandboxBuilder, the network and command
 // properties must be set manually after calling this method.
 func newSandboxBuilder(
-	payload *payloadType, network *network.Network,
+	payload *payloadType, network vm.Network, egress string,
 	c *runtime.TaskContext, e *engine, monitor runtime.Monitor,
 ) *sandboxBuilder {
 	imageDone := make(chan struct{})
 	sb := &sandboxBuilder{
 		network:   network,
+		egress:    egress,
 		command:   payload.Command,
 		imageDone: imageDone,
 		proxies:   make(map[string]http.Handler),
@@ -136,6 +143,67 @@ func (sb *sandboxBuilder) AttachProxy(hostname string, handler http.Handler) err
 	return nil
 }
 
+func (sb *sandboxBuilder) AttachVolume(mountpoint string, v engines.Volume, readOnly bool) error {
+	// We can type cast Volume to our internal type as we know the volume was
+	// created by NewCacheFolder() or NewMemoryDisk(), this is a contract.
+	vol, valid := v.(*volume)
+	if !valid {
+		// TODO: Write to some sort of log if the type assertion fails
+		return fmt.Errorf("invalid volume type")
+	}
+
+	// Mountpoints are absolute paths in the guest
+	if !path.IsAbs(mountpoint) || path.Clean(mountpoint) != mountpoint || mountpoint == "/" {
+		return runtime.NewMalformedPayloadError("Mountpoint: '", mountpoint, "'",
+			" is not allowed for QEMU engine. The mountpoint must be an absolute",
+			" path, such as '/home/worker/cache'")
+	}
+
+	// Acquire the lock
+	sb.m.Lock()
+	defer sb.m.Unlock()
+
+	// Check that the mountpoint isn't already in use
+	for _, m := range sb.mounts {
+		if m.Mountpoint == mountpoint {
+			return runtime.NewMalformedPayloadError("Mountpoint: '", mountpoint,
+				"' is already in use")
+		}
+	}
+
+	// Memory disks are mounted as tmpfs in the guest, nothing is attached
+	if vol.memorySize != 0 {
+		sb.mounts = append(sb.mounts, metaservice.Mount{
+			Type:       vol.mountType(),
+			Mountpoint: mountpoint,
+			ReadOnly:   readOnly,
+			Size:       vol.memorySize,
+		})
+		return nil
+	}
+
+	if len(sb.volumes) >= vm.MaxVolumes {
+		return runtime.NewMalformedPayloadError("QEMU engine supports at most ",
+			vm.MaxVolumes, " volumes per task")
+	}
+
+	// Tag is used to identify the volume in the guest
+	tag := fmt.Sprintf("volume-%d", len(sb.volumes))
+	sb.volumes = append(sb.volumes, vm.Volume{
+		Tag:      tag,
+		DiskFile: vol.diskFile,
+		Folder:   vol.folderPath(),
+		ReadOnly: readOnly,
+	})
+	sb.mounts = append(sb.mounts, metaservice.Mount{
+		Type:       vol.mountType(),
+		Tag:        tag,
+		Mountpoint: mountpoint,
+		ReadOnly:   readOnly,
+	})
+	return nil
+}
+
 // envVarPattern defines allowed environment variable names
 var envVarPattern = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
 
@@ -183,10 +251,17 @@ func (sb *sandboxBuilder) StartSandbox() (engines.Sandbox, error) {
 		return nil, err
 	}
 
+	// Proxies are unreachable, if the egress policy denies everything
+	proxies := sb.proxies
+	if sb.egress == network.EgressDeny {
+		proxies = nil
+	}
+
 	// Create a sandbox
 	s, err := newSandbox(
-		sb.command, sb.env, sb.proxies, sb.machine, sb.image, sb.network,
-		sb.context, sb.engine, sb.monitor,
+		sb.command, sb.env, proxies, sb.volumes, sb.mounts, sb.machine,
+		sb.image, sb.image.Hash(), sb.network, sb.egress, sb.context, sb.engine,
+		sb.monitor,
 	)
 	if err != nil {
 		sb.m.Unlock()
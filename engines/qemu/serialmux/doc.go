@@ -0,0 +1,25 @@
+// Package serialmux multiplexes streams over a single serial port, such that
+// the meta-data service can be served over a virtio-serial port.
+//
+// Each end of the serial port creates a Session, streams opened with
+// Session.Open() on one end are returned from Session.Accept() on the other
+// end. The Session implements net.Listener and streams implement net.Conn,
+// hence, an http.Server can serve requests from a Session and a http.Transport
+// can dial streams using Session.Open().
+//
+// Data is sent in frames with a 9 byte header: type (1 byte), stream id
+// (4 bytes) and length (4 bytes). Only data frames have a payload of length
+// bytes, for window frames the length is the number of bytes the receiver has
+// consumed. Each stream can have at-most windowSize bytes in-flight, so a
+// stream that isn't read can't block other streams.
+//
+// When a Session is created it writes a hello frame with a magic value and the
+// protocol version, and discards input until it reads a hello frame, or a reply
+// to its own hello frame, from the other end. Hence, data left in the serial
+// port from a previous session is ignored. A hello frame received later means
+// the other end started a new session, so all open streams are reset.
+package serialmux
+
+import "github.com/taskcluster/taskcluster-worker/runtime/util"
+
+var debug = util.Debug("serialmux")
@@ -0,0 +1,295 @@
+package serialmux
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newSessions returns a client and server session connected by a pipe
+func newSessions() (*Session, *Session) {
+	guest, host := net.Pipe()
+	return Client(guest), Server(host)
+}
+
+func TestOpenAccept(t *testing.T) {
+	client, server := newSessions()
+	defer client.Close()
+	defer server.Close()
+
+	// Echo server
+	go func() {
+		for {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	conn, err := client.Open()
+	nilOrFatal(t, err, "failed to open stream")
+	_, err = conn.Write([]byte("hello world"))
+	nilOrFatal(t, err, "failed to write")
+	data := make([]byte, 11)
+	_, err = io.ReadFull(conn, data)
+	nilOrFatal(t, err, "failed to read")
+	assert(t, string(data) == "hello world", "unexpected data: ", string(data))
+	nilOrFatal(t, conn.Close(), "failed to close stream")
+}
+
+func TestConcurrentStreams(t *testing.T) {
+	client, server := newSessions()
+	defer client.Close()
+	defer server.Close()
+
+	// Send 1 MiB on each stream, which is more than the window size, and check
+	// that the server receives what we sent.
+	const streams = 5
+	payloads := make([][]byte, streams)
+	for i := range payloads {
+		payloads[i] = make([]byte, 1024*1024)
+		rand.Read(payloads[i])
+	}
+
+	received := make(chan []byte, streams)
+	go func() {
+		for i := 0; i < streams; i++ {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				data, _ := ioutil.ReadAll(conn)
+				received <- data
+				conn.Close()
+			}()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, payload := range payloads {
+		wg.Add(1)
+		go func(payload []byte) {
+			defer wg.Done()
+			conn, err := client.Open()
+			if err != nil {
+				t.Error("failed to open stream, error: ", err)
+				return
+			}
+			if _, err = conn.Write(payload); err != nil {
+				t.Error("failed to write, error: ", err)
+			}
+			conn.Close()
+		}(payload)
+	}
+	wg.Wait()
+
+	for i := 0; i < streams; i++ {
+		data := <-received
+		found := false
+		for _, payload := range payloads {
+			found = found || bytes.Equal(data, payload)
+		}
+		assert(t, found, "received data that wasn't sent, length: ", len(data))
+	}
+}
+
+func TestBlockedStream(t *testing.T) {
+	client, server := newSessions()
+	defer client.Close()
+	defer server.Close()
+
+	// Fill the window of a stream that is never read
+	blocked, err := client.Open()
+	nilOrFatal(t, err, "failed to open stream")
+	_, err = server.Accept()
+	nilOrFatal(t, err, "failed to accept stream")
+	_, err = blocked.Write(make([]byte, windowSize))
+	nilOrFatal(t, err, "failed to write")
+	blocked.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = blocked.Write([]byte("x"))
+	nerr, ok := err.(net.Error)
+	assert(t, ok && nerr.Timeout(), "expected timeout error, got: ", err)
+
+	// Other streams should work
+	conn, err := client.Open()
+	nilOrFatal(t, err, "failed to open stream")
+	other, err := server.Accept()
+	nilOrFatal(t, err, "failed to accept stream")
+	go conn.Write([]byte("hello"))
+	data := make([]byte, 5)
+	_, err = io.ReadFull(other, data)
+	nilOrFatal(t, err, "failed to read")
+	assert(t, string(data) == "hello", "unexpected data: ", string(data))
+}
+
+func TestReadDeadline(t *testing.T) {
+	client, server := newSessions()
+	defer client.Close()
+	defer server.Close()
+
+	conn, err := client.Open()
+	nilOrFatal(t, err, "failed to open stream")
+
+	// Setting a deadline in the past must interrupt a pending read
+	done := make(chan error)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	conn.SetReadDeadline(time.Unix(1, 0))
+	err = <-done
+	nerr, ok := err.(net.Error)
+	assert(t, ok && nerr.Timeout(), "expected timeout error, got: ", err)
+}
+
+func TestCloseStream(t *testing.T) {
+	client, server := newSessions()
+	defer client.Close()
+	defer server.Close()
+
+	conn, err := client.Open()
+	nilOrFatal(t, err, "failed to open stream")
+	other, err := server.Accept()
+	nilOrFatal(t, err, "failed to accept stream")
+
+	_, err = conn.Write([]byte("bye"))
+	nilOrFatal(t, err, "failed to write")
+	nilOrFatal(t, conn.Close(), "failed to close")
+
+	data, err := ioutil.ReadAll(other)
+	nilOrFatal(t, err, "failed to read")
+	assert(t, string(data) == "bye", "unexpected data: ", string(data))
+	_, err = other.Write([]byte("x"))
+	assert(t, err != nil, "expected write to closed stream to fail")
+}
+
+func TestCloseSession(t *testing.T) {
+	client, server := newSessions()
+	defer server.Close()
+
+	conn, err := client.Open()
+	nilOrFatal(t, err, "failed to open stream")
+	other, err := server.Accept()
+	nilOrFatal(t, err, "failed to accept stream")
+
+	client.Close()
+	_, err = other.Read(make([]byte, 1))
+	assert(t, err != nil, "expected read to fail")
+	_, err = conn.Write([]byte("x"))
+	assert(t, err != nil, "expected write to fail")
+	_, err = server.Accept()
+	assert(t, err == ErrSessionClosed, "expected ErrSessionClosed, got: ", err)
+	_, err = client.Open()
+	assert(t, err == ErrSessionClosed, "expected ErrSessionClosed, got: ", err)
+}
+
+func TestHTTP(t *testing.T) {
+	client, server := newSessions()
+	defer client.Close()
+	defer server.Close()
+
+	go http.Serve(server, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hijack" {
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				panic(err)
+			}
+			rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n\r\nhijacked")
+			rw.Flush()
+			conn.Close()
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(append([]byte("echo: "), body...))
+	}))
+
+	dial := func(network, addr string) (net.Conn, error) { return client.Open() }
+	c := &http.Client{Transport: &http.Transport{Dial: dial}}
+	for i := 0; i < 3; i++ {
+		res, err := c.Post("http://metaservice/echo", "text/plain", bytes.NewBufferString("hello"))
+		nilOrFatal(t, err, "request failed")
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		assert(t, string(body) == "echo: hello", "unexpected body: ", string(body))
+	}
+
+	// Hijacking requires read deadlines to work
+	conn, err := client.Open()
+	nilOrFatal(t, err, "failed to open stream")
+	_, err = conn.Write([]byte("GET /hijack HTTP/1.1\r\nHost: metaservice\r\n\r\n"))
+	nilOrFatal(t, err, "failed to write")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	nilOrFatal(t, err, "failed to read status line")
+	assert(t, line == "HTTP/1.1 101 Switching Protocols\r\n", "unexpected status: ", line)
+	r.ReadString('\n')
+	rest, _ := ioutil.ReadAll(r)
+	assert(t, string(rest) == "hijacked", "unexpected data: ", string(rest))
+}
+
+func TestHandshake(t *testing.T) {
+	guest, host := net.Pipe()
+	defer host.Close()
+	client := Client(guest)
+	defer client.Close()
+
+	// writeHeader writes a frame header to the client from the host end
+	writeHeader := func(frameType byte, id, length uint32) {
+		header := make([]byte, headerSize)
+		header[0] = frameType
+		binary.BigEndian.PutUint32(header[1:5], id)
+		binary.BigEndian.PutUint32(header[5:9], length)
+		_, err := host.Write(header)
+		nilOrFatal(t, err, "failed to write frame")
+	}
+	// readHeader reads a frame header from the client on the host end
+	readHeader := func() (byte, uint32) {
+		header := make([]byte, headerSize)
+		_, err := io.ReadFull(host, header)
+		nilOrFatal(t, err, "failed to read frame")
+		return header[0], binary.BigEndian.Uint32(header[1:5])
+	}
+
+	// Data from a previous session is discarded until the hello frame
+	frameType, id := readHeader()
+	assert(t, frameType == frameHello && id == helloMagic, "expected hello frame")
+	go func() {
+		host.Write([]byte("stale data from a previous session"))
+		writeHeader(frameHello, helloMagic, protocolVersion)
+	}()
+	frameType, id = readHeader()
+	assert(t, frameType == frameAck && id == helloMagic, "expected reply to hello frame")
+
+	// A stream opened after the handshake is reset, if the host starts a new
+	// session
+	opened := make(chan net.Conn)
+	go func() {
+		conn, _ := client.Open()
+		opened <- conn
+	}()
+	frameType, _ = readHeader()
+	assert(t, frameType == frameOpen, "expected open frame")
+	conn := <-opened
+	assert(t, conn != nil, "failed to open stream")
+	go writeHeader(frameHello, helloMagic, protocolVersion)
+	frameType, _ = readHeader()
+	assert(t, frameType == frameAck, "expected reply to hello frame")
+	_, err := conn.Read(make([]byte, 1))
+	assert(t, err == ErrStreamReset, "expected ErrStreamReset, got: ", err)
+}
@@ -0,0 +1,348 @@
+package serialmux
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// Frame types
+const (
+	frameOpen   = 1 // Open a new stream
+	frameData   = 2 // Data for a stream, length bytes of payload follows
+	frameWindow = 3 // Grant length bytes of credit for writing to a stream
+	frameClose  = 4 // Stream is closed, no more data will be sent
+	frameHello  = 5 // Session started, id is helloMagic and length is protocolVersion
+	frameAck    = 6 // Reply to frameHello, with the same id and length
+)
+
+// Hello frames identify the start of a session, see readHello()
+const (
+	helloMagic      = 0x534d5558 // "SMUX"
+	protocolVersion = 1
+)
+
+const (
+	headerSize    = 9
+	maxFrameSize  = 32 * 1024  // Maximum payload of a data frame
+	windowSize    = 256 * 1024 // Maximum bytes in-flight for a stream
+	acceptBacklog = 64         // Maximum number of streams waiting for Accept()
+)
+
+// ErrSessionClosed is returned when the Session has been closed, or the
+// underlying serial port failed.
+var ErrSessionClosed = errors.New("serialmux: session is closed")
+
+// ErrStreamReset is returned from streams that were open when the other end
+// started a new session.
+var ErrStreamReset = errors.New("serialmux: stream was reset by the other end")
+
+// Session multiplexes streams over a serial port.
+type Session struct {
+	rwc       io.ReadWriteCloser
+	wm        sync.Mutex // Ensure frames are written one at the time
+	m         sync.Mutex // Protect streams and nextID
+	streams   map[uint32]*stream
+	nextID    uint32
+	accept    chan *stream
+	sent      chan struct{} // Closed when the hello frame has been written
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Client returns a Session for the end of the serial port inside the guest.
+func Client(rwc io.ReadWriteCloser) *Session {
+	return newSession(rwc, 1)
+}
+
+// Server returns a Session for the end of the serial port on the host.
+func Server(rwc io.ReadWriteCloser) *Session {
+	return newSession(rwc, 2)
+}
+
+// newSession creates a Session, streams opened from this end will have ids
+// starting from firstID, ends must use different parity.
+func newSession(rwc io.ReadWriteCloser, firstID uint32) *Session {
+	s := &Session{
+		rwc:     rwc,
+		streams: make(map[uint32]*stream),
+		nextID:  firstID,
+		accept:  make(chan *stream, acceptBacklog),
+		sent:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.readLoop()
+	go s.sayHello()
+	return s
+}
+
+// sayHello writes the hello frame, streams can't be opened until this is done,
+// so the other end reads the hello frame before any frames for streams.
+func (s *Session) sayHello() {
+	if s.writeFrame(frameHello, helloMagic, protocolVersion, nil) == nil {
+		close(s.sent)
+	}
+}
+
+// ack replies to a hello frame from the other end. This is written after our
+// own hello frame, so the other end doesn't read our hello frame after it has
+// received the reply, and mistake it for the start of a new session.
+func (s *Session) ack() {
+	select {
+	case <-s.sent:
+		s.writeFrame(frameAck, helloMagic, protocolVersion, nil)
+	case <-s.done:
+	}
+}
+
+// Open a new stream, which will be returned from Accept() on the other end.
+func (s *Session) Open() (net.Conn, error) {
+	select {
+	case <-s.sent:
+	case <-s.done:
+		return nil, ErrSessionClosed
+	}
+
+	s.m.Lock()
+	select {
+	case <-s.done:
+		s.m.Unlock()
+		return nil, ErrSessionClosed
+	default:
+	}
+	id := s.nextID
+	s.nextID += 2
+	st := newStream(s, id)
+	s.streams[id] = st
+	s.m.Unlock()
+
+	if err := s.writeFrame(frameOpen, id, 0, nil); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+	return st, nil
+}
+
+// Accept waits for and returns the next stream opened by the other end.
+func (s *Session) Accept() (net.Conn, error) {
+	select {
+	case st := <-s.accept:
+		return st, nil
+	case <-s.done:
+		return nil, ErrSessionClosed
+	}
+}
+
+// Close the session and the underlying serial port.
+func (s *Session) Close() error {
+	s.shutdown(ErrSessionClosed)
+	return nil
+}
+
+// Addr returns a placeholder address, this is only present to implement
+// net.Listener.
+func (s *Session) Addr() net.Addr {
+	return addr{}
+}
+
+// Done returns a channel that is closed when the session is closed.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// shutdown closes the session, the serial port and all streams.
+func (s *Session) shutdown(err error) {
+	s.closeOnce.Do(func() {
+		if err != ErrSessionClosed {
+			debug("session failed, error: %s", err)
+		}
+		s.m.Lock()
+		streams := s.streams
+		s.streams = make(map[uint32]*stream)
+		close(s.done)
+		s.m.Unlock()
+
+		s.rwc.Close()
+		for _, st := range streams {
+			st.sessionClosed()
+		}
+	})
+}
+
+// writeFrame writes a frame to the serial port, this closes the session if
+// writing fails.
+func (s *Session) writeFrame(frameType byte, id, length uint32, payload []byte) error {
+	frame := make([]byte, headerSize+len(payload))
+	frame[0] = frameType
+	binary.BigEndian.PutUint32(frame[1:5], id)
+	binary.BigEndian.PutUint32(frame[5:9], length)
+	copy(frame[headerSize:], payload)
+
+	s.wm.Lock()
+	defer s.wm.Unlock()
+	select {
+	case <-s.done:
+		return ErrSessionClosed
+	default:
+	}
+	if _, err := s.rwc.Write(frame); err != nil {
+		s.shutdown(err)
+		return ErrSessionClosed
+	}
+	return nil
+}
+
+// lookupStream returns the stream with given id, nil if it doesn't exist.
+func (s *Session) lookupStream(id uint32) *stream {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.streams[id]
+}
+
+// removeStream forgets the stream with given id.
+func (s *Session) removeStream(id uint32) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	delete(s.streams, id)
+}
+
+// resetStreams fails and forgets all streams, this is used when the other end
+// has started a new session, as it doesn't know about our streams.
+func (s *Session) resetStreams() {
+	s.m.Lock()
+	streams := s.streams
+	s.streams = make(map[uint32]*stream)
+	s.m.Unlock()
+
+	for _, st := range streams {
+		st.fail(ErrStreamReset)
+	}
+}
+
+// readHello discards input until a hello frame, or a reply to our hello frame,
+// is read. The serial port may hold data from a previous session, for example
+// if the other end was restored from a snapshot, which we must ignore.
+func (s *Session) readHello() error {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(s.rwc, header); err != nil {
+		return err
+	}
+	discarded := 0
+	for {
+		frameType := header[0]
+		id := binary.BigEndian.Uint32(header[1:5])
+		if (frameType == frameHello || frameType == frameAck) && id == helloMagic {
+			if discarded > 0 {
+				debug("discarded %d bytes before the hello frame", discarded)
+			}
+			if version := binary.BigEndian.Uint32(header[5:9]); version != protocolVersion {
+				return fmt.Errorf("serialmux: unsupported protocol version: %d", version)
+			}
+			if frameType == frameHello {
+				go s.ack()
+			}
+			return nil
+		}
+		copy(header, header[1:])
+		discarded++
+		if _, err := io.ReadFull(s.rwc, header[headerSize-1:]); err != nil {
+			return err
+		}
+	}
+}
+
+// readLoop reads frames from the serial port until the session is closed,
+// input before the hello frame from the other end is discarded.
+//
+// This must never block on anything but reading, as the other end may be
+// waiting for us to read before it can read our frames. Hence, frames sent in
+// response are written from a separate go-routine.
+func (s *Session) readLoop() {
+	if err := s.readHello(); err != nil {
+		s.shutdown(err)
+		return
+	}
+
+	header := make([]byte, headerSize)
+	for {
+		if _, err := io.ReadFull(s.rwc, header); err != nil {
+			s.shutdown(err)
+			return
+		}
+		frameType := header[0]
+		id := binary.BigEndian.Uint32(header[1:5])
+		length := binary.BigEndian.Uint32(header[5:9])
+
+		switch frameType {
+		case frameOpen:
+			s.m.Lock()
+			if s.streams[id] != nil || id%2 == s.nextID%2 {
+				s.m.Unlock()
+				s.shutdown(fmt.Errorf("serialmux: invalid stream id: %d in open frame", id))
+				return
+			}
+			st := newStream(s, id)
+			s.streams[id] = st
+			s.m.Unlock()
+			select {
+			case s.accept <- st:
+			default:
+				debug("accept backlog is full, rejecting stream: %d", id)
+				s.removeStream(id)
+				go s.writeFrame(frameClose, id, 0, nil)
+			}
+		case frameData:
+			if length > maxFrameSize {
+				s.shutdown(fmt.Errorf("serialmux: data frame with length: %d is too large", length))
+				return
+			}
+			data := make([]byte, length)
+			if _, err := io.ReadFull(s.rwc, data); err != nil {
+				s.shutdown(err)
+				return
+			}
+			st := s.lookupStream(id)
+			if st == nil {
+				// Stream may be from before this session was created, if the other end
+				// was restored from a snapshot, so we tell it the stream is closed.
+				go s.writeFrame(frameClose, id, 0, nil)
+				continue
+			}
+			if !st.receive(data) {
+				s.shutdown(fmt.Errorf("serialmux: stream: %d exceeded window size", id))
+				return
+			}
+		case frameWindow:
+			if st := s.lookupStream(id); st != nil {
+				st.grant(int(length))
+			}
+		case frameClose:
+			if st := s.lookupStream(id); st != nil {
+				st.remoteClose()
+			}
+		case frameHello:
+			if id != helloMagic || length != protocolVersion {
+				s.shutdown(fmt.Errorf("serialmux: invalid hello frame, version: %d", length))
+				return
+			}
+			// The other end started a new session, so our streams are gone
+			debug("other end started a new session, resetting streams")
+			s.resetStreams()
+			go s.ack()
+		case frameAck:
+			// Reply to our hello frame, nothing to do
+		default:
+			s.shutdown(fmt.Errorf("serialmux: unknown frame type: %d", frameType))
+			return
+		}
+	}
+}
+
+// addr implements net.Addr for sessions and streams.
+type addr struct{}
+
+func (addr) Network() string { return "serialmux" }
+func (addr) String() string  { return "serialmux" }
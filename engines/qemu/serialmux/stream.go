@@ -0,0 +1,242 @@
+package serialmux
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// stream implements net.Conn for a stream multiplexed over a Session.
+type stream struct {
+	session       *Session
+	id            uint32
+	wm            sync.Mutex // Ensure writes aren't interleaved
+	m             sync.Mutex // Protect the state below
+	c             *sync.Cond // Broadcast when the state below changes
+	buf           bytes.Buffer
+	credit        int  // Bytes we may write before the other end grants more
+	localClosed   bool // True, if Close() has been called
+	remoteClosed  bool // True, if the other end closed the stream
+	err           error
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readTimer     *time.Timer
+	writeTimer    *time.Timer
+}
+
+func newStream(s *Session, id uint32) *stream {
+	st := &stream{
+		session: s,
+		id:      id,
+		credit:  windowSize,
+	}
+	st.c = sync.NewCond(&st.m)
+	return st
+}
+
+func (st *stream) Read(p []byte) (int, error) {
+	st.m.Lock()
+	for st.buf.Len() == 0 {
+		switch {
+		case st.localClosed:
+			st.m.Unlock()
+			return 0, io.ErrClosedPipe
+		case st.remoteClosed:
+			st.m.Unlock()
+			return 0, io.EOF
+		case st.err != nil:
+			st.m.Unlock()
+			return 0, st.err
+		case expired(st.readDeadline):
+			st.m.Unlock()
+			return 0, errTimeout
+		}
+		st.c.Wait()
+	}
+	n, _ := st.buf.Read(p)
+	st.m.Unlock()
+
+	// Grant credit for the data consumed, errors are ignored as the session is
+	// closed if writing fails.
+	st.session.writeFrame(frameWindow, st.id, uint32(n), nil)
+	return n, nil
+}
+
+func (st *stream) Write(p []byte) (int, error) {
+	st.wm.Lock()
+	defer st.wm.Unlock()
+
+	n := 0
+	for n < len(p) {
+		st.m.Lock()
+		for st.credit == 0 && !st.localClosed && !st.remoteClosed && st.err == nil && !expired(st.writeDeadline) {
+			st.c.Wait()
+		}
+		switch {
+		case st.localClosed, st.remoteClosed:
+			st.m.Unlock()
+			return n, io.ErrClosedPipe
+		case st.err != nil:
+			st.m.Unlock()
+			return n, st.err
+		case expired(st.writeDeadline):
+			st.m.Unlock()
+			return n, errTimeout
+		}
+		size := len(p) - n
+		if size > st.credit {
+			size = st.credit
+		}
+		if size > maxFrameSize {
+			size = maxFrameSize
+		}
+		st.credit -= size
+		st.m.Unlock()
+
+		if err := st.session.writeFrame(frameData, st.id, uint32(size), p[n:n+size]); err != nil {
+			return n, err
+		}
+		n += size
+	}
+	return n, nil
+}
+
+func (st *stream) Close() error {
+	st.m.Lock()
+	if st.localClosed {
+		st.m.Unlock()
+		return nil
+	}
+	st.localClosed = true
+	st.buf.Reset()
+	remoteClosed := st.remoteClosed
+	st.c.Broadcast()
+	st.m.Unlock()
+
+	if remoteClosed {
+		st.session.removeStream(st.id)
+	}
+	err := st.session.writeFrame(frameClose, st.id, 0, nil)
+	if err == ErrSessionClosed {
+		return nil // Closing a stream in a closed session is fine
+	}
+	return err
+}
+
+func (st *stream) LocalAddr() net.Addr {
+	return addr{}
+}
+
+func (st *stream) RemoteAddr() net.Addr {
+	return addr{}
+}
+
+func (st *stream) SetDeadline(t time.Time) error {
+	st.SetReadDeadline(t)
+	st.SetWriteDeadline(t)
+	return nil
+}
+
+func (st *stream) SetReadDeadline(t time.Time) error {
+	st.m.Lock()
+	defer st.m.Unlock()
+	st.readDeadline = t
+	st.readTimer = st.resetTimer(st.readTimer, t)
+	st.c.Broadcast()
+	return nil
+}
+
+func (st *stream) SetWriteDeadline(t time.Time) error {
+	st.m.Lock()
+	defer st.m.Unlock()
+	st.writeDeadline = t
+	st.writeTimer = st.resetTimer(st.writeTimer, t)
+	st.c.Broadcast()
+	return nil
+}
+
+// resetTimer stops timer and returns a new timer that wakes up readers and
+// writers when deadline is reached, nil if deadline is zero or in the past.
+func (st *stream) resetTimer(timer *time.Timer, deadline time.Time) *time.Timer {
+	if timer != nil {
+		timer.Stop()
+	}
+	if deadline.IsZero() {
+		return nil
+	}
+	d := deadline.Sub(time.Now())
+	if d <= 0 {
+		return nil
+	}
+	return time.AfterFunc(d, func() {
+		st.m.Lock()
+		defer st.m.Unlock()
+		st.c.Broadcast()
+	})
+}
+
+// receive appends data from the other end to the read buffer, returns false if
+// the other end exceeded the window size.
+func (st *stream) receive(data []byte) bool {
+	st.m.Lock()
+	defer st.m.Unlock()
+	if st.localClosed {
+		return true // Discard data, if we're closed
+	}
+	if st.buf.Len()+len(data) > windowSize {
+		return false
+	}
+	st.buf.Write(data)
+	st.c.Broadcast()
+	return true
+}
+
+// grant adds credit for writing to the stream.
+func (st *stream) grant(credit int) {
+	st.m.Lock()
+	defer st.m.Unlock()
+	st.credit += credit
+	st.c.Broadcast()
+}
+
+// remoteClose marks the stream as closed by the other end.
+func (st *stream) remoteClose() {
+	st.m.Lock()
+	st.remoteClosed = true
+	localClosed := st.localClosed
+	st.c.Broadcast()
+	st.m.Unlock()
+
+	if localClosed {
+		st.session.removeStream(st.id)
+	}
+}
+
+// sessionClosed fails pending and future reads and writes.
+func (st *stream) sessionClosed() {
+	st.fail(ErrSessionClosed)
+}
+
+// fail makes pending and future reads and writes return err.
+func (st *stream) fail(err error) {
+	st.m.Lock()
+	defer st.m.Unlock()
+	st.err = err
+	st.c.Broadcast()
+}
+
+// expired returns true, if deadline is set and has been reached.
+func expired(deadline time.Time) bool {
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+// timeoutError implements net.Error for deadlines being exceeded.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "serialmux: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var errTimeout net.Error = timeoutError{}
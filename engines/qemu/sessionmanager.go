@@ -12,10 +12,10 @@ import (
 	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
 )
 
-// sessionManager is responsible for creating, tracking and aborting shells and
-// displays. This ensures that shells and displays can be created until:
+// sessionManager is responsible for creating, tracking and aborting shells,
+// displays and forwarded ports. This ensures that sessions can be created until:
 //   a) Execution is aborted, or
-//   b) The task is done and the all active shells/displays will end.
+//   b) The task is done and the all active shells/displays/ports will end.
 //
 // The sessionManager is basically responsible for isolating the shell and
 // display tracking logic.
@@ -27,6 +27,7 @@ type sessionManager struct {
 	newError error                // Error, if we don't allow new shells/displays
 	shells   []engines.Shell      // Active shells
 	displays []io.ReadWriteCloser // Active displays
+	ports    []io.ReadWriteCloser // Active port connections
 }
 
 func newSessionManager(meta *metaservice.MetaService, vm *vm.VirtualMachine) *sessionManager {
@@ -55,6 +56,11 @@ func (s *sessionManager) AbortSessions() {
 	for _, c := range s.displays {
 		c.Close()
 	}
+
+	// Call close on all port connections
+	for _, c := range s.ports {
+		c.Close()
+	}
 }
 
 func (s *sessionManager) KillSessions() {
@@ -74,12 +80,17 @@ func (s *sessionManager) KillSessions() {
 	for _, c := range s.displays {
 		c.Close()
 	}
+
+	// Call close on all port connections
+	for _, c := range s.ports {
+		c.Close()
+	}
 }
 
 func (s *sessionManager) WaitAndTerminate() {
 	// Wait for all shells to have finished
 	s.m.Lock()
-	for len(s.shells) > 0 || len(s.displays) > 0 {
+	for len(s.shells) > 0 || len(s.displays) > 0 || len(s.ports) > 0 {
 		s.c.Wait()
 	}
 	// Do now allow new shells
@@ -192,3 +203,44 @@ func (s *sessionManager) OpenDisplay() (io.ReadWriteCloser, error) {
 
 	return display, nil
 }
+
+func (s *sessionManager) DialPort(port int) (io.ReadWriteCloser, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// Check that we still allow creation of new sessions, see NewShell
+	if s.newError != nil {
+		return nil, s.newError
+	}
+
+	// Connect to port inside the virtual machine
+	conn, err := s.meta.DialPort(port)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a WatchPipe around conn, so that we can remove it from ports when
+	// it is closed
+	var c io.ReadWriteCloser
+	c = ioext.WatchPipe(conn, func(_ error) {
+		// Lock so we can remove c from ports
+		s.m.Lock()
+		defer s.m.Unlock()
+
+		// Remove c from s.ports
+		ports := s.ports[:0]
+		for _, p := range s.ports {
+			if p != c {
+				ports = append(ports, p)
+			}
+		}
+		s.ports = ports
+
+		// Signal threads that ports have changed
+		s.c.Broadcast()
+	})
+	s.ports = append(s.ports, c)
+	s.c.Broadcast() // signal that we've changed ports
+
+	return c, nil
+}
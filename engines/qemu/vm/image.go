@@ -6,6 +6,7 @@ type Image interface {
 	DiskFile() string // Primary disk file to be used as boot disk.
 	Format() string   // Image format 'qcow2', 'raw', etc.
 	Machine() Machine // Machine configuration.
+	Snapshot() string // Saved virtual machine state to restore, empty-string if none.
 	Release()         // Free resources held by this image instance.
 }
 
@@ -27,6 +28,15 @@ func (i *imageMachinePair) Machine() Machine {
 	return i.machine
 }
 
+// Snapshot returns the snapshot of the image, unless the machine overwrites
+// the machine definition the snapshot was created with.
+func (i *imageMachinePair) Snapshot() string {
+	if !i.machine.Equals(i.Image.Machine()) {
+		return ""
+	}
+	return i.Image.Snapshot()
+}
+
 // OverwriteMachine returns an image with a machine definition whose properties
 // is overwritten by machine given here.
 func OverwriteMachine(image Image, machine Machine) Image {
@@ -1,15 +1,59 @@
 package vm
 
 import (
+	"os"
+	rt "runtime"
+
 	schematypes "github.com/taskcluster/go-schematypes"
 	"github.com/taskcluster/taskcluster-worker/runtime/util"
 )
 
+// Accelerators supported by QEMU.
+const (
+	AcceleratorKVM = "kvm" // Hardware virtualization using /dev/kvm
+	AcceleratorTCG = "tcg" // Software emulation, slow but works anywhere
+)
+
+// tcgCPU is the CPU model used instead of 'host', when running with TCG.
+const tcgCPU = "qemu64"
+
 // MachineLimits imposes limits on a virtual machine definition.
 type MachineLimits struct {
-	MaxMemory      int `json:"maxMemory"`
-	MaxCPUs        int `json:"maxCPUs"`
-	DefaultThreads int `json:"defaultThreads"`
+	MaxMemory      int    `json:"maxMemory"`
+	MaxCPUs        int    `json:"maxCPUs"`
+	DefaultThreads int    `json:"defaultThreads"`
+	Accelerator    string `json:"accelerator,omitempty"`
+}
+
+// accelerator returns the accelerator to use, defaulting to KVM.
+func (l MachineLimits) accelerator() string {
+	if l.Accelerator == "" {
+		return AcceleratorKVM
+	}
+	return l.Accelerator
+}
+
+// forAccelerator returns limits adjusted for the accelerator. With TCG virtual
+// CPUs are emulated by host threads, so there is no benefit from hyper-threads
+// in the guest, nor from more virtual CPUs than host CPUs.
+func (l MachineLimits) forAccelerator() MachineLimits {
+	if l.accelerator() != AcceleratorTCG {
+		return l
+	}
+	l.DefaultThreads = 1
+	if l.MaxCPUs > rt.NumCPU() {
+		l.MaxCPUs = rt.NumCPU()
+	}
+	return l
+}
+
+// DetectAccelerator returns AcceleratorKVM if /dev/kvm is available, otherwise
+// AcceleratorTCG is returned.
+func DetectAccelerator() string {
+	if _, err := os.Stat("/dev/kvm"); err != nil {
+		return AcceleratorTCG
+	}
+	return AcceleratorKVM
 }
 
 // MachineLimitsSchema is the schema for MachineOptions.
@@ -57,6 +101,20 @@ var MachineLimitsSchema = schematypes.Object{
 			Minimum: 1,
 			Maximum: 255,
 		},
+		"accelerator": schematypes.StringEnum{
+			Title: "Accelerator",
+			Description: util.Markdown(`
+				Accelerator for running the virtual machine, defaults to 'kvm'.
+
+				Use 'tcg' for software emulation on hosts without '/dev/kvm', such as
+				CI machines and containers. This is very slow and the CPU model 'host'
+				will be replaced by '` + tcgCPU + `', so virtual machine images
+				with snapshots will boot from scratch. With 'tcg' the number of
+				virtual CPUs is limited to the number of host CPUs, and
+				'defaultThreads' is always 1.
+			`),
+			Options: []string{AcceleratorKVM, AcceleratorTCG},
+		},
 	},
 	Required: []string{
 		"maxMemory",
@@ -42,6 +42,7 @@ type Machine struct {
 		KeyboardLayout string   `json:"keyboardLayout"`
 		Mouse          string   `json:"mouse"`
 		Tablet         string   `json:"tablet"`
+		Serial         string   `json:"serial"`
 	}
 }
 
@@ -62,7 +63,8 @@ var defaultMachine = (func() Machine {
 		"keyboard":        "usb-kbd",
 		"keyboardLayout":  "en-us",
 		"mouse":           "usb-mouse",
-		"tablet":          "usb-tablet"
+		"tablet":          "usb-tablet",
+		"serial":          "virtio-serial-pci"
 	}`), &m.options)
 	if err != nil {
 		panic("failed to parse static JSON config")
@@ -122,10 +124,27 @@ func (m Machine) WithDefaults(defaults Machine) Machine {
 	return Machine{options: options}
 }
 
+// Equals returns true, if m and other are identical machine definitions.
+func (m Machine) Equals(other Machine) bool {
+	a, b := m.options, other.options
+	// Empty flags are identical regardless of nil, as this may not survive
+	// serialization to machine.json
+	if len(a.Flags) == 0 && len(b.Flags) == 0 {
+		a.Flags, b.Flags = nil, nil
+	}
+	return reflect.DeepEqual(a, b)
+}
+
 // ApplyLimits returns an Machine with defaults extracted from the limits, or
 // a MalformedPayloadError if limits were violated.
 func (m Machine) ApplyLimits(limits MachineLimits) (Machine, error) {
 	o := m.options
+	limits = limits.forAccelerator()
+
+	// TCG can't expose the host CPU, so use a CPU model it can emulate
+	if o.CPU == "host" && limits.accelerator() == AcceleratorTCG {
+		o.CPU = tcgCPU
+	}
 
 	// Set defaults for memory
 	if o.Memory == 0 {
@@ -216,6 +235,7 @@ func (m Machine) DeriveLimits() MachineLimits {
 		MaxMemory:      memory,
 		MaxCPUs:        maxCPUs,
 		DefaultThreads: 1,
+		Accelerator:    DetectAccelerator(),
 	}
 }
 
@@ -244,8 +264,11 @@ var MachineSchema schematypes.Schema = schematypes.Object{
 
 				The number of virtual CPUs inside the virtual machine will be
 				'threads * cores * sockets' as configured below.
+
+				The 'host' CPU is replaced with '` + tcgCPU + `' when running
+				without KVM, as software emulation can't expose the host CPU.
 			`),
-			Options: []string{"host"},
+			Options: []string{"host", tcgCPU},
 		},
 		"flags": schematypes.Array{
 			Items: schematypes.StringEnum{},
@@ -317,6 +340,16 @@ var MachineSchema schematypes.Schema = schematypes.Object{
 		"tablet": schematypes.StringEnum{
 			Options: []string{"usb-tablet", "none"},
 		},
+		"serial": schematypes.StringEnum{
+			Title: "Serial Controller",
+			Description: util.Markdown(`
+				Controller for the virtio-serial port over which the meta-data
+				service is exposed. Machines from before this was specified can't
+				restore snapshots, as the device wasn't present when the snapshot
+				was created.
+			`),
+			Options: []string{"virtio-serial-pci"},
+		},
 	},
 	Required: []string{"version"},
 }
@@ -1,6 +1,8 @@
 package vm
 
 import (
+	"encoding/json"
+	rt "runtime"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -26,6 +28,57 @@ func TestMachineWithDefaults(t *testing.T) {
 	assert.Equal(t, "nec-usb-xhci", m2.options.USB)
 }
 
+func TestMachineEquals(t *testing.T) {
+	m, err := NewMachine(map[string]interface{}{
+		"version": float64(1),
+		"memory":  float64(512),
+	}).WithDefaults(defaultMachine).ApplyLimits(MachineLimits{
+		MaxMemory:      1024,
+		MaxCPUs:        2,
+		DefaultThreads: 1,
+	})
+	assert.NoError(t, err)
+	assert.True(t, m.Equals(m))
+	assert.False(t, m.Equals(defaultMachine))
+
+	// Machines written to machine.json must be equal when loaded again, as
+	// snapshots are only restored with an identical machine
+	data, err := json.Marshal(m)
+	assert.NoError(t, err)
+	var definition interface{}
+	assert.NoError(t, json.Unmarshal(data, &definition))
+	assert.True(t, m.Equals(NewMachine(definition)))
+
+	// Machines from before the virtio-serial controller was specified must not
+	// be equal, as their snapshots were created without the device
+	delete(definition.(map[string]interface{}), "serial")
+	assert.False(t, m.Equals(NewMachine(definition)))
+}
+
+func TestMachineApplyLimitsTCG(t *testing.T) {
+	limits := MachineLimits{
+		MaxMemory:      1024,
+		MaxCPUs:        2,
+		DefaultThreads: 1,
+	}
+	m, err := defaultMachine.ApplyLimits(limits)
+	assert.NoError(t, err)
+	assert.Equal(t, "host", m.options.CPU)
+
+	limits.Accelerator = AcceleratorTCG
+	m, err = defaultMachine.ApplyLimits(limits)
+	assert.NoError(t, err)
+	assert.Equal(t, tcgCPU, m.options.CPU)
+
+	// TCG doesn't use hyper-threads, or more CPUs than the host has
+	limits.MaxCPUs = rt.NumCPU() + 2
+	limits.DefaultThreads = 2
+	m, err = defaultMachine.ApplyLimits(limits)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, m.options.Threads)
+	assert.Equal(t, rt.NumCPU(), m.options.Threads*m.options.Cores*m.options.Sockets)
+}
+
 func TestValidateMACWithValidMACs(t *testing.T) {
 	validMACs := []string{
 		"ba:47:78:65:e1:a5",
@@ -0,0 +1,52 @@
+package vm
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/serialmux"
+)
+
+// serveMetaData serves the meta-data service over the virtio-serial port, by
+// connecting to the chardev socket at socketFile, until the virtual machine is
+// done.
+//
+// If the session fails, for example because guest-tools re-opened the port, we
+// reconnect and serve a new session. Sessions start with a handshake, so data
+// left in the port from the previous session is discarded, see serialmux.
+func (vm *VirtualMachine) serveMetaData(socketFile string) {
+	server := &http.Server{
+		Handler: http.HandlerFunc(vm.dispatchMetaData),
+	}
+	for {
+		conn, err := net.Dial("unix", socketFile)
+		if err == nil {
+			session := serialmux.Server(conn)
+			err = server.Serve(session)
+			session.Close()
+		}
+		debug("meta-data service over virtio-serial stopped, error: %s", err)
+
+		// Reconnect after a second, unless the virtual machine is done
+		select {
+		case <-vm.Done:
+			return
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+// dispatchMetaData forwards requests over the virtio-serial port to the handler
+// set with SetHTTPHandler().
+func (vm *VirtualMachine) dispatchMetaData(w http.ResponseWriter, r *http.Request) {
+	vm.m.Lock()
+	handler := vm.handler
+	vm.m.Unlock()
+
+	if handler != nil {
+		handler.ServeHTTP(w, r)
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
@@ -0,0 +1,291 @@
+package vm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/go-qemu/qmp"
+)
+
+// usagePollInterval is the interval at which resource usage is polled.
+const usagePollInterval = 5 * time.Second
+
+// clockTicks is USER_HZ, the unit of CPU times in /proc/<pid>/stat, this is
+// 100 on all Linux platforms we support.
+const clockTicks = 100
+
+// balloonPath is the QOM path of the balloon device, see NewVirtualMachine.
+const balloonPath = "/machine/peripheral/balloon-0"
+
+// Usage holds resource usage of a virtual machine. Counters are totals since the
+// virtual machine was started.
+type Usage struct {
+	CPUTime                 float64 `json:"cpuTime"`                 // Seconds of CPU time used by virtual CPUs
+	Memory                  int64   `json:"memory"`                  // Bytes of memory assigned to the guest
+	MemoryPeak              int64   `json:"memoryPeak"`              // Peak bytes of memory used by the guest, 0 if unknown
+	DiskReadBytes           int64   `json:"diskReadBytes"`           // Bytes read from disks
+	DiskWriteBytes          int64   `json:"diskWriteBytes"`          // Bytes written to disks
+	DiskReadOperations      int64   `json:"diskReadOperations"`      // Read operations on disks
+	DiskWriteOperations     int64   `json:"diskWriteOperations"`     // Write operations on disks
+	NetworkReceivedBytes    int64   `json:"networkReceivedBytes"`    // Bytes received by the guest
+	NetworkTransmittedBytes int64   `json:"networkTransmittedBytes"` // Bytes transmitted by the guest
+}
+
+// NetworkCounters may optionally be implemented by a Network to report network
+// traffic for the virtual machine.
+type NetworkCounters interface {
+	// Counters returns bytes received and transmitted by the guest, these may
+	// be offset by traffic from previous virtual machines using the Network.
+	Counters() (received, transmitted int64, err error)
+}
+
+// Usage returns resource usage totals for the virtual machine. If the virtual
+// machine is still running usage is polled before returning.
+func (vm *VirtualMachine) Usage() Usage {
+	select {
+	case <-vm.Done:
+	default:
+		vm.updateUsage()
+	}
+
+	vm.m.Lock()
+	defer vm.m.Unlock()
+	return vm.usage
+}
+
+// pollUsage polls resource usage until the virtual machine is done.
+func (vm *VirtualMachine) pollUsage() {
+	// Ask the balloon driver to report memory statistics, this is ignored by
+	// guests without the balloon driver.
+	_, err := vm.domain.Run(qmp.Command{
+		Execute: "qom-set",
+		Args: map[string]interface{}{
+			"path":     balloonPath,
+			"property": "guest-stats-polling-interval",
+			"value":    int(usagePollInterval / time.Second),
+		},
+	})
+	if err != nil {
+		debug("failed to enable balloon statistics, error: %s", err)
+	}
+
+	ticker := time.NewTicker(usagePollInterval)
+	defer ticker.Stop()
+	for {
+		vm.updateUsage()
+		select {
+		case <-ticker.C:
+		case <-vm.Done:
+			return
+		}
+	}
+}
+
+// updateUsage polls resource usage from QMP and updates vm.usage, errors are
+// ignored as usage is only informational.
+func (vm *VirtualMachine) updateUsage() {
+	// Ensure that we don't poll concurrently
+	vm.usageLock.Lock()
+	defer vm.usageLock.Unlock()
+
+	vm.m.Lock()
+	domain := vm.domain
+	network := vm.network
+	vm.m.Unlock()
+	if domain == nil {
+		return
+	}
+
+	var usage Usage
+	if err := vm.pollCPUTime(&usage); err != nil {
+		debug("failed to poll CPU time, error: %s", err)
+	}
+	if err := vm.pollMemory(&usage); err != nil {
+		debug("failed to poll memory, error: %s", err)
+	}
+	if err := vm.pollBlockStats(&usage); err != nil {
+		debug("failed to poll block statistics, error: %s", err)
+	}
+	var received, transmitted int64
+	if n, ok := network.(NetworkCounters); ok {
+		var err error
+		if received, transmitted, err = n.Counters(); err != nil {
+			debug("failed to poll network counters, error: %s", err)
+		}
+	}
+
+	vm.m.Lock()
+	defer vm.m.Unlock()
+	// Counters only increase, so keep the largest value seen, this way a failed
+	// poll won't reset the totals.
+	vm.usage.CPUTime = maxFloat(vm.usage.CPUTime, usage.CPUTime)
+	vm.usage.Memory = maxInt(vm.usage.Memory, usage.Memory)
+	vm.usage.MemoryPeak = maxInt(vm.usage.MemoryPeak, usage.MemoryPeak)
+	vm.usage.DiskReadBytes = maxInt(vm.usage.DiskReadBytes, usage.DiskReadBytes)
+	vm.usage.DiskWriteBytes = maxInt(vm.usage.DiskWriteBytes, usage.DiskWriteBytes)
+	vm.usage.DiskReadOperations = maxInt(vm.usage.DiskReadOperations, usage.DiskReadOperations)
+	vm.usage.DiskWriteOperations = maxInt(vm.usage.DiskWriteOperations, usage.DiskWriteOperations)
+	vm.usage.NetworkReceivedBytes = maxInt(vm.usage.NetworkReceivedBytes, received-vm.receivedBaseline)
+	vm.usage.NetworkTransmittedBytes = maxInt(vm.usage.NetworkTransmittedBytes, transmitted-vm.transmittedBaseline)
+}
+
+// pollCPUTime sets CPUTime from the CPU time used by the threads running the
+// virtual CPUs.
+func (vm *VirtualMachine) pollCPUTime(usage *Usage) error {
+	// query-cpus-fast doesn't interrupt the guest, but requires QEMU 2.12, so
+	// fallback to query-cpus for older versions.
+	var result struct {
+		Return []struct {
+			ThreadID    int `json:"thread-id"`
+			OldThreadID int `json:"thread_id"`
+		} `json:"return"`
+	}
+	raw, err := vm.domain.Run(qmp.Command{Execute: "query-cpus-fast"})
+	if err != nil {
+		raw, err = vm.domain.Run(qmp.Command{Execute: "query-cpus"})
+	}
+	if err != nil {
+		return fmt.Errorf("Failed QMP command 'query-cpus', error: %s", err)
+	}
+	if err = json.Unmarshal(raw, &result); err != nil {
+		return fmt.Errorf("Failed to parse result from 'query-cpus', error: %s", err)
+	}
+
+	pid := vm.qemu.Process.Pid
+	for _, cpu := range result.Return {
+		tid := cpu.ThreadID
+		if tid == 0 {
+			tid = cpu.OldThreadID
+		}
+		ticks, err := threadCPUTicks(pid, tid)
+		if err != nil {
+			return err
+		}
+		usage.CPUTime += float64(ticks) / clockTicks
+	}
+	return nil
+}
+
+// threadCPUTicks returns user and system time used by a thread in clockTicks.
+func threadCPUTicks(pid, tid int) (int64, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/task/%d/stat", pid, tid))
+	if err != nil {
+		return 0, fmt.Errorf("Failed to read CPU time for thread %d, error: %s", tid, err)
+	}
+	return parseCPUTicks(string(data))
+}
+
+// parseCPUTicks returns utime + stime from the contents of /proc/<pid>/stat.
+func parseCPUTicks(stat string) (int64, error) {
+	// The process name may contain spaces, so skip past it before splitting,
+	// fields following are documented in proc(5) starting with state (3).
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 13 {
+		return 0, errors.New("Failed to parse CPU time, too few fields in stat")
+	}
+	utime, err := strconv.ParseInt(fields[11], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to parse utime, error: %s", err)
+	}
+	stime, err := strconv.ParseInt(fields[12], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to parse stime, error: %s", err)
+	}
+	return utime + stime, nil
+}
+
+// pollMemory sets Memory from the balloon device and MemoryPeak from the memory
+// statistics reported by the balloon driver in the guest.
+func (vm *VirtualMachine) pollMemory(usage *Usage) error {
+	raw, err := vm.domain.Run(qmp.Command{Execute: "query-balloon"})
+	if err != nil {
+		return fmt.Errorf("Failed QMP command 'query-balloon', error: %s", err)
+	}
+	var balloon struct {
+		Return struct {
+			Actual int64 `json:"actual"`
+		} `json:"return"`
+	}
+	if err = json.Unmarshal(raw, &balloon); err != nil {
+		return fmt.Errorf("Failed to parse result from 'query-balloon', error: %s", err)
+	}
+	usage.Memory = balloon.Return.Actual
+
+	raw, err = vm.domain.Run(qmp.Command{
+		Execute: "qom-get",
+		Args: map[string]interface{}{
+			"path":     balloonPath,
+			"property": "guest-stats",
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed QMP command 'qom-get', error: %s", err)
+	}
+	// Statistics are -1 if not reported by the guest
+	var stats struct {
+		Return struct {
+			Stats struct {
+				Total     int64 `json:"stat-total-memory"`
+				Free      int64 `json:"stat-free-memory"`
+				Available int64 `json:"stat-available-memory"`
+			} `json:"stats"`
+		} `json:"return"`
+	}
+	if err = json.Unmarshal(raw, &stats); err != nil {
+		return fmt.Errorf("Failed to parse result from 'qom-get', error: %s", err)
+	}
+	s := stats.Return.Stats
+	if s.Total > 0 && s.Available >= 0 {
+		usage.MemoryPeak = s.Total - s.Available
+	} else if s.Total > 0 && s.Free >= 0 {
+		usage.MemoryPeak = s.Total - s.Free
+	}
+	return nil
+}
+
+// pollBlockStats sets disk counters from the sum of all block devices.
+func (vm *VirtualMachine) pollBlockStats(usage *Usage) error {
+	raw, err := vm.domain.Run(qmp.Command{Execute: "query-blockstats"})
+	if err != nil {
+		return fmt.Errorf("Failed QMP command 'query-blockstats', error: %s", err)
+	}
+	var result struct {
+		Return []struct {
+			Stats struct {
+				ReadBytes       int64 `json:"rd_bytes"`
+				WriteBytes      int64 `json:"wr_bytes"`
+				ReadOperations  int64 `json:"rd_operations"`
+				WriteOperations int64 `json:"wr_operations"`
+			} `json:"stats"`
+		} `json:"return"`
+	}
+	if err = json.Unmarshal(raw, &result); err != nil {
+		return fmt.Errorf("Failed to parse result from 'query-blockstats', error: %s", err)
+	}
+	for _, device := range result.Return {
+		usage.DiskReadBytes += device.Stats.ReadBytes
+		usage.DiskWriteBytes += device.Stats.WriteBytes
+		usage.DiskReadOperations += device.Stats.ReadOperations
+		usage.DiskWriteOperations += device.Stats.WriteOperations
+	}
+	return nil
+}
+
+func maxInt(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,28 @@
+package vm
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCPUTicks(t *testing.T) {
+	// Process names may contain spaces and parentheses
+	stat := "4242 (qemu (x) 1) S 1 4242 4242 0 -1 4194560 1 0 0 0 150 25 0 0 20 0 4 0 100 0 0"
+	ticks, err := parseCPUTicks(stat)
+	require.NoError(t, err)
+	assert.Equal(t, int64(175), ticks)
+
+	_, err = parseCPUTicks("4242 (qemu) S 1 2 3")
+	assert.Error(t, err)
+}
+
+func TestThreadCPUTicks(t *testing.T) {
+	// The main thread has the same id as the process
+	pid := os.Getpid()
+	ticks, err := threadCPUTicks(pid, pid)
+	require.NoError(t, err)
+	assert.True(t, ticks >= 0)
+}
@@ -2,6 +2,9 @@ package vm
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"io"
@@ -20,14 +23,20 @@ import (
 	"github.com/fsnotify/fsnotify"
 	pnm "github.com/jbuchbinder/gopnm"
 	"github.com/taskcluster/slugid-go/slugid"
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/metaservice"
 	"github.com/taskcluster/taskcluster-worker/runtime"
 )
 
 const (
-	vncSocketFile = "vnc.sock"
-	qmpSocketFile = "qmp.sock"
+	vncSocketFile      = "vnc.sock"
+	qmpSocketFile      = "qmp.sock"
+	metaDataSocketFile = "metadata.sock"
 )
 
+// restoreTimeout is the maximum time to wait for virtual machine state to be
+// saved or loaded.
+const restoreTimeout = 5 * time.Minute
+
 // LinuxBootOptions holds optionals boot options for Linux.
 // These are exclusively useful for building images and should not be used in
 // production when running per-task VMs. But they can greatly simplify image
@@ -51,12 +60,31 @@ type VirtualMachine struct {
 	qemuDone     chan<- struct{}
 	Done         <-chan struct{} // Closed when the virtual machine is done
 	Error        error           // Error, to be read after Done is closed
+	Panicked     <-chan struct{} // Closed if the guest reports a kernel panic
 	monitor      runtime.Monitor
 	domain       *qemu.Domain
+	machine      Machine      // Machine with defaults and limits applied
+	handler      http.Handler // Handler for meta-data over virtio-serial
+
+	// State for restoring from a snapshot
+	restored       bool                     // True, if restoring a snapshot
+	hotplugDrives  []string                 // Arguments for drive_add after restore
+	hotplugDevices []map[string]interface{} // Arguments for device_add after restore
+
+	// State for diagnostics
+	panicked    chan<- struct{} // Closed when first GUEST_PANICKED is received
+	panicOnce   sync.Once       // Ensure panicked is closed once
+	panicEvents []qmp.Event     // GUEST_PANICKED events received
+
+	// State for resource usage
+	usageLock           sync.Mutex // Ensure usage isn't polled concurrently
+	usage               Usage      // Usage totals, protected by m
+	receivedBaseline    int64      // Network counters before QEMU was started
+	transmittedBaseline int64
 }
 
 // NewVirtualMachine constructs a new virtual machine using the given
-// machineOptions, image, network and cdroms.
+// machineOptions, image, network, cdroms and volumes.
 //
 // Returns engines.MalformedPayloadError if machineOptions and image definition
 // are conflicting. If this returns an error, caller is responsible for
@@ -65,7 +93,7 @@ type VirtualMachine struct {
 func NewVirtualMachine(
 	limits MachineLimits,
 	image Image, network Network, socketFolder, cdrom1, cdrom2 string,
-	bootOptions LinuxBootOptions,
+	volumes []Volume, bootOptions LinuxBootOptions,
 	monitor runtime.Monitor,
 ) (*VirtualMachine, error) {
 	// Get machine definition and set defaults
@@ -75,6 +103,14 @@ func NewVirtualMachine(
 	}
 	o := m.options
 
+	// Restore from snapshot only if the machine is identical to the machine the
+	// snapshot was created with, otherwise the snapshot can't be loaded.
+	snapshot := image.Snapshot()
+	if snapshot != "" && !m.Equals(image.Machine()) {
+		debug("not restoring snapshot '%s' as machine differs from image", snapshot)
+		snapshot = ""
+	}
+
 	// Create a sub-folder in the socketFolder
 	socketFolder = filepath.Join(socketFolder, slugid.Nice())
 
@@ -83,17 +119,20 @@ func NewVirtualMachine(
 		socketFolder: socketFolder,
 		network:      network,
 		image:        image,
+		machine:      m,
+		restored:     snapshot != "",
 		monitor:      monitor,
 	}
 
 	vncSocket := filepath.Join(vm.socketFolder, vncSocketFile)
 	qmpSocket := filepath.Join(vm.socketFolder, qmpSocketFile)
+	metaDataSocket := filepath.Join(vm.socketFolder, metaDataSocketFile)
 
 	// Construct options for QEMU
 	var options []string
 	// Auxiliary functions for defining options
 	type args map[string]string
-	option := func(option, prefix string, args args) {
+	format := func(prefix string, args args) string {
 		// Sort for consistency. QEMU shouldn't care about order, but if there is
 		// a bug it's nice that it's consistent.
 		keys := make([]string, 0, len(args))
@@ -110,7 +149,10 @@ func NewVirtualMachine(
 		if prefix != "" {
 			pairs = append([]string{prefix}, pairs...)
 		}
-		options = append(options, "-"+option, strings.Join(pairs, ","))
+		return strings.Join(pairs, ",")
+	}
+	option := func(option, prefix string, args args) {
+		options = append(options, "-"+option, format(prefix, args))
 	}
 	device := func(device string, args args) { option("device", device, args) }
 	drive := func(flags string, args args) { option("drive", flags, args) }
@@ -137,6 +179,12 @@ func NewVirtualMachine(
 		option("initrd", bootOptions.Initrd, nil)
 	}
 
+	if snapshot != "" {
+		// Load virtual machine state saved by SaveSnapshot(), the disk state is
+		// given by the image disk file.
+		options = append(options, "-incoming", "exec:cat '"+snapshot+"'")
+	}
+
 	option("boot", "", args{
 		"menu":   "off",
 		"strict": "on",
@@ -155,7 +203,7 @@ func NewVirtualMachine(
 		// TODO: fit to system HT, see: https://www.kernel.org/doc/Documentation/ABI/testing/sysfs-devices-system-cpu
 	})
 	option("machine", o.Chipset, args{
-		"accel": "kvm",
+		"accel": limits.accelerator(),
 		// TODO: Configure additional options
 	})
 	option("vnc", "unix:"+vncSocket, args{
@@ -193,6 +241,11 @@ func NewVirtualMachine(
 		"addr": "0x4", // Always put balloon on PCI 0x4
 	})
 
+	// Paravirtualized panic device, lets the guest report kernel panics
+	device("pvpanic", args{
+		"id": "pvpanic-0",
+	})
+
 	// Network
 	option("netdev", vm.network.NetDev("netdev-0"), nil)
 	device(o.Network, args{
@@ -226,6 +279,23 @@ func NewVirtualMachine(
 		})
 	}
 
+	// Virtio serial port for the meta-data service, this way guest-tools can
+	// reach the meta-data service without networking.
+	device(o.Serial, args{
+		"id":   "virtio-serial-0",
+		"bus":  "pci.0",
+		"addr": "0x7", // Always put virtio-serial on PCI 0x7
+	})
+	option("chardev", "socket,server,nowait", args{
+		"id":   "metadatasocket",
+		"path": metaDataSocket,
+	})
+	device("virtserialport", args{
+		"bus":     "virtio-serial-0.0",
+		"chardev": "metadatasocket",
+		"name":    metaservice.SerialPortName,
+	})
+
 	// Storage
 	drive("", args{
 		"file":   vm.image.DiskFile(),
@@ -240,7 +310,7 @@ func NewVirtualMachine(
 	device(o.Storage, args{
 		"scsi":      "off",
 		"bus":       "pci.0",
-		"addr":      "0x8", // Start disks as 0x8, reserve 0x7 for future
+		"addr":      "0x8", // Start disks as 0x8
 		"drive":     "boot-disk",
 		"id":        "virtio-disk0",
 		"bootindex": "1",
@@ -312,11 +382,84 @@ func NewVirtualMachine(
 		})
 	}
 
+	// Volumes for caches, etc. When restoring a snapshot the volume devices are
+	// hot-plugged after the snapshot is loaded, as the guest didn't have these
+	// devices when the snapshot was created.
+	if len(volumes) > MaxVolumes {
+		return nil, fmt.Errorf("at most %d volumes can be attached, got %d", MaxVolumes, len(volumes))
+	}
+	for i, v := range volumes {
+		id := fmt.Sprintf("volume-%d", i)
+		deviceArgs := args{
+			"id":   "virtio-" + id,
+			"bus":  "pci.0",
+			"addr": fmt.Sprintf("0x%x", 0x9+i), // Volumes are put on PCI 0x9 and up
+		}
+		if v.Folder != "" {
+			// Using 'mapped-file' the guest can own files and set permissions,
+			// without requiring extended attributes on the host file system.
+			flags := "local"
+			if v.ReadOnly {
+				flags = "local,readonly"
+			}
+			option("fsdev", flags, args{
+				"id":             id,
+				"path":           v.Folder,
+				"security_model": "mapped-file",
+			})
+			deviceArgs["driver"] = "virtio-9p-pci"
+			deviceArgs["fsdev"] = id
+			deviceArgs["mount_tag"] = v.Tag
+		} else {
+			driveArgs := args{
+				"file":   v.DiskFile,
+				"if":     "none",
+				"id":     id,
+				"cache":  "unsafe", // TODO: Reconsider 'native' w. cache not 'unsafe'
+				"aio":    "threads",
+				"format": "qcow2",
+				"werror": "report",
+				"rerror": "report",
+			}
+			if snapshot != "" {
+				// The saved state only has devices present when it was saved, so we
+				// add the drive after the state is loaded
+				if v.ReadOnly {
+					driveArgs["readonly"] = "on"
+				}
+				vm.hotplugDrives = append(vm.hotplugDrives, format("", driveArgs))
+			} else if v.ReadOnly {
+				drive("readonly", driveArgs)
+			} else {
+				drive("", driveArgs)
+			}
+			deviceArgs["driver"] = "virtio-blk-pci"
+			deviceArgs["drive"] = id
+			deviceArgs["serial"] = v.Tag
+		}
+		if snapshot != "" {
+			hotplug := make(map[string]interface{}, len(deviceArgs))
+			for k, v := range deviceArgs {
+				hotplug[k] = v
+			}
+			vm.hotplugDevices = append(vm.hotplugDevices, hotplug)
+		} else {
+			driver := deviceArgs["driver"]
+			delete(deviceArgs, "driver")
+			device(driver, deviceArgs)
+		}
+	}
+
 	// Create done channel
 	qemuDone := make(chan struct{})
 	vm.qemuDone = qemuDone
 	vm.Done = qemuDone
 
+	// Create panicked channel
+	panicked := make(chan struct{})
+	vm.panicked = panicked
+	vm.Panicked = panicked
+
 	// Create QEMU process
 	vm.qemu = exec.Command("qemu-system-x86_64", options...)
 
@@ -327,6 +470,7 @@ func NewVirtualMachine(
 func (vm *VirtualMachine) SetHTTPHandler(handler http.Handler) {
 	vm.m.Lock()
 	defer vm.m.Unlock()
+	vm.handler = handler
 	if vm.network != nil {
 		// Ignore the case where network has been released
 		vm.network.SetHandler(handler)
@@ -369,6 +513,11 @@ func (vm *VirtualMachine) Start() {
 		return
 	}
 
+	// Read network counters, as networks are reused between virtual machines
+	if n, ok := vm.network.(NetworkCounters); ok {
+		vm.receivedBaseline, vm.transmittedBaseline, _ = n.Counters()
+	}
+
 	// Start QEMU
 	vm.Error = vm.qemu.Start()
 	if vm.Error != nil {
@@ -424,7 +573,7 @@ func (vm *VirtualMachine) Start() {
 		close(vm.qemuDone)
 	}()
 
-	// Wait for vncSocket and qmpSocket to appear, or qemu to crash
+	// Wait for sockets to appear, or qemu to crash
 	select {
 	case err = <-socketsReady:
 		if err != nil {
@@ -435,6 +584,9 @@ func (vm *VirtualMachine) Start() {
 		return
 	}
 
+	// Serve meta-data over the virtio-serial port
+	go vm.serveMetaData(filepath.Join(socketFolder, metaDataSocketFile))
+
 	// Create monitor
 	qmpSocket := filepath.Join(socketFolder, qmpSocketFile)
 	monitor, err := qmp.NewSocketMonitor("unix", qmpSocket, 5*time.Second)
@@ -474,6 +626,24 @@ func (vm *VirtualMachine) Start() {
 	}
 	vm.m.Unlock()
 
+	// Watch for guest panic events
+	events, stopEvents, err := vm.domain.Events()
+	if err != nil {
+		debug("Error subscribing to QMP events, error: %s", err)
+		vm.abort(fmt.Errorf("Failed to subscribe to QMP events, error: %s", err))
+		return
+	}
+	go vm.watchEvents(events, stopEvents)
+
+	// Wait for the saved state to be loaded, before we start execution
+	if vm.restored {
+		if err = vm.waitForIncoming(); err != nil {
+			debug("Error loading saved state, error: %s", err)
+			vm.abort(err)
+			return
+		}
+	}
+
 	// Run QMP command continue to start execution
 	_, err = vm.domain.Run(qmp.Command{
 		Execute: "cont",
@@ -481,7 +651,267 @@ func (vm *VirtualMachine) Start() {
 	if err != nil {
 		debug("Error executing QMP command 'cont', error: %s", err)
 		vm.abort(fmt.Errorf("Failed QMP command 'cont', error: %s", err))
+		return
 	}
+
+	if vm.restored {
+		if err = vm.afterRestore(); err != nil {
+			debug("Error preparing restored snapshot, error: %s", err)
+			vm.abort(err)
+			return
+		}
+	}
+
+	// Poll resource usage while running
+	go vm.pollUsage()
+}
+
+// waitForIncoming waits for QEMU to load the virtual machine state given with
+// -incoming, QEMU exits if the state can't be loaded.
+func (vm *VirtualMachine) waitForIncoming() error {
+	deadline := time.Now().Add(restoreTimeout)
+	for {
+		raw, err := vm.domain.Run(qmp.Command{Execute: "query-status"})
+		if err != nil {
+			return fmt.Errorf("Failed QMP command 'query-status', error: %s", err)
+		}
+		var status struct {
+			Return struct {
+				Status string `json:"status"`
+			} `json:"return"`
+		}
+		if err = json.Unmarshal(raw, &status); err != nil {
+			return fmt.Errorf("Failed to parse result from 'query-status', error: %s", err)
+		}
+		if status.Return.Status != "inmigrate" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("Timeout loading saved virtual machine state")
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-vm.Done:
+			return errors.New("QEMU exited while loading saved virtual machine state")
+		}
+	}
+}
+
+// afterRestore hot-plugs volumes and resets the network link after a snapshot
+// has been restored, such that the guest renews its network configuration.
+func (vm *VirtualMachine) afterRestore() error {
+	for _, drive := range vm.hotplugDrives {
+		if err := vm.humanMonitorCommand("drive_add 0 " + drive); err != nil {
+			return fmt.Errorf("Failed to add drive after restoring snapshot, error: %s", err)
+		}
+	}
+	for _, device := range vm.hotplugDevices {
+		_, err := vm.domain.Run(qmp.Command{
+			Execute: "device_add",
+			Args:    device,
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to add device after restoring snapshot, error: %s", err)
+		}
+	}
+
+	// Toggle the network link, so the guest renews DHCP lease for the network
+	// it has been restored into.
+	for _, up := range []bool{false, true} {
+		_, err := vm.domain.Run(qmp.Command{
+			Execute: "set_link",
+			Args:    map[string]interface{}{"name": "nic0", "up": up},
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to reset network link after restoring snapshot, error: %s", err)
+		}
+	}
+	return nil
+}
+
+// watchEvents records GUEST_PANICKED events from events until the virtual
+// machine is done, then closes stop.
+func (vm *VirtualMachine) watchEvents(events <-chan qmp.Event, stop chan<- struct{}) {
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return // Event stream closed, the monitor socket is gone
+			}
+			if e.Event != "GUEST_PANICKED" {
+				continue
+			}
+			debug("guest panicked: %v", e.Data)
+			vm.m.Lock()
+			vm.panicEvents = append(vm.panicEvents, e)
+			vm.m.Unlock()
+			vm.panicOnce.Do(func() { close(vm.panicked) })
+		case <-vm.Done:
+			// Stop the subscription and drain events until the stream is closed, so
+			// the domain isn't blocked sending to us.
+			close(stop)
+			for range events {
+			}
+			return
+		}
+	}
+}
+
+// humanMonitor runs a command using the human monitor and returns the output,
+// this is necessary for commands without a QMP equivalent.
+func (vm *VirtualMachine) humanMonitor(command string) (string, error) {
+	raw, err := vm.domain.Run(qmp.Command{
+		Execute: "human-monitor-command",
+		Args:    map[string]interface{}{"command-line": command},
+	})
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		Return string `json:"return"`
+	}
+	if err = json.Unmarshal(raw, &result); err != nil {
+		return "", fmt.Errorf("Failed to parse result from '%s', error: %s", command, err)
+	}
+	return result.Return, nil
+}
+
+// humanMonitorCommand runs a command using the human monitor, this is necessary
+// for commands without a QMP equivalent. Output from such commands is an error.
+func (vm *VirtualMachine) humanMonitorCommand(command string) error {
+	output, err := vm.humanMonitor(command)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(output) != "" && !strings.HasPrefix(output, "OK") {
+		return errors.New(strings.TrimSpace(output))
+	}
+	return nil
+}
+
+// Diagnostics returns a human readable report of the virtual machine state for
+// debugging failed tasks. This includes the run state, guest panic events and
+// CPU registers.
+func (vm *VirtualMachine) Diagnostics() (string, error) {
+	vm.m.Lock()
+	domain := vm.domain
+	panics := append([]qmp.Event(nil), vm.panicEvents...)
+	vm.m.Unlock()
+	if domain == nil {
+		return "", errors.New("virtual machine isn't running")
+	}
+
+	var b bytes.Buffer
+	raw, err := domain.Run(qmp.Command{Execute: "query-status"})
+	if err != nil {
+		return "", fmt.Errorf("Failed QMP command 'query-status', error: %s", err)
+	}
+	var status struct {
+		Return struct {
+			Status  string `json:"status"`
+			Running bool   `json:"running"`
+		} `json:"return"`
+	}
+	if err = json.Unmarshal(raw, &status); err != nil {
+		return "", fmt.Errorf("Failed to parse result from 'query-status', error: %s", err)
+	}
+	fmt.Fprintf(&b, "status: %s\n", status.Return.Status)
+	fmt.Fprintf(&b, "running: %v\n", status.Return.Running)
+
+	fmt.Fprintf(&b, "\nguest panic events: %d\n", len(panics))
+	for _, e := range panics {
+		data, _ := json.Marshal(e.Data)
+		t := time.Unix(e.Timestamp.Seconds, e.Timestamp.Microseconds*int64(time.Microsecond))
+		fmt.Fprintf(&b, "%s %s\n", t.UTC().Format(time.RFC3339), data)
+	}
+
+	registers, err := vm.humanMonitor("info registers")
+	if err != nil {
+		return "", fmt.Errorf("Failed to read registers, error: %s", err)
+	}
+	fmt.Fprintf(&b, "\nregisters:\n%s", registers)
+
+	return b.String(), nil
+}
+
+// SaveSnapshot pauses the virtual machine and saves the memory and device state
+// to stateFile, then QEMU is terminated. Disk state isn't saved, so the image
+// disk file mustn't be modified afterwards, instead virtual machines restored
+// from stateFile should use a qcow2 overlay backed by the disk file.
+//
+// The state can be restored by starting a new VirtualMachine from an image
+// whose Snapshot() method returns stateFile.
+func (vm *VirtualMachine) SaveSnapshot(stateFile string) error {
+	vm.m.Lock()
+	domain := vm.domain
+	vm.m.Unlock()
+	if domain == nil {
+		return errors.New("virtual machine isn't running")
+	}
+
+	if _, err := domain.Run(qmp.Command{Execute: "stop"}); err != nil {
+		return fmt.Errorf("Failed to pause virtual machine, error: %s", err)
+	}
+	debug("saving virtual machine state to: %s", stateFile)
+	_, err := domain.Run(qmp.Command{
+		Execute: "migrate",
+		Args:    map[string]interface{}{"uri": "exec:cat > '" + stateFile + "'"},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to save virtual machine state, error: %s", err)
+	}
+	if err = vm.waitForMigration(domain); err != nil {
+		return err
+	}
+
+	// Quit gracefully, so QEMU closes the disk files
+	if _, err := domain.Run(qmp.Command{Execute: "quit"}); err != nil {
+		debug("Error executing QMP command 'quit', error: %s", err)
+		vm.Kill()
+	}
+	<-vm.Done
+	return nil
+}
+
+// waitForMigration waits for an outgoing migration to complete.
+func (vm *VirtualMachine) waitForMigration(domain *qemu.Domain) error {
+	deadline := time.Now().Add(restoreTimeout)
+	for {
+		raw, err := domain.Run(qmp.Command{Execute: "query-migrate"})
+		if err != nil {
+			return fmt.Errorf("Failed QMP command 'query-migrate', error: %s", err)
+		}
+		var status struct {
+			Return struct {
+				Status    string `json:"status"`
+				ErrorDesc string `json:"error-desc"`
+			} `json:"return"`
+		}
+		if err = json.Unmarshal(raw, &status); err != nil {
+			return fmt.Errorf("Failed to parse result from 'query-migrate', error: %s", err)
+		}
+		switch status.Return.Status {
+		case "completed":
+			return nil
+		case "failed", "cancelled":
+			return fmt.Errorf("Failed to save virtual machine state, status: %s, error: %s",
+				status.Return.Status, status.Return.ErrorDesc)
+		}
+		if time.Now().After(deadline) {
+			return errors.New("Timeout saving virtual machine state")
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-vm.Done:
+			return errors.New("QEMU exited while saving virtual machine state")
+		}
+	}
+}
+
+// Machine returns the machine definition the virtual machine is running, this
+// includes defaults and limits applied.
+func (vm *VirtualMachine) Machine() Machine {
+	return vm.machine
 }
 
 // abort kills the VM and sets the error, if it's not already dead with another
@@ -536,7 +966,7 @@ func (vm *VirtualMachine) Screenshot() (image.Image, error) {
 }
 
 // waitForSockets will monitor socketFolder and return a channel that is closed
-// when vncSocketFile and qmpSocketFile have been created.
+// when vncSocketFile, qmpSocketFile and metaDataSocketFile have been created.
 func (vm *VirtualMachine) waitForSockets() (<-chan error, error) {
 	done := make(chan error)
 
@@ -558,7 +988,8 @@ func (vm *VirtualMachine) waitForSockets() (<-chan error, error) {
 	go func() {
 		vncReady := false
 		qmpReady := false
-		for !vncReady || !qmpReady {
+		metaDataReady := false
+		for !vncReady || !qmpReady || !metaDataReady {
 			select {
 			case e := <-w.Events:
 				debug("fs-event: %s", e)
@@ -569,13 +1000,16 @@ func (vm *VirtualMachine) waitForSockets() (<-chan error, error) {
 					if e.Name == filepath.Join(socketFolder, qmpSocketFile) {
 						qmpReady = true
 					}
+					if e.Name == filepath.Join(socketFolder, metaDataSocketFile) {
+						metaDataReady = true
+					}
 				}
 			case <-vm.Done:
 				// Stop monitoring if QEMU has crashed
 				w.Close()
 				return
 			case <-time.After(90 * time.Second):
-				done <- fmt.Errorf("vnc and qmp sockets didn't show up in 90s")
+				done <- fmt.Errorf("vnc, qmp and meta-data sockets didn't show up in 90s")
 				w.Close()
 				return
 			case err := <-w.Errors:
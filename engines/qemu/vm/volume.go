@@ -0,0 +1,18 @@
+package vm
+
+// A Volume is a host folder or disk image attached to the virtual machine, such
+// that qemu-guest-tools can mount it inside the guest.
+//
+// Exactly one of Folder and DiskFile must be given. A folder is shared with the
+// guest using virtio-9p with Tag as mount tag, a disk image is attached as a
+// virtio-blk device with Tag as serial number.
+type Volume struct {
+	Tag      string // Mount tag or disk serial, at most 20 characters
+	Folder   string // Host folder to share with the guest
+	DiskFile string // qcow2 disk image to attach
+	ReadOnly bool
+}
+
+// MaxVolumes is the maximum number of volumes that can be attached to a
+// virtual machine, volumes are attached at PCI 0x9 to 0x1f.
+const MaxVolumes = 0x1f - 0x9 + 1
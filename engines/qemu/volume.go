@@ -0,0 +1,101 @@
+package qemuengine
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/taskcluster/taskcluster-worker/engines"
+	"github.com/taskcluster/taskcluster-worker/engines/qemu/metaservice"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+)
+
+const (
+	volumeTypeFolder = "folder"
+	volumeTypeDisk   = "disk"
+)
+
+// volume is a cache folder or memory disk. A cache folder is either a host
+// folder shared with the guest using virtio-9p, or an ext4 formatted qcow2 disk
+// image attached as a virtio-blk device. A memory disk is a tmpfs mounted in
+// the guest, so it has nothing attached to the virtual machine.
+type volume struct {
+	engines.VolumeBase
+	folder     runtime.TemporaryFolder // Set, if this is a folder volume
+	diskFile   string                  // Set, if this is a disk volume
+	memorySize int                     // Set, if this is a memory disk (MiB)
+}
+
+// newVolume creates a new volume of given volumeType in storage, diskSize is
+// the virtual size of disk volumes in MiB.
+func newVolume(storage runtime.TemporaryStorage, volumeType string, diskSize int) (*volume, error) {
+	if volumeType == volumeTypeFolder {
+		folder, err := storage.NewFolder()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create folder for volume, error: %s", err)
+		}
+		return &volume{folder: folder}, nil
+	}
+
+	// Format the disk before it is attached, so that it can be mounted read-only
+	// the first time it is used. mkfs.ext4 only writes metadata to the sparse
+	// file, and qemu-img convert skips zeros, so the qcow2 image stays sparse.
+	rawFile := storage.NewFilePath()
+	defer os.Remove(rawFile)
+	f, err := os.Create(rawFile)
+	if err == nil {
+		err = f.Truncate(int64(diskSize) * 1024 * 1024)
+		f.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create disk for volume, error: %s", err)
+	}
+	p := exec.Command("mkfs.ext4", "-q", "-F", "--", rawFile)
+	if output, err := p.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to format disk for volume, error: %s, output: %s", err, string(output))
+	}
+	diskFile := storage.NewFilePath() + ".qcow2"
+	p = exec.Command(
+		"qemu-img", "convert", "-f", "raw", "-O", "qcow2", "-o", "lazy_refcounts=on",
+		rawFile, diskFile,
+	)
+	if output, err := p.CombinedOutput(); err != nil {
+		os.Remove(diskFile)
+		return nil, fmt.Errorf("failed to create disk for volume, error: %s, output: %s", err, string(output))
+	}
+	return &volume{diskFile: diskFile}, nil
+}
+
+// newMemoryDisk creates a new memory disk with a maximum size in MiB
+func newMemoryDisk(size int) *volume {
+	return &volume{memorySize: size}
+}
+
+// folderPath returns the host folder for folder volumes, empty otherwise
+func (v *volume) folderPath() string {
+	if v.folder != nil {
+		return v.folder.Path()
+	}
+	return ""
+}
+
+// mountType returns the type of mount in the guest for this volume
+func (v *volume) mountType() string {
+	if v.folder != nil {
+		return metaservice.MountTypeFolder
+	}
+	if v.memorySize != 0 {
+		return metaservice.MountTypeMemory
+	}
+	return metaservice.MountTypeDisk
+}
+
+func (v *volume) Dispose() error {
+	if v.folder != nil {
+		return v.folder.Remove()
+	}
+	if v.diskFile != "" {
+		return os.Remove(v.diskFile)
+	}
+	return nil
+}
@@ -83,6 +83,45 @@ type ResultSet interface {
 	// as a tar-stream. Ideally this also includes cache folders.
 	ArchiveSandbox() (ioext.ReadSeekCloser, error)
 
+	// NewShell creates a new Shell for interaction with the environment the task
+	// was executed in, after the task command has exited. This allows for
+	// inspection of a failed environment before results are reported.
+	//
+	// The command and tty arguments are interpreted as for Sandbox.NewShell().
+	// All shells created must be aborted when Dispose() is called.
+	//
+	// Non-fatal errors: ErrFeatureNotSupported, MalformedPayloadError
+	NewShell(command []string, tty bool) (Shell, error)
+
+	// Metadata returns engine-specific facts about the environment the task was
+	// executed in, such as a hash of the image used.
+	//
+	// This is used by plugins for auditing, like the chain-of-trust certificate
+	// created by the artifacts plugin. Keys should be camelCase, and the result
+	// may be nil, if the engine has nothing interesting to report.
+	Metadata() map[string]string
+
+	// Diagnostics returns engine-specific files useful for diagnosing why the
+	// task failed, such as a screenshot of a hung virtual machine. Keys are
+	// relative artifact names like "qemu/screenshot.png", and the result may be
+	// nil, if the engine has nothing interesting to report.
+	//
+	// Engines are only expected to return diagnostics when Success() is false.
+	// Streams returned are owned by the caller and must be closed.
+	//
+	// Non-fatal errors: ErrFeatureNotSupported
+	Diagnostics() (map[string]ioext.ReadSeekCloser, error)
+
+	// Usage returns engine-specific resource usage totals for the task, such as
+	// CPU time in seconds or bytes transferred over the network. Keys should be
+	// camelCase, like "cpuTime" or "networkReceivedBytes".
+	//
+	// This is used by plugins to report usage, engines should collect usage when
+	// the sandbox is resolved, such that this method is cheap.
+	//
+	// Non-fatal errors: ErrFeatureNotSupported
+	Usage() (map[string]float64, error)
+
 	// Dispose shall release all resources.
 	//
 	// CacheFolders given to the sandbox shall not be disposed, instead they are
@@ -120,6 +159,29 @@ func (ResultSetBase) ArchiveSandbox() (ioext.ReadSeekCloser, error) {
 	return nil, ErrFeatureNotSupported
 }
 
+// NewShell returns ErrFeatureNotSupported indicating that the feature isn't
+// supported.
+func (ResultSetBase) NewShell(command []string, tty bool) (Shell, error) {
+	return nil, ErrFeatureNotSupported
+}
+
+// Metadata returns nil indicating that no metadata is available.
+func (ResultSetBase) Metadata() map[string]string {
+	return nil
+}
+
+// Diagnostics returns ErrFeatureNotSupported indicating that the feature isn't
+// supported.
+func (ResultSetBase) Diagnostics() (map[string]ioext.ReadSeekCloser, error) {
+	return nil, ErrFeatureNotSupported
+}
+
+// Usage returns ErrFeatureNotSupported indicating that the feature isn't
+// supported.
+func (ResultSetBase) Usage() (map[string]float64, error) {
+	return nil, ErrFeatureNotSupported
+}
+
 // Dispose returns nil indicating that resources have been released.
 func (ResultSetBase) Dispose() error {
 	return nil
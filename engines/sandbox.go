@@ -1,6 +1,10 @@
 package engines
 
-import "io"
+import (
+	"io"
+
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+)
 
 // The Shell interface opens an interactive sh or bash shell inside the Sandbox.
 type Shell interface {
@@ -99,6 +103,65 @@ type Sandbox interface {
 	// ErrSandboxTerminated, ErrSandboxAborted.
 	OpenDisplay(name string) (io.ReadWriteCloser, error)
 
+	// DialPort opens a TCP connection to the given port inside the running
+	// Sandbox. This allows for interactive debugging of services, such as
+	// debuggers or development servers, running inside the task.
+	//
+	// If nothing is listening on the given port this method should return
+	// ErrNoSuchPort. Connections must be closed when the sandbox is aborted or
+	// terminated.
+	//
+	// Non-fatal errors: ErrFeatureNotSupported, ErrNoSuchPort,
+	// ErrSandboxTerminated, ErrSandboxAborted.
+	DialPort(port int) (io.ReadWriteCloser, error)
+
+	// ExtractFile returns a snapshot of a file from the sandbox while it is
+	// running. This is useful for plugins that wish to expose files that are
+	// being written, such as a growing log or test-results file.
+	//
+	// Interpretation of the string path format is engine specific, but should
+	// be the same as for ResultSet.ExtractFile. The stream returned must reflect
+	// the contents of the file at the time of the call, or later. Hence, callers
+	// wishing to follow a growing file can call this repeatedly.
+	//
+	// If the file requested doesn't exist the engine should return
+	// ErrResourceNotFound. If the WaitForResult() method has returned this
+	// method must return ErrSandboxTerminated, as files should be extracted
+	// from the ResultSet.
+	//
+	// Non-fatal errors: ErrFeatureNotSupported, ErrResourceNotFound,
+	// ErrSandboxTerminated, ErrSandboxAborted, MalformedPayloadError
+	ExtractFile(path string) (ioext.ReadSeekCloser, error)
+
+	// ExtractFolder iterates through all files in a folder inside the sandbox
+	// while it is running, calling handler(path, stream) for each file. This is
+	// useful for plugins that wish to copy folders out of a running sandbox.
+	//
+	// Interpretation of the string path format and the semantics of handler are
+	// the same as for ResultSet.ExtractFolder. If the WaitForResult() method has
+	// returned this method must return ErrSandboxTerminated.
+	//
+	// Non-fatal errors: ErrFeatureNotSupported, ErrResourceNotFound,
+	// ErrSandboxTerminated, ErrSandboxAborted, MalformedPayloadError,
+	// ErrNonFatalInternalError, ErrHandlerInterrupt
+	ExtractFolder(path string, handler FileHandler) error
+
+	// WriteFile writes the contents of stream to a file at path inside the
+	// sandbox while it is running. This is useful for plugins that wish to copy
+	// files into a running sandbox. Parent folders are created as needed and an
+	// existing file at path is overwritten.
+	//
+	// Interpretation of the string path format is engine specific, but should be
+	// the same as for ExtractFile. If the path is invalid or the file cannot be
+	// written the engine should return a MalformedPayloadError. If reading from
+	// stream fails, the error from stream should be returned as is. If the
+	// WaitForResult() method has returned this method must return
+	// ErrSandboxTerminated.
+	//
+	// Non-fatal errors: ErrFeatureNotSupported, ErrSandboxTerminated,
+	// ErrSandboxAborted, MalformedPayloadError, ErrNonFatalInternalError
+	WriteFile(path string, stream io.Reader) error
+
 	// Abort the sandbox. This means killing the task execution as well as all
 	// associated shells and releasing all resources held.
 	//
@@ -147,6 +210,30 @@ func (SandboxBase) OpenDisplay(string) (io.ReadWriteCloser, error) {
 	return nil, ErrFeatureNotSupported
 }
 
+// DialPort returns ErrFeatureNotSupported indicating that the feature isn't
+// supported.
+func (SandboxBase) DialPort(int) (io.ReadWriteCloser, error) {
+	return nil, ErrFeatureNotSupported
+}
+
+// ExtractFile returns ErrFeatureNotSupported indicating that the feature isn't
+// supported.
+func (SandboxBase) ExtractFile(string) (ioext.ReadSeekCloser, error) {
+	return nil, ErrFeatureNotSupported
+}
+
+// ExtractFolder returns ErrFeatureNotSupported indicating that the feature
+// isn't supported.
+func (SandboxBase) ExtractFolder(string, FileHandler) error {
+	return ErrFeatureNotSupported
+}
+
+// WriteFile returns ErrFeatureNotSupported indicating that the feature isn't
+// supported.
+func (SandboxBase) WriteFile(string, io.Reader) error {
+	return ErrFeatureNotSupported
+}
+
 // Abort returns nil indicating that resources have been released.
 func (SandboxBase) Abort() error {
 	return nil
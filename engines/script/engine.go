@@ -52,6 +52,12 @@ func (engineProvider) NewEngine(options engines.EngineOptions) (engines.Engine,
 	}, nil
 }
 
+func (e *engine) Capabilities() engines.Capabilities {
+	return engines.Capabilities{
+		SeparateStderr: true,
+	}
+}
+
 func (e *engine) PayloadSchema() schematypes.Object {
 	return e.schema
 }
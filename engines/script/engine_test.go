@@ -9,7 +9,7 @@ import (
 var provider = &enginetest.EngineProvider{
 	Engine: "script",
 	Config: `{
-    "command": ["bash", "-ec", "v=$(cat); echo \"$v\"; echo \"$v\" | grep success"],
+    "command": ["bash", "-ec", "v=$(cat); if echo \"$v\" | grep -q stderr; then echo \"$v\" >&2; else echo \"$v\"; fi; echo \"$v\" | grep -q success"],
     "schema": {
       "type": "object",
       "properties": {
@@ -32,10 +32,14 @@ var loggingTestCase = enginetest.LoggingTestCase{
   }`,
 	SilentPayload: `{
     "arg": "This is a successful task, that doesn't log target string"
+  }`,
+	StderrPayload: `{
+    "arg": "hello-world, this is a successful task writing to stderr"
   }`,
 }
 
 func TestLogTarget(t *t.T)            { loggingTestCase.TestLogTarget() }
 func TestLogTargetWhenFailing(t *t.T) { loggingTestCase.TestLogTargetWhenFailing() }
 func TestSilentTask(t *t.T)           { loggingTestCase.TestSilentTask() }
+func TestStderrTarget(t *t.T)         { loggingTestCase.TestStderrTarget() }
 func TestLoggingTestCase(t *t.T)      { loggingTestCase.Test() }
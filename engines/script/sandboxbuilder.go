@@ -38,9 +38,8 @@ func (b *sandboxBuilder) StartSandbox() (engines.Sandbox, error) {
 
 	cmd.Dir = folder.Path()
 	cmd.Stdin = bytes.NewBuffer(data)
-	log := b.context.LogDrain()
-	cmd.Stdout = log
-	cmd.Stderr = log
+	cmd.Stdout = b.context.LogDrain()
+	cmd.Stderr = b.context.StderrLogDrain()
 	cmd.Env = formatEnv(map[string]string{
 		"TASK_ID": b.context.TaskID,
 		"RUN_ID":  fmt.Sprintf("%d", b.context.RunID),
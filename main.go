@@ -25,7 +25,9 @@ import (
 	// Import all sub-packages from commands/, config/, engines/ and plugins/
 	// as they will register themselves using extension registries.
 
+	_ "github.com/taskcluster/taskcluster-worker/commands/cp"
 	_ "github.com/taskcluster/taskcluster-worker/commands/daemon"
+	_ "github.com/taskcluster/taskcluster-worker/commands/forward"
 	_ "github.com/taskcluster/taskcluster-worker/commands/help"
 	_ "github.com/taskcluster/taskcluster-worker/commands/qemu-build"
 	_ "github.com/taskcluster/taskcluster-worker/commands/qemu-guest-tools"
@@ -47,15 +49,21 @@ import (
 	_ "github.com/taskcluster/taskcluster-worker/engines/qemu"
 	_ "github.com/taskcluster/taskcluster-worker/engines/script"
 	_ "github.com/taskcluster/taskcluster-worker/plugins/artifacts"
+	_ "github.com/taskcluster/taskcluster-worker/plugins/diagnostics"
 	_ "github.com/taskcluster/taskcluster-worker/plugins/env"
 	_ "github.com/taskcluster/taskcluster-worker/plugins/interactive"
 	_ "github.com/taskcluster/taskcluster-worker/plugins/livelog"
+	_ "github.com/taskcluster/taskcluster-worker/plugins/loglimit"
 	_ "github.com/taskcluster/taskcluster-worker/plugins/logprefix"
 	_ "github.com/taskcluster/taskcluster-worker/plugins/maxruntime"
 	_ "github.com/taskcluster/taskcluster-worker/plugins/plugintest"
 	_ "github.com/taskcluster/taskcluster-worker/plugins/reboot"
+	_ "github.com/taskcluster/taskcluster-worker/plugins/redact"
+	_ "github.com/taskcluster/taskcluster-worker/plugins/structuredlog"
 	_ "github.com/taskcluster/taskcluster-worker/plugins/success"
 	_ "github.com/taskcluster/taskcluster-worker/plugins/tcproxy"
+	_ "github.com/taskcluster/taskcluster-worker/plugins/testresults"
+	_ "github.com/taskcluster/taskcluster-worker/plugins/usage"
 	_ "github.com/taskcluster/taskcluster-worker/plugins/watchdog"
 )
 
@@ -2,11 +2,7 @@ package artifacts
 
 import (
 	"bytes"
-	"compress/gzip"
-	"context"
 	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"io"
 	"mime"
@@ -15,8 +11,8 @@ import (
 	"strings"
 	"sync"
 
+	"golang.org/x/crypto/ed25519"
 	"golang.org/x/crypto/openpgp"
-	"golang.org/x/crypto/openpgp/clearsign"
 
 	"github.com/pkg/errors"
 	schematypes "github.com/taskcluster/go-schematypes"
@@ -30,11 +26,6 @@ import (
 
 const unknownMimetype = "application/octet-stream"
 
-// Maximum concurrent uploads, note that we might do concurrent uploads for
-// folder artifacts too, causing a total of:
-//   maxUploadConcurrency * maxUploadConcurrency
-const maxUploadConcurrency = 5
-
 type pluginProvider struct {
 	plugins.PluginProviderBase
 }
@@ -42,18 +33,31 @@ type pluginProvider struct {
 type plugin struct {
 	plugins.PluginBase
 	environment *runtime.Environment
-	privateKey  *openpgp.Entity // nil, if COT is disabled
+	privateKey  *openpgp.Entity    // nil, if GPG signing is disabled
+	ed25519Key  ed25519.PrivateKey // nil, if ed25519 signing is disabled
+	// Upload settings
+	uploadConcurrency int
+	uploadRetries     int
+	compressMimetypes []string
 }
 
 type taskPlugin struct {
 	plugins.TaskPluginBase
 	plugin       *plugin
 	context      *runtime.TaskContext
+	logger       *runtime.TaskLogger
 	artifacts    []artifact
 	createCOT    bool
 	certifiedLog bool
 	uploaded     map[string][]byte // Map from artifact to sha256 hash
 	mUploaded    sync.Mutex
+	metadata     map[string]string // Metadata from ResultSet for COT environment
+	uploadSlots  chan struct{}     // Semaphore limiting concurrent uploads
+	uploads      sync.WaitGroup    // Uploads started with spawnUpload()
+	liveDetach   []func()          // Detach hooks serving live artifacts
+	liveFiles    []*liveFile       // Files followed for live artifacts
+	liveDone     chan struct{}     // Closed when live artifacts are stopped
+	liveStopped  atomics.Once      // Guards stopLiveArtifacts()
 	monitor      runtime.Monitor
 	failed       atomics.Bool                    // If true, Stopped() returns false
 	mErrors      sync.Mutex                      // Guards errors
@@ -86,10 +90,38 @@ func (pluginProvider) NewPlugin(options plugins.PluginOptions) (plugins.Plugin,
 		key = keyring[0]
 	}
 
-	return &plugin{
-		environment: options.Environment,
-		privateKey:  key,
-	}, nil
+	var ed25519Key ed25519.PrivateKey
+	if c.Ed25519PrivateKey != "" {
+		var err error
+		ed25519Key, err = parseEd25519PrivateKey(c.Ed25519PrivateKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to load ed25519 private key")
+		}
+	}
+
+	p := &plugin{
+		environment:       options.Environment,
+		privateKey:        key,
+		ed25519Key:        ed25519Key,
+		uploadConcurrency: c.UploadConcurrency,
+		uploadRetries:     defaultUploadRetries,
+		compressMimetypes: c.CompressMimetypes,
+	}
+	if p.uploadConcurrency == 0 {
+		p.uploadConcurrency = defaultUploadConcurrency
+	}
+	if c.UploadRetries != nil {
+		p.uploadRetries = *c.UploadRetries
+	}
+	if p.compressMimetypes == nil {
+		p.compressMimetypes = defaultCompressMimetypes
+	}
+	return p, nil
+}
+
+// cotEnabled returns true, if a key for signing chain-of-trust is configured
+func (p *plugin) cotEnabled() bool {
+	return p.privateKey != nil || p.ed25519Key != nil
 }
 
 func (p *plugin) PayloadSchema() schematypes.Object {
@@ -98,18 +130,25 @@ func (p *plugin) PayloadSchema() schematypes.Object {
 			"artifacts": artifactSchema,
 		},
 	}
-	if p.privateKey != nil {
+	if p.cotEnabled() {
 		schema.Properties["chainOfTrust"] = schematypes.Boolean{
 			Title: "Create chain-of-trust Certificate",
 			Description: util.Markdown(`
-				Generate a 'public/chainOfTrust.json.asc' artifact with signed hashes
-				of the artifacts generated from this task.
+				Generate a 'public/chain-of-trust.json' artifact with hashes of the
+				artifacts generated from this task, along with a description of the
+				worker environment.
+
+				The document is signed with the keys configured for the worker, an
+				ed25519 signature is uploaded as 'public/chain-of-trust.json.sig', and
+				a detached GPG signature is uploaded as 'public/chain-of-trust.json.asc'.
+				For GPG the clearsigned 'public/chainOfTrust.json.asc' artifact is
+				also generated.
 			`),
 		}
 		schema.Properties["certifiedLog"] = schematypes.Boolean{
 			Title: "Create Certified Log",
 			Description: util.Markdown(`
-				Default log artifact is not covered by 'public/chainOfTrust.json.asc',
+				Default log artifact is not covered by 'public/chain-of-trust.json',
 				if this is set to 'true' an artifact 'public/logs/certified.log' will
 				be created and covered by chain-of-trust certificate.
 			`),
@@ -122,20 +161,50 @@ func (p *plugin) NewTaskPlugin(options plugins.TaskPluginOptions) (plugins.TaskP
 	var P payload
 	schematypes.MustValidateAndMap(p.PayloadSchema(), options.Payload, &P)
 
+	// Validate glob patterns and live artifacts
+	for _, a := range P.Artifacts {
+		if a.Live && a.Type != typeFile {
+			return nil, runtime.NewMalformedPayloadError(
+				"Live artifacts must have type 'file', artifact: '", a.Name, "' has type: '", a.Type, "'",
+			)
+		}
+		if a.Type == typeGlob && !validGlob(a.Path) {
+			return nil, runtime.NewMalformedPayloadError(
+				"Invalid glob pattern: '", a.Path, "' in artifact: '", a.Name, "'",
+			)
+		}
+		for _, pattern := range a.Exclude {
+			if !validGlob(pattern) {
+				return nil, runtime.NewMalformedPayloadError(
+					"Invalid exclude pattern: '", pattern, "' in artifact: '", a.Name, "'",
+				)
+			}
+		}
+	}
+
 	return &taskPlugin{
 		plugin:       p,
 		artifacts:    P.Artifacts,
-		createCOT:    p.privateKey != nil && P.CreateCOT,
-		certifiedLog: p.privateKey != nil && P.CertifiedLog,
+		createCOT:    p.cotEnabled() && P.CreateCOT,
+		certifiedLog: p.cotEnabled() && P.CertifiedLog,
 		uploaded:     make(map[string][]byte),
+		uploadSlots:  make(chan struct{}, p.uploadConcurrency),
+		liveDone:     make(chan struct{}),
 		context:      options.TaskContext,
+		logger:       options.Logger,
 		monitor:      options.Monitor,
 	}, nil
 }
 
 func (tp *taskPlugin) Stopped(result engines.ResultSet) (bool, error) {
+	// Stop serving live artifacts, final versions are uploaded below
+	tp.stopLiveArtifacts()
+
+	// Keep metadata for the chain-of-trust environment
+	tp.metadata = result.Metadata()
+
 	debug("Extracting artifacts")
-	util.SpawnWithLimit(len(tp.artifacts), maxUploadConcurrency, func(i int) {
+	util.SpawnWithLimit(len(tp.artifacts), tp.plugin.uploadConcurrency, func(i int) {
 		// Abort, if task context is cancelled
 		if tp.context.Err() != nil {
 			return
@@ -148,9 +217,16 @@ func (tp *taskPlugin) Stopped(result engines.ResultSet) (bool, error) {
 		case typeFile:
 			tp.processFile(result, a)
 		case typeDirectory:
-			tp.processDirectory(result, a)
+			tp.processFolder(result, a, a.Path, "")
+		case typeGlob:
+			folder, pattern := splitGlob(a.Path)
+			tp.processFolder(result, a, folder, pattern)
 		}
 	})
+
+	// Wait for uploads to finish, upload errors are recorded in nonFatalErr, and
+	// artifact hashes for chain-of-trust are recorded when uploads are done.
+	tp.uploads.Wait()
 	debug("Artifacts extracted and uploaded")
 
 	// Find error condition
@@ -211,25 +287,12 @@ func (tp *taskPlugin) Finished(success bool) error {
 			return err
 		}
 
-		compressed, err := tp.plugin.environment.TemporaryStorage.NewFile()
+		compressed, err := tp.compress(r)
 		if err != nil {
 			return err
 		}
 		defer compressed.Close()
 
-		// Compress log
-		zipper := gzip.NewWriter(compressed)
-		if _, err = io.Copy(zipper, r); err != nil {
-			return errors.Wrap(err, "failed to compress log")
-		}
-		if err = zipper.Close(); err != nil {
-			return errors.Wrap(err, "failed to close compressing of log")
-		}
-		_, err = compressed.Seek(0, 0)
-		if err != nil {
-			return errors.Wrap(err, "Failed to seek to start of compressed log")
-		}
-
 		// Let's upload from compressed
 		err = tp.context.UploadS3Artifact(runtime.S3Artifact{
 			Name:     certifiedLogName,
@@ -247,51 +310,16 @@ func (tp *taskPlugin) Finished(success bool) error {
 		}
 	}
 
-	COT := chainOfTrust{
-		Version:     1,
-		TaskID:      tp.context.TaskID,
-		RunID:       tp.context.RunID,
-		WorkerGroup: tp.plugin.environment.WorkerGroup,
-		WorkerID:    tp.plugin.environment.WorkerID,
-		Environment: map[string]interface{}{},
-		Task:        tp.context.Task,
-		Artifacts:   make(map[string]cotArtifact),
-	}
-	for name, hash := range tp.uploaded {
-		COT.Artifacts[name] = cotArtifact{
-			Sha256: hex.EncodeToString(hash),
-		}
-	}
-	data, err := json.MarshalIndent(COT, "", "  ")
-	if err != nil {
-		panic(errors.Wrap(err, "failed to serialize COT certificate"))
-	}
-	cot := bytes.NewBuffer(nil)
-	w, err := clearsign.Encode(cot, tp.plugin.privateKey.PrivateKey, nil)
-	if err != nil {
-		return errors.Wrap(err, "failed to setup signing of COT certificate")
-	}
-	_, err = w.Write(data)
-	if err != nil {
-		return errors.Wrap(err, "failed to write COT certificate")
-	}
-	err = w.Close()
-	if err != nil {
-		return errors.Wrap(err, "failed to sign COT certificate")
-	}
+	return tp.uploadCOT()
+}
 
-	const cotCertificateName = "public/chainOfTrust.json.asc"
-	err = tp.context.UploadS3Artifact(runtime.S3Artifact{
-		Name:     cotCertificateName,
-		Mimetype: "text/plain; charset=utf-8",
-		Stream:   ioext.NopCloser(bytes.NewReader(cot.Bytes())),
-		Expires:  tp.context.TaskInfo.Expires,
-	})
-	if err != nil {
-		err = errors.Wrap(err, "failed to upload COT certificate")
-		tp.monitor.Error(err)
-		return runtime.ErrNonFatalInternalError // We don't expect upload errors to be fatal
-	}
+func (tp *taskPlugin) Exception(runtime.ExceptionReason) error {
+	tp.stopLiveArtifacts()
+	return nil
+}
+
+func (tp *taskPlugin) Dispose() error {
+	tp.stopLiveArtifacts()
 	return nil
 }
 
@@ -301,6 +329,67 @@ const (
 	reasonTooLarge        = "too-large-file-on-worker"
 )
 
+// artifactMissing handles a missing artifact, this will fail the task and
+// create an error artifact, unless the artifact is optional.
+func (tp *taskPlugin) artifactMissing(result engines.ResultSet, a artifact, logMessage, errorMessage string) {
+	if a.Optional {
+		tp.logger.Log(fmt.Sprintf("Skipping optional artifact '%s': %s", a.Name, errorMessage))
+		// Live artifacts must not be left redirecting to the live URL
+		if a.Live {
+			tp.missingErrorArtifact(a, errorMessage)
+		}
+		return
+	}
+	tp.failed.Set(true)
+	// Only complain about missing artifacts, if the task was successful
+	if result.Success() {
+		tp.logger.LogError(logMessage)
+	}
+	tp.missingErrorArtifact(a, errorMessage)
+}
+
+// missingErrorArtifact creates an error artifact for artifact a, which is
+// missing. Live artifacts already exist as a redirect, so the error artifact is
+// created as the backing artifact, and the live artifact redirected to it.
+func (tp *taskPlugin) missingErrorArtifact(a artifact, errorMessage string) {
+	name := a.Name
+	if a.Live {
+		name = backingName(a.Name)
+	}
+	err := tp.context.CreateErrorArtifact(runtime.ErrorArtifact{
+		Name:    name,
+		Reason:  reasonFileMissing,
+		Message: errorMessage,
+		Expires: a.Expires,
+	})
+	if err == nil && a.Live {
+		tp.reportUploadError(tp.redirectLiveArtifact(a, name, guessMimetype(a.Path, a.Name)))
+	}
+}
+
+// isExcluded returns true, if p matches one of the exclude patterns for a
+func isExcluded(a artifact, p string) bool {
+	for _, pattern := range a.Exclude {
+		if matchGlob(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// guessMimetype returns the mimetype from the extension of filePath, falling
+// back to the extension of name, or unknownMimetype.
+func guessMimetype(filePath, name string) string {
+	mtype := mime.TypeByExtension(filepath.Ext(filePath))
+	if mtype == "" {
+		mtype = mime.TypeByExtension(filepath.Ext(name))
+	}
+	if mtype == "" {
+		mtype = unknownMimetype
+	}
+	return mtype
+}
+
 func (tp *taskPlugin) processFile(result engines.ResultSet, a artifact) {
 	debug("extracting file from path: %s", a.Path)
 	r, err := result.ExtractFile(a.Path)
@@ -324,17 +413,10 @@ func (tp *taskPlugin) processFile(result engines.ResultSet, a artifact) {
 
 	// If resource isn't found, task should fail and we print a message to task log
 	if err == engines.ErrResourceNotFound {
-		tp.failed.Set(true)
-		if result.Success() {
-			// Only complain about missing artifacts, if the task was successful
-			tp.context.LogError(fmt.Sprintf("Artifact '%s' was not found.", a.Path))
-		}
-		tp.context.CreateErrorArtifact(runtime.ErrorArtifact{
-			Name:    a.Name,
-			Reason:  reasonFileMissing,
-			Message: fmt.Sprintf("No file was found at path: '%s' on worker", a.Path),
-			Expires: a.Expires,
-		})
+		tp.artifactMissing(result, a,
+			fmt.Sprintf("Artifact '%s' was not found.", a.Path),
+			fmt.Sprintf("No file was found at path: '%s' on worker", a.Path),
+		)
 		return
 	}
 
@@ -366,55 +448,48 @@ func (tp *taskPlugin) processFile(result engines.ResultSet, a artifact) {
 	if err != nil {
 		tp.fatalErr.Set(true)
 		i := tp.monitor.ReportError(err, "Unhandled error from ResultSet.ExtractFile()")
-		tp.context.LogError("Failed to extract artifact unhandled error, incidentId:", i)
+		tp.logger.LogError("Failed to extract artifact unhandled error, incidentId:", i)
 		return
 	}
 
-	// Guess the mimetype
-	mtype := mime.TypeByExtension(filepath.Ext(a.Path))
-	if mtype == "" {
-		mtype = mime.TypeByExtension(filepath.Ext(a.Name))
-	}
-	if mtype == "" {
-		mtype = unknownMimetype
-	}
+	mtype := guessMimetype(a.Path, a.Name)
 
-	// Compute artifact hash for chain-of-trust
-	if err = tp.hashArtifact(a.Name, r); err == nil {
-		// Let's upload from r
-		err = tp.context.UploadS3Artifact(runtime.S3Artifact{
-			Name:     a.Name,
-			Mimetype: mtype,
-			Stream:   r,
-			Expires:  a.Expires,
-		})
+	// Live artifacts are uploaded to a backing artifact, and the live artifact
+	// is redirected to the backing artifact when upload is done
+	name := a.Name
+	if a.Live {
+		name = backingName(a.Name)
 	}
 
-	if err != nil && err != context.Canceled {
-		tp.nonFatalErr.Set(true)
-		i := tp.monitor.ReportError(err, "Failed to upload artifact")
-		tp.context.LogError("Failed to upload artifact unhandled error, incidentId:", i)
+	// Upload in the background, the reader is closed when upload is done
+	f := r
+	r = nil // don't close r when returning
+	err = tp.spawnUpload(func() {
+		defer f.Close()
+		err := tp.uploadArtifact(name, mtype, a.Expires, f)
+		if err == nil && a.Live {
+			err = tp.redirectLiveArtifact(a, name, mtype)
+		}
+		tp.reportUploadError(err)
+	})
+	if err != nil {
+		f.Close() // Task was canceled while waiting to upload
 	}
 }
 
-func (tp *taskPlugin) processDirectory(result engines.ResultSet, a artifact) {
-	debug("extracting directory from path: %s", a.Path)
-	semaphore := make(chan struct{}, maxUploadConcurrency)
-	err := result.ExtractFolder(a.Path, func(p string, r ioext.ReadSeekCloser) error {
-		debug(" - Found artifact: %s in %s", p, a.Path)
-		// Always close the reader
-		defer r.Close()
-
-		// Block until we can write to semaphore, then read when we're done uploading
-		// This way the capacity o the semaphore channel limits concurrency.
-		select {
-		case semaphore <- struct{}{}:
-		case <-tp.context.Done():
-			return context.Canceled
+// processFolder uploads files from folder, if pattern is non-empty only files
+// whose path relative to folder matches the glob pattern are uploaded.
+func (tp *taskPlugin) processFolder(result engines.ResultSet, a artifact, folder, pattern string) {
+	debug("extracting directory from path: %s", folder)
+	found := atomics.NewBool(false)
+	err := result.ExtractFolder(folder, func(p string, r ioext.ReadSeekCloser) error {
+		// Skip files that doesn't match the pattern, or matches an exclude pattern
+		if (pattern != "" && !matchGlob(pattern, p)) || isExcluded(a, p) {
+			r.Close()
+			return nil
 		}
-		defer func() {
-			<-semaphore
-		}()
+		found.Set(true)
+		debug(" - Found artifact: %s in %s", p, folder)
 
 		// Guess the mimetype
 		mtype := mime.TypeByExtension(filepath.Ext(p))
@@ -425,32 +500,28 @@ func (tp *taskPlugin) processDirectory(result engines.ResultSet, a artifact) {
 		// Construct artifact name
 		name := path.Join(a.Name, p)
 
-		var uerr error
-		// Compute artifact hash for chain-of-trust
-		if uerr = tp.hashArtifact(name, r); uerr == nil {
-			// Upload artifact
-			debug(" - Uploading %s from %s -> %s", p, a.Path, name)
-			uerr = tp.context.UploadS3Artifact(runtime.S3Artifact{
-				Name:     name,
-				Expires:  a.Expires,
-				Stream:   r,
-				Mimetype: mtype,
-			})
-		}
-
-		// If we have an upload error, that's just a internal non-fatal error.
-		// We ignore the error, if TaskContext was canceled, as requests should be
-		// aborted when that happens.
-		if uerr != nil && tp.context.Err() != nil {
-			tp.nonFatalErr.Set(true)
-			i := tp.monitor.ReportError(uerr, "Failed to upload artifact")
-			tp.context.LogError("Failed to upload artifact unhandled error, incidentId:", i)
+		// Upload in the background, this blocks until an upload slot is available
+		// such that we don't extract files faster than we can upload them.
+		err := tp.spawnUpload(func() {
+			defer r.Close()
+			debug(" - Uploading %s from %s -> %s", p, folder, name)
+			tp.reportUploadError(tp.uploadArtifact(name, mtype, a.Expires, r))
+		})
+		if err != nil {
+			r.Close()
 		}
-
-		// Return nil, if we're not aborted
-		return tp.context.Err()
+		return err
 	})
 
+	// If no files matched the glob pattern, the artifact is missing
+	if err == nil && pattern != "" && !found.Get() {
+		tp.artifactMissing(result, a,
+			fmt.Sprintf("No files matching '%s' was found, artifact upload failed.", a.Path),
+			fmt.Sprintf("No files matching: '%s' was found on worker", a.Path),
+		)
+		return
+	}
+
 	// If feature isn't supported this is malformed-payload
 	if err == engines.ErrFeatureNotSupported {
 		e := runtime.NewMalformedPayloadError(
@@ -464,17 +535,10 @@ func (tp *taskPlugin) processDirectory(result engines.ResultSet, a artifact) {
 
 	// If resource isn't found, task should fail and we print a message to task log
 	if err == engines.ErrResourceNotFound {
-		tp.failed.Set(true)
-		// Only complain about missing artifact folder if task is successful
-		if result.Success() {
-			tp.context.LogError(fmt.Sprintf("No folder was found at '%s', artifact upload failed.", a.Path))
-		}
-		tp.context.CreateErrorArtifact(runtime.ErrorArtifact{
-			Name:    a.Name,
-			Reason:  reasonFileMissing,
-			Message: fmt.Sprintf("No folder was found at path: '%s' on worker", a.Path),
-			Expires: a.Expires,
-		})
+		tp.artifactMissing(result, a,
+			fmt.Sprintf("No folder was found at '%s', artifact upload failed.", folder),
+			fmt.Sprintf("No folder was found at path: '%s' on worker", folder),
+		)
 		return
 	}
 
@@ -488,7 +552,7 @@ func (tp *taskPlugin) processDirectory(result engines.ResultSet, a artifact) {
 	if e, ok := runtime.IsMalformedPayloadError(err); ok {
 		tp.mErrors.Lock()
 		tp.errors = append(tp.errors, runtime.NewMalformedPayloadError(
-			"Invalid path: '", a.Path, "' reason: ", strings.Join(e.Messages(), ";"),
+			"Invalid path: '", folder, "' reason: ", strings.Join(e.Messages(), ";"),
 		))
 		tp.mErrors.Unlock()
 		return
@@ -512,7 +576,7 @@ func (tp *taskPlugin) processDirectory(result engines.ResultSet, a artifact) {
 	if err != nil {
 		tp.fatalErr.Set(true)
 		i := tp.monitor.ReportError(err, "Unhandled error from ResultSet.ExtractFolder()")
-		tp.context.LogError("Failed to extract artifact unhandled error, incidentId:", i)
+		tp.logger.LogError("Failed to extract artifact unhandled error, incidentId:", i)
 		return
 	}
 }
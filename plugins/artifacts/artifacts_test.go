@@ -1,21 +1,25 @@
 package artifacts
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/stretchr/testify/require"
 	"github.com/taskcluster/slugid-go/slugid"
 	"github.com/taskcluster/taskcluster-client-go/queue"
 	"github.com/taskcluster/taskcluster-worker/plugins/plugintest"
 	"github.com/taskcluster/taskcluster-worker/runtime/client"
+	"golang.org/x/crypto/ed25519"
 )
 
 type artifactTestCase struct {
 	plugintest.Case
 	Artifacts []string
+	Redirects []string
 }
 
 func (a artifactTestCase) Test() {
@@ -40,6 +44,10 @@ func (a artifactTestCase) Test() {
 		).Return(&resp, nil)
 	}
 
+	for _, name := range a.Redirects {
+		mockedQueue.ExpectRedirectArtifact(taskID, 0, name)
+	}
+
 	a.Case.QueueMock = mockedQueue
 	a.Case.TaskID = taskID
 	a.Case.Test()
@@ -130,3 +138,121 @@ func TestArtifactsDirectory(t *testing.T) {
 		},
 	}.Test()
 }
+
+func TestArtifactsChainOfTrustEd25519(t *testing.T) {
+	taskID := slugid.Nice()
+	q := &client.MockQueue{}
+	q.ExpectS3Artifact(taskID, 0, "public/blah.txt")
+	cot := q.ExpectS3Artifact(taskID, 0, "public/chain-of-trust.json")
+	sig := q.ExpectS3Artifact(taskID, 0, "public/chain-of-trust.json.sig")
+
+	plugintest.Case{
+		Payload: `{
+			"delay": 0,
+			"function": "write-files",
+			"argument": "/artifacts/blah.txt",
+			"chainOfTrust": true,
+			"artifacts": [
+				{
+					"type": "file",
+					"path": "/artifacts/blah.txt",
+					"name": "public/blah.txt"
+				}
+			]
+		}`,
+		Plugin: "artifacts",
+		PluginConfig: `{
+			"ed25519PrivateKey": "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+		}`,
+		QueueMock:     q,
+		TaskID:        taskID,
+		TestStruct:    t,
+		PluginSuccess: true,
+		EngineSuccess: true,
+	}.Test()
+	q.AssertExpectations(t)
+
+	// The signature must verify against the public key for the configured seed
+	public, _, err := ed25519.GenerateKey(bytes.NewReader([]byte("0123456789abcdef0123456789abcdef")))
+	require.NoError(t, err)
+	require.True(t, ed25519.Verify(public, <-cot, <-sig), "invalid chain-of-trust signature")
+}
+
+func TestArtifactsGlob(t *testing.T) {
+	artifactTestCase{
+		Artifacts: []string{"public/a/blah.txt", "public/foo.txt"},
+		Case: plugintest.Case{
+			Payload: `{
+				"delay": 0,
+				"function": "write-files",
+				"argument": "/artifacts/a/blah.txt /artifacts/foo.txt /artifacts/bar.json /artifacts/tmp/ignored.txt",
+				"artifacts": [
+					{
+						"type": "glob",
+						"path": "/artifacts/**/*.txt",
+						"name": "public",
+						"exclude": ["tmp/**"]
+					}
+				]
+			}`,
+			Plugin:        "artifacts",
+			PluginConfig:  `{}`,
+			TestStruct:    t,
+			PluginSuccess: true,
+			EngineSuccess: true,
+		},
+	}.Test()
+}
+
+func TestArtifactsOptionalMissing(t *testing.T) {
+	artifactTestCase{
+		Case: plugintest.Case{
+			Payload: `{
+				"delay": 0,
+				"function": "true",
+				"argument": "whatever",
+				"artifacts": [
+					{
+						"type": "file",
+						"path": "/artifacts/missing.txt",
+						"name": "public/missing.txt",
+						"optional": true
+					}
+				]
+			}`,
+			Plugin:        "artifacts",
+			PluginConfig:  `{}`,
+			TestStruct:    t,
+			PluginSuccess: true,
+			EngineSuccess: true,
+			MatchLog:      "Skipping optional artifact 'public/missing.txt'",
+		},
+	}.Test()
+}
+
+func TestArtifactsLive(t *testing.T) {
+	artifactTestCase{
+		Artifacts: []string{"public/live_backing.txt"},
+		Redirects: []string{"public/live.txt"},
+		Case: plugintest.Case{
+			Payload: `{
+				"delay": 0,
+				"function": "write-files",
+				"argument": "/artifacts/live.txt",
+				"artifacts": [
+					{
+						"type": "file",
+						"path": "/artifacts/live.txt",
+						"name": "public/live.txt",
+						"live": true
+					}
+				]
+			}`,
+			Plugin:        "artifacts",
+			PluginConfig:  `{}`,
+			TestStruct:    t,
+			PluginSuccess: true,
+			EngineSuccess: true,
+		},
+	}.Test()
+}
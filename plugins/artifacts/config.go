@@ -6,14 +6,34 @@ import (
 )
 
 type config struct {
-	PrivateKey string `json:"privateKey"`
+	PrivateKey        string   `json:"privateKey"`
+	Ed25519PrivateKey string   `json:"ed25519PrivateKey"`
+	UploadConcurrency int      `json:"uploadConcurrency"`
+	UploadRetries     *int     `json:"uploadRetries"`
+	CompressMimetypes []string `json:"compressMimetypes"`
+}
+
+const (
+	defaultUploadConcurrency = 5
+	defaultUploadRetries     = 5
+)
+
+// Mimetypes compressed with gzip when uploaded, unless configured otherwise.
+// Entries ending with '/' match all subtypes.
+var defaultCompressMimetypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/x-javascript",
+	"application/xml",
+	"image/svg+xml",
 }
 
 var configSchema = schematypes.Object{
 	Title: "Artifact Configuration",
 	Description: util.Markdown(`
 		Configuration for artifact plugin. This is mostly COT (chain-of-trust)
-		configuration, such as private key.
+		configuration, such as private key, and options for tuning uploads.
 	`),
 	Properties: schematypes.Properties{
 		"privateKey": schematypes.String{
@@ -21,9 +41,49 @@ var configSchema = schematypes.Object{
 			Description: util.Markdown(`
 				GPG armoured private key (unencrypted) for signing chain-of-trust
 				certificates.
-
-				If not given, chain-of-trust signing will be disabled.
+				If neither this or 'ed25519PrivateKey' is given, chain-of-trust
+				signing will be disabled.
+			`),
+		},
+		"ed25519PrivateKey": schematypes.String{
+			Title: "COT ed25519 Private Key",
+			Description: util.Markdown(`
+				Base64 encoded ed25519 private key (32 byte seed or 64 byte key) for
+				signing chain-of-trust certificates.
+				If neither this or 'privateKey' is given, chain-of-trust signing
+				will be disabled.
+			`),
+			Pattern: `^[A-Za-z0-9+/]+={0,2}$`,
+		},
+		"uploadConcurrency": schematypes.Integer{
+			Title: "Upload Concurrency",
+			Description: util.Markdown(`
+				Maximum number of files to upload in parallel for a single task,
+				defaults to 5.
+			`),
+			Minimum: 1,
+			Maximum: 100,
+		},
+		"uploadRetries": schematypes.Integer{
+			Title: "Upload Retries",
+			Description: util.Markdown(`
+				Number of times to retry a failed upload of a file, with exponential
+				backoff between attempts, defaults to 5.
+			`),
+			Minimum: 0,
+			Maximum: 20,
+		},
+		"compressMimetypes": schematypes.Array{
+			Title: "Compressed Mimetypes",
+			Description: util.Markdown(`
+				Artifacts with these mimetypes will be gzip compressed and uploaded
+				with 'Content-Encoding: gzip'. Entries ending with '/' matches all
+				subtypes, such as 'text/'. Defaults to common text formats, an empty
+				list disables compression.
 			`),
+			Items: schematypes.String{
+				Pattern: `^[a-z0-9.+-]+/[a-z0-9.+-]*$`,
+			},
 		},
 	},
 }
@@ -1,5 +1,31 @@
 package artifacts
 
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+// Artifacts created when chain-of-trust is enabled
+const (
+	cotDocumentName      = "public/chain-of-trust.json"
+	cotEd25519SigName    = "public/chain-of-trust.json.sig" // ed25519 signature
+	cotGPGSigName        = "public/chain-of-trust.json.asc" // detached GPG signature
+	cotCertificateName   = "public/chainOfTrust.json.asc"   // clearsigned with GPG
+	cotDocumentMimetype  = "application/json"
+	cotSignatureMimetype = "application/octet-stream"
+	cotArmoredMimetype   = "text/plain; charset=utf-8"
+)
+
 type cotArtifact struct {
 	Sha256 string `json:"sha256"`
 }
@@ -14,3 +40,136 @@ type chainOfTrust struct {
 	Task        interface{}            `json:"task"`
 	Artifacts   map[string]cotArtifact `json:"artifacts"`
 }
+
+// parseEd25519PrivateKey parses a base64 encoded ed25519 private key, given
+// either as a 32 byte seed or as the full 64 byte private key.
+func parseEd25519PrivateKey(key string) (ed25519.PrivateKey, error) {
+	data, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "ed25519 private key isn't valid base64")
+	}
+	switch len(data) {
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(data), nil
+	case 32: // seed
+		_, k, _ := ed25519.GenerateKey(bytes.NewReader(data))
+		return k, nil
+	default:
+		return nil, fmt.Errorf(
+			"Expected ed25519 private key of 32 or %d bytes, found: %d bytes",
+			ed25519.PrivateKeySize, len(data),
+		)
+	}
+}
+
+// cotEnvironment returns the 'environment' property for the chain-of-trust
+// document, describing the worker and the engine environment the task ran in.
+func (tp *taskPlugin) cotEnvironment() map[string]interface{} {
+	env := map[string]interface{}{
+		"provisionerId": tp.plugin.environment.ProvisionerID,
+		"workerType":    tp.plugin.environment.WorkerType,
+		"engine":        tp.plugin.environment.EngineName,
+	}
+	// Engine metadata may not overwrite the worker metadata
+	for k, v := range tp.metadata {
+		if _, ok := env[k]; !ok {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// createCOTDocument returns the serialized chain-of-trust document covering
+// all artifacts uploaded so far.
+func (tp *taskPlugin) createCOTDocument() []byte {
+	COT := chainOfTrust{
+		Version:     1,
+		TaskID:      tp.context.TaskID,
+		RunID:       tp.context.RunID,
+		WorkerGroup: tp.plugin.environment.WorkerGroup,
+		WorkerID:    tp.plugin.environment.WorkerID,
+		Environment: tp.cotEnvironment(),
+		Task:        tp.context.Task,
+		Artifacts:   make(map[string]cotArtifact),
+	}
+	tp.mUploaded.Lock()
+	for name, hash := range tp.uploaded {
+		COT.Artifacts[name] = cotArtifact{
+			Sha256: hex.EncodeToString(hash),
+		}
+	}
+	tp.mUploaded.Unlock()
+
+	data, err := json.MarshalIndent(COT, "", "  ")
+	if err != nil {
+		panic(errors.Wrap(err, "failed to serialize COT certificate"))
+	}
+	return data
+}
+
+// signGPG returns a clearsigned certificate and a detached armored signature
+// for data using key.
+func signGPG(key *openpgp.Entity, data []byte) (certificate, signature []byte, err error) {
+	cert := bytes.NewBuffer(nil)
+	w, err := clearsign.Encode(cert, key.PrivateKey, nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to setup signing of COT certificate")
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to write COT certificate")
+	}
+	if err = w.Close(); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to sign COT certificate")
+	}
+
+	sig := bytes.NewBuffer(nil)
+	err = openpgp.ArmoredDetachSign(sig, key, bytes.NewReader(data), nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create detached COT signature")
+	}
+	return cert.Bytes(), sig.Bytes(), nil
+}
+
+// uploadCOT creates, signs and uploads the chain-of-trust document along with
+// signatures for each of the configured keys.
+func (tp *taskPlugin) uploadCOT() error {
+	data := tp.createCOTDocument()
+
+	// Create signatures before uploading anything, so we don't upload a document
+	// that isn't signed.
+	type blob struct {
+		name     string
+		mimetype string
+		data     []byte
+	}
+	blobs := []blob{{cotDocumentName, cotDocumentMimetype, data}}
+	if tp.plugin.ed25519Key != nil {
+		sig := ed25519.Sign(tp.plugin.ed25519Key, data)
+		blobs = append(blobs, blob{cotEd25519SigName, cotSignatureMimetype, sig})
+	}
+	if tp.plugin.privateKey != nil {
+		cert, sig, err := signGPG(tp.plugin.privateKey, data)
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs,
+			blob{cotGPGSigName, cotArmoredMimetype, sig},
+			blob{cotCertificateName, cotArmoredMimetype, cert},
+		)
+	}
+
+	for _, b := range blobs {
+		err := tp.context.UploadS3Artifact(runtime.S3Artifact{
+			Name:     b.name,
+			Mimetype: b.mimetype,
+			Stream:   ioext.NopCloser(bytes.NewReader(b.data)),
+			Expires:  tp.context.TaskInfo.Expires,
+		})
+		if err != nil {
+			err = errors.Wrapf(err, "failed to upload COT artifact: %s", b.name)
+			tp.monitor.Error(err)
+			return runtime.ErrNonFatalInternalError // We don't expect upload errors to be fatal
+		}
+	}
+	return nil
+}
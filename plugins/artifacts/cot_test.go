@@ -0,0 +1,30 @@
+package artifacts
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestParseEd25519PrivateKey(t *testing.T) {
+	seed := []byte("0123456789abcdef0123456789abcdef")
+	key, err := parseEd25519PrivateKey(base64.StdEncoding.EncodeToString(seed))
+	require.NoError(t, err)
+	require.Len(t, key, ed25519.PrivateKeySize)
+
+	// Parsing the full key should give the same key
+	key2, err := parseEd25519PrivateKey(base64.StdEncoding.EncodeToString(key))
+	require.NoError(t, err)
+	require.Equal(t, key, key2)
+
+	data := []byte(`{"chainOfTrustVersion": 1}`)
+	sig := ed25519.Sign(key, data)
+	require.True(t, ed25519.Verify(key.Public().(ed25519.PublicKey), data, sig))
+
+	_, err = parseEd25519PrivateKey(base64.StdEncoding.EncodeToString([]byte("too short")))
+	require.Error(t, err)
+	_, err = parseEd25519PrivateKey("not base64 !")
+	require.Error(t, err)
+}
@@ -0,0 +1,74 @@
+package artifacts
+
+import (
+	"path"
+	"strings"
+)
+
+// hasWildcard returns true, if segment contains glob wildcard characters
+func hasWildcard(segment string) bool {
+	return strings.ContainsAny(segment, "*?[")
+}
+
+// splitGlob splits a glob pattern into the folder prefix without wildcards
+// and the remaining pattern relative to the folder.
+//
+// Example: splitGlob("build/**/*.log") returns ("build", "**/*.log")
+func splitGlob(pattern string) (folder, rest string) {
+	segments := strings.Split(pattern, "/")
+	for i, s := range segments {
+		if hasWildcard(s) {
+			return strings.Join(segments[:i], "/"), strings.Join(segments[i:], "/")
+		}
+	}
+	// If there is no wildcards, the last segment is the pattern
+	i := len(segments) - 1
+	return strings.Join(segments[:i], "/"), segments[i]
+}
+
+// matchGlob returns true, if name matches pattern. Both must use slash as
+// separator. Segments are matched with path.Match, except for '**' which
+// matches zero or more segments.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Skip consecutive '**' segments
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			// Try to match the rest of the pattern from every offset
+			for i := range name {
+				if matchSegments(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); !ok || err != nil {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
+
+// validGlob returns true, if all segments of pattern are valid for path.Match
+func validGlob(pattern string) bool {
+	for _, s := range strings.Split(pattern, "/") {
+		if _, err := path.Match(s, ""); err != nil {
+			return false
+		}
+	}
+	return true
+}
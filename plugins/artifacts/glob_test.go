@@ -0,0 +1,47 @@
+package artifacts
+
+import "testing"
+
+func TestSplitGlob(t *testing.T) {
+	cases := []struct {
+		pattern, folder, rest string
+	}{
+		{"build/**/*.log", "build", "**/*.log"},
+		{"/home/worker/*.txt", "/home/worker", "*.txt"},
+		{"**/*.log", "", "**/*.log"},
+		{"build/logs/test.log", "build/logs", "test.log"},
+	}
+	for _, c := range cases {
+		folder, rest := splitGlob(c.pattern)
+		if folder != c.folder || rest != c.rest {
+			t.Errorf("splitGlob('%s') returned ('%s', '%s') expected ('%s', '%s')",
+				c.pattern, folder, rest, c.folder, c.rest)
+		}
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"*.log", "test.log", true},
+		{"*.log", "sub/test.log", false},
+		{"**/*.log", "test.log", true},
+		{"**/*.log", "a/b/c/test.log", true},
+		{"**/*.log", "a/b/c/test.txt", false},
+		{"a/**/c/*.txt", "a/c/x.txt", true},
+		{"a/**/c/*.txt", "a/b/b/c/x.txt", true},
+		{"a/**/c/*.txt", "a/b/d/x.txt", false},
+		{"**", "anything/at/all", true},
+		{"tmp/**", "tmp/a/b", true},
+		{"tmp/**", "other/a", false},
+		{"test-?.xml", "test-1.xml", true},
+		{"[", "[", false},
+	}
+	for _, c := range cases {
+		if matchGlob(c.pattern, c.name) != c.match {
+			t.Errorf("expected matchGlob('%s', '%s') == %v", c.pattern, c.name, c.match)
+		}
+	}
+}
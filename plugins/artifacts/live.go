@@ -0,0 +1,305 @@
+package artifacts
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/taskcluster/taskcluster-worker/engines"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+)
+
+// Interval between polling the sandbox for changes to a live artifact, the
+// interval is doubled up to livePollMaxInterval while there is no new data, as
+// some engines download the entire file when it's extracted.
+const (
+	livePollInterval    = 500 * time.Millisecond
+	livePollMaxInterval = 8 * time.Second
+)
+
+// backingName returns the name of the S3 artifact backing a live artifact,
+// following the livelog convention: 'live.log' -> 'live_backing.log'.
+func backingName(name string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "_backing" + ext
+}
+
+func (tp *taskPlugin) Started(sandbox engines.Sandbox) error {
+	var err error
+	for _, a := range tp.artifacts {
+		if !a.Live {
+			continue
+		}
+		if tp.plugin.environment.WebHookServer == nil {
+			tp.monitor.Info("live artifacts disabled when WebHookServer isn't provided")
+			return nil
+		}
+		if a.Expires.IsZero() {
+			a.Expires = tp.context.TaskInfo.Expires
+		}
+
+		mtype := guessMimetype(a.Path, a.Name)
+		url, detach := tp.plugin.environment.WebHookServer.AttachHook(
+			tp.serveLiveArtifact(sandbox, a, mtype),
+		)
+		tp.liveDetach = append(tp.liveDetach, detach)
+
+		debug("exposing live artifact %s at %s", a.Name, url)
+		rerr := tp.context.CreateRedirectArtifact(runtime.RedirectArtifact{
+			Name:     a.Name,
+			Mimetype: mtype,
+			URL:      url,
+			Expires:  a.Expires,
+		})
+		if rerr != nil {
+			incidentID := tp.monitor.ReportError(rerr, "Failed to create live artifact")
+			tp.logger.LogError("Failed to create live artifact: ", a.Name, " incidentId: ", incidentID)
+			// This isn't good, but the final artifact may still be uploaded
+			err = runtime.ErrNonFatalInternalError
+		}
+	}
+	return err
+}
+
+// serveLiveArtifact returns a handler that streams the file for artifact a,
+// from the sandbox while the task is running.
+func (tp *taskPlugin) serveLiveArtifact(sandbox engines.Sandbox, a artifact, mimetype string) http.Handler {
+	lf := newLiveFile(sandbox, a.Path, tp.plugin.environment.TemporaryStorage, tp.liveDone)
+	tp.liveFiles = append(tp.liveFiles, lf)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Streaming")
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// Start following the file, and open the buffer for reading
+		var buffer *os.File
+		err := lf.Start()
+		if err == nil {
+			buffer, err = os.Open(lf.buffer.Path())
+		}
+		if err != nil {
+			tp.monitor.Error("Failed to follow file for live artifact, error: ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		defer buffer.Close()
+
+		w.Header().Set("Content-Type", mimetype)
+
+		// Get an HTTP flusher if supported in the current context, or wrap in
+		// a NopFlusher, if flushing isn't available.
+		wf, ok := w.(ioext.WriteFlusher)
+		if ok {
+			w.Header().Set("X-Streaming", "true") // Allow clients to detect that we're streaming
+		} else {
+			wf = ioext.NopFlusher(w)
+		}
+		w.WriteHeader(http.StatusOK)
+
+		// Write data from the buffer as it's appended
+		var offset int64
+		for {
+			size, changed, stopped := lf.State()
+			if size > offset {
+				n, cerr := io.Copy(wf, io.NewSectionReader(buffer, offset, size-offset))
+				offset += n
+				if cerr != nil {
+					return // Most likely the client disconnected
+				}
+				wf.Flush()
+			}
+			if stopped {
+				return
+			}
+
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
+
+// liveFile follows a file in the sandbox for a live artifact. New data is
+// appended to a temporary buffer shared by all requests for the live artifact,
+// such that the sandbox is polled once per interval regardless of how many
+// clients are reading.
+type liveFile struct {
+	sandbox engines.Sandbox
+	path    string
+	storage runtime.TemporaryStorage
+	done    <-chan struct{} // Closed when following should stop
+	started sync.Once
+	polling sync.WaitGroup // Done when poll() has returned
+	buffer  runtime.TemporaryFile
+	err     error // Error from creating buffer
+	m       sync.Mutex
+	size    int64         // Bytes written to buffer
+	changed chan struct{} // Closed and replaced when size changes or stopped
+	stopped bool
+}
+
+func newLiveFile(sandbox engines.Sandbox, path string, storage runtime.TemporaryStorage, done <-chan struct{}) *liveFile {
+	return &liveFile{
+		sandbox: sandbox,
+		path:    path,
+		storage: storage,
+		done:    done,
+		changed: make(chan struct{}),
+	}
+}
+
+// Start creates the buffer and starts polling the sandbox, this is safe to call
+// more than once.
+func (lf *liveFile) Start() error {
+	lf.started.Do(func() {
+		lf.buffer, lf.err = lf.storage.NewFile()
+		if lf.err != nil {
+			lf.stop()
+			return
+		}
+		lf.polling.Add(1)
+		go func() {
+			defer lf.polling.Done()
+			lf.poll()
+		}()
+	})
+	return lf.err
+}
+
+// State returns the number of bytes in the buffer, a channel that is closed
+// when this changes, and true if no more data will be appended.
+func (lf *liveFile) State() (int64, <-chan struct{}, bool) {
+	lf.m.Lock()
+	defer lf.m.Unlock()
+	return lf.size, lf.changed, lf.stopped
+}
+
+// poll copies new data from the file in the sandbox to the buffer. The file is
+// kept open between polls and read from the last offset, it's only extracted
+// again when no new data can be read, as engines may return a snapshot.
+func (lf *liveFile) poll() {
+	defer lf.stop()
+
+	var f ioext.ReadSeekCloser
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	interval := livePollInterval
+	for {
+		var n int64
+		var err error
+		if f != nil {
+			n, err = io.Copy(lf.buffer, f)
+			if n == 0 && err == nil {
+				f.Close()
+				f = nil
+			}
+		}
+		if f == nil && err == nil {
+			f, err = lf.sandbox.ExtractFile(lf.path)
+			if err == nil {
+				if _, err = f.Seek(lf.size, io.SeekStart); err == nil {
+					n, err = io.Copy(lf.buffer, f)
+				}
+			} else if err == engines.ErrResourceNotFound {
+				err = nil // File may not have been created yet
+			}
+		}
+		if n > 0 {
+			lf.m.Lock()
+			lf.size += n
+			close(lf.changed)
+			lf.changed = make(chan struct{})
+			lf.m.Unlock()
+			interval = livePollInterval
+		} else if interval < livePollMaxInterval {
+			interval *= 2
+		}
+		if err != nil {
+			// Sandbox is terminated, or the file can't be extracted
+			return
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-lf.done:
+			return
+		}
+	}
+}
+
+// stop marks the buffer as complete, no more data will be appended.
+func (lf *liveFile) stop() {
+	lf.m.Lock()
+	defer lf.m.Unlock()
+	if !lf.stopped {
+		lf.stopped = true
+		close(lf.changed)
+	}
+}
+
+// Dispose removes the buffer, requests still reading will reach the end of the
+// buffer, as they have it open. This waits for polling to stop, so the done
+// channel given to newLiveFile() must be closed first.
+func (lf *liveFile) Dispose() {
+	// Ensure the buffer isn't created after it has been disposed
+	lf.started.Do(func() {
+		lf.err = errors.New("live artifact is no longer available")
+	})
+	lf.polling.Wait()
+	lf.stop()
+	if lf.buffer != nil {
+		lf.buffer.Close()
+	}
+}
+
+// stopLiveArtifacts detaches all live artifact hooks and stops on-going
+// requests, this is safe to call more than once.
+func (tp *taskPlugin) stopLiveArtifacts() {
+	tp.liveStopped.Do(func() {
+		close(tp.liveDone)
+		for _, detach := range tp.liveDetach {
+			detach()
+		}
+		tp.liveDetach = nil
+		for _, lf := range tp.liveFiles {
+			lf.Dispose()
+		}
+		tp.liveFiles = nil
+	})
+}
+
+// redirectLiveArtifact updates the reference artifact for live artifact a to
+// point to the S3 artifact backing it.
+func (tp *taskPlugin) redirectLiveArtifact(a artifact, backing, mimetype string) error {
+	backingURL := fmt.Sprintf(
+		"https://queue.taskcluster.net/v1/task/%s/runs/%d/artifacts/%s",
+		tp.context.TaskInfo.TaskID, tp.context.TaskInfo.RunID, backing,
+	)
+	return tp.context.CreateRedirectArtifact(runtime.RedirectArtifact{
+		Name:     a.Name,
+		Mimetype: mimetype,
+		URL:      backingURL,
+		Expires:  a.Expires,
+	})
+}
@@ -14,15 +14,19 @@ type payload struct {
 }
 
 type artifact struct {
-	Type    string    `json:"type"`
-	Path    string    `json:"path"`
-	Name    string    `json:"name"`
-	Expires time.Time `json:"expires"`
+	Type     string    `json:"type"`
+	Path     string    `json:"path"`
+	Name     string    `json:"name"`
+	Expires  time.Time `json:"expires"`
+	Exclude  []string  `json:"exclude"`
+	Optional bool      `json:"optional"`
+	Live     bool      `json:"live"`
 }
 
 const (
 	typeFile      = "file"
 	typeDirectory = "directory"
+	typeGlob      = "glob"
 )
 
 var artifactSchema = schematypes.Array{
@@ -33,11 +37,12 @@ var artifactSchema = schematypes.Array{
 			"type": schematypes.StringEnum{
 				Title: "Upload type",
 				Description: util.Markdown(`
-					Artifacts can be either an individual 'file' or a 'directory'
+					Artifacts can be either an individual 'file', a 'directory'
 					containing potentially multiple files with recursively included
-					subdirectories.
+					subdirectories, or a 'glob' pattern matching files, where '**'
+					matches any number of subdirectories.
 				`),
-				Options: []string{typeFile, typeDirectory},
+				Options: []string{typeFile, typeDirectory, typeGlob},
 			},
 			"path": schematypes.String{
 				Title:       "Artifact Path",
@@ -54,6 +59,32 @@ var artifactSchema = schematypes.Array{
 				`),
 				Pattern: `^([\x20-\x2e\x30-\x7e][\x20-\x7e]*)[\x20-\x2e\x30-\x7e]$`,
 			},
+			"exclude": schematypes.Array{
+				Title: "Exclude Patterns",
+				Description: util.Markdown(`
+					Files matching any of these glob patterns are not uploaded. This only
+					applies to 'directory' and 'glob' artifacts, and patterns are matched
+					against the path relative to the directory, or relative to the
+					leading segments of a 'glob' pattern that don't contain wildcards.
+				`),
+				Items: schematypes.String{},
+			},
+			"optional": schematypes.Boolean{
+				Title: "Optional Artifact",
+				Description: util.Markdown(`
+					If 'true' the task will not fail if the artifact is missing, instead
+					a message will be written to the task log.
+				`),
+			},
+			"live": schematypes.Boolean{
+				Title: "Live Artifact",
+				Description: util.Markdown(`
+					If 'true' the artifact will be available while the task is running,
+					streaming the contents of the file as it is written. When the task is
+					resolved the artifact is redirected to the final upload. This is only
+					supported for artifacts of type 'file'.
+				`),
+			},
 			"expires": schematypes.DateTime{
 				Title:       "Expiration Date",
 				Description: "",
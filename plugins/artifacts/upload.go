@@ -0,0 +1,128 @@
+package artifacts
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+)
+
+// isCompressible returns true, if mimetype matches one of the patterns given.
+// Patterns ending with '/' matches all subtypes.
+func isCompressible(mimetype string, patterns []string) bool {
+	mediatype, _, err := mime.ParseMediaType(mimetype)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range patterns {
+		if mediatype == pattern {
+			return true
+		}
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(mediatype, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// spawnUpload runs fn in the background once an upload slot is available.
+//
+// This blocks until an upload slot is available, or returns context.Canceled
+// if the task is canceled while waiting. Use tp.uploads.Wait() to wait for all
+// spawned uploads to finish.
+func (tp *taskPlugin) spawnUpload(fn func()) error {
+	select {
+	case tp.uploadSlots <- struct{}{}:
+	case <-tp.context.Done():
+		return context.Canceled
+	}
+	tp.uploads.Add(1)
+	go func() {
+		defer tp.uploads.Done()
+		defer func() { <-tp.uploadSlots }()
+		fn()
+	}()
+	return nil
+}
+
+// compress returns a temporary file with the gzip compressed contents of r
+func (tp *taskPlugin) compress(r io.ReadSeeker) (runtime.TemporaryFile, error) {
+	if _, err := r.Seek(0, 0); err != nil {
+		return nil, errors.Wrap(err, "failed to seek to start of artifact")
+	}
+	compressed, err := tp.plugin.environment.TemporaryStorage.NewFile()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary file")
+	}
+	zipper := gzip.NewWriter(compressed)
+	if _, err = io.Copy(zipper, r); err != nil {
+		compressed.Close()
+		return nil, errors.Wrap(err, "failed to compress artifact")
+	}
+	if err = zipper.Close(); err != nil {
+		compressed.Close()
+		return nil, errors.Wrap(err, "failed to close compressing of artifact")
+	}
+	if _, err = compressed.Seek(0, 0); err != nil {
+		compressed.Close()
+		return nil, errors.Wrap(err, "failed to seek to start of compressed artifact")
+	}
+	return compressed, nil
+}
+
+// uploadArtifact hashes r for chain-of-trust, compresses it if mimetype is
+// compressible and uploads it as an S3 artifact, retrying with backoff if the
+// upload fails.
+//
+// Returns context.Canceled, if the task is canceled.
+func (tp *taskPlugin) uploadArtifact(name, mimetype string, expires time.Time, r ioext.ReadSeekCloser) error {
+	// Compute artifact hash for chain-of-trust, this is always the hash of the
+	// uncompressed content, as that is what consumers will see.
+	if err := tp.hashArtifact(name, r); err != nil {
+		return err
+	}
+
+	var headers map[string]string
+	if isCompressible(mimetype, tp.plugin.compressMimetypes) {
+		compressed, err := tp.compress(r)
+		if err != nil {
+			return err
+		}
+		defer compressed.Close()
+		r = compressed
+		headers = map[string]string{
+			"Content-Encoding": "gzip",
+		}
+	}
+
+	// Retries with backoff are handled by UploadS3Artifact
+	err := tp.context.UploadS3Artifact(runtime.S3Artifact{
+		Name:              name,
+		Mimetype:          mimetype,
+		Expires:           expires,
+		Stream:            r,
+		AdditionalHeaders: headers,
+		Attempts:          tp.plugin.uploadRetries + 1,
+	})
+	if err != nil && tp.context.Err() != nil {
+		return context.Canceled
+	}
+	return err
+}
+
+// reportUploadError reports err from uploadArtifact, if any. Upload errors are
+// internal non-fatal errors, unless the task was canceled in which case
+// requests are expected to be aborted.
+func (tp *taskPlugin) reportUploadError(err error) {
+	if err != nil && err != context.Canceled {
+		tp.nonFatalErr.Set(true)
+		i := tp.monitor.ReportError(err, "Failed to upload artifact")
+		tp.logger.LogError("Failed to upload artifact unhandled error, incidentId:", i)
+	}
+}
@@ -0,0 +1,27 @@
+package artifacts
+
+import "testing"
+
+func TestIsCompressible(t *testing.T) {
+	cases := []struct {
+		mimetype     string
+		compressible bool
+	}{
+		{"text/plain; charset=utf-8", true},
+		{"text/html", true},
+		{"application/json", true},
+		{"image/svg+xml", true},
+		{"image/png", false},
+		{"application/octet-stream", false},
+		{"application/json-seq", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		if isCompressible(c.mimetype, defaultCompressMimetypes) != c.compressible {
+			t.Errorf("expected isCompressible('%s') == %v", c.mimetype, c.compressible)
+		}
+	}
+	if isCompressible("text/plain", []string{}) {
+		t.Error("expected nothing to be compressible with no patterns")
+	}
+}
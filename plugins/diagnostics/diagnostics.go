@@ -0,0 +1,96 @@
+package diagnostics
+
+import (
+	"mime"
+	"path"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/taskcluster/taskcluster-worker/engines"
+	"github.com/taskcluster/taskcluster-worker/plugins"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+)
+
+const unknownMimetype = "application/octet-stream"
+
+type pluginProvider struct {
+	plugins.PluginProviderBase
+}
+
+type plugin struct {
+	plugins.PluginBase
+}
+
+type taskPlugin struct {
+	plugins.TaskPluginBase
+	context *runtime.TaskContext
+	monitor runtime.Monitor
+}
+
+func init() {
+	plugins.Register("diagnostics", pluginProvider{})
+}
+
+func (pluginProvider) NewPlugin(plugins.PluginOptions) (plugins.Plugin, error) {
+	return plugin{}, nil
+}
+
+func (plugin) NewTaskPlugin(options plugins.TaskPluginOptions) (plugins.TaskPlugin, error) {
+	return &taskPlugin{
+		context: options.TaskContext,
+		monitor: options.Monitor,
+	}, nil
+}
+
+func (tp *taskPlugin) Stopped(result engines.ResultSet) (bool, error) {
+	if result.Success() {
+		return true, nil
+	}
+
+	files, err := result.Diagnostics()
+	if err == engines.ErrFeatureNotSupported {
+		return true, nil
+	}
+	if err != nil {
+		tp.monitor.Error(errors.Wrap(err, "failed to capture diagnostics"))
+		return true, runtime.ErrNonFatalInternalError
+	}
+
+	// Upload in sorted order, so artifacts are created in consistent order
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var uploadErr error
+	for _, name := range names {
+		if err = tp.upload(name, files[name]); err != nil {
+			uploadErr = err
+		}
+	}
+	return true, uploadErr
+}
+
+func (tp *taskPlugin) upload(name string, file ioext.ReadSeekCloser) error {
+	defer file.Close()
+
+	mimetype := mime.TypeByExtension(path.Ext(name))
+	if mimetype == "" {
+		mimetype = unknownMimetype
+	}
+
+	debug("Uploading diagnostics: %s", name)
+	err := tp.context.UploadS3Artifact(runtime.S3Artifact{
+		Name:     "public/" + name,
+		Mimetype: mimetype,
+		Expires:  tp.context.TaskInfo.Expires,
+		Stream:   file,
+	})
+	if err != nil {
+		tp.monitor.Error(errors.Wrapf(err, "failed to upload diagnostics: %s", name))
+		return runtime.ErrNonFatalInternalError // Upload error isn't fatal
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package diagnostics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/taskcluster/slugid-go/slugid"
+	"github.com/taskcluster/taskcluster-worker/plugins/plugintest"
+	"github.com/taskcluster/taskcluster-worker/runtime/client"
+)
+
+func TestDiagnosticsFailedTask(t *testing.T) {
+	taskID := slugid.V4()
+	q := &client.MockQueue{}
+	diagnostics := q.ExpectS3Artifact(taskID, 0, "public/mock/diagnostics.txt")
+
+	plugintest.Case{
+		Payload: `{
+			"delay": 0,
+			"function": "false",
+			"argument": ""
+		}`,
+		Plugin:        "diagnostics",
+		TestStruct:    t,
+		PluginSuccess: true,
+		EngineSuccess: false,
+		TaskID:        taskID,
+		QueueMock:     q,
+		AfterFinished: func(plugintest.Options) {
+			assert.Equal(t, "mock diagnostics\n", string(<-diagnostics))
+		},
+	}.Test()
+}
+
+func TestDiagnosticsSuccessfulTask(t *testing.T) {
+	plugintest.Case{
+		Payload: `{
+			"delay": 0,
+			"function": "true",
+			"argument": ""
+		}`,
+		Plugin:        "diagnostics",
+		TestStruct:    t,
+		PluginSuccess: true,
+		EngineSuccess: true,
+	}.Test()
+}
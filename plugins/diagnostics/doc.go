@@ -0,0 +1,12 @@
+// Package diagnostics provides a taskcluster-worker plugin that uploads
+// engine diagnostics as artifacts when a task fails.
+//
+// Engines may capture files such as a screenshot of the virtual machine or a
+// dump of CPU registers when a task fails, crashes or times out. These files
+// are uploaded as 'public/<name>', for example the QEMU engine provides
+// 'public/qemu/screenshot.png' and 'public/qemu/diagnostics.txt'.
+package diagnostics
+
+import "github.com/taskcluster/taskcluster-worker/runtime/util"
+
+var debug = util.Debug("diagnostics")
@@ -24,7 +24,8 @@ type plugin struct {
 }
 
 type payload struct {
-	Env map[string]string `json:"env"`
+	Env       map[string]string `json:"env"`
+	SecretEnv []string          `json:"secretEnv"`
 }
 
 type config struct {
@@ -82,6 +83,17 @@ func (p *plugin) PayloadSchema() schematypes.Object {
 				Description: "Mapping from environment variables to values",
 				Values:      schematypes.String{},
 			},
+			"secretEnv": schematypes.Array{
+				Title: "Secret Environment Variables",
+				Description: util.Markdown(`
+					Names of environment variables whose values are secret, these values
+					will be redacted from the task log.
+
+					Notice that values written to the log before the task starts can't
+					be redacted, and values shorter than 4 characters are not redacted.
+				`),
+				Items: schematypes.String{},
+			},
 		},
 	}
 }
@@ -105,6 +117,16 @@ func (p *plugin) NewTaskPlugin(options plugins.TaskPluginOptions) (plugins.TaskP
 		env[k] = v
 	}
 
+	// Redact values of secret variables from the task log
+	for _, k := range P.SecretEnv {
+		v, ok := env[k]
+		if !ok {
+			return nil, runtime.NewMalformedPayloadError(
+				"Environment variable ", k, " is listed in task.payload.secretEnv, but isn't set")
+		}
+		options.TaskContext.RedactSecret(v)
+	}
+
 	return &taskPlugin{
 		variables: env,
 	}, nil
@@ -133,3 +133,23 @@ func TestInjectsRunID(*testing.T) {
 		MatchLog:      "7",
 	}.Test()
 }
+
+func TestEnvSecret(*testing.T) {
+	plugintest.Case{
+		Payload: `{
+			"delay": 0,
+			"function": "print-env-var",
+			"argument": "ENV1",
+			"env": {
+				"ENV1": "my-secret-value"
+			},
+			"secretEnv": ["ENV1"]
+		}`,
+		PluginConfig:  `{}`,
+		Plugin:        "env",
+		PluginSuccess: true,
+		EngineSuccess: true,
+		MatchLog:      `\[REDACTED\]`,
+		NotMatchLog:   "my-secret-value",
+	}.Test()
+}
@@ -0,0 +1,29 @@
+package interactive
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/taskcluster/taskcluster-worker/runtime"
+)
+
+// An auditLog writes interactive session events to the task log, such that
+// anyone inspecting the task can see who accessed it and what they did.
+//
+// Methods on a nil auditLog are no-ops, so servers used outside the plugin
+// don't need an audit log.
+type auditLog struct {
+	log *runtime.TaskLogger
+}
+
+// Log writes an event for the session requested by r to the task log
+func (a *auditLog) Log(r *http.Request, event string) {
+	if a == nil {
+		return
+	}
+	who := requestClientID(r)
+	if who == "" {
+		who = "anonymous"
+	}
+	a.log.Log(fmt.Sprintf("[interactive] %s %s", who, event))
+}
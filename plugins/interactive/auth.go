@@ -0,0 +1,234 @@
+package interactive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/client"
+)
+
+// defaultAuthBaseURL is the default base URL for the taskcluster auth service
+// used to authenticate requests for interactive sessions.
+const defaultAuthBaseURL = "https://auth.taskcluster.net/v1"
+
+// interactiveScopePrefix is the prefix for the scope required to access
+// interactive sessions, the scope is: worker:interactive:<workerType>/<taskId>
+const interactiveScopePrefix = "worker:interactive:"
+
+// An authenticator authenticates requests with taskcluster credentials, using
+// the authenticateHawk end-point from the auth service. Requests to the auth
+// service are signed with the task credentials.
+type authenticator struct {
+	authorizer  client.Authorizer
+	authBaseURL string
+	scope       string
+	monitor     runtime.Monitor
+}
+
+// hawkRequest is the payload for the authenticateHawk end-point
+type hawkRequest struct {
+	Method        string `json:"method"`
+	Resource      string `json:"resource"`
+	Host          string `json:"host"`
+	Port          int    `json:"port"`
+	Authorization string `json:"authorization,omitempty"`
+}
+
+// hawkResponse is the response from the authenticateHawk end-point
+type hawkResponse struct {
+	Status   string   `json:"status"`
+	Message  string   `json:"message"`
+	ClientID string   `json:"clientId"`
+	Scopes   []string `json:"scopes"`
+}
+
+type clientIDKey struct{}
+
+// requestClientID returns the clientId that authenticated the request, or
+// empty string if the request wasn't authenticated.
+func requestClientID(r *http.Request) string {
+	clientID, _ := r.Context().Value(clientIDKey{}).(string)
+	return clientID
+}
+
+// Handler returns an http.Handler that only forwards requests to handler, if
+// they are authenticated with credentials that satisfy the required scope.
+// The hook URL at which handler is exposed must be given with SetURL, requests
+// arriving before this wait for the URL to be set.
+func (a *authenticator) Handler(handler http.Handler) *authenticatedHandler {
+	return &authenticatedHandler{
+		auth:    a,
+		handler: handler,
+		hasURL:  make(chan struct{}),
+	}
+}
+
+type authenticatedHandler struct {
+	auth    *authenticator
+	handler http.Handler
+	hookURL *url.URL
+	hasURL  chan struct{} // Closed when hookURL is set
+}
+
+// SetURL sets the public URL for the hook, requests are authenticated against
+// the public URL as the path and host may be rewritten by the WebHookServer.
+func (h *authenticatedHandler) SetURL(hookURL string) {
+	u, err := url.Parse(hookURL)
+	if err != nil {
+		panic(errors.Wrap(err, "WebHookServer returned an invalid URL"))
+	}
+	h.hookURL = u
+	close(h.hasURL)
+}
+
+func (h *authenticatedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The handler is attached before the URL is known, so a request may arrive
+	// before SetURL has been called.
+	select {
+	case <-h.hasURL:
+	case <-r.Context().Done():
+		return
+	}
+
+	// Require credentials as Authorization header or bewit
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" && r.URL.Query().Get("bewit") == "" {
+		setCORS(w)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	clientID, scopes, err := h.auth.authenticate(h.hookURL, r.Method, r.URL.RequestURI(), authorization)
+	if err != nil {
+		incidentID := h.auth.monitor.ReportError(err, "Failed to authenticate interactive request")
+		debug("Failed to authenticate request, incidentId: %s", incidentID)
+		setCORS(w)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if clientID == "" {
+		setCORS(w)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if !scopeSatisfied(scopes, h.auth.scope) {
+		debug("clientId: %s doesn't have scope: %s", clientID, h.auth.scope)
+		setCORS(w)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	h.handler.ServeHTTP(w, r.WithContext(
+		context.WithValue(r.Context(), clientIDKey{}, clientID),
+	))
+}
+
+// authenticate returns clientId and scopes for the credentials used to sign a
+// request for resource at hookURL, clientId is empty if authentication failed.
+func (a *authenticator) authenticate(hookURL *url.URL, method, resource, authorization string) (string, []string, error) {
+	// Find port, defaulting to the port implied by the scheme
+	port := 443
+	if hookURL.Port() != "" {
+		port, _ = strconv.Atoi(hookURL.Port())
+	} else if hookURL.Scheme == "http" || hookURL.Scheme == "ws" {
+		port = 80
+	}
+
+	data, _ := json.Marshal(hawkRequest{
+		Method:        strings.ToLower(method),
+		Resource:      strings.TrimSuffix(hookURL.Path, "/") + resource,
+		Host:          hookURL.Hostname(),
+		Port:          port,
+		Authorization: authorization,
+	})
+
+	// Create request signed with task credentials
+	u, err := url.Parse(a.authBaseURL + "/authenticate-hawk")
+	if err != nil {
+		return "", nil, errors.Wrap(err, "invalid authBaseUrl")
+	}
+	req, _ := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(data))
+	signature, err := a.authorizer.SignHeader(http.MethodPost, u, data)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "SignHeader failed")
+	}
+	req.Header.Set("Authorization", signature)
+	req.Header.Set("Content-Type", "application/json")
+
+	// Send request
+	c := http.Client{Timeout: 30 * time.Second}
+	res, err := c.Do(req)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "authenticateHawk request failed")
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to read authenticateHawk response")
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", nil, errors.Errorf("authenticateHawk returned status: %d, body: %s", res.StatusCode, body)
+	}
+
+	var result hawkResponse
+	if err = json.Unmarshal(body, &result); err != nil {
+		return "", nil, errors.Wrap(err, "failed to parse authenticateHawk response")
+	}
+	if result.Status != "auth-success" {
+		debug("authenticateHawk failed, message: %s", result.Message)
+		return "", nil, nil
+	}
+	return result.ClientID, result.Scopes, nil
+}
+
+// scopeSatisfied returns true, if scopes satisfies the required scope
+func scopeSatisfied(scopes []string, required string) bool {
+	for _, scope := range scopes {
+		if scope == required {
+			return true
+		}
+		if strings.HasSuffix(scope, "*") && strings.HasPrefix(required, scope[:len(scope)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizationHeader returns an Authorization header for a request to an
+// interactive session URL, using the credentials from TASKCLUSTER_CLIENT_ID,
+// TASKCLUSTER_ACCESS_TOKEN and TASKCLUSTER_CERTIFICATE environment variables.
+// If no credentials are present an empty string is returned.
+//
+// This is useful for command line utilities connecting to interactive sessions
+// that require authentication. Browsers can't set headers for websockets, and
+// must use a URL signed with a bewit instead.
+func AuthorizationHeader(method string, u *url.URL) (string, error) {
+	clientID := os.Getenv("TASKCLUSTER_CLIENT_ID")
+	accessToken := os.Getenv("TASKCLUSTER_ACCESS_TOKEN")
+	if clientID == "" || accessToken == "" {
+		return "", nil
+	}
+	a := client.NewAuthorizer(func() (string, string, string, error) {
+		return clientID, accessToken, os.Getenv("TASKCLUSTER_CERTIFICATE"), nil
+	})
+
+	// Sign the http(s) equivalent of websocket URLs
+	target := *u
+	switch target.Scheme {
+	case "ws":
+		target.Scheme = "http"
+	case "wss":
+		target.Scheme = "https"
+	}
+	return a.SignHeader(method, &target, nil)
+}
@@ -2,19 +2,26 @@ package interactive
 
 import (
 	"fmt"
+	"time"
 
 	schematypes "github.com/taskcluster/go-schematypes"
 	"github.com/taskcluster/taskcluster-worker/runtime/util"
 )
 
 type config struct {
-	ArtifactPrefix             string `json:"artifactPrefix"`
-	ForbidCustomArtifactPrefix bool   `json:"forbidCustomArtifactPrefix"`
-	AlwaysEnabled              bool   `json:"alwaysEnabled"`
-	DisableShell               bool   `json:"disableShell"`
-	DisableDisplay             bool   `json:"disableDisplay"`
-	ShellToolURL               string `json:"shellToolUrl"`
-	DisplayToolURL             string `json:"displayToolUrl"`
+	ArtifactPrefix             string        `json:"artifactPrefix"`
+	ForbidCustomArtifactPrefix bool          `json:"forbidCustomArtifactPrefix"`
+	AlwaysEnabled              bool          `json:"alwaysEnabled"`
+	DisableShell               bool          `json:"disableShell"`
+	DisableDisplay             bool          `json:"disableDisplay"`
+	DisableForward             bool          `json:"disableForward"`
+	DisableFiles               bool          `json:"disableFiles"`
+	ShellToolURL               string        `json:"shellToolUrl"`
+	DisplayToolURL             string        `json:"displayToolUrl"`
+	AlwaysRecord               bool          `json:"alwaysRecord"`
+	MaxKeepAlive               time.Duration `json:"maxKeepAlive"`
+	RequireAuthentication      bool          `json:"requireAuthentication"`
+	AuthBaseURL                string        `json:"authBaseUrl"`
 }
 
 var configSchema = schematypes.Object{
@@ -53,6 +60,50 @@ var configSchema = schematypes.Object{
 			Title:       "Disable Display",
 			Description: "If set the interactive display will be disabled.",
 		},
+		"disableForward": schematypes.Boolean{
+			Title:       "Disable Port Forwarding",
+			Description: "If set forwarding of TCP ports from the sandbox will be disabled.",
+		},
+		"disableFiles": schematypes.Boolean{
+			Title:       "Disable File Copying",
+			Description: "If set copying files into and out of the sandbox will be disabled.",
+		},
+		"alwaysRecord": schematypes.Boolean{
+			Title: "Always Record Sessions",
+			Description: util.Markdown(`
+				If set all interactive shell and display sessions will be recorded and
+				uploaded as artifacts under '<artifactPrefix>recordings/', regardless
+				of whether the task requested recording. This is useful for auditing.
+			`),
+		},
+		"maxKeepAlive": schematypes.Duration{
+			Title: "Maximum Keep-Alive",
+			Description: util.Markdown(`
+				Maximum time tasks may keep the interactive shell available after the
+				task command has exited, using 'keepAlive' in the task payload.
+				Defaults to ` + defaultMaxKeepAlive.String() + `, if not specified.
+
+				This property is specified in seconds as integer or as string on the
+				form '1 day 2 hours 3 minutes'.
+			`),
+		},
+		"requireAuthentication": schematypes.Boolean{
+			Title: "Require Authentication",
+			Description: util.Markdown(`
+				If set all requests for interactive sessions must be authenticated
+				with taskcluster credentials that satisfy the scope
+				'` + interactiveScopePrefix + `<workerType>/<taskId>', regardless of
+				whether the task requested authentication.
+			`),
+		},
+		"authBaseUrl": schematypes.URI{
+			Title: "Auth Base URL",
+			Description: util.Markdown(`
+				Base URL for the taskcluster auth service used to authenticate
+				requests for interactive sessions. Defaults to
+				'` + defaultAuthBaseURL + `'.
+			`),
+		},
 		"shellToolUrl": schematypes.URI{
 			Title: "Shell Tool URL",
 			Description: util.Markdown(`
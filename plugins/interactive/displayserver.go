@@ -12,6 +12,7 @@ import (
 	"github.com/taskcluster/taskcluster-worker/engines"
 	"github.com/taskcluster/taskcluster-worker/plugins/interactive/displayconsts"
 	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
 )
 
 // A DisplayProvider is an object that supplies displays. This is a subset of
@@ -25,11 +26,13 @@ type DisplayProvider interface {
 // A DisplayServer exposes a DisplayProvider over a websocket, tracks
 // connections and ensures they are all cleaned up.
 type DisplayServer struct {
-	m        sync.Mutex
-	provider DisplayProvider
-	monitor  runtime.Monitor
-	done     chan struct{}
-	handlers []*DisplayHandler
+	m          sync.Mutex
+	provider   DisplayProvider
+	monitor    runtime.Monitor
+	done       chan struct{}
+	handlers   []*DisplayHandler
+	recordings *recordingSet // nil, if sessions aren't recorded
+	audit      *auditLog     // nil, if sessions aren't audited
 }
 
 // NewDisplayServer creates a DisplayServer for exposing the given provider
@@ -134,6 +137,25 @@ func (s *DisplayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	default:
 	}
 
+	// Record the session, if recording is enabled
+	if s.recordings != nil {
+		r, err := s.recordings.newRecording("display", "fbs", "application/octet-stream")
+		if err != nil {
+			s.monitor.ReportError(err, "Failed to create display recording")
+		}
+		if r != nil {
+			display = &recordedDisplay{display, newDisplayRecording(r)}
+		}
+	}
+
+	// Log when the display is opened and closed, if sessions are audited
+	if s.audit != nil {
+		s.audit.Log(r, fmt.Sprintf("opened display: %s", displayName))
+		display = ioext.WatchPipe(display, func(error) {
+			s.audit.Log(r, fmt.Sprintf("closed display: %s", displayName))
+		})
+	}
+
 	// Create new handler and add it to the list
 	h := NewDisplayHandler(ws, display, s.monitor.WithTag("display", displayName))
 	s.handlers = append(s.handlers, h)
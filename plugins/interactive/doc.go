@@ -1,5 +1,6 @@
 // Package interactive implements the plugin that serves the interactive
-// display and shell sessions over websockets.
+// display and shell sessions, forwarded ports and file copying over
+// websockets and HTTP.
 //
 // The package can also be used as library that provides functionality to host
 // display and shell sessions over websockets. This is useful for reusing the
@@ -0,0 +1,245 @@
+package interactive
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/taskcluster/taskcluster-worker/engines"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+)
+
+// A FileProvider is an object that can read and write files. This is a subset
+// of the Sandbox interface.
+type FileProvider interface {
+	// See engines.Sandbox for documentation for methods.
+	ExtractFile(path string) (ioext.ReadSeekCloser, error)
+	ExtractFolder(path string, handler engines.FileHandler) error
+	WriteFile(path string, stream io.Reader) error
+}
+
+// A FileServer exposes files from a FileProvider over HTTP, allowing files to
+// be copied into and out of a running sandbox.
+//
+// The path is given in the 'path' querystring parameter, files are downloaded
+// with GET and uploaded with PUT requests. If the querystring parameter
+// 'archive=tar' is given, the request concerns the folder at path and the body
+// is a tar-stream of the files in the folder.
+type FileServer struct {
+	m        sync.Mutex
+	provider FileProvider
+	monitor  runtime.Monitor
+	done     chan struct{}
+	audit    *auditLog // nil, if sessions aren't audited
+}
+
+// NewFileServer creates a FileServer for exposing files from the given
+// provider over HTTP.
+func NewFileServer(provider FileProvider, monitor runtime.Monitor) *FileServer {
+	return &FileServer{
+		monitor:  monitor,
+		provider: provider,
+		done:     make(chan struct{}),
+	}
+}
+
+// Abort stops new requests from being accepted, requests in progress will
+// fail as the sandbox is terminated.
+func (s *FileServer) Abort() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// Ensure the done channel is closed
+	select {
+	case <-s.done: // can't close twice
+	default:
+		close(s.done)
+	}
+}
+
+func (s *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	setCORS(w)
+
+	// Quickly check that server haven't been aborted yet
+	select {
+	case <-s.done:
+		w.WriteHeader(http.StatusGone)
+		return
+	default:
+	}
+
+	// Get path and archive format from query-string
+	qs := r.URL.Query()
+	p := qs.Get("path")
+	archive := qs.Get("archive")
+	if p == "" || (archive != "" && archive != "tar") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	switch {
+	case r.Method == http.MethodGet && archive == "":
+		s.audit.Log(r, fmt.Sprintf("downloading file: %s", p))
+		err = s.getFile(w, p)
+	case r.Method == http.MethodGet:
+		s.audit.Log(r, fmt.Sprintf("downloading folder: %s", p))
+		err = s.getFolder(w, p)
+	case r.Method == http.MethodPut && archive == "":
+		s.audit.Log(r, fmt.Sprintf("uploading file: %s", p))
+		debug("Writing file: %s", p)
+		err = s.provider.WriteFile(p, r.Body)
+	case r.Method == http.MethodPut:
+		s.audit.Log(r, fmt.Sprintf("uploading folder: %s", p))
+		err = s.putFolder(r.Body, p)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Reply with status code matching the error
+	if _, ok := runtime.IsMalformedPayloadError(err); ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	switch err {
+	case nil:
+		if r.Method == http.MethodPut {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case engines.ErrResourceNotFound:
+		w.WriteHeader(http.StatusNotFound)
+	case engines.ErrSandboxTerminated, engines.ErrSandboxAborted:
+		w.WriteHeader(http.StatusGone)
+	case engines.ErrFeatureNotSupported:
+		w.WriteHeader(http.StatusNotImplemented)
+	case errResponseStarted:
+		// We can't change the status code, the client will see a truncated body
+	default:
+		debug("Failed to serve file: %s, error: %s", p, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// errResponseStarted is returned when an error happens after the response
+// status has been sent.
+var errResponseStarted = errors.New("error after response was started")
+
+func (s *FileServer) getFile(w http.ResponseWriter, p string) error {
+	debug("Reading file: %s", p)
+	f, err := s.provider.ExtractFile(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Find size of the file
+	size, err := f.Seek(0, 2)
+	if err == nil {
+		_, err = f.Seek(0, 0)
+	}
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(http.StatusOK)
+	if _, err = io.Copy(w, f); err != nil {
+		return errResponseStarted
+	}
+	return nil
+}
+
+func (s *FileServer) getFolder(w http.ResponseWriter, p string) error {
+	debug("Reading folder: %s", p)
+	var m sync.Mutex
+	var tw *tar.Writer
+	// Response headers are sent when we have the first file, so the status code
+	// can reflect errors from ExtractFolder() until then
+	start := func() {
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.WriteHeader(http.StatusOK)
+		tw = tar.NewWriter(w)
+	}
+	err := s.provider.ExtractFolder(p, func(name string, f ioext.ReadSeekCloser) error {
+		defer f.Close()
+
+		// Find size of the file
+		size, err := f.Seek(0, 2)
+		if err == nil {
+			_, err = f.Seek(0, 0)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Handler may be called concurrently, so we must lock
+		m.Lock()
+		defer m.Unlock()
+
+		if tw == nil {
+			start()
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     size,
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if tw == nil {
+		if err != nil {
+			return err
+		}
+		start() // The folder is empty, so we reply with an empty tar-stream
+	} else if err != nil {
+		return errResponseStarted
+	}
+	if tw.Close() != nil {
+		return errResponseStarted
+	}
+	return nil
+}
+
+func (s *FileServer) putFolder(r io.Reader, p string) error {
+	debug("Writing folder: %s", p)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return runtime.NewMalformedPayloadError("Invalid tar-stream, error: ", err)
+		}
+
+		// Skip anything that isn't a plain file
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		// Clean the name, so that it can't leave the folder
+		name := path.Clean("/" + hdr.Name)[1:]
+		if name == "" {
+			return runtime.NewMalformedPayloadError("Invalid file name in tar-stream: ", hdr.Name)
+		}
+
+		debug("Writing file: %s", name)
+		if err = s.provider.WriteFile(strings.TrimSuffix(p, "/")+"/"+name, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package interactive
+
+import (
+	"archive/tar"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/taskcluster/taskcluster-worker/engines"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+	"github.com/taskcluster/taskcluster-worker/runtime/mocks"
+)
+
+// emptyFolderProvider is a FileProvider where every folder is empty
+type emptyFolderProvider struct{}
+
+func (emptyFolderProvider) ExtractFile(path string) (ioext.ReadSeekCloser, error) {
+	return nil, engines.ErrResourceNotFound
+}
+
+func (emptyFolderProvider) ExtractFolder(path string, handler engines.FileHandler) error {
+	return nil
+}
+
+func (emptyFolderProvider) WriteFile(path string, stream io.Reader) error {
+	return engines.ErrFeatureNotSupported
+}
+
+func TestFileServerEmptyFolder(t *testing.T) {
+	s := NewFileServer(emptyFolderProvider{}, mocks.NewMockMonitor(true))
+	defer s.Abort()
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "?archive=tar&path=/empty")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "application/x-tar", res.Header.Get("Content-Type"))
+
+	// Body must be a valid tar-stream without any files
+	_, err = tar.NewReader(res.Body).Next()
+	require.Equal(t, io.EOF, err)
+}
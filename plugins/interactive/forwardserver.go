@@ -0,0 +1,163 @@
+package interactive
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/taskcluster/taskcluster-worker/engines"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+)
+
+// A PortProvider is an object that can open connections to TCP ports. This is
+// a subset of the Sandbox interface.
+type PortProvider interface {
+	// See engines.Sandbox for documentation for methods.
+	DialPort(port int) (io.ReadWriteCloser, error)
+}
+
+// A ForwardServer exposes TCP ports from a PortProvider over websockets, one
+// websocket for each connection. The port is given in the 'port' querystring
+// parameter, and data is forwarded as binary messages.
+//
+// Forwarding a connection is the same as serving a display, hence, connections
+// are served using a DisplayHandler and clients can use displayclient.New().
+type ForwardServer struct {
+	m        sync.Mutex
+	provider PortProvider
+	monitor  runtime.Monitor
+	done     chan struct{}
+	handlers []*DisplayHandler
+	audit    *auditLog // nil, if sessions aren't audited
+}
+
+// NewForwardServer creates a ForwardServer for exposing ports from the given
+// provider over websockets.
+func NewForwardServer(provider PortProvider, monitor runtime.Monitor) *ForwardServer {
+	return &ForwardServer{
+		monitor:  monitor,
+		provider: provider,
+		done:     make(chan struct{}),
+	}
+}
+
+// Abort stops new connections from opening and aborts all existing
+// connections, cleaning up all resources held.
+func (s *ForwardServer) Abort() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// Ensure the done channel is closed
+	select {
+	case <-s.done: // can't close twice
+	default:
+		close(s.done)
+	}
+
+	// Abort all existing handlers
+	for _, h := range s.handlers {
+		h.Abort()
+	}
+	s.handlers = nil
+}
+
+func (s *ForwardServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Quickly check that server haven't been aborted yet
+	select {
+	case <-s.done:
+		setCORS(w)
+		w.WriteHeader(http.StatusGone)
+		return
+	default:
+	}
+
+	// Only websocket upgrades are supported
+	if !websocket.IsWebSocketUpgrade(r) {
+		setCORS(w)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Get port from query-string
+	port, err := strconv.Atoi(r.URL.Query().Get("port"))
+	if err != nil || port < 1 || port > 65535 {
+		setCORS(w)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Dial port before we upgrade, so we can return an error status
+	conn, err := s.provider.DialPort(port)
+	switch err {
+	case engines.ErrNoSuchPort:
+		setCORS(w)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case engines.ErrSandboxTerminated, engines.ErrSandboxAborted:
+		setCORS(w)
+		w.WriteHeader(http.StatusGone)
+		return
+	case engines.ErrFeatureNotSupported:
+		setCORS(w)
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	case nil:
+	default:
+		setCORS(w)
+		w.WriteHeader(http.StatusInternalServerError)
+		debug("Failed to dial port: %d, error: %s", port, err)
+		return
+	}
+
+	// Upgrade the connection, close conn if upgrade fails
+	ws, err := displayUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		debug("Failed to upgrade request to websocket, error: %s", err)
+		conn.Close()
+		return
+	}
+
+	// Lock and ensure that we haven't aborted
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	select {
+	case <-s.done:
+		ws.Close()
+		conn.Close()
+		return
+	default:
+	}
+
+	// Log when the connection is opened and closed, if sessions are audited
+	if s.audit != nil {
+		s.audit.Log(r, fmt.Sprintf("opened connection to port: %d", port))
+		conn = ioext.WatchPipe(conn, func(error) {
+			s.audit.Log(r, fmt.Sprintf("closed connection to port: %d", port))
+		})
+	}
+
+	// Remove the handler from the list when the connection is closed, onClose
+	// is called in a separate go-routine, so h is set when we get the lock.
+	var h *DisplayHandler
+	conn = ioext.WatchPipe(conn, func(error) {
+		s.m.Lock()
+		defer s.m.Unlock()
+
+		handlers := s.handlers[:0]
+		for _, handler := range s.handlers {
+			if handler != h {
+				handlers = append(handlers, handler)
+			}
+		}
+		s.handlers = handlers
+	})
+
+	// Create new handler and add it to the list
+	h = NewDisplayHandler(ws, conn, s.monitor.WithTag("port", fmt.Sprintf("%d", port)))
+	s.handlers = append(s.handlers, h)
+}
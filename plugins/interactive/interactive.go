@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
 	schematypes "github.com/taskcluster/go-schematypes"
 	"github.com/taskcluster/taskcluster-worker/engines"
@@ -29,6 +31,10 @@ const defaultShellToolURL = "https://tools.taskcluster.net/shell/"
 // and connect to the display socket with interactive noVNC session.
 const defaultDisplayToolURL = "https://tools.taskcluster.net/display/"
 
+// defaultMaxKeepAlive is the default maximum time a task may keep the
+// interactive shell available after the task command has exited.
+const defaultMaxKeepAlive = 15 * time.Minute
+
 type provider struct {
 	plugins.PluginProviderBase
 }
@@ -49,6 +55,12 @@ func (provider) NewPlugin(options plugins.PluginOptions) (plugins.Plugin, error)
 	if c.DisplayToolURL == "" {
 		c.DisplayToolURL = defaultDisplayToolURL
 	}
+	if c.MaxKeepAlive == 0 {
+		c.MaxKeepAlive = defaultMaxKeepAlive
+	}
+	if c.AuthBaseURL == "" {
+		c.AuthBaseURL = defaultAuthBaseURL
+	}
 
 	// IF no WebHookServer is available we disabling the interactive plugin
 	if options.Environment.WebHookServer == nil {
@@ -60,6 +72,9 @@ func (provider) NewPlugin(options plugins.PluginOptions) (plugins.Plugin, error)
 		config:        c,
 		monitor:       options.Monitor,
 		webhookserver: options.Environment.WebHookServer,
+		storage:       options.Environment.TemporaryStorage,
+		workerType:    options.Environment.WorkerType,
+		keepAlive:     options.Engine.Capabilities().ShellsAfterExit,
 	}, nil
 }
 
@@ -68,6 +83,9 @@ type plugin struct {
 	config        config
 	monitor       runtime.Monitor
 	webhookserver webhookserver.WebHookServer
+	storage       runtime.TemporaryStorage
+	workerType    string
+	keepAlive     bool // True, if shells can be created after the task command exits
 }
 
 func (p *plugin) PayloadSchema() schematypes.Object {
@@ -93,6 +111,57 @@ func (p *plugin) PayloadSchema() schematypes.Object {
 					is given for 'interactive', even an empty object.
 				`),
 			},
+			"disableForward": schematypes.Boolean{
+				Title: "Disable Port Forwarding",
+				Description: util.Markdown(`
+					Disable forwarding of TCP ports from the task, defaults to enabled
+					if any options is given for 'interactive', even an empty object.
+					Ports can be forwarded using 'taskcluster-worker forward' with the
+					'forwardSocketUrl' from 'sockets.json'.
+				`),
+			},
+			"disableFiles": schematypes.Boolean{
+				Title: "Disable File Copying",
+				Description: util.Markdown(`
+					Disable copying of files into and out of the task, defaults to
+					enabled if any options is given for 'interactive', even an empty
+					object. Files can be copied using 'taskcluster-worker cp' with the
+					'filesUrl' from 'sockets.json'.
+				`),
+			},
+			"record": schematypes.Boolean{
+				Title: "Record Sessions",
+				Description: util.Markdown(`
+					Record interactive sessions and upload the recordings as artifacts
+					under '<artifactPrefix>recordings/'. Shell sessions are recorded in the
+					asciicast v2 format as 'shell-<n>.cast', and display sessions are
+					recorded in the FBS format as 'display-<n>.fbs'.
+				`),
+			},
+			"requireAuthentication": schematypes.Boolean{
+				Title: "Require Authentication",
+				Description: util.Markdown(`
+					Require requests for interactive sessions to be authenticated with
+					taskcluster credentials that satisfy the scope
+					'` + interactiveScopePrefix + `<workerType>/<taskId>'. Credentials
+					can be given as 'Authorization' header or as 'bewit' in the URL.
+					The command line tools will use the credentials from the
+					'TASKCLUSTER_CLIENT_ID', 'TASKCLUSTER_ACCESS_TOKEN' and
+					'TASKCLUSTER_CERTIFICATE' environment variables.
+				`),
+			},
+			"keepAlive": schematypes.Duration{
+				Title: "Keep-Alive after Exit",
+				Description: util.Markdown(`
+					Keep the interactive shell available for debugging after the task
+					command has exited. Results are reported when the keep-alive period
+					has expired, or when all shells opened after the command exited have
+					disconnected. This may not exceed '` + p.config.MaxKeepAlive.String() + `'.
+
+					This requires support from the engine, and is specified in seconds
+					as integer or as string on the form '1 day 2 hours 3 minutes'.
+				`),
+			},
 		},
 	}
 	if !p.config.ForbidCustomArtifactPrefix {
@@ -134,13 +203,39 @@ func (p *plugin) NewTaskPlugin(options plugins.TaskPluginOptions) (
 	if o.ArtifactPrefix == "" || p.config.ForbidCustomArtifactPrefix {
 		o.ArtifactPrefix = p.config.ArtifactPrefix
 	}
+	if o.KeepAlive > p.config.MaxKeepAlive {
+		return nil, runtime.NewMalformedPayloadError(
+			"task.payload.interactive.keepAlive may not exceed ", p.config.MaxKeepAlive.String(),
+			" as is configured the maximum keep-alive for this workerType",
+		)
+	}
+
+	// Create recordingSet, if sessions are to be recorded
+	var recordings *recordingSet
+	if o.Record || p.config.AlwaysRecord {
+		recordings = newRecordingSet(p.storage)
+	}
+
+	// Create authenticator, if sessions require authentication
+	var auth *authenticator
+	if o.RequireAuth || p.config.RequireAuthentication {
+		auth = &authenticator{
+			authorizer:  options.TaskContext.Authorizer(),
+			authBaseURL: p.config.AuthBaseURL,
+			scope:       interactiveScopePrefix + p.workerType + "/" + options.TaskContext.TaskID,
+			monitor:     options.Monitor.WithPrefix("authenticator"),
+		}
+	}
 
 	return &taskPlugin{
-		context:  options.TaskContext,
-		webhooks: webhookserver.NewWebHookSet(p.webhookserver),
-		opts:     o,
-		monitor:  options.Monitor,
-		parent:   p,
+		context:    options.TaskContext,
+		webhooks:   webhookserver.NewWebHookSet(p.webhookserver),
+		opts:       o,
+		monitor:    options.Monitor,
+		parent:     p,
+		recordings: recordings,
+		auth:       auth,
+		audit:      &auditLog{log: options.Logger},
 	}, nil
 }
 
@@ -157,11 +252,22 @@ type taskPlugin struct {
 	displaysURL      string
 	displaySocketURL string
 	displayServer    *DisplayServer
+	forwardURL       string
+	forwardServer    *ForwardServer
+	filesURL         string
+	fileServer       *FileServer
+	recordings       *recordingSet  // nil, if sessions aren't recorded
+	auth             *authenticator // nil, if authentication isn't required
+	audit            *auditLog
 }
 
 func (p *taskPlugin) Started(sandbox engines.Sandbox) error {
 	p.sandbox = sandbox
 
+	if p.auth != nil {
+		p.context.Log("Interactive sessions require authentication with scope: ", p.auth.scope)
+	}
+
 	// Setup shell and display in parallel
 	wg := sync.WaitGroup{}
 	wg.Add(2)
@@ -184,6 +290,9 @@ func (p *taskPlugin) Started(sandbox engines.Sandbox) error {
 		return fmt.Errorf("Setting up interactive display failed, error: %s", err2)
 	}
 
+	p.setupForward()
+	p.setupFiles()
+
 	err := p.createSocketsFile()
 	if err != nil {
 		return fmt.Errorf("Failed to create sockets.json file, error: %s", err)
@@ -191,16 +300,85 @@ func (p *taskPlugin) Started(sandbox engines.Sandbox) error {
 	return nil
 }
 
-func (p *taskPlugin) Stopped(_ engines.ResultSet) (bool, error) {
-	return true, p.Dispose()
+func (p *taskPlugin) Stopped(result engines.ResultSet) (bool, error) {
+	p.keepAlive(result)
+	p.abortSessions()
+	return true, nil
+}
+
+// keepAlive keeps the interactive shell available after the task command has
+// exited, until the keep-alive period expires or all shells opened after the
+// command exited have disconnected.
+func (p *taskPlugin) keepAlive(result engines.ResultSet) {
+	if p.opts.KeepAlive == 0 || p.shellServer == nil {
+		return
+	}
+	if !p.parent.keepAlive {
+		p.context.Log("Interactive shell can't be kept alive, as the engine doesn't support it")
+		return
+	}
+
+	// Create new shells from the ResultSet, as the sandbox has terminated
+	count := p.shellServer.setShellFactory(result.NewShell)
+	p.context.Log(fmt.Sprintf(
+		"Task command exited, interactive shell is kept alive for %s or until all shells opened after exit have disconnected",
+		p.opts.KeepAlive,
+	))
+
+	idle := make(chan struct{})
+	go func() {
+		p.shellServer.waitForIdle(count)
+		close(idle)
+	}()
+
+	select {
+	case <-idle:
+		debug("All shells disconnected, ending keep-alive")
+	case <-time.After(p.opts.KeepAlive):
+		p.context.Log("Keep-alive period for interactive shell has expired")
+	case <-p.context.Done():
+	}
+}
+
+func (p *taskPlugin) Finished(success bool) error {
+	return p.uploadRecordings()
 }
 
 func (p *taskPlugin) Exception(_ runtime.ExceptionReason) error {
-	return p.Dispose()
+	p.abortSessions()
+	return p.uploadRecordings()
 }
 
 func (p *taskPlugin) Dispose() error {
-	// NOTE: This is also called from Stopped() and Exception()
+	p.abortSessions()
+	if p.recordings != nil {
+		p.recordings.Dispose()
+	}
+	return nil
+}
+
+// uploadRecordings uploads session recordings, if sessions are recorded
+func (p *taskPlugin) uploadRecordings() error {
+	if p.recordings == nil {
+		return nil
+	}
+	err := p.recordings.Upload(p.context, p.opts.ArtifactPrefix)
+	if err != nil {
+		incidentID := p.monitor.ReportError(err, "Failed to upload interactive session recordings")
+		p.context.LogError("Failed to upload interactive session recordings, incidentId: ", incidentID)
+		return runtime.ErrNonFatalInternalError
+	}
+	return nil
+}
+
+// abortSessions aborts all interactive sessions and closes recordings
+func (p *taskPlugin) abortSessions() {
+	// NOTE: This is called from Stopped(), Exception() and Dispose()
+	defer func() {
+		if p.recordings != nil {
+			p.recordings.Close()
+		}
+	}()
 	util.Parallel(func() {
 		if p.shellServer != nil {
 			p.shellServer.Abort()
@@ -212,13 +390,22 @@ func (p *taskPlugin) Dispose() error {
 			p.displayServer.Abort()
 		}
 		p.displayServer = nil
+	}, func() {
+		if p.forwardServer != nil {
+			p.forwardServer.Abort()
+		}
+		p.forwardServer = nil
+	}, func() {
+		if p.fileServer != nil {
+			p.fileServer.Abort()
+		}
+		p.fileServer = nil
 	}, func() {
 		if p.webhooks != nil {
 			p.webhooks.Dispose()
 		}
 		p.webhooks = nil
 	})
-	return nil
 }
 
 func (p *taskPlugin) setupShell() error {
@@ -232,7 +419,9 @@ func (p *taskPlugin) setupShell() error {
 	p.shellServer = NewShellServer(
 		p.sandbox.NewShell, p.monitor.WithPrefix("shell-server"),
 	)
-	u := p.webhooks.AttachHook(p.shellServer)
+	p.shellServer.recordings = p.recordings
+	p.shellServer.audit = p.audit
+	u := p.attachHook(p.shellServer)
 	p.shellURL = urlProtocolToWebsocket(u)
 
 	query := url.Values{}
@@ -260,7 +449,9 @@ func (p *taskPlugin) setupDisplay() error {
 	p.displayServer = NewDisplayServer(
 		p.sandbox, p.monitor.WithPrefix("display-server"),
 	)
-	u := p.webhooks.AttachHook(p.displayServer)
+	p.displayServer.recordings = p.recordings
+	p.displayServer.audit = p.audit
+	u := p.attachHook(p.displayServer)
 	p.displaysURL = u
 	p.displaySocketURL = urlProtocolToWebsocket(u)
 
@@ -283,6 +474,49 @@ func (p *taskPlugin) setupDisplay() error {
 	})
 }
 
+func (p *taskPlugin) setupForward() {
+	// Setup port forwarding if not disabled
+	if p.opts.DisableForward || p.parent.config.DisableForward {
+		return
+	}
+	debug("Setting up port forwarding")
+
+	// Create forward server and get a URL to reach it
+	p.forwardServer = NewForwardServer(
+		p.sandbox, p.monitor.WithPrefix("forward-server"),
+	)
+	p.forwardServer.audit = p.audit
+	p.forwardURL = urlProtocolToWebsocket(p.attachHook(p.forwardServer))
+}
+
+func (p *taskPlugin) setupFiles() {
+	// Setup file copying if not disabled
+	if p.opts.DisableFiles || p.parent.config.DisableFiles {
+		return
+	}
+	debug("Setting up file copying")
+
+	// Create file server and get a URL to reach it
+	p.fileServer = NewFileServer(
+		p.sandbox, p.monitor.WithPrefix("file-server"),
+	)
+	p.fileServer.audit = p.audit
+	p.filesURL = p.attachHook(p.fileServer)
+}
+
+// attachHook attaches handler to the WebHookSet and returns the URL for the
+// hook, requests must be authenticated if authentication is required.
+// Authenticated requests are held until the URL has been given with SetURL.
+func (p *taskPlugin) attachHook(handler http.Handler) string {
+	if p.auth == nil {
+		return p.webhooks.AttachHook(handler)
+	}
+	h := p.auth.Handler(handler)
+	u := p.webhooks.AttachHook(h)
+	h.SetURL(u)
+	return u
+}
+
 func (p *taskPlugin) createSocketsFile() error {
 	debug("Uploading sockets.json")
 	// Create sockets.json
@@ -298,6 +532,12 @@ func (p *taskPlugin) createSocketsFile() error {
 	if p.displaySocketURL != "" {
 		sockets["displaySocketUrl"] = p.displaySocketURL
 	}
+	if p.forwardURL != "" {
+		sockets["forwardSocketUrl"] = p.forwardURL
+	}
+	if p.filesURL != "" {
+		sockets["filesUrl"] = p.filesURL
+	}
 	data, _ := json.MarshalIndent(sockets, "", "  ")
 	return p.context.UploadS3Artifact(runtime.S3Artifact{
 		Name:     p.opts.ArtifactPrefix + "sockets.json",
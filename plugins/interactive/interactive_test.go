@@ -1,13 +1,20 @@
 package interactive
 
 import (
+	"archive/tar"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/websocket"
 	vnc "github.com/mitchellh/go-vnc"
 	"github.com/taskcluster/slugid-go/slugid"
 	"github.com/taskcluster/taskcluster-worker/plugins/interactive/displayclient"
@@ -181,3 +188,312 @@ func TestInteractivePluginDisplay(t *testing.T) {
 		},
 	}.Test()
 }
+
+func TestInteractivePluginKeepAlive(t *testing.T) {
+	taskID := slugid.V4()
+	q := &client.MockQueue{}
+	shell := q.ExpectRedirectArtifact(taskID, 0, "private/interactive/shell.html")
+	q.ExpectS3Artifact(taskID, 0, "private/interactive/sockets.json")
+	var shellSocketURL string
+	plugintest.Case{
+		Payload: `{
+			"delay": 2000,
+			"function": "true",
+			"argument": "whatever",
+			"interactive": {
+				"disableDisplay": true,
+				"keepAlive": 300
+			}
+		}`,
+		Plugin:        "interactive",
+		PluginConfig:  `{}`,
+		PluginSuccess: true,
+		EngineSuccess: true,
+		QueueMock:     q,
+		TaskID:        taskID,
+		MatchLog:      "interactive shell is kept alive",
+		AfterStarted: func(plugintest.Options) {
+			u, _ := url.Parse(<-shell)
+			shellSocketURL = u.Query().Get("socketUrl")
+		},
+		BeforeStopped: func(plugintest.Options) {
+			// Stopped() blocks until the shell opened after exit disconnects
+			go func() {
+				var sh *shellclient.ShellClient
+				var err error
+				for i := 0; i < 50; i++ {
+					sh, err = shellclient.Dial(shellSocketURL, nil, false)
+					if err == nil {
+						break
+					}
+					time.Sleep(100 * time.Millisecond)
+				}
+				if err != nil {
+					panic(fmt.Sprintf("Failed to open shell after exit, error: %s", err))
+				}
+				go func() {
+					sh.StdinPipe().Write([]byte("print-hello"))
+					sh.StdinPipe().Close()
+				}()
+				msg, _ := ioutil.ReadAll(sh.StdoutPipe())
+				if string(msg) != "Hello World" {
+					panic(fmt.Sprintf("Expected 'Hello World' got: '%s'", string(msg)))
+				}
+				sh.Wait()
+			}()
+		},
+	}.Test()
+}
+
+func TestInteractivePluginKeepAliveUnused(t *testing.T) {
+	taskID := slugid.V4()
+	q := &client.MockQueue{}
+	q.ExpectRedirectArtifact(taskID, 0, "private/interactive/shell.html")
+	q.ExpectS3Artifact(taskID, 0, "private/interactive/sockets.json")
+	plugintest.Case{
+		Payload: `{
+			"delay": 0,
+			"function": "true",
+			"argument": "whatever",
+			"interactive": {
+				"disableDisplay": true,
+				"keepAlive": 1
+			}
+		}`,
+		Plugin:        "interactive",
+		PluginConfig:  `{}`,
+		PluginSuccess: true,
+		EngineSuccess: true,
+		QueueMock:     q,
+		TaskID:        taskID,
+		// No shells were opened after exit, so Stopped() waits for keepAlive
+		MatchLog: "Keep-alive period for interactive shell has expired",
+	}.Test()
+}
+
+func TestInteractivePluginForward(t *testing.T) {
+	taskID := slugid.V4()
+	q := &client.MockQueue{}
+	sockets := q.ExpectS3Artifact(taskID, 0, "private/interactive/sockets.json")
+	plugintest.Case{
+		Payload: `{
+			"delay": 250,
+			"function": "true",
+			"argument": "whatever",
+			"interactive": {
+				"disableShell": true,
+				"disableDisplay": true
+			}
+		}`,
+		Plugin:        "interactive",
+		PluginConfig:  `{}`,
+		PluginSuccess: true,
+		EngineSuccess: true,
+		QueueMock:     q,
+		TaskID:        taskID,
+		AfterStarted: func(plugintest.Options) {
+			var s map[string]interface{}
+			json.Unmarshal(<-sockets, &s)
+			forwardURL, _ := s["forwardSocketUrl"].(string)
+			if forwardURL == "" {
+				panic("Expected forwardSocketUrl in sockets.json")
+			}
+
+			debug("Dial port without a service")
+			_, res, err := websocket.DefaultDialer.Dial(forwardURL+"?port=8", nil)
+			if err != websocket.ErrBadHandshake || res.StatusCode != http.StatusNotFound {
+				panic(fmt.Sprintf("Expected 404 for port without service, error: %s", err))
+			}
+
+			debug("Dial echo port")
+			ws, _, err := websocket.DefaultDialer.Dial(forwardURL+"?port=7", nil)
+			if err != nil {
+				panic(fmt.Sprintf("Failed to dial echo port, error: %s", err))
+			}
+			c := displayclient.New(ws)
+			defer c.Close()
+			if _, err = c.Write([]byte("hello")); err != nil {
+				panic(fmt.Sprintf("Failed to write to echo port, error: %s", err))
+			}
+			data := make([]byte, 5)
+			if _, err = io.ReadFull(c, data); err != nil {
+				panic(fmt.Sprintf("Failed to read from echo port, error: %s", err))
+			}
+			if string(data) != "hello" {
+				panic(fmt.Sprintf("Expected 'hello' got: '%s'", string(data)))
+			}
+		},
+	}.Test()
+}
+
+func TestInteractivePluginFiles(t *testing.T) {
+	taskID := slugid.V4()
+	q := &client.MockQueue{}
+	sockets := q.ExpectS3Artifact(taskID, 0, "private/interactive/sockets.json")
+	plugintest.Case{
+		Payload: `{
+			"delay": 250,
+			"function": "true",
+			"argument": "whatever",
+			"interactive": {
+				"disableShell": true,
+				"disableDisplay": true
+			}
+		}`,
+		Plugin:        "interactive",
+		PluginConfig:  `{}`,
+		PluginSuccess: true,
+		EngineSuccess: true,
+		QueueMock:     q,
+		TaskID:        taskID,
+		AfterStarted: func(plugintest.Options) {
+			var s map[string]interface{}
+			json.Unmarshal(<-sockets, &s)
+			filesURL, _ := s["filesUrl"].(string)
+			if filesURL == "" {
+				panic("Expected filesUrl in sockets.json")
+			}
+
+			debug("Download missing file")
+			res, err := http.Get(filesURL + "?path=missing.txt")
+			if err != nil || res.StatusCode != http.StatusNotFound {
+				panic(fmt.Sprintf("Expected 404 for missing file, error: %s", err))
+			}
+
+			debug("Upload file")
+			req, _ := http.NewRequest(http.MethodPut, filesURL+"?path=hello.txt", strings.NewReader("Hello World"))
+			res, err = http.DefaultClient.Do(req)
+			if err != nil || res.StatusCode != http.StatusNoContent {
+				panic(fmt.Sprintf("Failed to upload file, error: %s", err))
+			}
+
+			debug("Download file")
+			res, err = http.Get(filesURL + "?path=hello.txt")
+			if err != nil || res.StatusCode != http.StatusOK {
+				panic(fmt.Sprintf("Failed to download file, error: %s", err))
+			}
+			data, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if string(data) != "Hello World" {
+				panic(fmt.Sprintf("Expected 'Hello World' got: '%s'", string(data)))
+			}
+
+			debug("Upload folder")
+			b := bytes.NewBuffer(nil)
+			tw := tar.NewWriter(b)
+			tw.WriteHeader(&tar.Header{Name: "sub/file.txt", Mode: 0644, Size: 4})
+			tw.Write([]byte("data"))
+			tw.Close()
+			req, _ = http.NewRequest(http.MethodPut, filesURL+"?path=folder&archive=tar", b)
+			res, err = http.DefaultClient.Do(req)
+			if err != nil || res.StatusCode != http.StatusNoContent {
+				panic(fmt.Sprintf("Failed to upload folder, error: %s", err))
+			}
+
+			debug("Download folder")
+			res, err = http.Get(filesURL + "?path=folder&archive=tar")
+			if err != nil || res.StatusCode != http.StatusOK {
+				panic(fmt.Sprintf("Failed to download folder, error: %s", err))
+			}
+			defer res.Body.Close()
+			tr := tar.NewReader(res.Body)
+			hdr, err := tr.Next()
+			if err != nil || hdr.Name != "sub/file.txt" {
+				panic(fmt.Sprintf("Expected sub/file.txt in tar-stream, error: %s", err))
+			}
+			data, _ = ioutil.ReadAll(tr)
+			if string(data) != "data" {
+				panic(fmt.Sprintf("Expected 'data' got: '%s'", string(data)))
+			}
+		},
+	}.Test()
+}
+
+func TestInteractivePluginAuthentication(t *testing.T) {
+	// Fake auth service granting scopes based on the clientId in the header
+	authService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req hawkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var res hawkResponse
+		switch {
+		case strings.Contains(req.Authorization, `id="good-client"`):
+			res = hawkResponse{Status: "auth-success", ClientID: "good-client", Scopes: []string{interactiveScopePrefix + "*"}}
+		case strings.Contains(req.Authorization, `id="limited-client"`):
+			res = hawkResponse{Status: "auth-success", ClientID: "limited-client", Scopes: []string{"queue:*"}}
+		default:
+			res = hawkResponse{Status: "auth-failed", Message: "bad credentials"}
+		}
+		data, _ := json.Marshal(res)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	defer authService.Close()
+
+	taskID := slugid.V4()
+	q := &client.MockQueue{}
+	sockets := q.ExpectS3Artifact(taskID, 0, "private/interactive/sockets.json")
+	plugintest.Case{
+		Payload: `{
+			"delay": 250,
+			"function": "true",
+			"argument": "whatever",
+			"interactive": {
+				"disableShell": true,
+				"disableDisplay": true,
+				"requireAuthentication": true
+			}
+		}`,
+		Plugin:        "interactive",
+		PluginConfig:  `{"authBaseUrl": "` + authService.URL + `"}`,
+		PluginSuccess: true,
+		EngineSuccess: true,
+		QueueMock:     q,
+		TaskID:        taskID,
+		ClientID:      "task-client",
+		AccessToken:   "task-secret",
+		MatchLog:      `\[interactive\] good-client downloading file: /missing-file`,
+		NotMatchLog:   `limited-client downloading`,
+		AfterStarted: func(plugintest.Options) {
+			var s map[string]interface{}
+			json.Unmarshal(<-sockets, &s)
+			filesURL, _ := s["filesUrl"].(string)
+			if filesURL == "" {
+				panic("Expected filesUrl in sockets.json")
+			}
+			u := filesURL + "?path=" + url.QueryEscape("/missing-file")
+
+			get := func(authorization string) int {
+				req, _ := http.NewRequest(http.MethodGet, u, nil)
+				if authorization != "" {
+					req.Header.Set("Authorization", authorization)
+				}
+				res, err := http.DefaultClient.Do(req)
+				if err != nil {
+					panic(fmt.Sprintf("Request failed, error: %s", err))
+				}
+				res.Body.Close()
+				return res.StatusCode
+			}
+
+			debug("Request without credentials")
+			if status := get(""); status != http.StatusUnauthorized {
+				panic(fmt.Sprintf("Expected 401 without credentials, got: %d", status))
+			}
+			debug("Request with invalid credentials")
+			if status := get(`Hawk id="bad-client"`); status != http.StatusUnauthorized {
+				panic(fmt.Sprintf("Expected 401 with invalid credentials, got: %d", status))
+			}
+			debug("Request with insufficient scopes")
+			if status := get(`Hawk id="limited-client"`); status != http.StatusForbidden {
+				panic(fmt.Sprintf("Expected 403 with insufficient scopes, got: %d", status))
+			}
+			debug("Request with sufficient scopes")
+			if status := get(`Hawk id="good-client"`); status != http.StatusNotFound {
+				panic(fmt.Sprintf("Expected 404 for missing file, got: %d", status))
+			}
+		},
+	}.Test()
+}
@@ -1,11 +1,18 @@
 package interactive
 
+import "time"
+
 type payload struct {
 	Interactive *opts `json:"interactive,omitempty"`
 }
 
 type opts struct {
-	ArtifactPrefix string `json:"artifactPrefix"`
-	DisableDisplay bool   `json:"disableDisplay"`
-	DisableShell   bool   `json:"disableShell"`
+	ArtifactPrefix string        `json:"artifactPrefix"`
+	DisableDisplay bool          `json:"disableDisplay"`
+	DisableShell   bool          `json:"disableShell"`
+	DisableForward bool          `json:"disableForward"`
+	DisableFiles   bool          `json:"disableFiles"`
+	Record         bool          `json:"record"`
+	KeepAlive      time.Duration `json:"keepAlive"`
+	RequireAuth    bool          `json:"requireAuthentication"`
 }
@@ -0,0 +1,309 @@
+package interactive
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+)
+
+// Default terminal size declared in asciicast header, shells that are resized
+// will have a resize event recorded.
+const (
+	defaultRecordingColumns = 80
+	defaultRecordingRows    = 24
+)
+
+// A recordingSet holds the recordings of interactive sessions for a task,
+// recordings are written to gzipped temporary files until they are uploaded.
+type recordingSet struct {
+	m          sync.Mutex
+	storage    runtime.TemporaryStorage
+	recordings []*recording
+	counts     map[string]int
+	closed     bool
+}
+
+func newRecordingSet(storage runtime.TemporaryStorage) *recordingSet {
+	return &recordingSet{
+		storage: storage,
+		counts:  make(map[string]int),
+	}
+}
+
+// A recording is a gzipped temporary file, writes after the recording is
+// closed are ignored.
+type recording struct {
+	m        sync.Mutex
+	name     string
+	mimetype string
+	file     runtime.TemporaryFile
+	zip      *gzip.Writer
+	started  time.Time
+	closed   bool
+	err      error
+}
+
+// newRecording creates a new recording named <kind>-<n>.<ext>, returns nil if
+// the recordingSet is closed.
+func (s *recordingSet) newRecording(kind, ext, mimetype string) (*recording, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return nil, nil
+	}
+
+	file, err := s.storage.NewFile()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary file for recording")
+	}
+	r := &recording{
+		name:     fmt.Sprintf("%s-%d.%s", kind, s.counts[kind], ext),
+		mimetype: mimetype,
+		file:     file,
+		zip:      gzip.NewWriter(file),
+		started:  time.Now(),
+	}
+	s.counts[kind]++
+	s.recordings = append(s.recordings, r)
+	return r, nil
+}
+
+// Close all recordings and prevent new recordings from being created
+func (s *recordingSet) Close() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.closed = true
+	for _, r := range s.recordings {
+		r.Close()
+	}
+}
+
+// Upload all recordings as artifacts under prefix, this must be called after
+// Close().
+func (s *recordingSet) Upload(context *runtime.TaskContext, prefix string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, r := range s.recordings {
+		if r.err != nil {
+			return errors.Wrapf(r.err, "failed to write recording: %s", r.name)
+		}
+		if _, err := r.file.Seek(0, 0); err != nil {
+			return errors.Wrap(err, "failed to seek to start of recording")
+		}
+		debug("Uploading recording: %s", r.name)
+		err := context.UploadS3Artifact(runtime.S3Artifact{
+			Name:     prefix + "recordings/" + r.name,
+			Mimetype: r.mimetype,
+			Expires:  context.TaskInfo.Expires,
+			Stream:   ioext.NopCloser(r.file),
+			AdditionalHeaders: map[string]string{
+				"Content-Encoding": "gzip",
+			},
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to upload recording: %s", r.name)
+		}
+	}
+	return nil
+}
+
+// Dispose all recordings, removing the temporary files
+func (s *recordingSet) Dispose() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.closed = true
+	for _, r := range s.recordings {
+		r.Close()
+		r.file.Close()
+	}
+	s.recordings = nil
+}
+
+// elapsed returns the time since recording started
+func (r *recording) elapsed() time.Duration {
+	return time.Since(r.started)
+}
+
+// write data to recording, must be called with lock held
+func (r *recording) write(data []byte) {
+	if r.closed || r.err != nil {
+		return
+	}
+	_, r.err = r.zip.Write(data)
+}
+
+// Close the recording, flushing the gzip stream
+func (r *recording) Close() {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.closed {
+		return
+	}
+	r.closed = true
+	if err := r.zip.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+// A shellRecording records a shell session in the asciicast v2 format, see:
+// https://github.com/asciinema/asciinema/blob/develop/doc/asciicast-v2.md
+type shellRecording struct {
+	*recording
+	pending map[string][]byte // incomplete utf-8 sequences for each stream
+}
+
+func newShellRecording(r *recording, command []string, tty bool) *shellRecording {
+	s := &shellRecording{
+		recording: r,
+		pending:   make(map[string][]byte),
+	}
+	header := map[string]interface{}{
+		"version":   2,
+		"width":     defaultRecordingColumns,
+		"height":    defaultRecordingRows,
+		"timestamp": r.started.Unix(),
+	}
+	if len(command) > 0 {
+		header["command"] = strings.Join(command, " ")
+	}
+	if !tty {
+		header["title"] = "non-tty shell"
+	}
+	data, _ := json.Marshal(header)
+	r.m.Lock()
+	r.write(append(data, '\n'))
+	r.m.Unlock()
+	return s
+}
+
+// event records an event of given type, stream identifies the stream for
+// which incomplete utf-8 sequences must be buffered.
+func (s *shellRecording) event(eventType, stream string, data []byte) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	data = append(s.pending[stream], data...)
+	data, s.pending[stream] = splitUTF8(data)
+	if len(data) == 0 {
+		return
+	}
+	line, _ := json.Marshal([]interface{}{
+		s.elapsed().Seconds(), eventType, string(data),
+	})
+	s.write(append(line, '\n'))
+}
+
+// Input returns a writer that records input events
+func (s *shellRecording) Input() io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		s.event("i", "stdin", p)
+		return len(p), nil
+	})
+}
+
+// Output returns a writer that records output events for the given stream,
+// as asciicast doesn't distinguish stdout and stderr.
+func (s *shellRecording) Output(stream string) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		s.event("o", stream, p)
+		return len(p), nil
+	})
+}
+
+// Resize records a resize event
+func (s *shellRecording) Resize(columns, rows uint16) {
+	s.event("r", "size", []byte(fmt.Sprintf("%dx%d", columns, rows)))
+}
+
+// splitUTF8 splits data into complete utf-8 sequences and a trailing
+// incomplete utf-8 sequence, if any.
+func splitUTF8(data []byte) ([]byte, []byte) {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return data[:i], append([]byte{}, data[i:]...)
+			}
+			break
+		}
+	}
+	return data, nil
+}
+
+// A displayRecording records the data sent from a VNC server in the FBS 001.000
+// format, as used by rfbproxy and supported by various VNC replay tools.
+//
+// The file starts with 'FBS 001.000\n' followed by blocks on the form:
+//   [length] [data] [padding] [timestamp]
+// where [length] is a big-endian 32 bit unsigned integer, [data] is padded to
+// a multiple of 4 bytes and [timestamp] is big-endian 32 bit unsigned integer
+// specifying the number of milliseconds since the recording started.
+type displayRecording struct {
+	*recording
+}
+
+const fbsHeader = "FBS 001.000\n"
+
+func newDisplayRecording(r *recording) *displayRecording {
+	r.m.Lock()
+	r.write([]byte(fbsHeader))
+	r.m.Unlock()
+	return &displayRecording{r}
+}
+
+func (d *displayRecording) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	block := make([]byte, 4+len(p)+(4-len(p)%4)%4+4)
+	binary.BigEndian.PutUint32(block, uint32(len(p)))
+	copy(block[4:], p)
+	ms := d.elapsed() / time.Millisecond
+	binary.BigEndian.PutUint32(block[len(block)-4:], uint32(ms))
+	d.write(block)
+	return len(p), nil
+}
+
+// A recordedDisplay wraps a display connection, recording all data read from
+// the display. The recording is closed when the display is closed.
+type recordedDisplay struct {
+	io.ReadWriteCloser
+	rec *displayRecording
+}
+
+func (d *recordedDisplay) Read(p []byte) (int, error) {
+	n, err := d.ReadWriteCloser.Read(p)
+	if n > 0 {
+		d.rec.Write(p[:n])
+	}
+	return n, err
+}
+
+func (d *recordedDisplay) Close() error {
+	d.rec.Close()
+	return d.ReadWriteCloser.Close()
+}
+
+// writerFunc implements io.Writer using a function
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,109 @@
+package interactive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+)
+
+func readRecording(t *testing.T, r *recording) []byte {
+	_, err := r.file.Seek(0, 0)
+	require.NoError(t, err)
+	zr, err := gzip.NewReader(r.file)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(zr)
+	require.NoError(t, err)
+	return data
+}
+
+func TestSplitUTF8(t *testing.T) {
+	data := []byte("hello æ")
+	complete, rest := splitUTF8(data[:len(data)-1])
+	assert.Equal(t, "hello ", string(complete))
+	assert.Equal(t, data[len(data)-2:len(data)-1], rest)
+
+	complete, rest = splitUTF8(data)
+	assert.Equal(t, "hello æ", string(complete))
+	assert.Nil(t, rest)
+}
+
+func TestShellRecording(t *testing.T) {
+	storage := runtime.NewTemporaryTestFolderOrPanic()
+	defer storage.Remove()
+
+	set := newRecordingSet(storage)
+	defer set.Dispose()
+	r, err := set.newRecording("shell", "cast", "application/x-asciicast")
+	require.NoError(t, err)
+	assert.Equal(t, "shell-0.cast", r.name)
+
+	rec := newShellRecording(r, []string{"bash"}, true)
+	rec.Input().Write([]byte("ls\n"))
+	out := []byte("æøå")
+	rec.Output("stdout").Write(out[:3])
+	rec.Output("stdout").Write(out[3:])
+	rec.Resize(120, 40)
+	set.Close()
+	rec.Output("stdout").Write([]byte("ignored after close"))
+
+	scanner := bufio.NewScanner(bytes.NewReader(readRecording(t, r)))
+	require.True(t, scanner.Scan())
+	var header map[string]interface{}
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &header))
+	assert.Equal(t, float64(2), header["version"])
+	assert.Equal(t, "bash", header["command"])
+
+	var events [][]interface{}
+	for scanner.Scan() {
+		var e []interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		events = append(events, e)
+	}
+	require.Len(t, events, 4)
+	assert.Equal(t, []interface{}{"i", "ls\n"}, events[0][1:])
+	assert.Equal(t, []interface{}{"o", "æ"}, events[1][1:])
+	assert.Equal(t, []interface{}{"o", "øå"}, events[2][1:])
+	assert.Equal(t, []interface{}{"r", "120x40"}, events[3][1:])
+}
+
+func TestDisplayRecording(t *testing.T) {
+	storage := runtime.NewTemporaryTestFolderOrPanic()
+	defer storage.Remove()
+
+	set := newRecordingSet(storage)
+	defer set.Dispose()
+	r, err := set.newRecording("display", "fbs", "application/octet-stream")
+	require.NoError(t, err)
+
+	rec := newDisplayRecording(r)
+	rec.Write([]byte("RFB 003.008\n"))
+	rec.Write([]byte("abcde"))
+	set.Close()
+
+	data := readRecording(t, r)
+	require.Equal(t, fbsHeader, string(data[:len(fbsHeader)]))
+	data = data[len(fbsHeader):]
+
+	// First block is 12 bytes, no padding
+	require.Equal(t, uint32(12), binary.BigEndian.Uint32(data))
+	assert.Equal(t, "RFB 003.008\n", string(data[4:16]))
+	data = data[4+12+4:]
+
+	// Second block is 5 bytes, padded to 8 bytes
+	require.Equal(t, uint32(5), binary.BigEndian.Uint32(data))
+	assert.Equal(t, "abcde", string(data[4:9]))
+	assert.Len(t, data, 4+8+4)
+
+	// New recordings can't be created after close
+	r, err = set.newRecording("display", "fbs", "application/octet-stream")
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+}
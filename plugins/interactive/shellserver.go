@@ -27,6 +27,8 @@ type ShellServer struct {
 	refCount      int
 	instanceCount int
 	monitor       runtime.Monitor
+	recordings    *recordingSet // nil, if sessions aren't recorded
+	audit         *auditLog     // nil, if sessions aren't audited
 }
 
 // NewShellServer returns a new ShellServer which creates shells using the
@@ -77,6 +79,35 @@ func (s *ShellServer) Abort() {
 	default:
 		close(s.done)
 	}
+	s.c.Broadcast()
+}
+
+// setShellFactory sets the function used to create new shells and returns the
+// number of shells created so far, this is used to create shells from the
+// ResultSet after the task command has exited.
+func (s *ShellServer) setShellFactory(makeShell ShellFactory) int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.makeShell = makeShell
+	return s.instanceCount
+}
+
+// waitForIdle blocks until more than count shells have been created and all
+// shells have terminated, or the ShellServer is aborted.
+func (s *ShellServer) waitForIdle(count int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		if s.instanceCount > count && s.refCount == 0 {
+			return
+		}
+		s.c.Wait()
+	}
 }
 
 var upgrader = websocket.Upgrader{
@@ -102,12 +133,20 @@ func (s *ShellServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	tty := strings.ToLower(qs.Get("tty")) == "true"
 
 	// Create a new shell, do this before we upgrade so we can return 410 on error
-	shell, err := s.makeShell(command, tty)
+	s.m.Lock()
+	makeShell := s.makeShell
+	s.m.Unlock()
+	shell, err := makeShell(command, tty)
 	if err == engines.ErrSandboxTerminated || err == engines.ErrSandboxAborted {
 		setCORS(w)
 		w.WriteHeader(http.StatusGone)
 		return
 	}
+	if err == engines.ErrFeatureNotSupported {
+		setCORS(w)
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
 	if err != nil {
 		setCORS(w)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -123,7 +162,15 @@ func (s *ShellServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	go s.handleShell(ws, shell)
+	if len(command) > 0 {
+		s.audit.Log(r, fmt.Sprintf("opened shell with command: %s", strings.Join(command, " ")))
+	} else {
+		s.audit.Log(r, "opened shell")
+	}
+	go func() {
+		s.handleShell(ws, shell, command, tty)
+		s.audit.Log(r, "closed shell")
+	}()
 }
 
 func copyCloseDone(w io.WriteCloser, r io.Reader, wg *sync.WaitGroup) {
@@ -131,22 +178,37 @@ func copyCloseDone(w io.WriteCloser, r io.Reader, wg *sync.WaitGroup) {
 	wg.Done()
 }
 
-func (s *ShellServer) handleShell(ws *websocket.Conn, shell engines.Shell) {
+func (s *ShellServer) handleShell(ws *websocket.Conn, shell engines.Shell, command []string, tty bool) {
 	done := make(chan struct{})
 
 	// Create a shell handler
 	s.updateRefCount(1)
 	handler := NewShellHandler(ws, s.monitor.WithTag("shell-instance-id", fmt.Sprintf("%d", s.nextID())))
 
+	var stdin, stdout, stderr io.Reader = handler.StdinPipe(), shell.StdoutPipe(), shell.StderrPipe()
+	setSize := SetSizeFunc(shell.SetSize)
+
+	// Record the session, if recording is enabled
+	rec := s.newRecording(command, tty)
+	if rec != nil {
+		stdin = io.TeeReader(stdin, rec.Input())
+		stdout = io.TeeReader(stdout, rec.Output("stdout"))
+		stderr = io.TeeReader(stderr, rec.Output("stderr"))
+		setSize = func(columns, rows uint16) error {
+			rec.Resize(columns, rows)
+			return shell.SetSize(columns, rows)
+		}
+	}
+
 	// Connect pipes
 	wg := sync.WaitGroup{}
 	wg.Add(2)
-	go ioext.CopyAndClose(shell.StdinPipe(), handler.StdinPipe())
-	go copyCloseDone(handler.StdoutPipe(), shell.StdoutPipe(), &wg)
-	go copyCloseDone(handler.StderrPipe(), shell.StderrPipe(), &wg)
+	go ioext.CopyAndClose(shell.StdinPipe(), stdin)
+	go copyCloseDone(handler.StdoutPipe(), stdout, &wg)
+	go copyCloseDone(handler.StderrPipe(), stderr, &wg)
 
 	// Start streaming
-	handler.Communicate(shell.SetSize, shell.Abort)
+	handler.Communicate(setSize, shell.Abort)
 
 	// Wait for call to abort all shells
 	go func() {
@@ -161,12 +223,30 @@ func (s *ShellServer) handleShell(ws *websocket.Conn, shell engines.Shell) {
 	success, _ := shell.Wait()
 	wg.Wait() // Wait for pipes to be copied before terminating
 	handler.Terminated(success)
+	if rec != nil {
+		rec.Close()
+	}
 	s.updateRefCount(-1)
 
 	// Close done so we stop waiting for abort on all shells
 	close(done)
 }
 
+// newRecording returns a new shellRecording, or nil if sessions aren't recorded
+func (s *ShellServer) newRecording(command []string, tty bool) *shellRecording {
+	if s.recordings == nil {
+		return nil
+	}
+	r, err := s.recordings.newRecording("shell", "cast", "application/x-asciicast")
+	if err != nil {
+		s.monitor.ReportError(err, "Failed to create shell recording")
+	}
+	if r == nil {
+		return nil
+	}
+	return newShellRecording(r, command, tty)
+}
+
 func (s *ShellServer) updateRefCount(change int) {
 	s.m.Lock()
 	s.refCount += change
@@ -0,0 +1,25 @@
+package livelog
+
+import (
+	schematypes "github.com/taskcluster/go-schematypes"
+	"github.com/taskcluster/taskcluster-worker/runtime/util"
+)
+
+type config struct {
+	UploadStreams bool `json:"uploadStreams"`
+}
+
+var configSchema = schematypes.Object{
+	Properties: schematypes.Properties{
+		"uploadStreams": schematypes.Boolean{
+			Title: "Upload stdout and stderr",
+			Description: util.Markdown(`
+				Upload stdout and stderr from the task as 'public/logs/stdout.log' and
+				'public/logs/stderr.log', in addition to the task log.
+
+				The 'stderr.log' artifact is only uploaded if the engine delivers
+				stderr separately, otherwise stderr is included in 'stdout.log'.
+			`),
+		},
+	},
+}
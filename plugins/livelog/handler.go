@@ -0,0 +1,269 @@
+package livelog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+)
+
+// heartbeatInterval is the interval between heartbeat messages when serving
+// the log as server-sent events, this keeps proxies from closing idle
+// connections.
+var heartbeatInterval = 15 * time.Second
+
+// logHandler serves the task log as it is written.
+//
+// By default the log is streamed as plain text, HTTP Range requests can be used
+// to resume from an offset. If the client accepts 'text/event-stream' the log
+// is served as server-sent events, one event per line with the offset of the
+// next line as event id, so clients can resume using the 'Last-Event-ID'
+// header. The 'tail' querystring parameter can be used to start from the last
+// N lines, it is ignored when resuming from an offset.
+type logHandler struct {
+	context *runtime.TaskContext
+}
+
+func (h *logHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Range, Last-Event-ID")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Streaming, Content-Range, Accept-Ranges")
+	w.Header().Set("Accept-Ranges", "bytes")
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Get an HTTP flusher if supported in the current context, or wrap in
+	// a NopFlusher, if flushing isn't available.
+	wf, ok := w.(ioext.WriteFlusher)
+	if ok {
+		w.Header().Set("X-Streaming", "true") // Allow clients to detect that we're streaming
+	} else {
+		wf = ioext.NopFlusher(w)
+	}
+
+	// Find the offset to start from, resuming takes precedence over tail
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	var start, end int64 = 0, -1
+	resume := false
+	if sse && r.Header.Get("Last-Event-ID") != "" {
+		offset, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+		if err != nil || offset < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid Last-Event-ID header"))
+			return
+		}
+		start, resume = offset, true
+	} else if !sse && r.Header.Get("Range") != "" {
+		start, end, resume = parseRange(r.Header.Get("Range"))
+	}
+	if !resume && r.URL.Query().Get("tail") != "" {
+		n, err := strconv.Atoi(r.URL.Query().Get("tail"))
+		if err != nil || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid tail parameter, must be a non-negative integer"))
+			return
+		}
+		start, err = tailOffset(h.context, n)
+		if err != nil {
+			debug("Failed to find offset for tail=%d, error: %s", n, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Error opening up live log"))
+			return
+		}
+	}
+
+	logReader, err := h.context.NewLogReader()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error opening up live log"))
+		return
+	}
+	defer logReader.Close()
+
+	// Skip to the start offset, this blocks until the offset has been written
+	if _, err = io.CopyN(ioutil.Discard, logReader, start); err != nil {
+		size, _ := h.context.LogSize()
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		serveEvents(wf, r, logReader, start)
+		return
+	}
+
+	var body io.Reader = logReader
+	if end >= 0 {
+		body = io.LimitReader(logReader, end-start+1)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if resume {
+		// The total size isn't known while the log is being written
+		if end >= 0 {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", start, end))
+		} else {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-/*", start))
+		}
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	ioext.CopyAndFlush(wf, body, 100*time.Millisecond)
+}
+
+// parseRange parses a Range header with a single byte range on the form
+// 'bytes=start-' or 'bytes=start-end', returns false if header can't be parsed
+// in which case the header should be ignored.
+func parseRange(header string) (start, end int64, ok bool) {
+	if !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		return 0, -1, false
+	}
+	parts := strings.SplitN(strings.TrimSpace(header[len("bytes="):]), "-", 2)
+	if len(parts) != 2 {
+		return 0, -1, false
+	}
+	start, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || start < 0 {
+		return 0, -1, false
+	}
+	if parts[1] == "" {
+		return start, -1, true
+	}
+	end, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || end < start {
+		return 0, -1, false
+	}
+	return start, end, true
+}
+
+// tailOffset returns the offset of the last n lines written to the log
+func tailOffset(context *runtime.TaskContext, n int) (int64, error) {
+	size, err := context.LogSize()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return size, nil
+	}
+
+	logReader, err := context.NewLogReader()
+	if err != nil {
+		return 0, err
+	}
+	defer logReader.Close()
+
+	// Keep the offsets of the last n line starts
+	starts := []int64{0}
+	br := bufio.NewReader(io.LimitReader(logReader, size))
+	var offset int64
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return starts[0], nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		offset++
+		if b == '\n' && offset < size {
+			starts = append(starts, offset)
+			if len(starts) > n {
+				starts = starts[1:]
+			}
+		}
+	}
+}
+
+// eventLineBreaks matches the line breaks of the server-sent events format
+var eventLineBreaks = regexp.MustCompile(`\r\n|\r|\n`)
+
+// eventData returns the data field lines for an event with line as payload,
+// any line breaks within the line, such as carriage returns from progress bars,
+// must be split into multiple data fields.
+func eventData(line string) string {
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	data := ""
+	for _, piece := range eventLineBreaks.Split(line, -1) {
+		data += "data: " + piece + "\n"
+	}
+	return data
+}
+
+// serveEvents writes the log as server-sent events, one event for each line
+// with the offset after the line as id. Comments are sent as heartbeats when no
+// lines are written, and an 'end' event is sent when the log is closed.
+//
+// Response headers must have been written before serveEvents is called.
+func serveEvents(wf ioext.WriteFlusher, r *http.Request, logReader io.Reader, offset int64) {
+	wf.Flush()
+
+	// Read lines in a goroutine, so we can send heartbeats while waiting
+	done := make(chan struct{})
+	defer close(done)
+	lines := make(chan string, 64)
+	go func() {
+		defer close(lines)
+		br := bufio.NewReader(logReader)
+		for {
+			line, err := br.ReadString('\n')
+			if line != "" {
+				select {
+				case lines <- line:
+				case <-done:
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				fmt.Fprint(wf, "event: end\ndata: \n\n")
+				wf.Flush()
+				return
+			}
+			offset += int64(len(line))
+			_, err = fmt.Fprintf(wf, "id: %d\n%s\n", offset, eventData(line))
+			// Only flush when we've caught up, to avoid flushing for every line
+			if err == nil && len(lines) == 0 {
+				wf.Flush()
+			}
+		case <-ticker.C:
+			_, err = fmt.Fprint(wf, ": heartbeat\n\n")
+			wf.Flush()
+		case <-r.Context().Done():
+			return
+		}
+		if err != nil {
+			debug("Failed to write server-sent event, error: %s", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package livelog
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/taskcluster/slugid-go/slugid"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+)
+
+func TestParseRange(t *testing.T) {
+	start, end, ok := parseRange("bytes=10-")
+	assert.True(t, ok)
+	assert.Equal(t, int64(10), start)
+	assert.Equal(t, int64(-1), end)
+
+	start, end, ok = parseRange("bytes=10-19")
+	assert.True(t, ok)
+	assert.Equal(t, int64(10), start)
+	assert.Equal(t, int64(19), end)
+
+	for _, header := range []string{"bytes=-10", "bytes=10-5", "bytes=0-1,5-6", "lines=1-", "bytes=a-"} {
+		_, _, ok = parseRange(header)
+		assert.False(t, ok, "Expected '%s' to be ignored", header)
+	}
+}
+
+func TestEventData(t *testing.T) {
+	assert.Equal(t, "data: hello\n", eventData("hello\n"))
+	assert.Equal(t, "data: hello\n", eventData("hello\r\n"))
+	assert.Equal(t, "data: hello\n", eventData("hello"))
+	assert.Equal(t, "data: 10%\ndata: 20%\ndata: done\n", eventData("10%\r20%\r\ndone\n"))
+	assert.Equal(t, "data: \n", eventData("\n"))
+}
+
+func TestLogHandler(t *testing.T) {
+	path := filepath.Join(os.TempDir(), slugid.Nice())
+	context, control, err := runtime.NewTaskContext(path, runtime.TaskInfo{})
+	require.NoError(t, err)
+	defer control.Dispose()
+
+	log := "line 1\nline 2\nline 3\n"
+	context.LogDrain().Write([]byte(log))
+	require.NoError(t, control.CloseLog())
+
+	s := httptest.NewServer(&logHandler{context: context})
+	defer s.Close()
+
+	get := func(query string, header http.Header) (*http.Response, string) {
+		req, rerr := http.NewRequest(http.MethodGet, s.URL+query, nil)
+		require.NoError(t, rerr)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		res, rerr := http.DefaultClient.Do(req)
+		require.NoError(t, rerr)
+		defer res.Body.Close()
+		data, rerr := ioutil.ReadAll(res.Body)
+		require.NoError(t, rerr)
+		return res, string(data)
+	}
+
+	t.Run("full", func(t *testing.T) {
+		res, body := get("", nil)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, log, body)
+	})
+
+	t.Run("range", func(t *testing.T) {
+		res, body := get("", http.Header{"Range": {"bytes=7-"}})
+		assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+		assert.Equal(t, "bytes 7-/*", res.Header.Get("Content-Range"))
+		assert.Equal(t, log[7:], body)
+
+		res, body = get("", http.Header{"Range": {"bytes=7-12"}})
+		assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+		assert.Equal(t, "line 2", body)
+
+		res, _ = get("", http.Header{"Range": {"bytes=1000-"}})
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, res.StatusCode)
+	})
+
+	t.Run("tail", func(t *testing.T) {
+		_, body := get("?tail=2", nil)
+		assert.Equal(t, "line 2\nline 3\n", body)
+		_, body = get("?tail=10", nil)
+		assert.Equal(t, log, body)
+		_, body = get("?tail=0", nil)
+		assert.Equal(t, "", body)
+		res, _ := get("?tail=-1", nil)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+
+	t.Run("events", func(t *testing.T) {
+		res, body := get("?tail=2", http.Header{"Accept": {"text/event-stream"}})
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+		assert.Equal(t, "id: 14\ndata: line 2\n\nid: 21\ndata: line 3\n\nevent: end\ndata: \n\n", body)
+
+		// Resume from the id of the first event, this ignores tail
+		res, body = get("?tail=3", http.Header{
+			"Accept":        {"text/event-stream"},
+			"Last-Event-Id": {"14"},
+		})
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		r := bufio.NewReader(strings.NewReader(body))
+		line, _ := r.ReadString('\n')
+		assert.Equal(t, "id: 21\n", line)
+	})
+}
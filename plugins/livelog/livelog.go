@@ -6,12 +6,11 @@ import (
 	"compress/gzip"
 	"fmt"
 	"io"
-	"net/http"
 	"sync"
-	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/pkg/errors"
+	schematypes "github.com/taskcluster/go-schematypes"
 	tcclient "github.com/taskcluster/taskcluster-client-go"
 	"github.com/taskcluster/taskcluster-worker/plugins"
 	"github.com/taskcluster/taskcluster-worker/runtime"
@@ -25,13 +24,16 @@ type pluginProvider struct {
 
 type plugin struct {
 	plugins.PluginBase
-	monitor     runtime.Monitor
-	environment *runtime.Environment
+	monitor        runtime.Monitor
+	environment    *runtime.Environment
+	uploadStreams  bool
+	separateStderr bool
 }
 
 type taskPlugin struct {
 	plugins.TaskPluginBase
 	context     *runtime.TaskContext
+	logger      *runtime.TaskLogger
 	url         string
 	detach      func()
 	log         *logrus.Entry
@@ -41,22 +43,41 @@ type taskPlugin struct {
 	uploaded    atomics.Once
 	setupDone   sync.WaitGroup
 	setupErr    error
+	plugin      plugin
+}
+
+func (pluginProvider) ConfigSchema() schematypes.Schema {
+	return configSchema
 }
 
 func (pluginProvider) NewPlugin(options plugins.PluginOptions) (plugins.Plugin, error) {
+	var c config
+	if options.Config != nil {
+		schematypes.MustValidateAndMap(configSchema, options.Config, &c)
+	}
 	debug("Created livelog plugin")
 	return plugin{
-		monitor:     options.Monitor,
-		environment: options.Environment,
+		monitor:        options.Monitor,
+		environment:    options.Environment,
+		uploadStreams:  c.UploadStreams,
+		separateStderr: options.Engine.Capabilities().SeparateStderr,
 	}, nil
 }
 
 func (p plugin) NewTaskPlugin(options plugins.TaskPluginOptions) (plugins.TaskPlugin, error) {
 	debug("Creating taskPlugin")
+	if p.uploadStreams {
+		if err := options.TaskContext.CaptureLogStreams(); err != nil {
+			options.Monitor.Error(errors.Wrap(err, "failed to create stdout/stderr logs"))
+			return nil, runtime.ErrNonFatalInternalError
+		}
+	}
 	tp := &taskPlugin{
 		context:     options.TaskContext,
+		logger:      options.Logger,
 		monitor:     options.Monitor,
 		environment: p.environment,
+		plugin:      p,
 	}
 	tp.setupDone.Add(1)
 	go tp.setup()
@@ -71,41 +92,7 @@ func (tp *taskPlugin) setup() {
 		return
 	}
 
-	tp.url, tp.detach = tp.environment.WebHookServer.AttachHook(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet && r.Method != http.MethodHead {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
-		}
-		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Expose-Headers", "X-Streaming")
-		if r.Method == http.MethodHead {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-
-		// Get an HTTP flusher if supported in the current context, or wrap in
-		// a NopFlusher, if flushing isn't available.
-		wf, ok := w.(ioext.WriteFlusher)
-		if ok {
-			w.Header().Set("X-Streaming", "true") // Allow clients to detect that we're streaming
-		} else {
-			wf = ioext.NopFlusher(w)
-		}
-
-		// TODO (garndt): add support for range headers.  Might not be used at all currently
-		logReader, err := tp.context.NewLogReader()
-		if err != nil {
-			w.WriteHeader(500)
-			w.Write([]byte("Error opening up live log"))
-			return
-		}
-		defer logReader.Close()
-
-		w.WriteHeader(http.StatusOK)
-		ioext.CopyAndFlush(wf, logReader, 100*time.Millisecond)
-	}))
+	tp.url, tp.detach = tp.environment.WebHookServer.AttachHook(&logHandler{context: tp.context})
 
 	err := tp.context.CreateRedirectArtifact(runtime.RedirectArtifact{
 		Name:     "public/logs/live.log",
@@ -115,7 +102,7 @@ func (tp *taskPlugin) setup() {
 	})
 	if err != nil {
 		incidentID := tp.monitor.ReportError(err, "Failed to setup live logging")
-		tp.context.LogError("Failed to setup livelogging: ", incidentID)
+		tp.logger.LogError("Failed to setup livelogging: ", incidentID)
 		// This isn't good, but let's not consider it fatal...
 		tp.setupErr = runtime.ErrNonFatalInternalError
 	}
@@ -161,7 +148,37 @@ func (tp *taskPlugin) uploadLog() error {
 		tp.detach = nil
 	}
 
-	file, err := tp.context.ExtractLog()
+	err := tp.uploadGzipped("public/logs/live_backing.log", tp.context.ExtractLog)
+	if err != nil {
+		return err
+	}
+
+	backingURL := fmt.Sprintf("https://queue.taskcluster.net/v1/task/%s/runs/%d/artifacts/public/logs/live_backing.log", tp.context.TaskInfo.TaskID, tp.context.TaskInfo.RunID)
+	err = tp.context.CreateRedirectArtifact(runtime.RedirectArtifact{
+		Name:     "public/logs/live.log",
+		Mimetype: "text/plain; charset=utf-8",
+		URL:      backingURL,
+		Expires:  tp.context.TaskInfo.Expires,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed to update live.log")
+		tp.monitor.Error(err)
+		return runtime.ErrNonFatalInternalError // Upload error isn't fatal
+	}
+
+	// Upload stdout and stderr, if requested
+	if tp.plugin.uploadStreams {
+		err = tp.uploadGzipped("public/logs/stdout.log", tp.context.ExtractStdoutLog)
+		if err == nil && tp.plugin.separateStderr {
+			err = tp.uploadGzipped("public/logs/stderr.log", tp.context.ExtractStderrLog)
+		}
+	}
+	return err
+}
+
+// uploadGzipped uploads the log returned by extract as gzipped S3 artifact
+func (tp *taskPlugin) uploadGzipped(name string, extract func() (ioext.ReadSeekCloser, error)) error {
+	file, err := extract()
 	if err != nil {
 		return err
 	}
@@ -188,9 +205,9 @@ func (tp *taskPlugin) uploadLog() error {
 		return err
 	}
 
-	debug("Uploading live_backing.log")
+	debug("Uploading %s", name)
 	err = tp.context.UploadS3Artifact(runtime.S3Artifact{
-		Name:     "public/logs/live_backing.log",
+		Name:     name,
 		Mimetype: "text/plain; charset=utf-8",
 		Expires:  tp.context.TaskInfo.Expires,
 		Stream:   tempFile,
@@ -199,24 +216,10 @@ func (tp *taskPlugin) uploadLog() error {
 		},
 	})
 	if err != nil {
-		err = errors.Wrap(err, "failed to upload live_backing.log")
+		err = errors.Wrapf(err, "failed to upload %s", name)
 		tp.monitor.Error(err)
 		return err // Upload error isn't fatal
 	}
-
-	backingURL := fmt.Sprintf("https://queue.taskcluster.net/v1/task/%s/runs/%d/artifacts/public/logs/live_backing.log", tp.context.TaskInfo.TaskID, tp.context.TaskInfo.RunID)
-	err = tp.context.CreateRedirectArtifact(runtime.RedirectArtifact{
-		Name:     "public/logs/live.log",
-		Mimetype: "text/plain; charset=utf-8",
-		URL:      backingURL,
-		Expires:  tp.context.TaskInfo.Expires,
-	})
-	if err != nil {
-		err = errors.Wrap(err, "failed to update live.log")
-		tp.monitor.Error(err)
-		return runtime.ErrNonFatalInternalError // Upload error isn't fatal
-	}
-
 	return nil
 }
 
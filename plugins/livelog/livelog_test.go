@@ -97,3 +97,35 @@ func TestLiveLogStreaming(t *testing.T) {
 		},
 	}.Test()
 }
+
+func TestLiveLogUploadStreams(t *testing.T) {
+	taskID := slugid.V4()
+
+	// Create a mock queue
+	q := &client.MockQueue{}
+	q.ExpectRedirectArtifact(taskID, 0, "public/logs/live.log")
+	backing := q.ExpectS3Artifact(taskID, 0, "public/logs/live_backing.log")
+	stdout := q.ExpectS3Artifact(taskID, 0, "public/logs/stdout.log")
+	stderr := q.ExpectS3Artifact(taskID, 0, "public/logs/stderr.log")
+
+	plugintest.Case{
+		Payload: `{
+			"delay": 0,
+			"function": "write-stderr",
+			"argument": "[hello-stderr]"
+		}`,
+		Plugin:        "livelog",
+		PluginConfig:  `{"uploadStreams": true}`,
+		TestStruct:    t,
+		PluginSuccess: true,
+		EngineSuccess: true,
+		MatchLog:      "[hello-stderr]",
+		TaskID:        taskID,
+		QueueMock:     q,
+		AfterFinished: func(plugintest.Options) {
+			assert.Contains(t, string(<-backing), "[hello-stderr]")
+			assert.NotContains(t, string(<-stdout), "[hello-stderr]")
+			assert.Equal(t, "[hello-stderr]\n", string(<-stderr))
+		},
+	}.Test()
+}
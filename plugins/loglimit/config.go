@@ -0,0 +1,64 @@
+package loglimit
+
+import (
+	"math"
+
+	schematypes "github.com/taskcluster/go-schematypes"
+	"github.com/taskcluster/taskcluster-worker/runtime/util"
+)
+
+type config struct {
+	MaxLogSize     int64 `json:"maxLogSize"`
+	TailSize       int64 `json:"tailSize"`
+	KillOnExceeded bool  `json:"killOnExceeded"`
+}
+
+// defaultTailSize is the number of bytes kept from the end of the log, if not
+// configured, it is reduced to half of 'maxLogSize' for very small limits.
+const defaultTailSize = 64 * 1024
+
+var configSchema = schematypes.Object{
+	Title: "Log Limit Plugin",
+	Description: util.Markdown(`
+		The 'loglimit' plugin limits the size of the task log, this prevents
+		runaway tasks from filling the disk with log output.
+
+		When the task log exceeds 'maxLogSize' the first part of the log is
+		kept, and the last 'tailSize' bytes of the log is kept, everything in
+		between is replaced with a marker stating how many bytes were omitted.
+
+		If 'killOnExceeded' is 'true' the task is killed when the limit is
+		exceeded, and the task will be resolved as failed.
+	`),
+	Properties: schematypes.Properties{
+		"maxLogSize": schematypes.Integer{
+			Title: "Maximum Log Size",
+			Description: util.Markdown(`
+				Maximum size of the task log in bytes, not including the marker
+				inserted when the log is truncated.
+			`),
+			Minimum: 1,
+			Maximum: math.MaxInt64,
+		},
+		"tailSize": schematypes.Integer{
+			Title: "Tail Size",
+			Description: util.Markdown(`
+				Number of bytes to keep from the end of the log when the log is
+				truncated, must not exceed 'maxLogSize'. The tail is held in memory
+				until the task is finished.
+
+				Defaults to 64 KiB, or half of 'maxLogSize' if that is smaller.
+			`),
+			Minimum: 0,
+			Maximum: math.MaxInt32,
+		},
+		"killOnExceeded": schematypes.Boolean{
+			Title: "Kill on Exceeded",
+			Description: util.Markdown(`
+				Kill the task when the log exceeds 'maxLogSize', causing the task to
+				be resolved as failed. Defaults to 'false'.
+			`),
+		},
+	},
+	Required: []string{"maxLogSize"},
+}
@@ -0,0 +1,9 @@
+// Package loglimit provides a taskcluster-worker plugin that limits the size of
+// the task log. When the limit is exceeded the log is truncated, keeping the
+// head and the tail of the log, and optionally the task is killed causing it to
+// be resolved as failed.
+package loglimit
+
+import "github.com/taskcluster/taskcluster-worker/runtime/util"
+
+var debug = util.Debug("loglimit")
@@ -0,0 +1,114 @@
+package loglimit
+
+import (
+	"fmt"
+
+	schematypes "github.com/taskcluster/go-schematypes"
+	"github.com/taskcluster/taskcluster-worker/engines"
+	"github.com/taskcluster/taskcluster-worker/plugins"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/atomics"
+)
+
+type provider struct {
+	plugins.PluginProviderBase
+}
+
+type plugin struct {
+	plugins.PluginBase
+	config
+}
+
+type taskPlugin struct {
+	plugins.TaskPluginBase
+	maxLogSize     int64
+	killOnExceeded bool
+	exceeded       <-chan struct{}
+	monitor        runtime.Monitor
+	context        *runtime.TaskContext
+	logger         *runtime.TaskLogger
+	stopped        atomics.Once
+	killed         atomics.Bool
+}
+
+func init() {
+	plugins.Register("loglimit", provider{})
+}
+
+func (provider) ConfigSchema() schematypes.Schema {
+	return configSchema
+}
+
+func (provider) NewPlugin(options plugins.PluginOptions) (plugins.Plugin, error) {
+	var c config
+	schematypes.MustValidateAndMap(configSchema, options.Config, &c)
+
+	// Default tailSize, if not configured
+	if m, ok := options.Config.(map[string]interface{}); ok && m["tailSize"] == nil {
+		c.TailSize = defaultTailSize
+		if c.TailSize > c.MaxLogSize/2 {
+			c.TailSize = c.MaxLogSize / 2
+		}
+	}
+	if c.TailSize > c.MaxLogSize {
+		return nil, fmt.Errorf(
+			"loglimit config 'tailSize': %d may not exceed 'maxLogSize': %d",
+			c.TailSize, c.MaxLogSize,
+		)
+	}
+
+	debug("Created loglimit plugin with maxLogSize: %d and tailSize: %d", c.MaxLogSize, c.TailSize)
+	return &plugin{
+		config: c,
+	}, nil
+}
+
+func (p *plugin) NewTaskPlugin(options plugins.TaskPluginOptions) (plugins.TaskPlugin, error) {
+	return &taskPlugin{
+		maxLogSize:     p.MaxLogSize,
+		killOnExceeded: p.KillOnExceeded,
+		exceeded:       options.TaskContext.LimitLog(p.MaxLogSize, int(p.TailSize)),
+		monitor:        options.Monitor,
+		context:        options.TaskContext,
+		logger:         options.Logger,
+	}, nil
+}
+
+func (p *taskPlugin) Started(sandbox engines.Sandbox) error {
+	if !p.killOnExceeded {
+		return nil
+	}
+	go func() {
+		select {
+		case <-p.exceeded:
+			// when the log size is exceeded we kill the task
+			p.killed.Set(true)
+			p.monitor.Info("Killing task due to maxLogSize exceeded")
+			p.logger.LogError(fmt.Sprintf(
+				"Task killed because the task log exceeded %d bytes", p.maxLogSize,
+			))
+			sandbox.Kill()
+		case <-p.context.Done():
+			// when task context is canceled, then we need not kill anything
+		case <-p.stopped.Done():
+			// when task has stopped, we need not kill anything
+		}
+	}()
+	return nil
+}
+
+func (p *taskPlugin) Stopped(engines.ResultSet) (bool, error) {
+	p.stopped.Do(nil)
+	// If we've killed the task, then we want to force a negative resolution
+	return !p.killed.Get(), nil
+}
+
+func (p *taskPlugin) Exception(runtime.ExceptionReason) error {
+	p.stopped.Do(nil)
+	return nil
+}
+
+func (p *taskPlugin) Dispose() error {
+	p.stopped.Do(nil)
+	return nil
+}
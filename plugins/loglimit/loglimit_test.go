@@ -0,0 +1,65 @@
+package loglimit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/taskcluster/taskcluster-worker/plugins/plugintest"
+)
+
+var longLine = strings.Repeat("a", 1024)
+
+func TestLogLimitNotExceeded(t *testing.T) {
+	plugintest.Case{
+		Payload: `{
+			"delay": 0,
+			"function": "write-stdout",
+			"argument": "Hello World"
+		}`,
+		Plugin: "loglimit",
+		PluginConfig: `{
+			"maxLogSize": 65536
+		}`,
+		PluginSuccess: true,
+		EngineSuccess: true,
+		MatchLog:      "Hello World",
+		NotMatchLog:   "Log exceeded",
+	}.Test()
+}
+
+func TestLogLimitTruncated(t *testing.T) {
+	plugintest.Case{
+		Payload: `{
+			"delay": 0,
+			"function": "write-stdout",
+			"argument": "` + longLine + `"
+		}`,
+		Plugin: "loglimit",
+		PluginConfig: `{
+			"maxLogSize": 256,
+			"tailSize": 64
+		}`,
+		PluginSuccess: true,
+		EngineSuccess: true,
+		MatchLog:      "Log exceeded 256 bytes, [0-9]+ bytes omitted here",
+	}.Test()
+}
+
+func TestLogLimitKillOnExceeded(t *testing.T) {
+	plugintest.Case{
+		Payload: `{
+			"delay": 0,
+			"function": "write-stdout-sleep",
+			"argument": "` + longLine + `"
+		}`,
+		Plugin: "loglimit",
+		PluginConfig: `{
+			"maxLogSize": 256,
+			"tailSize": 200,
+			"killOnExceeded": true
+		}`,
+		PluginSuccess: false,
+		EngineSuccess: false,
+		MatchLog:      "Task killed because the task log exceeded 256 bytes",
+	}.Test()
+}
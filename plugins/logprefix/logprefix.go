@@ -99,7 +99,7 @@ func (p *plugin) NewTaskPlugin(options plugins.TaskPluginOptions) (plugins.TaskP
 			v = keys[k]
 		}
 
-		options.TaskContext.Log(fmt.Sprintf("%s: %s", k, v))
+		options.Logger.Log(fmt.Sprintf("%s: %s", k, v))
 	}
 
 	// Return a plugin that does nothing
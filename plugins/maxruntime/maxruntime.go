@@ -25,6 +25,7 @@ type taskPlugin struct {
 	maxRunTime time.Duration
 	monitor    runtime.Monitor
 	context    *runtime.TaskContext
+	logger     *runtime.TaskLogger
 	stopped    atomics.Once
 	killed     atomics.Bool
 }
@@ -98,6 +99,7 @@ func (p *plugin) NewTaskPlugin(options plugins.TaskPluginOptions) (plugins.TaskP
 
 	return &taskPlugin{
 		context:    options.TaskContext,
+		logger:     options.Logger,
 		monitor:    options.Monitor,
 		maxRunTime: maxRunTime,
 	}, nil
@@ -110,7 +112,7 @@ func (p *taskPlugin) Started(sandbox engines.Sandbox) error {
 			// when maxRunTime has elapsed we kill the task
 			p.killed.Set(true)
 			p.monitor.Info("Killing task due to maxRunTime exceeded")
-			p.context.LogError("Task killed because maxRunTime was exceeded")
+			p.logger.LogError("Task killed because maxRunTime was exceeded")
 			sandbox.Kill()
 		case <-p.context.Done():
 			// when task context is canceled, then we need not kill anything
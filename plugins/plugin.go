@@ -18,6 +18,7 @@ type TaskPluginOptions struct {
 	TaskContext *runtime.TaskContext
 	Payload     map[string]interface{}
 	Monitor     runtime.Monitor
+	Logger      *runtime.TaskLogger // Writes to the task log on behalf of the plugin
 	// Note: This is passed by-value for efficiency (and to prohibit nil), if
 	// adding any large fields please consider adding them as pointers.
 	// Note: This is intended to be a simple argument wrapper, do not add methods
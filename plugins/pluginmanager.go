@@ -350,6 +350,7 @@ func (pm *PluginManager) NewTaskPlugin(options TaskPluginOptions) (TaskPlugin, e
 			TaskContext: options.TaskContext,
 			Payload:     payload,
 			Monitor:     m.monitors[i],
+			Logger:      options.TaskContext.Logger(pm.pluginNames[i]),
 		})
 		if m.taskPlugins[i] == nil {
 			m.taskPlugins[i] = TaskPluginBase{}
@@ -165,6 +165,7 @@ func (c Case) Test() {
 		TaskContext: context,
 		Payload:     parsePluginPayload(p, c.Payload),
 		Monitor:     runtimeEnvironment.Monitor.WithTag("plugin", c.Plugin).WithTag("taskId", taskID),
+		Logger:      context.Logger(c.Plugin),
 	})
 	nilOrPanic(err, "plugin.NewTaskPlugin failed")
 	// taskPlugin can be nil, if the plugin doesn't want any hooks
@@ -316,6 +317,7 @@ func newTestEnvironment() runtime.Environment {
 		TemporaryStorage: folder,
 		Monitor:          mocks.NewMockMonitor(true),
 		Worker:           &runtime.LifeCycleTracker{},
+		EngineName:       "mock",
 	}
 }
 
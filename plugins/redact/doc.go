@@ -0,0 +1,11 @@
+// Package redact provides a taskcluster-worker plugin that redacts configured
+// patterns from the task log.
+//
+// Secrets can also be redacted by other plugins, the env plugin redacts values
+// of environment variables marked secret, and the tcproxy plugin redacts
+// secrets fetched from the secrets service.
+package redact
+
+import "github.com/taskcluster/taskcluster-worker/runtime/util"
+
+var debug = util.Debug("redact")
@@ -0,0 +1,72 @@
+package redact
+
+import (
+	"fmt"
+	"regexp"
+
+	schematypes "github.com/taskcluster/go-schematypes"
+	"github.com/taskcluster/taskcluster-worker/plugins"
+	"github.com/taskcluster/taskcluster-worker/runtime/util"
+)
+
+type provider struct {
+	plugins.PluginProviderBase
+}
+
+type plugin struct {
+	plugins.PluginBase
+	patterns []*regexp.Regexp
+}
+
+type config struct {
+	Patterns []string `json:"patterns"`
+}
+
+var configSchema = schematypes.Object{
+	Properties: schematypes.Properties{
+		"patterns": schematypes.Array{
+			Title: "Patterns to Redact",
+			Description: util.Markdown(`
+				Regular expressions for secrets that must be redacted from the task
+				log, all matches will be replaced with '[REDACTED]'.
+
+				Patterns are applied to each line of output, hence, matches can't
+				span multiple lines.
+			`),
+			Items: schematypes.String{},
+		},
+	},
+	Required: []string{"patterns"},
+}
+
+func init() {
+	plugins.Register("redact", provider{})
+}
+
+func (provider) ConfigSchema() schematypes.Schema {
+	return configSchema
+}
+
+func (provider) NewPlugin(options plugins.PluginOptions) (plugins.Plugin, error) {
+	var c config
+	schematypes.MustValidateAndMap(configSchema, options.Config, &c)
+
+	patterns := make([]*regexp.Regexp, len(c.Patterns))
+	for i, p := range c.Patterns {
+		pattern, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: '%s' in redact config, error: %s", p, err)
+		}
+		patterns[i] = pattern
+	}
+	debug("Created redact plugin with %d patterns", len(patterns))
+
+	return plugin{patterns: patterns}, nil
+}
+
+func (p plugin) NewTaskPlugin(options plugins.TaskPluginOptions) (plugins.TaskPlugin, error) {
+	for _, pattern := range p.patterns {
+		options.TaskContext.RedactPattern(pattern)
+	}
+	return plugins.TaskPluginBase{}, nil
+}
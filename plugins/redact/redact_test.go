@@ -0,0 +1,38 @@
+package redact
+
+import (
+	"testing"
+
+	"github.com/taskcluster/taskcluster-worker/plugins/plugintest"
+)
+
+func TestRedactPattern(*testing.T) {
+	plugintest.Case{
+		Payload: `{
+			"delay": 0,
+			"function": "write-stdout",
+			"argument": "Using token: tok-1234567890, please don't log it"
+		}`,
+		PluginConfig:  `{"patterns": ["tok-[0-9]+"]}`,
+		Plugin:        "redact",
+		PluginSuccess: true,
+		EngineSuccess: true,
+		MatchLog:      `Using token: \[REDACTED\], please`,
+		NotMatchLog:   "tok-1234567890",
+	}.Test()
+}
+
+func TestRedactNoMatch(*testing.T) {
+	plugintest.Case{
+		Payload: `{
+			"delay": 0,
+			"function": "write-stdout",
+			"argument": "Nothing secret here"
+		}`,
+		PluginConfig:  `{"patterns": ["tok-[0-9]+"]}`,
+		Plugin:        "redact",
+		PluginSuccess: true,
+		EngineSuccess: true,
+		MatchLog:      "Nothing secret here",
+	}.Test()
+}
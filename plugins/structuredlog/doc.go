@@ -0,0 +1,12 @@
+// Package structuredlog provides a taskcluster-worker plugin that uploads the
+// structured task log as 'public/logs/structured.jsonl' when the task is
+// resolved.
+//
+// The structured task log carries a record for each line in the task log with
+// timestamp, source, severity and the section (task stage) it was written in,
+// so UIs can render and filter the task log.
+package structuredlog
+
+import "github.com/taskcluster/taskcluster-worker/runtime/util"
+
+var debug = util.Debug("structuredlog")
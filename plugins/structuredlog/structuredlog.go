@@ -0,0 +1,79 @@
+package structuredlog
+
+import (
+	"github.com/pkg/errors"
+	"github.com/taskcluster/taskcluster-worker/plugins"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/atomics"
+)
+
+type pluginProvider struct {
+	plugins.PluginProviderBase
+}
+
+type plugin struct {
+	plugins.PluginBase
+}
+
+type taskPlugin struct {
+	plugins.TaskPluginBase
+	context  *runtime.TaskContext
+	monitor  runtime.Monitor
+	uploaded atomics.Once
+}
+
+func init() {
+	plugins.Register("structuredlog", pluginProvider{})
+}
+
+func (pluginProvider) NewPlugin(plugins.PluginOptions) (plugins.Plugin, error) {
+	return plugin{}, nil
+}
+
+func (plugin) NewTaskPlugin(options plugins.TaskPluginOptions) (plugins.TaskPlugin, error) {
+	if err := options.TaskContext.RecordStructuredLog(); err != nil {
+		options.Monitor.Error(errors.Wrap(err, "failed to create structured log"))
+		return nil, runtime.ErrNonFatalInternalError
+	}
+	return &taskPlugin{
+		context: options.TaskContext,
+		monitor: options.Monitor,
+	}, nil
+}
+
+func (tp *taskPlugin) Finished(success bool) error {
+	var err error
+	tp.uploaded.Do(func() {
+		err = tp.uploadLog()
+	})
+	return err
+}
+
+func (tp *taskPlugin) Exception(runtime.ExceptionReason) error {
+	var err error
+	tp.uploaded.Do(func() {
+		err = tp.uploadLog()
+	})
+	return err
+}
+
+func (tp *taskPlugin) uploadLog() error {
+	file, err := tp.context.ExtractStructuredLog()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	debug("Uploading structured.jsonl")
+	err = tp.context.UploadS3Artifact(runtime.S3Artifact{
+		Name:     "public/logs/structured.jsonl",
+		Mimetype: "application/x-ndjson",
+		Expires:  tp.context.TaskInfo.Expires,
+		Stream:   file,
+	})
+	if err != nil {
+		tp.monitor.Error(errors.Wrap(err, "failed to upload structured.jsonl"))
+		return runtime.ErrNonFatalInternalError // Upload error isn't fatal
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package structuredlog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/taskcluster/slugid-go/slugid"
+	"github.com/taskcluster/taskcluster-worker/plugins/plugintest"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/client"
+)
+
+func TestStructuredLog(t *testing.T) {
+	taskID := slugid.V4()
+	q := &client.MockQueue{}
+	structured := q.ExpectS3Artifact(taskID, 0, "public/logs/structured.jsonl")
+
+	plugintest.Case{
+		Payload: `{
+			"delay": 0,
+			"function": "write-log",
+			"argument": "Hello structured log"
+		}`,
+		Plugin:        "structuredlog",
+		TestStruct:    t,
+		PluginSuccess: true,
+		EngineSuccess: true,
+		MatchLog:      "Hello structured log",
+		TaskID:        taskID,
+		QueueMock:     q,
+		AfterFinished: func(plugintest.Options) {
+			var records []runtime.LogRecord
+			s := bufio.NewScanner(bytes.NewReader(<-structured))
+			for s.Scan() {
+				var r runtime.LogRecord
+				require.NoError(t, json.Unmarshal(s.Bytes(), &r), "invalid record: %s", s.Text())
+				records = append(records, r)
+			}
+			require.NoError(t, s.Err())
+
+			found := false
+			for _, r := range records {
+				assert.False(t, r.Time.IsZero(), "expected a timestamp")
+				if r.Message == "Hello structured log" {
+					found = true
+					assert.Equal(t, runtime.LogSourceWorker, r.Source)
+					assert.Equal(t, runtime.SeverityInfo, r.Severity)
+				}
+			}
+			assert.True(t, found, "expected a record with the message")
+		},
+	}.Test()
+}
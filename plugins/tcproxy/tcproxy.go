@@ -1,9 +1,11 @@
 package tcproxy
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
@@ -27,6 +29,7 @@ type taskPlugin struct {
 	plugins.TaskPluginBase
 	monitor runtime.Monitor
 	context *runtime.TaskContext
+	logger  *runtime.TaskLogger
 }
 
 func init() {
@@ -53,6 +56,7 @@ func (p *plugin) NewTaskPlugin(options plugins.TaskPluginOptions) (plugins.TaskP
 	return &taskPlugin{
 		monitor: options.Monitor,
 		context: options.TaskContext,
+		logger:  options.Logger,
 	}, nil
 }
 
@@ -80,7 +84,7 @@ func (p *taskPlugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	u, err := url.Parse("https://" + raw)
 	if err != nil {
 		debug("bad URL: '%s'", r.URL.Path)
-		p.context.LogError(fmt.Sprintf("tcproxy received path: '%s' which it failed to parse as a URL", raw))
+		p.logger.LogError(fmt.Sprintf("tcproxy received path: '%s' which it failed to parse as a URL", raw))
 		w.WriteHeader(http.StatusBadRequest)
 		data, _ := json.MarshalIndent(struct {
 			Code    string `json:"code"`
@@ -111,7 +115,7 @@ func (p *taskPlugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			errors.Wrap(err, "SignHeader failed"),
 			"SignHeader failed for URL: ", u.String(),
 		)
-		p.context.LogError(fmt.Sprintf("tcproxy expirenced an internal error, incidentID: %s", incidentID))
+		p.logger.LogError(fmt.Sprintf("tcproxy expirenced an internal error, incidentID: %s", incidentID))
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(`{
       "code": "InternalServerError",
@@ -146,7 +150,7 @@ func (p *taskPlugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		// This could be task that canceled the request, or task connection broke
 		// and r.Body returned an error or something like that... Or it could be
 		// the request URL was bad, or service was down...
-		p.context.Log(fmt.Sprintf(
+		p.logger.Log(fmt.Sprintf(
 			"tcproxy was unable to forward request to %s, error: %s",
 			u.String(), err,
 		))
@@ -162,6 +166,17 @@ func (p *taskPlugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Redact secrets from the task log, before they are given to the task
+	var body io.Reader = res.Body
+	if r.Method == http.MethodGet && res.StatusCode == http.StatusOK && isSecretURL(u) {
+		var data []byte
+		data, err = ioutil.ReadAll(io.LimitReader(res.Body, maxSecretSize))
+		if err == nil {
+			p.redactSecret(data)
+		}
+		body = io.MultiReader(bytes.NewReader(data), res.Body)
+	}
+
 	// Set headers from res
 	for k, v := range res.Header {
 		w.Header()[k] = v
@@ -171,9 +186,46 @@ func (p *taskPlugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(res.StatusCode)
 
 	// Copy body
-	_, err = io.Copy(w, res.Body)
+	_, err = io.Copy(w, body)
 	if err != nil {
 		debug("failed for proxy response, error: %s", err)
-		p.context.Log(fmt.Sprintf("tcproxy failed to proxy the entire response from: %s", u.String()))
+		p.logger.Log(fmt.Sprintf("tcproxy failed to proxy the entire response from: %s", u.String()))
+	}
+}
+
+// maxSecretSize is the maximum size of a response from the secrets service
+// that we'll parse to redact the secret from the task log.
+const maxSecretSize = 10 * 1024 * 1024
+
+// isSecretURL returns true, if u is for a secret in the secrets service
+func isSecretURL(u *url.URL) bool {
+	return u.Host == "secrets.taskcluster.net" && strings.HasPrefix(u.Path, "/v1/secret/")
+}
+
+// redactSecret redacts all string values from the secret in a response from
+// the secrets service.
+func (p *taskPlugin) redactSecret(data []byte) {
+	var result struct {
+		Secret interface{} `json:"secret"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		debug("failed to parse secret for redaction, error: %s", err)
+		return
+	}
+	var redact func(value interface{})
+	redact = func(value interface{}) {
+		switch v := value.(type) {
+		case string:
+			p.context.RedactSecret(v)
+		case []interface{}:
+			for _, item := range v {
+				redact(item)
+			}
+		case map[string]interface{}:
+			for _, item := range v {
+				redact(item)
+			}
+		}
 	}
+	redact(result.Secret)
 }
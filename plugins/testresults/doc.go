@@ -0,0 +1,9 @@
+// Package testresults provides a taskcluster-worker plugin that parses test
+// results in JUnit XML or TAP format from files produced by the task, uploads
+// a normalized JSON summary as artifact, and writes a digest of failed tests
+// to the task log.
+package testresults
+
+import "github.com/taskcluster/taskcluster-worker/runtime/util"
+
+var debug = util.Debug("testresults")
@@ -0,0 +1,88 @@
+package testresults
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// junitSuite represents both <testsuites> and <testsuite> elements, as
+// test suites may be nested, and the root element may be either.
+type junitSuite struct {
+	Name   string       `xml:"name,attr"`
+	Suites []junitSuite `xml:"testsuite"`
+	Cases  []junitCase  `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Error     *junitMessage `xml:"error"`
+	Skipped   *junitMessage `xml:"skipped"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func (m *junitMessage) String() string {
+	text := strings.TrimSpace(m.Text)
+	if m.Message == "" {
+		return text
+	}
+	if text == "" {
+		return m.Message
+	}
+	return m.Message + "\n" + text
+}
+
+// parseJUnit parses JUnit XML test results from r
+func parseJUnit(r io.Reader) ([]testCase, error) {
+	var root junitSuite
+	if err := xml.NewDecoder(r).Decode(&root); err != nil {
+		return nil, errors.Wrap(err, "invalid JUnit XML")
+	}
+	return root.testCases(), nil
+}
+
+// testCases returns test cases from the suite, including nested suites
+func (s *junitSuite) testCases() []testCase {
+	var tests []testCase
+	for _, c := range s.Cases {
+		suite := c.ClassName
+		if suite == "" {
+			suite = s.Name
+		}
+		t := testCase{
+			Name:   c.Name,
+			Suite:  suite,
+			Status: statusPassed,
+		}
+		// Durations may be formatted with thousands separators
+		if d, err := strconv.ParseFloat(strings.Replace(c.Time, ",", "", -1), 64); err == nil {
+			t.Duration = d
+		}
+		switch {
+		case c.Failure != nil:
+			t.Status = statusFailed
+			t.Message = c.Failure.String()
+		case c.Error != nil:
+			t.Status = statusFailed
+			t.Message = c.Error.String()
+		case c.Skipped != nil:
+			t.Status = statusSkipped
+			t.Message = c.Skipped.String()
+		}
+		tests = append(tests, t)
+	}
+	for i := range s.Suites {
+		tests = append(tests, s.Suites[i].testCases()...)
+	}
+	return tests
+}
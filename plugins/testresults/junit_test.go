@@ -0,0 +1,56 @@
+package testresults
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const junitSample = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="math" tests="4">
+    <testcase name="add" classname="math.Add" time="0.5"/>
+    <testcase name="sub" classname="math.Sub" time="1,000.25">
+      <failure message="expected 1 got 2">stack trace</failure>
+    </testcase>
+    <testcase name="div">
+      <error message="panic: division by zero"/>
+    </testcase>
+    <testcase name="mul">
+      <skipped/>
+    </testcase>
+    <testsuite name="nested">
+      <testcase name="inner"/>
+    </testsuite>
+  </testsuite>
+</testsuites>`
+
+func TestParseJUnit(t *testing.T) {
+	tests, err := parseJUnit(strings.NewReader(junitSample))
+	require.NoError(t, err)
+	require.Len(t, tests, 5)
+
+	assert.Equal(t, testCase{Name: "add", Suite: "math.Add", Status: statusPassed, Duration: 0.5}, tests[0])
+	assert.Equal(t, statusFailed, tests[1].Status)
+	assert.Equal(t, 1000.25, tests[1].Duration)
+	assert.Equal(t, "expected 1 got 2\nstack trace", tests[1].Message)
+	assert.Equal(t, statusFailed, tests[2].Status)
+	assert.Equal(t, "math", tests[2].Suite)
+	assert.Equal(t, "panic: division by zero", tests[2].Message)
+	assert.Equal(t, statusSkipped, tests[3].Status)
+	assert.Equal(t, "nested", tests[4].Suite)
+}
+
+func TestParseJUnitSingleSuite(t *testing.T) {
+	tests, err := parseJUnit(strings.NewReader(`<testsuite name="s"><testcase name="a"/></testsuite>`))
+	require.NoError(t, err)
+	require.Len(t, tests, 1)
+	assert.Equal(t, "s", tests[0].Suite)
+}
+
+func TestParseJUnitInvalid(t *testing.T) {
+	_, err := parseJUnit(strings.NewReader("Hello World"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,74 @@
+package testresults
+
+import (
+	schematypes "github.com/taskcluster/go-schematypes"
+	"github.com/taskcluster/taskcluster-worker/runtime/util"
+)
+
+type payload struct {
+	TestResults *testResults `json:"testResults"`
+}
+
+type testResults struct {
+	Files          []resultFile `json:"files"`
+	FailOnFailures bool         `json:"failOnFailures"`
+}
+
+type resultFile struct {
+	Path   string `json:"path"`
+	Format string `json:"format"`
+}
+
+const (
+	formatJUnit = "junit"
+	formatTAP   = "tap"
+)
+
+const summaryArtifactName = "public/test-results.json"
+
+var payloadSchema = schematypes.Object{
+	Properties: schematypes.Properties{
+		"testResults": schematypes.Object{
+			Title: "Test Results",
+			Description: util.Markdown(`
+				Test result files to be parsed when the task has finished. A
+				normalized summary of all test results is uploaded as
+				'` + summaryArtifactName + `', and a digest of failed tests is
+				written to the task log.
+			`),
+			Properties: schematypes.Properties{
+				"files": schematypes.Array{
+					Title:       "Test Result Files",
+					Description: "List of test result files to be parsed.",
+					Items: schematypes.Object{
+						Properties: schematypes.Properties{
+							"path": schematypes.String{
+								Title:       "Path",
+								Description: "File system path of the test result file.",
+								Pattern:     `^.*[^/]$`,
+							},
+							"format": schematypes.StringEnum{
+								Title: "Format",
+								Description: util.Markdown(`
+									Format of the test result file, either JUnit XML as 'junit', or
+									'tap' for the Test Anything Protocol.
+								`),
+								Options: []string{formatJUnit, formatTAP},
+							},
+						},
+						Required: []string{"path", "format"},
+					},
+				},
+				"failOnFailures": schematypes.Boolean{
+					Title: "Fail on Test Failures",
+					Description: util.Markdown(`
+						If 'true' the task will be resolved as failed, if any tests failed
+						or a test result file could not be read, even if the command
+						exited successfully.
+					`),
+				},
+			},
+			Required: []string{"files"},
+		},
+	},
+}
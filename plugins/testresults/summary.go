@@ -0,0 +1,107 @@
+package testresults
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	statusPassed  = "passed"
+	statusFailed  = "failed"
+	statusSkipped = "skipped"
+)
+
+// Maximum number of failed tests to list in the task log
+const maxDigestFailures = 25
+
+type testCase struct {
+	Name     string  `json:"name"`
+	Suite    string  `json:"suite,omitempty"`
+	Status   string  `json:"status"`
+	Duration float64 `json:"duration,omitempty"` // seconds
+	Message  string  `json:"message,omitempty"`
+}
+
+type fileResult struct {
+	Path   string     `json:"path"`
+	Format string     `json:"format"`
+	Error  string     `json:"error,omitempty"`
+	Tests  []testCase `json:"tests"`
+}
+
+type summary struct {
+	Total   int          `json:"total"`
+	Passed  int          `json:"passed"`
+	Failed  int          `json:"failed"`
+	Skipped int          `json:"skipped"`
+	Errors  int          `json:"errors"` // Number of files that couldn't be read
+	Files   []fileResult `json:"files"`
+}
+
+// add adds results from a file to the summary and updates the counts
+func (s *summary) add(f fileResult) {
+	if f.Tests == nil {
+		f.Tests = []testCase{}
+	}
+	if f.Error != "" {
+		s.Errors++
+	}
+	for _, t := range f.Tests {
+		s.Total++
+		switch t.Status {
+		case statusPassed:
+			s.Passed++
+		case statusFailed:
+			s.Failed++
+		case statusSkipped:
+			s.Skipped++
+		}
+	}
+	s.Files = append(s.Files, f)
+}
+
+// Digest returns a human readable digest of the summary listing failed tests
+func (s *summary) Digest() string {
+	lines := []string{fmt.Sprintf(
+		"Test results: %d passed, %d failed, %d skipped",
+		s.Passed, s.Failed, s.Skipped,
+	)}
+	count := 0
+	for _, f := range s.Files {
+		if f.Error != "" {
+			lines = append(lines, fmt.Sprintf("ERROR %s: %s", f.Path, f.Error))
+		}
+		for _, t := range f.Tests {
+			if t.Status != statusFailed {
+				continue
+			}
+			count++
+			if count > maxDigestFailures {
+				continue
+			}
+			name := t.Name
+			if t.Suite != "" {
+				name = t.Suite + "." + t.Name
+			}
+			line := "FAILED " + name
+			if msg := firstLine(t.Message); msg != "" {
+				line += ": " + msg
+			}
+			lines = append(lines, line)
+		}
+	}
+	if count > maxDigestFailures {
+		lines = append(lines, fmt.Sprintf("... and %d more failed tests", count-maxDigestFailures))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// firstLine returns the first non-empty line of s
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
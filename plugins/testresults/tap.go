@@ -0,0 +1,122 @@
+package testresults
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	tapPlanPattern   = regexp.MustCompile(`^1\.\.(\d+)`)
+	tapResultPattern = regexp.MustCompile(
+		`^(not )?ok\b\s*(\d*)\s*(?:-\s*)?([^#]*?)\s*(?:#\s*(?i:(skip|todo))\S*\s*(.*))?$`,
+	)
+)
+
+// parseTAP parses test results in the Test Anything Protocol format from r
+func parseTAP(r io.Reader) ([]testCase, error) {
+	var tests []testCase
+	planned := -1
+	inYAML := false
+	var last *testCase // last failed test, to which diagnostics are attached
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		// Attach YAML blocks and diagnostics to the last failed test
+		if inYAML {
+			if trimmed == "..." {
+				inYAML = false
+			} else if last != nil {
+				last.Message = appendLine(last.Message, trimmed)
+			}
+			continue
+		}
+		if trimmed == "---" && len(tests) > 0 {
+			inYAML = true
+			continue
+		}
+		if strings.HasPrefix(trimmed, "#") {
+			if last != nil {
+				last.Message = appendLine(last.Message, strings.TrimSpace(trimmed[1:]))
+			}
+			continue
+		}
+
+		if m := tapPlanPattern.FindStringSubmatch(trimmed); m != nil {
+			planned, _ = strconv.Atoi(m[1])
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "Bail out!") {
+			tests = append(tests, testCase{
+				Name:    "Bail out!",
+				Status:  statusFailed,
+				Message: strings.TrimSpace(strings.TrimPrefix(trimmed, "Bail out!")),
+			})
+			return tests, nil
+		}
+
+		// Only consider test lines that aren't indented, as indented lines are
+		// results from sub-tests, which are summarized by the parent test line.
+		if line != trimmed {
+			continue
+		}
+		m := tapResultPattern.FindStringSubmatch(trimmed)
+		if m == nil {
+			last = nil
+			continue
+		}
+		t := testCase{
+			Name:   m[3],
+			Status: statusPassed,
+		}
+		if t.Name == "" {
+			t.Name = "test " + m[2]
+		}
+		if m[1] != "" {
+			t.Status = statusFailed
+		}
+		// Skipped tests and failing TODO tests, doesn't count as failures
+		directive := strings.ToLower(m[4])
+		if directive == "skip" || (directive == "todo" && t.Status == statusFailed) {
+			t.Status = statusSkipped
+			t.Message = m[5]
+		}
+		tests = append(tests, t)
+		last = nil
+		if t.Status == statusFailed {
+			last = &tests[len(tests)-1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read TAP output")
+	}
+
+	// If fewer tests than planned were reported, we report a failure
+	if planned > len(tests) {
+		tests = append(tests, testCase{
+			Name:   "missing tests",
+			Status: statusFailed,
+			Message: fmt.Sprintf(
+				"planned %d tests, but only %d tests were reported", planned, len(tests),
+			),
+		})
+	}
+	return tests, nil
+}
+
+func appendLine(s, line string) string {
+	if s == "" {
+		return line
+	}
+	return s + "\n" + line
+}
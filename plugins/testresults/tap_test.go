@@ -0,0 +1,54 @@
+package testresults
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const tapSample = `TAP version 13
+1..6
+ok 1 - first test
+not ok 2 - second test
+  ---
+  message: 'expected true'
+  ...
+ok 3 # SKIP not on linux
+not ok 4 - unfinished # TODO implement this
+    ok 1 - subtest is ignored
+not ok 5
+# diagnostic for test 5
+ok 6 - last test`
+
+func TestParseTAP(t *testing.T) {
+	tests, err := parseTAP(strings.NewReader(tapSample))
+	require.NoError(t, err)
+	require.Len(t, tests, 6)
+
+	assert.Equal(t, testCase{Name: "first test", Status: statusPassed}, tests[0])
+	assert.Equal(t, statusFailed, tests[1].Status)
+	assert.Equal(t, "message: 'expected true'", tests[1].Message)
+	assert.Equal(t, statusSkipped, tests[2].Status)
+	assert.Equal(t, "not on linux", tests[2].Message)
+	assert.Equal(t, statusSkipped, tests[3].Status)
+	assert.Equal(t, "unfinished", tests[3].Name)
+	assert.Equal(t, testCase{Name: "test 5", Status: statusFailed, Message: "diagnostic for test 5"}, tests[4])
+	assert.Equal(t, statusPassed, tests[5].Status)
+}
+
+func TestParseTAPMissingTests(t *testing.T) {
+	tests, err := parseTAP(strings.NewReader("1..3\nok 1\n"))
+	require.NoError(t, err)
+	require.Len(t, tests, 2)
+	assert.Equal(t, statusFailed, tests[1].Status)
+}
+
+func TestParseTAPBailOut(t *testing.T) {
+	tests, err := parseTAP(strings.NewReader("1..3\nok 1\nBail out! database is down\nok 2\n"))
+	require.NoError(t, err)
+	require.Len(t, tests, 2)
+	assert.Equal(t, statusFailed, tests[1].Status)
+	assert.Equal(t, "database is down", tests[1].Message)
+}
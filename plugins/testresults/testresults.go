@@ -0,0 +1,171 @@
+package testresults
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	schematypes "github.com/taskcluster/go-schematypes"
+	"github.com/taskcluster/taskcluster-worker/engines"
+	"github.com/taskcluster/taskcluster-worker/plugins"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+)
+
+// Maximum size of a test result file, larger files are not parsed
+const maxResultFileSize = 64 * 1024 * 1024
+
+type pluginProvider struct {
+	plugins.PluginProviderBase
+}
+
+type plugin struct {
+	plugins.PluginBase
+}
+
+type taskPlugin struct {
+	plugins.TaskPluginBase
+	context        *runtime.TaskContext
+	logger         *runtime.TaskLogger
+	monitor        runtime.Monitor
+	files          []resultFile
+	failOnFailures bool
+}
+
+func init() {
+	plugins.Register("testresults", pluginProvider{})
+}
+
+func (pluginProvider) NewPlugin(plugins.PluginOptions) (plugins.Plugin, error) {
+	return plugin{}, nil
+}
+
+func (plugin) PayloadSchema() schematypes.Object {
+	return payloadSchema
+}
+
+func (plugin) NewTaskPlugin(options plugins.TaskPluginOptions) (plugins.TaskPlugin, error) {
+	var p payload
+	schematypes.MustValidateAndMap(payloadSchema, options.Payload, &p)
+
+	// Do nothing, if no test results are declared
+	if p.TestResults == nil || len(p.TestResults.Files) == 0 {
+		return plugins.TaskPluginBase{}, nil
+	}
+
+	return &taskPlugin{
+		context:        options.TaskContext,
+		logger:         options.Logger,
+		monitor:        options.Monitor,
+		files:          p.TestResults.Files,
+		failOnFailures: p.TestResults.FailOnFailures,
+	}, nil
+}
+
+func (tp *taskPlugin) Stopped(result engines.ResultSet) (bool, error) {
+	var s summary
+	for _, f := range tp.files {
+		r, err := tp.parseFile(result, f)
+		if err != nil {
+			return false, err
+		}
+		s.add(r)
+	}
+
+	// Write digest to task log, before the log is uploaded in Finished
+	if s.Failed > 0 || s.Errors > 0 {
+		tp.logger.LogError(s.Digest())
+	} else {
+		tp.logger.Log(s.Digest())
+	}
+
+	if err := tp.uploadSummary(&s); err != nil {
+		return false, err
+	}
+
+	// Fail the task, if tests failed even though the command exited successfully
+	if tp.failOnFailures && (s.Failed > 0 || s.Errors > 0) {
+		if result.Success() {
+			tp.logger.LogError(fmt.Sprintf(
+				"Task failed because %d tests failed, and %d test result files could not be read",
+				s.Failed, s.Errors,
+			))
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// parseFile extracts and parses test results from f, returning an error only
+// if the task should be resolved exception.
+func (tp *taskPlugin) parseFile(result engines.ResultSet, f resultFile) (fileResult, error) {
+	fr := fileResult{
+		Path:   f.Path,
+		Format: f.Format,
+	}
+
+	debug("extracting test results from: %s", f.Path)
+	r, err := result.ExtractFile(f.Path)
+	switch {
+	case err == engines.ErrFeatureNotSupported:
+		return fr, runtime.NewMalformedPayloadError(
+			"Extraction of test results is not supported in current configuration of this workerType",
+		)
+	case err == engines.ErrResourceNotFound:
+		fr.Error = "file not found"
+		return fr, nil
+	case err == runtime.ErrNonFatalInternalError || err == runtime.ErrFatalInternalError:
+		return fr, err
+	case err != nil:
+		if _, ok := runtime.IsMalformedPayloadError(err); ok {
+			return fr, err
+		}
+		incidentID := tp.monitor.ReportError(err, "Unhandled error from ResultSet.ExtractFile()")
+		tp.logger.LogError("Failed to extract test results, incidentId: ", incidentID)
+		return fr, runtime.ErrFatalInternalError
+	}
+	defer r.Close()
+
+	// Read the file with a size limit, as we don't want to parse huge files
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxResultFileSize+1))
+	if err != nil {
+		tp.monitor.Warnf("failed to read test result file: %s, error: %s", f.Path, err)
+		return fr, runtime.ErrNonFatalInternalError
+	}
+	if len(data) > maxResultFileSize {
+		fr.Error = fmt.Sprintf("file is larger than %d bytes", maxResultFileSize)
+		return fr, nil
+	}
+
+	switch f.Format {
+	case formatJUnit:
+		fr.Tests, err = parseJUnit(bytes.NewReader(data))
+	case formatTAP:
+		fr.Tests, err = parseTAP(bytes.NewReader(data))
+	}
+	if err != nil {
+		fr.Error = err.Error()
+	}
+	return fr, nil
+}
+
+func (tp *taskPlugin) uploadSummary(s *summary) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		panic(fmt.Sprintf("failed to serialize test result summary, error: %s", err))
+	}
+	err = tp.context.UploadS3Artifact(runtime.S3Artifact{
+		Name:     summaryArtifactName,
+		Mimetype: "application/json",
+		Stream:   ioext.NopCloser(bytes.NewReader(data)),
+		Expires:  tp.context.TaskInfo.Expires,
+	})
+	if err != nil {
+		incidentID := tp.monitor.ReportError(err, "Failed to upload test result summary")
+		tp.logger.LogError("Failed to upload test result summary, incidentId: ", incidentID)
+		return runtime.ErrNonFatalInternalError
+	}
+	return nil
+}
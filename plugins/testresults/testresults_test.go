@@ -0,0 +1,100 @@
+package testresults
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/taskcluster/slugid-go/slugid"
+	"github.com/taskcluster/taskcluster-worker/plugins/plugintest"
+	"github.com/taskcluster/taskcluster-worker/runtime/client"
+)
+
+func testSummary(t *testing.T, c plugintest.Case) summary {
+	taskID := slugid.Nice()
+	mockedQueue := &client.MockQueue{}
+	data := mockedQueue.ExpectS3Artifact(taskID, 0, summaryArtifactName)
+
+	c.Plugin = "testresults"
+	c.PluginConfig = `{}`
+	c.TestStruct = t
+	c.TaskID = taskID
+	c.QueueMock = mockedQueue
+	c.Test()
+	mockedQueue.AssertExpectations(t)
+
+	var s summary
+	require.NoError(t, json.Unmarshal(<-data, &s))
+	return s
+}
+
+func TestTestResultsNone(t *testing.T) {
+	plugintest.Case{
+		Payload: `{
+			"delay": 0,
+			"function": "true",
+			"argument": "whatever"
+		}`,
+		Plugin:        "testresults",
+		PluginConfig:  `{}`,
+		TestStruct:    t,
+		PluginSuccess: true,
+		EngineSuccess: true,
+	}.Test()
+}
+
+func TestTestResultsTAP(t *testing.T) {
+	s := testSummary(t, plugintest.Case{
+		Payload: `{
+			"delay": 0,
+			"function": "write-file",
+			"argument": "/results.tap:1..2\nok 1 - works\nnot ok 2 - broken\n",
+			"testResults": {
+				"files": [{"path": "/results.tap", "format": "tap"}]
+			}
+		}`,
+		PluginSuccess: true,
+		EngineSuccess: true,
+		MatchLog:      "FAILED broken",
+	})
+	assert.Equal(t, 2, s.Total)
+	assert.Equal(t, 1, s.Passed)
+	assert.Equal(t, 1, s.Failed)
+}
+
+func TestTestResultsFailOnFailures(t *testing.T) {
+	s := testSummary(t, plugintest.Case{
+		Payload: `{
+			"delay": 0,
+			"function": "write-file",
+			"argument": "/results.xml:<testsuite name=\"s\"><testcase name=\"a\"><failure/></testcase></testsuite>",
+			"testResults": {
+				"files": [{"path": "/results.xml", "format": "junit"}],
+				"failOnFailures": true
+			}
+		}`,
+		PluginSuccess: false,
+		EngineSuccess: true,
+		MatchLog:      "Task failed because 1 tests failed",
+	})
+	assert.Equal(t, 1, s.Failed)
+}
+
+func TestTestResultsMissingFile(t *testing.T) {
+	s := testSummary(t, plugintest.Case{
+		Payload: `{
+			"delay": 0,
+			"function": "true",
+			"argument": "whatever",
+			"testResults": {
+				"files": [{"path": "/missing.xml", "format": "junit"}]
+			}
+		}`,
+		PluginSuccess: true,
+		EngineSuccess: true,
+		MatchLog:      "ERROR /missing.xml: file not found",
+	})
+	assert.Equal(t, 1, s.Errors)
+	assert.Equal(t, 0, s.Total)
+}
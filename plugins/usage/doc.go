@@ -0,0 +1,12 @@
+// Package usage provides a taskcluster-worker plugin that uploads resource
+// usage reported by the engine as 'public/<engine>/usage.json' when the task is
+// done, for example 'public/qemu/usage.json'.
+//
+// Usage is engine-specific, for example the QEMU engine reports CPU time, memory
+// and disk and network IO for the virtual machine. Nothing is uploaded, if the
+// engine doesn't report usage.
+package usage
+
+import "github.com/taskcluster/taskcluster-worker/runtime/util"
+
+var debug = util.Debug("usage")
@@ -0,0 +1,77 @@
+package usage
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+
+	"github.com/pkg/errors"
+	"github.com/taskcluster/taskcluster-worker/engines"
+	"github.com/taskcluster/taskcluster-worker/plugins"
+	"github.com/taskcluster/taskcluster-worker/runtime"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
+)
+
+type pluginProvider struct {
+	plugins.PluginProviderBase
+}
+
+type plugin struct {
+	plugins.PluginBase
+	artifactName string
+}
+
+type taskPlugin struct {
+	plugins.TaskPluginBase
+	artifactName string
+	context      *runtime.TaskContext
+	monitor      runtime.Monitor
+}
+
+func init() {
+	plugins.Register("usage", pluginProvider{})
+}
+
+func (pluginProvider) NewPlugin(options plugins.PluginOptions) (plugins.Plugin, error) {
+	// Usage is engine-specific, so the artifact is scoped by engine
+	return plugin{
+		artifactName: path.Join("public", options.Environment.EngineName, "usage.json"),
+	}, nil
+}
+
+func (p plugin) NewTaskPlugin(options plugins.TaskPluginOptions) (plugins.TaskPlugin, error) {
+	return &taskPlugin{
+		artifactName: p.artifactName,
+		context:      options.TaskContext,
+		monitor:      options.Monitor,
+	}, nil
+}
+
+func (tp *taskPlugin) Stopped(result engines.ResultSet) (bool, error) {
+	usage, err := result.Usage()
+	if err == engines.ErrFeatureNotSupported {
+		return true, nil
+	}
+	if err != nil {
+		tp.monitor.Error(errors.Wrap(err, "failed to get usage"))
+		return true, runtime.ErrNonFatalInternalError
+	}
+
+	data, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		panic(errors.Wrap(err, "failed to serialize usage"))
+	}
+
+	debug("Uploading %s", tp.artifactName)
+	err = tp.context.UploadS3Artifact(runtime.S3Artifact{
+		Name:     tp.artifactName,
+		Mimetype: "application/json",
+		Expires:  tp.context.TaskInfo.Expires,
+		Stream:   ioext.NopCloser(bytes.NewReader(data)),
+	})
+	if err != nil {
+		tp.monitor.Error(errors.Wrap(err, "failed to upload usage.json"))
+		return true, runtime.ErrNonFatalInternalError // Upload error isn't fatal
+	}
+	return true, nil
+}
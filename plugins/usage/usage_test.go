@@ -0,0 +1,36 @@
+package usage
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/taskcluster/slugid-go/slugid"
+	"github.com/taskcluster/taskcluster-worker/plugins/plugintest"
+	"github.com/taskcluster/taskcluster-worker/runtime/client"
+)
+
+func TestUsage(t *testing.T) {
+	taskID := slugid.V4()
+	q := &client.MockQueue{}
+	usage := q.ExpectS3Artifact(taskID, 0, "public/mock/usage.json")
+
+	plugintest.Case{
+		Payload: `{
+			"delay": 500,
+			"function": "true",
+			"argument": ""
+		}`,
+		Plugin:        "usage",
+		TestStruct:    t,
+		PluginSuccess: true,
+		EngineSuccess: true,
+		TaskID:        taskID,
+		QueueMock:     q,
+		AfterFinished: func(plugintest.Options) {
+			var u map[string]float64
+			assert.NoError(t, json.Unmarshal(<-usage, &u))
+			assert.Equal(t, map[string]float64{"cpuTime": 0.5}, u)
+		},
+	}.Test()
+}
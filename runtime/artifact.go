@@ -1,8 +1,10 @@
 package runtime
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -22,6 +24,7 @@ type S3Artifact struct {
 	Expires           time.Time
 	Stream            ioext.ReadSeekCloser
 	AdditionalHeaders map[string]string
+	Attempts          int // Maximum number of upload attempts, zero for default
 }
 
 // ErrorArtifact wraps all of the needed fields to upload an error artifact
@@ -62,7 +65,11 @@ func (context *TaskContext) UploadS3Artifact(artifact S3Artifact) error {
 		return err
 	}
 
-	return putArtifact(resp.PutURL, artifact.Mimetype, artifact.Stream, artifact.AdditionalHeaders)
+	attempts := artifact.Attempts
+	if attempts == 0 {
+		attempts = maxPutArtifactAttempts
+	}
+	return putArtifact(context, resp.PutURL, artifact.Mimetype, artifact.Stream, artifact.AdditionalHeaders, attempts)
 }
 
 // CreateErrorArtifact is responsible for inserting error
@@ -123,7 +130,17 @@ func (context *TaskContext) createArtifact(name string, req []byte) ([]byte, err
 	return json.RawMessage(*parsp), nil
 }
 
-func putArtifact(urlStr, mime string, stream ioext.ReadSeekCloser, additionalArtifacts map[string]string) error {
+// Default maximum number of attempts to PUT an artifact, before giving up
+const maxPutArtifactAttempts = 10
+
+// putArtifact uploads stream to urlStr, retrying connection errors and 5xx
+// errors with backoff up to the given number of attempts.
+//
+// Returns context.Canceled, if ctx is canceled while waiting to retry.
+func putArtifact(
+	ctx context.Context, urlStr, mime string, stream ioext.ReadSeekCloser,
+	additionalArtifacts map[string]string, attempts int,
+) error {
 	u, err := url.Parse(urlStr)
 	if err != nil {
 		return err
@@ -141,52 +158,78 @@ func putArtifact(urlStr, mime string, stream ioext.ReadSeekCloser, additionalArt
 	}
 
 	backoff := got.DefaultBackOff
-	attempts := 0
+	attempt := 0
 	client := &http.Client{
 		Timeout: 10 * time.Minute, // There should be _some_ timeout, this seems like a good starting value.
 	}
+	// Wait for backoff before retrying, returns false if out of attempts or ctx
+	// is canceled.
+	retry := func() bool {
+		if attempt >= attempts {
+			return false
+		}
+		select {
+		case <-time.After(backoff.Delay(attempt)):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
 	for {
-		attempts++
+		attempt++
 		_, err := stream.Seek(0, 0)
 		if err != nil {
 			return err
 		}
 		req := &http.Request{
-			Method:        "PUT",
-			URL:           u,
-			Proto:         "HTTP/1.1",
-			ProtoMajor:    1,
-			ProtoMinor:    1,
-			Header:        header,
-			Body:          stream,
+			Method:     "PUT",
+			URL:        u,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     header,
+			// http.Client closes the body, so we can't give it stream, as we need
+			// to seek back to the start, if we have to retry.
+			Body:          ioutil.NopCloser(stream),
 			ContentLength: contentLength,
 		}
 		resp, err := client.Do(req)
 		if err != nil {
+			// Connection errors are retried like 5xx errors
+			if retry() {
+				continue
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 			return err
 		}
-		defer resp.Body.Close()
 		if resp.StatusCode/100 == 4 {
-			httpErr, err := httputil.DumpResponse(resp, true)
-			if err != nil {
-				return err
-			}
-			return errors.New(string(httpErr))
+			return dumpResponseError(resp)
 		}
 		if resp.StatusCode/100 == 5 {
-			// TODO: Make this configurable
-			if attempts < 10 {
-				time.Sleep(backoff.Delay(attempts))
-				continue
-			} else {
-				httpErr, err := httputil.DumpResponse(resp, true)
-				if err != nil {
-					return err
+			if attempt < attempts {
+				resp.Body.Close()
+				if retry() {
+					continue
 				}
-				return errors.New(string(httpErr))
+				return ctx.Err()
 			}
+			return dumpResponseError(resp)
 		}
+		resp.Body.Close()
 		// If we've made it here, the upload has succeeded
 		return nil
 	}
 }
+
+// dumpResponseError returns an error with the dumped response and closes the
+// response body.
+func dumpResponseError(resp *http.Response) error {
+	defer resp.Body.Close()
+	httpErr, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		return err
+	}
+	return errors.New(string(httpErr))
+}
@@ -2,10 +2,13 @@ package runtime
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 
 	"github.com/taskcluster/slugid-go/slugid"
@@ -99,7 +102,7 @@ func TestPutArtifact200(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	err := putArtifact(ts.URL, "text/plain; charset=utf-8", ioext.NopCloser(&bytes.Reader{}), map[string]string{})
+	err := putArtifact(context.Background(), ts.URL, "text/plain; charset=utf-8", ioext.NopCloser(&bytes.Reader{}), map[string]string{}, maxPutArtifactAttempts)
 	if err != nil {
 		t.Error(err)
 	}
@@ -111,7 +114,7 @@ func TestPutArtifact400(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	err := putArtifact(ts.URL, "text/plain; charset=utf-8", ioext.NopCloser(&bytes.Reader{}), map[string]string{})
+	err := putArtifact(context.Background(), ts.URL, "text/plain; charset=utf-8", ioext.NopCloser(&bytes.Reader{}), map[string]string{}, maxPutArtifactAttempts)
 	if err == nil {
 		t.Fail()
 	}
@@ -129,8 +132,62 @@ func TestPutArtifact500(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	err := putArtifact(ts.URL, "text/plain; charset=utf-8", ioext.NopCloser(&bytes.Reader{}), map[string]string{})
+	err := putArtifact(context.Background(), ts.URL, "text/plain; charset=utf-8", ioext.NopCloser(&bytes.Reader{}), map[string]string{}, maxPutArtifactAttempts)
 	if err != nil {
 		t.Error(err)
 	}
 }
+
+func TestPutArtifactRetryFile(t *testing.T) {
+	// Retries must be able to seek back to the start of the file, so the file
+	// must not be closed after the first attempt.
+	tries := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		if string(data) != "hello-world" {
+			t.Errorf("Unexpected body: '%s'", string(data))
+		}
+		if tries < 2 {
+			w.WriteHeader(500)
+			tries++
+		} else {
+			w.WriteHeader(200)
+		}
+	}))
+	defer ts.Close()
+
+	f, err := ioutil.TempFile("", "put-artifact-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err = f.Write([]byte("hello-world")); err != nil {
+		t.Fatal(err)
+	}
+
+	err = putArtifact(context.Background(), ts.URL, "text/plain; charset=utf-8", f, map[string]string{}, 3)
+	if err != nil {
+		t.Error(err)
+	}
+	if tries != 2 {
+		t.Error("Expected 2 failed attempts, got: ", tries)
+	}
+}
+
+func TestPutArtifactAttempts(t *testing.T) {
+	tries := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tries++
+		w.WriteHeader(500)
+	}))
+	defer ts.Close()
+
+	err := putArtifact(context.Background(), ts.URL, "text/plain; charset=utf-8", ioext.NopCloser(&bytes.Reader{}), map[string]string{}, 2)
+	if err == nil {
+		t.Error("Expected an error")
+	}
+	if tries != 2 {
+		t.Error("Expected 2 attempts, got: ", tries)
+	}
+}
@@ -14,7 +14,10 @@ type Environment struct {
 	TemporaryStorage
 	webhookserver.WebHookServer // Optional, may be nil if not available
 	Monitor
-	Worker      Stoppable
-	WorkerGroup string
-	WorkerID    string
+	Worker        Stoppable
+	ProvisionerID string
+	WorkerType    string
+	WorkerGroup   string
+	WorkerID      string
+	EngineName    string // Name of the engine in use, e.g. 'qemu'
 }
@@ -0,0 +1,129 @@
+package runtime
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// logLimiter limits the size of the text log, when the limit is exceeded the
+// head of the log is kept and the tail of the log is held in memory until the
+// log is closed, at which point a truncation marker and the tail is written.
+type logLimiter struct {
+	m         sync.Mutex
+	w         io.Writer
+	limited   bool
+	headSize  int64 // bytes written before truncating
+	tailSize  int   // bytes kept from the end of the log
+	written   int64
+	tail      []byte
+	tailTotal int64 // total bytes written to tail
+	exceeded  chan struct{}
+	closed    bool
+}
+
+func newLogLimiter(w io.Writer) *logLimiter {
+	return &logLimiter{
+		w:        w,
+		exceeded: make(chan struct{}),
+	}
+}
+
+// SetLimit limits the log to maxSize bytes plus truncation marker, keeping the
+// last tailSize bytes. Returns a channel that is closed when the log is
+// truncated.
+//
+// If more than maxSize - tailSize bytes have already been written, the log is
+// truncated immediately, as what has been written can't be taken back.
+func (l *logLimiter) SetLimit(maxSize int64, tailSize int) <-chan struct{} {
+	if maxSize <= 0 || tailSize < 0 || int64(tailSize) > maxSize {
+		panic(fmt.Sprintf(
+			"SetLimit: invalid maxSize: %d and tailSize: %d, 0 <= tailSize <= maxSize is required",
+			maxSize, tailSize,
+		))
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.limited = true
+	l.headSize = maxSize - int64(tailSize)
+	l.tailSize = tailSize
+	if l.written >= l.headSize && !l.closed && !l.Truncated() {
+		close(l.exceeded)
+	}
+	return l.exceeded
+}
+
+// Truncated returns true, if the log has been truncated
+func (l *logLimiter) Truncated() bool {
+	select {
+	case <-l.exceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *logLimiter) Write(p []byte) (int, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if !l.limited || l.closed || (!l.Truncated() && l.written+int64(len(p)) <= l.headSize) {
+		n, err := l.w.Write(p)
+		l.written += int64(n)
+		return n, err
+	}
+
+	// Write what fits in the head, before truncating
+	rest := p
+	if !l.Truncated() {
+		k := l.headSize - l.written
+		if k < 0 {
+			k = 0
+		}
+		n, err := l.w.Write(p[:k])
+		l.written += int64(n)
+		if err != nil {
+			return n, err
+		}
+		rest = p[k:]
+		close(l.exceeded)
+	}
+
+	// Hold the rest in tail, trimming tail when it's twice the size we keep
+	l.tail = append(l.tail, rest...)
+	l.tailTotal += int64(len(rest))
+	if len(l.tail) > 2*l.tailSize {
+		l.tail = append([]byte(nil), l.tail[len(l.tail)-l.tailSize:]...)
+	}
+	return len(p), nil
+}
+
+// Close writes the truncation marker and the tail of the log, if the log was
+// truncated. Further writes are written without limit.
+func (l *logLimiter) Close() error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.closed || !l.Truncated() {
+		l.closed = true
+		return nil
+	}
+	l.closed = true
+
+	tail := l.tail
+	if len(tail) > l.tailSize {
+		tail = tail[len(tail)-l.tailSize:]
+	}
+	omitted := l.tailTotal - int64(len(tail))
+	marker := fmt.Sprintf(
+		"\n[taskcluster:error] Log exceeded %d bytes, %d bytes omitted here\n",
+		l.headSize+int64(l.tailSize), omitted,
+	)
+	if _, err := io.WriteString(l.w, marker); err != nil {
+		return err
+	}
+	_, err := l.w.Write(tail)
+	l.tail = nil
+	return err
+}
@@ -0,0 +1,139 @@
+package runtime
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// redactedText is the text secrets are replaced with in the task log
+const redactedText = "[REDACTED]"
+
+// minSecretLength is the minimum length of secrets that are redacted, shorter
+// values would make the log unreadable and are unlikely to be secret.
+const minSecretLength = 4
+
+// redactor holds secrets and patterns that must be redacted from the task log.
+type redactor struct {
+	m        sync.RWMutex
+	secrets  [][]byte // sorted by length, longest first
+	patterns []*regexp.Regexp
+}
+
+// AddSecret adds a value that must be redacted
+func (r *redactor) AddSecret(secret string) {
+	if len(secret) < minSecretLength {
+		return
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for _, s := range r.secrets {
+		if string(s) == secret {
+			return
+		}
+	}
+	r.secrets = append(r.secrets, []byte(secret))
+	// Redact longest secrets first, so secrets containing other secrets are
+	// redacted entirely
+	sort.SliceStable(r.secrets, func(i, j int) bool {
+		return len(r.secrets[i]) > len(r.secrets[j])
+	})
+}
+
+// AddPattern adds a regular expression for values that must be redacted
+func (r *redactor) AddPattern(pattern *regexp.Regexp) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.patterns = append(r.patterns, pattern)
+}
+
+// Active returns true, if any secrets or patterns have been added
+func (r *redactor) Active() bool {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return len(r.secrets) > 0 || len(r.patterns) > 0
+}
+
+// Redact returns data with all secrets and matches of patterns redacted, data
+// is returned as is if there is nothing to redact.
+func (r *redactor) Redact(data []byte) []byte {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	for _, secret := range r.secrets {
+		if bytes.Contains(data, secret) {
+			data = bytes.Replace(data, secret, []byte(redactedText), -1)
+		}
+	}
+	for _, pattern := range r.patterns {
+		data = pattern.ReplaceAllLiteral(data, []byte(redactedText))
+	}
+	return data
+}
+
+// Split returns the offset in data up to which data can be redacted and
+// written, the remainder must be held back until more data is available.
+//
+// Data is held back if it ends with a prefix of a secret, this is at most the
+// length of the longest secret minus one. If there are patterns, the last line
+// is held back too, as further data could complete or extend a match, hence,
+// matches of patterns can't span lines. Matches of secrets or patterns that
+// would be split are held back too.
+func (r *redactor) Split(data []byte) int {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	// Find the longest suffix of data that is a prefix of a secret
+	offset := len(data)
+	for _, secret := range r.secrets {
+		for n := len(secret) - 1; n > 0 && len(data)-n < offset; n-- {
+			if n <= len(data) && bytes.HasSuffix(data, secret[:n]) {
+				offset = len(data) - n
+				break
+			}
+		}
+	}
+
+	// Find matches of secrets and patterns
+	type span struct{ start, end int }
+	var spans []span
+	for _, secret := range r.secrets {
+		for i := 0; i < len(data); {
+			j := bytes.Index(data[i:], secret)
+			if j == -1 {
+				break
+			}
+			spans = append(spans, span{i + j, i + j + len(secret)})
+			i += j + 1
+		}
+	}
+	for _, pattern := range r.patterns {
+		for _, m := range pattern.FindAllIndex(data, -1) {
+			if m[1] == len(data) && m[0] < offset {
+				offset = m[0] // hold back matches that could be extended
+			}
+			spans = append(spans, span{m[0], m[1]})
+		}
+	}
+
+	// Hold back the last line, if there are patterns
+	if len(r.patterns) > 0 {
+		if i := bytes.LastIndexByte(data, '\n') + 1; i < offset {
+			offset = i
+		}
+	}
+
+	// Hold back any match that would be split, until nothing is split
+	for split := true; split; {
+		split = false
+		for _, s := range spans {
+			if s.start < offset && offset < s.end {
+				offset = s.start
+				split = true
+			}
+		}
+	}
+	return offset
+}
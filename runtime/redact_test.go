@@ -0,0 +1,52 @@
+package runtime
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactorSplit(t *testing.T) {
+	t.Parallel()
+	r := &redactor{}
+	r.AddSecret("my-secret")
+	r.AddSecret("-----BEGIN KEY-----\nabc\n-----END KEY-----")
+
+	for _, c := range []struct {
+		data   string
+		offset int
+	}{
+		{"hello", 5},
+		{"progress 10%", 12},
+		{"split my-se", 6},
+		{"split my-secret", 15},
+		{"my-secret my-", 10},
+		{"key: -----BEGIN KEY-----\nab", 5},
+		{"-----BEGIN KEY-----\nabc\n-----END KEY-----\n", 42},
+	} {
+		assert.Equal(t, c.offset, r.Split([]byte(c.data)), "Split(%q)", c.data)
+	}
+}
+
+func TestRedactorSplitPattern(t *testing.T) {
+	t.Parallel()
+	r := &redactor{}
+	r.AddSecret("my-secret")
+	r.AddPattern(regexp.MustCompile(`token=\w+`))
+
+	for _, c := range []struct {
+		data   string
+		offset int
+	}{
+		{"hello", 0},
+		{"hello\n", 6},
+		{"hello\nto", 6},
+		{"token=abc", 0},
+		{"token=abc and\n", 14},
+		{"a\nb token=abc\nc my-se", 14},
+		{"my-secret\nmy-", 10},
+	} {
+		assert.Equal(t, c.offset, r.Split([]byte(c.data)), "Split(%q)", c.data)
+	}
+}
@@ -0,0 +1,257 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogSeverity is the severity of a record in the structured task log.
+type LogSeverity string
+
+// Severities for records in the structured task log.
+const (
+	SeverityInfo  LogSeverity = "info"
+	SeverityError LogSeverity = "error"
+)
+
+// Sources for records in the structured task log, records written through a
+// TaskLogger use the source given to TaskContext.Logger(), typically the name
+// of a plugin.
+//
+// Records from TaskContext.LogDrain() have source LogSourceTask, this is stdout
+// from the task, or both stdout and stderr if the engine doesn't separate them.
+const (
+	LogSourceWorker = "worker"
+	LogSourceTask   = "task"
+	LogSourceStderr = "stderr"
+)
+
+// A LogRecord is a record in the structured task log, the structured task log
+// is written as JSON lines with one LogRecord per line.
+type LogRecord struct {
+	Time     time.Time   `json:"time"`
+	Source   string      `json:"source"`
+	Severity LogSeverity `json:"severity"`
+	Section  string      `json:"section,omitempty"`
+	Message  string      `json:"message"`
+}
+
+// structuredLog writes LogRecords as JSON lines to a file, records are ignored
+// until the log has been enabled.
+type structuredLog struct {
+	m       sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	section string
+	closed  bool
+}
+
+// Enable creates filename and starts writing records to it, this has no effect
+// if the log is already enabled or closed.
+func (l *structuredLog) Enable(filename string) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if l.file != nil || l.closed {
+		return nil
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	l.file = file
+	l.encoder = json.NewEncoder(file)
+	return nil
+}
+
+// Enabled returns true, if records are being written
+func (l *structuredLog) Enabled() bool {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.file != nil && !l.closed
+}
+
+// SetSection sets the section for all records written from now on.
+func (l *structuredLog) SetSection(section string) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.section = section
+}
+
+// Write writes a record with the current time and section, records written
+// after the log has been closed are ignored.
+func (l *structuredLog) Write(source string, severity LogSeverity, message string) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if l.file == nil || l.closed {
+		return
+	}
+	err := l.encoder.Encode(LogRecord{
+		Time:     time.Now().UTC(),
+		Source:   source,
+		Severity: severity,
+		Section:  l.section,
+		Message:  message,
+	})
+	if err != nil {
+		_ = err //TODO: Forward this to the system log, it's not a critical error
+	}
+}
+
+// Close closes the underlying file, further records will be ignored.
+func (l *structuredLog) Close() error {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// maxPartialLine is the maximum number of bytes buffered by a logDrain while
+// waiting for a newline, longer lines will be split into multiple records.
+// This is also the maximum number of bytes held back for redaction.
+const maxPartialLine = 64 * 1024
+
+// logDrain writes to the text log, to a capture file holding only what is
+// written to this drain, if capturing is enabled, and splits what is written
+// into lines that are written as records to the structured log, if enabled.
+//
+// When the redactor is active data that could be the beginning of a secret is
+// held back, as is the last line if there are patterns, so that secrets and
+// matches split across writes are redacted too.
+type logDrain struct {
+	m        sync.Mutex
+	text     *logLimiter
+	capture  *os.File // nil, unless capturing is enabled
+	records  *structuredLog
+	redactor *redactor
+	source   string
+	pending  []byte // data held back for redaction
+	partial  []byte // partial line not yet written to the structured log
+	closed   bool
+}
+
+func (d *logDrain) Write(p []byte) (int, error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	// Write directly, if there is nothing to redact
+	if len(d.pending) == 0 && !d.redactor.Active() {
+		return d.write(p)
+	}
+
+	d.pending = append(d.pending, p...)
+	i := d.redactor.Split(d.pending)
+	if len(d.pending)-i > maxPartialLine {
+		i = len(d.pending)
+	}
+	if i == 0 {
+		return len(p), nil
+	}
+	data := d.pending[:i]
+	d.pending = append([]byte(nil), d.pending[i:]...)
+	_, err := d.write(d.redactor.Redact(data))
+	return len(p), err
+}
+
+// Capture creates filename and writes everything written to the drain from now
+// on to it, this has no effect if the drain is already capturing or closed.
+func (d *logDrain) Capture(filename string) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if d.capture != nil || d.closed {
+		return nil
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	d.capture = file
+	return nil
+}
+
+// write writes p to the text log, capture file and structured log, once the
+// text log has been truncated p is only written to the text log.
+func (d *logDrain) write(p []byte) (int, error) {
+	n, err := d.text.Write(p)
+	if d.closed || d.text.Truncated() {
+		return n, err
+	}
+	if d.capture != nil {
+		if _, cerr := d.capture.Write(p[:n]); cerr != nil {
+			_ = cerr //TODO: Forward this to the system log, it's not a critical error
+		}
+	}
+	if !d.records.Enabled() {
+		return n, err
+	}
+
+	data := append(d.partial, p[:n]...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i == -1 {
+			break
+		}
+		d.records.Write(d.source, SeverityInfo, string(bytes.TrimSuffix(data[:i], []byte("\r"))))
+		data = data[i+1:]
+	}
+	if len(data) > maxPartialLine {
+		d.records.Write(d.source, SeverityInfo, string(data))
+		data = nil
+	}
+	d.partial = append([]byte(nil), data...)
+
+	return n, err
+}
+
+// Close writes any data held back for redaction, writes any partial line as a
+// record and closes the capture file, if any, further writes will only go to
+// the text log.
+func (d *logDrain) Close() {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if d.closed {
+		return
+	}
+	if len(d.pending) > 0 {
+		d.write(d.redactor.Redact(d.pending))
+		d.pending = nil
+	}
+	d.closed = true
+	if len(d.partial) > 0 {
+		d.records.Write(d.source, SeverityInfo, string(d.partial))
+		d.partial = nil
+	}
+	if d.capture != nil {
+		d.capture.Close()
+	}
+}
+
+// A TaskLogger writes messages to the task log on behalf of a source, such
+// that records in the structured task log are attributed to the source.
+//
+// Messages are written to the text log exactly as TaskContext.Log() and
+// TaskContext.LogError() would write them.
+type TaskLogger struct {
+	context *TaskContext
+	source  string
+}
+
+// Log writes a log message from the source
+func (l *TaskLogger) Log(a ...interface{}) {
+	l.context.log(l.source, SeverityInfo, "[taskcluster] ", a...)
+}
+
+// LogError writes a log error message from the source
+func (l *TaskLogger) LogError(a ...interface{}) {
+	l.context.log(l.source, SeverityError, "[taskcluster:error] ", a...)
+}
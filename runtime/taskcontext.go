@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 
@@ -21,6 +22,11 @@ import (
 // while it is still open.
 var ErrLogNotClosed = errors.New("Log is still open")
 
+// ErrLogNotCaptured represents an attempt to extract a log that wasn't
+// captured, see TaskContext.CaptureLogStreams() and
+// TaskContext.RecordStructuredLog().
+var ErrLogNotCaptured = errors.New("Log wasn't captured")
+
 // TaskStatus represents the current status of the task.
 type TaskStatus string // TODO: (jonasfj) TaskContext shouldn't track status
 
@@ -63,6 +69,11 @@ type TaskContext struct {
 	TaskInfo
 	logStream   *stream.Stream
 	logLocation string // Absolute path to log file
+	logRecords  *structuredLog
+	logDrain    *logDrain
+	stderrDrain *logDrain
+	redactor    *redactor
+	logLimiter  *logLimiter
 	logClosed   bool
 	mu          sync.RWMutex
 	queue       client.Queue
@@ -88,11 +99,29 @@ func NewTaskContext(tempLogFile string, task TaskInfo) (*TaskContext, *TaskConte
 	if err != nil {
 		return nil, nil, err
 	}
+	logRecords := &structuredLog{}
+	redactor := &redactor{}
+	logLimiter := newLogLimiter(logStream)
 	ctx := &TaskContext{
 		logStream:   logStream,
 		logLocation: tempLogFile,
-		TaskInfo:    task,
-		done:        make(chan struct{}),
+		logRecords:  logRecords,
+		logDrain: &logDrain{
+			text:     logLimiter,
+			records:  logRecords,
+			redactor: redactor,
+			source:   LogSourceTask,
+		},
+		stderrDrain: &logDrain{
+			text:     logLimiter,
+			records:  logRecords,
+			redactor: redactor,
+			source:   LogSourceStderr,
+		},
+		redactor:   redactor,
+		logLimiter: logLimiter,
+		TaskInfo:   task,
+		done:       make(chan struct{}),
 	}
 	ctx.authorizer = client.NewAuthorizer(func() (string, string, string, error) {
 		ctx.mu.RLock()
@@ -116,15 +145,51 @@ func (c *TaskContextController) CloseLog() error {
 
 	debug("closing log on TaskContext")
 	c.logClosed = true
+	c.logDrain.Close()
+	c.stderrDrain.Close()
+	c.logRecords.Close()
+	if err := c.logLimiter.Close(); err != nil {
+		return err
+	}
 	return c.logStream.Close()
 }
 
+// SetLogSection sets the section for records written to the structured log
+// from now on, sections allow UIs to group records by stage of the task.
+func (c *TaskContextController) SetLogSection(section string) {
+	c.logRecords.SetSection(section)
+}
+
 // Dispose will clean-up all resources held by the TaskContext
 func (c *TaskContextController) Dispose() error {
 	debug("disposing TaskContext")
+	c.logDrain.Close()
+	c.stderrDrain.Close()
+	c.logRecords.Close()
+	if err := removeLogFiles(c.logLocation); err != nil {
+		return err
+	}
 	return c.logStream.Remove()
 }
 
+// Suffixes for the files stored next to the text log
+const (
+	structuredLogSuffix = ".jsonl"
+	stdoutLogSuffix     = ".stdout"
+	stderrLogSuffix     = ".stderr"
+)
+
+// removeLogFiles removes the files stored next to the text log
+func removeLogFiles(logLocation string) error {
+	for _, suffix := range []string{structuredLogSuffix, stdoutLogSuffix, stderrLogSuffix} {
+		err := os.Remove(logLocation + suffix)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
 // SetQueueClient will set a client for the TaskCluster Queue.  This client
 // can then be used by others that have access to the task context and require
 // interaction with the queue.
@@ -244,7 +309,7 @@ func (c *TaskContext) IsCancelled() bool {
 // These log messages will be prefixed "[taskcluster]" so it's easy to see to
 // that they are worker logs.
 func (c *TaskContext) Log(a ...interface{}) {
-	c.log("[taskcluster] ", a...)
+	c.log(LogSourceWorker, SeverityInfo, "[taskcluster] ", a...)
 }
 
 // LogError writes a log error message from the worker
@@ -253,23 +318,100 @@ func (c *TaskContext) Log(a ...interface{}) {
 // that they are worker logs.  These errors are also easy to grep from the logs in
 // case of failure.
 func (c *TaskContext) LogError(a ...interface{}) {
-	c.log("[taskcluster:error] ", a...)
+	c.log(LogSourceWorker, SeverityError, "[taskcluster:error] ", a...)
+}
+
+// RedactSecret ensures that secret is redacted from everything written to the
+// task log from now on, this includes both the log drains and messages from
+// the worker. Secrets shorter than 4 characters are ignored.
+//
+// Data written to LogDrain() and StderrLogDrain() is held back while it ends
+// with what could be the beginning of a secret, secrets may span lines.
+func (c *TaskContext) RedactSecret(secret string) {
+	c.redactor.AddSecret(secret)
+}
+
+// RedactPattern ensures that matches of pattern are redacted from everything
+// written to the task log from now on, see RedactSecret().
+//
+// Data written to LogDrain() and StderrLogDrain() is held back until the end
+// of the line, so matches split across writes are redacted too, but matches
+// can't span lines. Lines longer than 64 KiB are flushed without waiting for
+// the end of the line.
+func (c *TaskContext) RedactPattern(pattern *regexp.Regexp) {
+	c.redactor.AddPattern(pattern)
+}
+
+// LimitLog limits the size of the task log to maxSize bytes, when exceeded the
+// log is truncated keeping the head of the log and the last tailSize bytes,
+// with a marker stating how many bytes were omitted. The tail of the log is
+// held in memory and written when the log is closed.
+//
+// Once the log is truncated ExtractStdoutLog(), ExtractStderrLog() and the
+// structured log no longer record what is written to the log drains.
+//
+// Returns a channel that is closed when the log is truncated, this can be used
+// to kill the sandbox when the log size is exceeded.
+func (c *TaskContext) LimitLog(maxSize int64, tailSize int) <-chan struct{} {
+	return c.logLimiter.SetLimit(maxSize, tailSize)
+}
+
+// CaptureLogStreams enables capturing of what is written to LogDrain() and
+// StderrLogDrain(), such that it can be read with ExtractStdoutLog() and
+// ExtractStderrLog(). Only what is written after this call is captured, so
+// plugins that need the streams should call this from NewTaskPlugin().
+func (c *TaskContext) CaptureLogStreams() error {
+	if err := c.logDrain.Capture(c.logLocation + stdoutLogSuffix); err != nil {
+		return err
+	}
+	return c.stderrDrain.Capture(c.logLocation + stderrLogSuffix)
+}
+
+// RecordStructuredLog enables the structured log, such that it can be read with
+// ExtractStructuredLog(). Only what is written after this call is recorded, so
+// plugins that need the structured log should call this from NewTaskPlugin().
+func (c *TaskContext) RecordStructuredLog() error {
+	return c.logRecords.Enable(c.logLocation + structuredLogSuffix)
+}
+
+// Logger returns a TaskLogger that writes messages to the task log on behalf of
+// source, typically the name of a plugin.
+func (c *TaskContext) Logger(source string) *TaskLogger {
+	return &TaskLogger{context: c, source: source}
 }
 
-func (c *TaskContext) log(prefix string, a ...interface{}) {
+func (c *TaskContext) log(source string, severity LogSeverity, prefix string, a ...interface{}) {
 	a = append([]interface{}{prefix}, a...)
-	_, err := fmt.Fprintln(c.logStream, a...)
+	line := string(c.redactor.Redact([]byte(fmt.Sprintln(a...))))
+	_, err := io.WriteString(c.logLimiter, line)
 	if err != nil {
 		_ = err //TODO: Forward this to the system log, it's not a critical error
 	}
+	message := strings.TrimSuffix(strings.TrimPrefix(line, prefix+" "), "\n")
+	c.logRecords.Write(source, severity, message)
 }
 
 // LogDrain returns a drain to which log message can be written.
 //
 // Users should note that multiple writers are writing to this drain
 // concurrently, and it is recommend that writers write in chunks of one line.
+//
+// Lines written to this drain are recorded in the structured log with the
+// source LogSourceTask. Engines that declare SeparateStderr in their
+// capabilities write stdout from the task to this drain and stderr to
+// StderrLogDrain(), other engines write both to this drain.
 func (c *TaskContext) LogDrain() io.Writer {
-	return c.logStream
+	return c.logDrain
+}
+
+// StderrLogDrain returns a drain to which stderr from the task can be written.
+//
+// Data written to this drain is written to the task log like data written to
+// LogDrain(), but it's recorded separately such that stdout and stderr can be
+// distinguished. Lines written to this drain are recorded in the structured log
+// with the source LogSourceStderr.
+func (c *TaskContext) StderrLogDrain() io.Writer {
+	return c.stderrDrain
 }
 
 // NewLogReader returns a ReadCloser that reads the log from the start as the
@@ -283,6 +425,18 @@ func (c *TaskContext) NewLogReader() (io.ReadCloser, error) {
 	return c.logStream.NextReader()
 }
 
+// LogSize returns the number of bytes written to the log so far.
+//
+// This is useful for readers that wish to skip to the end of the log, as
+// readers from NewLogReader() always start from the beginning of the log.
+func (c *TaskContext) LogSize() (int64, error) {
+	info, err := os.Stat(c.logLocation)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
 // ExtractLog returns an IO object to read the log.
 func (c *TaskContext) ExtractLog() (ioext.ReadSeekCloser, error) {
 	c.mu.Lock()
@@ -300,6 +454,46 @@ func (c *TaskContext) ExtractLog() (ioext.ReadSeekCloser, error) {
 	return file, nil
 }
 
+// ExtractStructuredLog returns an IO object to read the structured log, this
+// is JSON lines with one LogRecord per line. Returns ErrLogNotCaptured, unless
+// RecordStructuredLog() was called.
+func (c *TaskContext) ExtractStructuredLog() (ioext.ReadSeekCloser, error) {
+	return c.extractLogFile(structuredLogSuffix)
+}
+
+// ExtractStdoutLog returns an IO object to read everything written to
+// LogDrain(), this is the task log without worker messages and stderr. Returns
+// ErrLogNotCaptured, unless CaptureLogStreams() was called.
+func (c *TaskContext) ExtractStdoutLog() (ioext.ReadSeekCloser, error) {
+	return c.extractLogFile(stdoutLogSuffix)
+}
+
+// ExtractStderrLog returns an IO object to read everything written to
+// StderrLogDrain(). Returns ErrLogNotCaptured, unless CaptureLogStreams() was
+// called.
+func (c *TaskContext) ExtractStderrLog() (ioext.ReadSeekCloser, error) {
+	return c.extractLogFile(stderrLogSuffix)
+}
+
+func (c *TaskContext) extractLogFile(suffix string) (ioext.ReadSeekCloser, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.logClosed {
+		return nil, ErrLogNotClosed
+	}
+
+	file, err := os.Open(c.logLocation + suffix)
+	if os.IsNotExist(err) {
+		return nil, ErrLogNotCaptured
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
 // HasScopes returns true, if task.scopes covers one of the scopeSets given
 func (c *TaskContext) HasScopes(scopeSets ...[]string) bool {
 	for _, scopes := range scopeSets {
@@ -1,9 +1,11 @@
 package runtime
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 	"testing"
@@ -11,6 +13,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/taskcluster/slugid-go/slugid"
+	"github.com/taskcluster/taskcluster-worker/runtime/ioext"
 )
 
 func TestTaskContextLogging(t *testing.T) {
@@ -110,3 +113,212 @@ func TestTaskContextHasScopes(t *testing.T) {
 		"false:*",
 	}), "star false")
 }
+
+func TestTaskContextStructuredLog(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(os.TempDir(), slugid.Nice())
+	context, control, err := NewTaskContext(path, TaskInfo{})
+	require.NoError(t, err, "Failed to create context")
+	defer control.Dispose()
+	require.NoError(t, context.RecordStructuredLog(), "Failed to enable structured log")
+
+	control.SetLogSection("build")
+	context.Log("Hello World")
+	control.SetLogSection("run")
+	context.Logger("my-plugin").LogError("Something", "failed")
+	context.LogDrain().Write([]byte("line 1\nline"))
+	context.LogDrain().Write([]byte(" 2\r\npartial"))
+	require.NoError(t, control.CloseLog(), "Failed to close log file")
+
+	// Check that the text log is unchanged
+	reader, err := context.ExtractLog()
+	require.NoError(t, err, "Failed to open log file")
+	data, err := ioutil.ReadAll(reader)
+	require.NoError(t, err, "Failed to read log file")
+	reader.Close()
+	assert.Equal(t, "[taskcluster]  Hello World\n[taskcluster:error]  Something failed\nline 1\nline 2\r\npartial", string(data))
+
+	reader, err = context.ExtractStructuredLog()
+	require.NoError(t, err, "Failed to open structured log")
+	defer reader.Close()
+	var records []LogRecord
+	decoder := json.NewDecoder(reader)
+	for decoder.More() {
+		var r LogRecord
+		require.NoError(t, decoder.Decode(&r), "Failed to decode record")
+		records = append(records, r)
+	}
+	require.Len(t, records, 5)
+	assert.Equal(t, LogRecord{Time: records[0].Time, Source: LogSourceWorker, Severity: SeverityInfo, Section: "build", Message: "Hello World"}, records[0])
+	assert.Equal(t, LogRecord{Time: records[1].Time, Source: "my-plugin", Severity: SeverityError, Section: "run", Message: "Something failed"}, records[1])
+	assert.Equal(t, "line 1", records[2].Message)
+	assert.Equal(t, "line 2", records[3].Message)
+	assert.Equal(t, "partial", records[4].Message)
+	assert.Equal(t, LogSourceTask, records[4].Source)
+}
+
+func TestTaskContextStderrLog(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(os.TempDir(), slugid.Nice())
+	context, control, err := NewTaskContext(path, TaskInfo{})
+	require.NoError(t, err, "Failed to create context")
+	defer control.Dispose()
+	require.NoError(t, context.CaptureLogStreams(), "Failed to capture log streams")
+
+	context.Log("Hello World")
+	context.LogDrain().Write([]byte("to stdout\n"))
+	context.StderrLogDrain().Write([]byte("to stderr\n"))
+	require.NoError(t, control.CloseLog(), "Failed to close log file")
+
+	read := func(extract func() (ioext.ReadSeekCloser, error)) string {
+		reader, rerr := extract()
+		require.NoError(t, rerr, "Failed to open log")
+		defer reader.Close()
+		data, rerr := ioutil.ReadAll(reader)
+		require.NoError(t, rerr, "Failed to read log")
+		return string(data)
+	}
+	assert.Equal(t, "[taskcluster]  Hello World\nto stdout\nto stderr\n", read(context.ExtractLog))
+	assert.Equal(t, "to stdout\n", read(context.ExtractStdoutLog))
+	assert.Equal(t, "to stderr\n", read(context.ExtractStderrLog))
+}
+
+func TestTaskContextLogNotCaptured(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(os.TempDir(), slugid.Nice())
+	context, control, err := NewTaskContext(path, TaskInfo{})
+	require.NoError(t, err, "Failed to create context")
+	defer control.Dispose()
+
+	context.Log("Hello World")
+	context.LogDrain().Write([]byte("to stdout\n"))
+	context.StderrLogDrain().Write([]byte("to stderr\n"))
+	require.NoError(t, control.CloseLog(), "Failed to close log file")
+
+	// Nothing but the text log is written, unless requested
+	for _, extract := range []func() (ioext.ReadSeekCloser, error){
+		context.ExtractStdoutLog, context.ExtractStderrLog, context.ExtractStructuredLog,
+	} {
+		_, err = extract()
+		assert.Equal(t, ErrLogNotCaptured, err)
+	}
+}
+
+func TestTaskContextRedaction(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(os.TempDir(), slugid.Nice())
+	context, control, err := NewTaskContext(path, TaskInfo{})
+	require.NoError(t, err, "Failed to create context")
+	defer control.Dispose()
+
+	context.RedactSecret("my-secret")
+	context.RedactSecret("abc") // too short to be redacted
+	context.RedactPattern(regexp.MustCompile(`token=\w+`))
+	context.Log("Secret is my-secret")
+	context.LogDrain().Write([]byte("split my-se"))
+	context.LogDrain().Write([]byte("cret abc\ntoken=xyz123 and "))
+	context.StderrLogDrain().Write([]byte("my-secret"))
+	context.LogDrain().Write([]byte("more\n"))
+	require.NoError(t, control.CloseLog(), "Failed to close log file")
+
+	reader, err := context.ExtractLog()
+	require.NoError(t, err, "Failed to open log file")
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	require.NoError(t, err, "Failed to read log file")
+	assert.Equal(t, "[taskcluster]  Secret is [REDACTED]\nsplit [REDACTED] abc\n[REDACTED] and more\n[REDACTED]", string(data))
+}
+
+func TestTaskContextRedactionSplit(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(os.TempDir(), slugid.Nice())
+	context, control, err := NewTaskContext(path, TaskInfo{})
+	require.NoError(t, err, "Failed to create context")
+	defer control.Dispose()
+
+	key := "-----BEGIN KEY-----\nabc\n-----END KEY-----"
+	context.RedactSecret(key)
+	context.RedactSecret("my-secret")
+	context.RedactPattern(regexp.MustCompile(`token=\w+`))
+	context.LogDrain().Write([]byte("key: " + key[:22]))
+	context.LogDrain().Write([]byte(key[22:] + "\n"))
+	context.LogDrain().Write([]byte("a tok"))
+	context.LogDrain().Write([]byte("en=xyz"))
+	context.LogDrain().Write([]byte("123 b\n"))
+	context.LogDrain().Write([]byte(strings.Repeat("x", maxPartialLine-3) + "my-"))
+	context.LogDrain().Write([]byte("secret\n"))
+	require.NoError(t, control.CloseLog(), "Failed to close log file")
+
+	reader, err := context.ExtractLog()
+	require.NoError(t, err, "Failed to open log file")
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	require.NoError(t, err, "Failed to read log file")
+	assert.Equal(t, "key: [REDACTED]\na [REDACTED] b\n"+strings.Repeat("x", maxPartialLine-3)+"[REDACTED]\n", string(data))
+}
+
+func TestTaskContextLimitLog(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(os.TempDir(), slugid.Nice())
+	context, control, err := NewTaskContext(path, TaskInfo{})
+	require.NoError(t, err, "Failed to create context")
+	defer control.Dispose()
+	require.NoError(t, context.CaptureLogStreams(), "Failed to capture log streams")
+
+	exceeded := context.LimitLog(20, 5)
+	context.LogDrain().Write([]byte("0123456789"))
+	select {
+	case <-exceeded:
+		assert.Fail(t, "Log shouldn't be truncated yet")
+	default:
+	}
+	context.LogDrain().Write([]byte("abcdefghijklmnop"))
+	context.LogDrain().Write([]byte("XYZ\n"))
+	select {
+	case <-exceeded:
+	default:
+		assert.Fail(t, "Log should have been truncated")
+	}
+	require.NoError(t, control.CloseLog(), "Failed to close log file")
+
+	reader, err := context.ExtractLog()
+	require.NoError(t, err, "Failed to open log file")
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	require.NoError(t, err, "Failed to read log file")
+	assert.Equal(t, "0123456789abcde\n[taskcluster:error] Log exceeded 20 bytes, 10 bytes omitted here\npXYZ\n", string(data))
+
+	// Only what was written before truncation is captured
+	reader, err = context.ExtractStdoutLog()
+	require.NoError(t, err, "Failed to open stdout log file")
+	defer reader.Close()
+	data, err = ioutil.ReadAll(reader)
+	require.NoError(t, err, "Failed to read stdout log file")
+	assert.Equal(t, "0123456789", string(data))
+}
+
+func TestTaskContextLimitLogAfterWrite(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(os.TempDir(), slugid.Nice())
+	context, control, err := NewTaskContext(path, TaskInfo{})
+	require.NoError(t, err, "Failed to create context")
+	defer control.Dispose()
+
+	// Limit the log after more than the head has been written
+	context.LogDrain().Write([]byte("0123456789abcdefghij"))
+	exceeded := context.LimitLog(10, 5)
+	select {
+	case <-exceeded:
+	default:
+		assert.Fail(t, "Log should have been truncated")
+	}
+	context.LogDrain().Write([]byte("XYZ\n"))
+	require.NoError(t, control.CloseLog(), "Failed to close log file")
+
+	reader, err := context.ExtractLog()
+	require.NoError(t, err, "Failed to open log file")
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	require.NoError(t, err, "Failed to read log file")
+	assert.Equal(t, "0123456789abcdefghij\n[taskcluster:error] Log exceeded 10 bytes, 0 bytes omitted here\nXYZ\n", string(data))
+}
@@ -52,14 +52,18 @@ func SpawnWithLimit(N, limit int, fn func(i int)) {
 			defer wg.Done()
 
 			m.Lock()
-			defer m.Unlock()
-
 			for limit == 0 {
 				c.Wait()
 			}
 			limit--
+			m.Unlock()
+
+			// Don't hold the lock while fn(i) is running, as that would prevent
+			// anything from running in parallel.
 			defer func() {
+				m.Lock()
 				limit++
+				m.Unlock()
 				c.Signal()
 			}()
 
@@ -0,0 +1,33 @@
+package util
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSpawnWithLimit(t *testing.T) {
+	m := sync.Mutex{}
+	running := 0
+	maxRunning := 0
+	SpawnWithLimit(20, 4, func(i int) {
+		m.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		m.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		m.Lock()
+		running--
+		m.Unlock()
+	})
+	if maxRunning > 4 {
+		t.Errorf("expected at-most 4 in parallel, got %d", maxRunning)
+	}
+	if maxRunning < 2 {
+		t.Errorf("expected calls to run in parallel, got %d", maxRunning)
+	}
+}
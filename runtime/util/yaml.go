@@ -0,0 +1,31 @@
+package util
+
+import "fmt"
+
+// ConvertSimpleJSONTypes converts a value parsed from YAML into the types
+// produced by encoding/json, such that it can be validated against a JSON
+// schema. Maps get string keys and integers become float64.
+func ConvertSimpleJSONTypes(val interface{}) interface{} {
+	switch val := val.(type) {
+	case []interface{}:
+		r := make([]interface{}, len(val))
+		for i, v := range val {
+			r[i] = ConvertSimpleJSONTypes(v)
+		}
+		return r
+	case map[interface{}]interface{}:
+		r := make(map[string]interface{})
+		for k, v := range val {
+			s, ok := k.(string)
+			if !ok {
+				s = fmt.Sprintf("%v", k)
+			}
+			r[s] = ConvertSimpleJSONTypes(v)
+		}
+		return r
+	case int:
+		return float64(val)
+	default:
+		return val
+	}
+}
@@ -0,0 +1,19 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertSimpleJSONTypes(t *testing.T) {
+	assert.Equal(t, map[string]interface{}{
+		"a":  float64(1),
+		"2":  []interface{}{"b", float64(3), map[string]interface{}{"true": 4.5}},
+		"ok": true,
+	}, ConvertSimpleJSONTypes(map[interface{}]interface{}{
+		"a":  1,
+		2:    []interface{}{"b", 3, map[interface{}]interface{}{true: 4.5}},
+		"ok": true,
+	}))
+}
@@ -165,6 +165,7 @@ func (t *TaskRun) RunToStage(targetStage Stage) {
 		t.m.Unlock()
 		monitor := t.monitor.WithTag("stage", stage.String())
 		monitor.Debug("running stage: ", stage.String())
+		t.controller.SetLogSection(stage.String())
 		var err error
 		incidentID := monitor.CapturePanic(func() {
 			err = stages[stage](t)
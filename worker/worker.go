@@ -96,8 +96,11 @@ func New(config interface{}) (w *Worker, err error) {
 		TemporaryStorage: w.temporaryStorage,
 		WebHookServer:    w.webhookserver,
 		Worker:           &w.lifeCycleTracker,
+		ProvisionerID:    c.WorkerOptions.ProvisionerID,
+		WorkerType:       c.WorkerOptions.WorkerType,
 		WorkerGroup:      c.WorkerOptions.WorkerGroup,
 		WorkerID:         c.WorkerOptions.WorkerID,
+		EngineName:       c.Engine,
 	}
 
 	// Create engine